@@ -33,6 +33,9 @@ type Datastore struct {
 	path string
 	// length of the dir splay prefix, in bytes of hex digits
 	hexPrefixLen int
+
+	// noSync skips the fsyncs that make every write durable on return
+	noSync bool
 }
 
 var _ datastore.Datastore = (*Datastore)(nil)
@@ -49,6 +52,26 @@ func New(path string, prefixLen int) (*Datastore, error) {
 	return fs, nil
 }
 
+// SetSync sets whether writes are fsynced before returning, which they are
+// by default. Without it writes are faster, but a crash may lose them.
+func (fs *Datastore) SetSync(sync bool) {
+	fs.noSync = !sync
+}
+
+func (fs *Datastore) syncDir(dir string) error {
+	if fs.noSync {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+func (fs *Datastore) syncFile(f *os.File) error {
+	if fs.noSync {
+		return nil
+	}
+	return f.Sync()
+}
+
 var padding = strings.Repeat("_", maxPrefixLen*hex.EncodedLen(1))
 
 func (fs *Datastore) encode(key datastore.Key) (dir, file string) {
@@ -80,7 +103,7 @@ func (fs *Datastore) makePrefixDir(dir string) error {
 	// it, the creation of the prefix dir itself might not be
 	// durable yet. Sync the root dir after a successful mkdir of
 	// a prefix dir, just to be paranoid.
-	if err := syncDir(fs.path); err != nil {
+	if err := fs.syncDir(fs.path); err != nil {
 		return err
 	}
 	return nil
@@ -116,7 +139,7 @@ func (fs *Datastore) Put(key datastore.Key, value interface{}) error {
 			return err
 		}
 
-		log.Error("too many open files, retrying in %dms", 100*i)
+		log.Errorf("too many open files, retrying in %dms", 100*i)
 		time.Sleep(time.Millisecond * 100 * time.Duration(i))
 	}
 	return err
@@ -148,7 +171,7 @@ func (fs *Datastore) doPut(key datastore.Key, val []byte) error {
 	if _, err := tmp.Write(val); err != nil {
 		return err
 	}
-	if err := tmp.Sync(); err != nil {
+	if err := fs.syncFile(tmp); err != nil {
 		return err
 	}
 	if err := tmp.Close(); err != nil {
@@ -162,7 +185,7 @@ func (fs *Datastore) doPut(key datastore.Key, val []byte) error {
 	}
 	removed = true
 
-	if err := syncDir(dir); err != nil {
+	if err := fs.syncDir(dir); err != nil {
 		return err
 	}
 	return nil
@@ -213,7 +236,7 @@ func (fs *Datastore) putMany(data map[datastore.Key]interface{}) error {
 	// Now we sync everything
 	// sync and close files
 	for fi, _ := range files {
-		if err := fi.Sync(); err != nil {
+		if err := fs.syncFile(fi); err != nil {
 			return err
 		}
 
@@ -237,13 +260,13 @@ func (fs *Datastore) putMany(data map[datastore.Key]interface{}) error {
 
 	// now sync the dirs for those files
 	for _, dir := range dirsToSync {
-		if err := syncDir(dir); err != nil {
+		if err := fs.syncDir(dir); err != nil {
 			return err
 		}
 	}
 
 	// sync top flatfs dir
-	if err := syncDir(fs.path); err != nil {
+	if err := fs.syncDir(fs.path); err != nil {
 		return err
 	}
 
@@ -59,7 +59,10 @@ func addAssetList(nd *core.IpfsNode, l []string) (*key.Key, error) {
 		}
 	}
 
-	dir := dirb.GetNode()
+	dir, err := dirb.GetNode()
+	if err != nil {
+		return nil, err
+	}
 	dkey, err := nd.DAG.Add(dir)
 	if err != nil {
 		return nil, fmt.Errorf("assets: DAG.Add(dir) failed: %s", err)
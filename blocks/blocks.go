@@ -3,6 +3,7 @@
 package blocks
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -11,6 +12,9 @@ import (
 	u "github.com/ipfs/go-ipfs/util"
 )
 
+// ErrWrongHash is returned when data does not hash to the expected multihash.
+var ErrWrongHash = errors.New("Data did not match given hash!")
+
 // Block is a singular block of data in ipfs
 type Block struct {
 	Multihash mh.Multihash
@@ -22,19 +26,45 @@ func NewBlock(data []byte) *Block {
 	return &Block{Data: data, Multihash: u.Hash(data)}
 }
 
+// NewBlockWithHashFunc creates a Block object from opaque data, hashing it
+// with the multihash function code.
+func NewBlockWithHashFunc(data []byte, code int) (*Block, error) {
+	h, err := mh.Sum(data, code, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{Data: data, Multihash: h}, nil
+}
+
 // NewBlockWithHash creates a new block when the hash of the data
 // is already known, this is used to save time in situations where
 // we are able to be confident that the data is correct
 func NewBlockWithHash(data []byte, h mh.Multihash) (*Block, error) {
 	if u.Debug {
-		chk := u.Hash(data)
-		if string(chk) != string(h) {
-			return nil, errors.New("Data did not match given hash!")
+		if err := VerifyHash(data, h); err != nil {
+			return nil, err
 		}
 	}
 	return &Block{Data: data, Multihash: h}, nil
 }
 
+// VerifyHash checks that data hashes to h, using the hash function named
+// by h.
+func VerifyHash(data []byte, h mh.Multihash) error {
+	dec, err := mh.Decode(h)
+	if err != nil {
+		return err
+	}
+	chk, err := mh.Sum(data, dec.Code, dec.Length)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(chk, h) {
+		return ErrWrongHash
+	}
+	return nil
+}
+
 // Key returns the block's Multihash as a Key value.
 func (b *Block) Key() key.Key {
 	return key.Key(b.Multihash)
@@ -1,6 +1,10 @@
 package blocks
 
-import "testing"
+import (
+	"testing"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+)
 
 func TestBlocksBasic(t *testing.T) {
 
@@ -14,3 +18,20 @@ func TestBlocksBasic(t *testing.T) {
 	// Test some data
 	NewBlock([]byte("Hello world!"))
 }
+
+func TestHashFunc(t *testing.T) {
+	data := []byte("Hello world!")
+	b, err := NewBlockWithHashFunc(data, mh.SHA3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyHash(data, b.Multihash); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyHash([]byte("Hello world?"), b.Multihash); err != ErrWrongHash {
+		t.Fatal("other data should not verify")
+	}
+	if _, err := NewBlockWithHashFunc(data, 0x99); err == nil {
+		t.Fatal("expected an error for an unknown hash function")
+	}
+}
@@ -0,0 +1,92 @@
+package blockstore
+
+import (
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/codahale/metrics"
+	lru "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/hashicorp/golang-lru"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+)
+
+// arccache remembers the keys recently found to be in the blockstore, so
+// that repeated Has calls for them don't hit the datastore.
+type arccache struct {
+	arc        *lru.ARCCache
+	blockstore Blockstore
+
+	hits  metrics.Counter
+	total metrics.Counter
+}
+
+func arcCached(bs Blockstore, size int, metricsPrefix string) (*arccache, error) {
+	arc, err := lru.NewARC(size)
+	if err != nil {
+		return nil, err
+	}
+	return &arccache{
+		arc:        arc,
+		blockstore: bs,
+		hits:       metrics.Counter(metricsPrefix + ".arc.hits"),
+		total:      metrics.Counter(metricsPrefix + ".arc.total"),
+	}, nil
+}
+
+func (b *arccache) DeleteBlock(k key.Key) error {
+	err := b.blockstore.DeleteBlock(k)
+	b.arc.Remove(k)
+	return err
+}
+
+func (b *arccache) Has(k key.Key) (bool, error) {
+	b.total.Add()
+	if _, ok := b.arc.Get(k); ok {
+		b.hits.Add()
+		return true, nil
+	}
+
+	has, err := b.blockstore.Has(k)
+	if err != nil {
+		return false, err
+	}
+	if has {
+		b.arc.Add(k, struct{}{})
+	}
+	return has, nil
+}
+
+func (b *arccache) Get(k key.Key) (*blocks.Block, error) {
+	bl, err := b.blockstore.Get(k)
+	switch err {
+	case nil:
+		b.arc.Add(k, struct{}{})
+	case ErrNotFound:
+		b.arc.Remove(k)
+	}
+	return bl, err
+}
+
+func (b *arccache) Put(bl *blocks.Block) error {
+	if err := b.blockstore.Put(bl); err != nil {
+		return err
+	}
+	b.arc.Add(bl.Key(), struct{}{})
+	return nil
+}
+
+func (b *arccache) PutMany(bs []*blocks.Block) error {
+	if err := b.blockstore.PutMany(bs); err != nil {
+		return err
+	}
+	for _, bl := range bs {
+		b.arc.Add(bl.Key(), struct{}{})
+	}
+	return nil
+}
+
+func (b *arccache) AllKeysChan(ctx context.Context) (<-chan key.Key, error) {
+	return b.blockstore.AllKeysChan(ctx)
+}
+
+func (b *arccache) HashOnRead(enabled bool) {
+	b.blockstore.HashOnRead(enabled)
+}
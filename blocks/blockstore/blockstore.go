@@ -4,6 +4,8 @@ package blockstore
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
@@ -24,6 +26,10 @@ var ValueTypeMismatch = errors.New("The retrieved value is not a Block")
 
 var ErrNotFound = errors.New("blockstore: block not found")
 
+// ErrHashMismatch is returned by Get when HashOnRead is enabled and the
+// stored data does not hash to the requested key.
+var ErrHashMismatch = errors.New("blockstore: block in storage has different hash than requested")
+
 // Blockstore wraps a ThreadSafeDatastore
 type Blockstore interface {
 	DeleteBlock(key.Key) error
@@ -33,6 +39,62 @@ type Blockstore interface {
 	PutMany([]*blocks.Block) error
 
 	AllKeysChan(ctx context.Context) (<-chan key.Key, error)
+
+	// HashOnRead makes Get check the data it reads against the key.
+	HashOnRead(enabled bool)
+}
+
+// GCLocker coordinates garbage collection with the operations writing
+// blocks they mean to pin.
+type GCLocker interface {
+	// GCLock locks the blockstore for garbage collection. No operation
+	// expected to finish with a pin can run until the returned function
+	// is called. Reading during GC is safe and needs no lock.
+	GCLock() func()
+
+	// PinLock locks the blockstore for a sequence of puts expected to
+	// finish with a pin. Any number of them can run at the same time, but
+	// not along with a GC. The returned function releases the lock.
+	//
+	// PinLock is not reentrant: a GC waiting for GCLock keeps new pin
+	// locks out, so taking it again while holding it can deadlock. Take
+	// it once, where the operation starts.
+	PinLock() func()
+}
+
+// GCBlockstore is a Blockstore that can be locked for garbage collection.
+type GCBlockstore interface {
+	Blockstore
+	GCLocker
+}
+
+// NewGCLocker returns a GCLocker backed by a RWMutex.
+func NewGCLocker() GCLocker {
+	return new(gclocker)
+}
+
+type gclocker struct {
+	lk sync.RWMutex
+}
+
+func (l *gclocker) GCLock() func() {
+	l.lk.Lock()
+	return l.lk.Unlock
+}
+
+func (l *gclocker) PinLock() func() {
+	l.lk.RLock()
+	return l.lk.RUnlock
+}
+
+// NewGCBlockstore makes bs lockable with gcl.
+func NewGCBlockstore(bs Blockstore, gcl GCLocker) GCBlockstore {
+	return gcBlockstore{bs, gcl}
+}
+
+type gcBlockstore struct {
+	Blockstore
+	GCLocker
 }
 
 func NewBlockstore(d ds.ThreadSafeDatastore) Blockstore {
@@ -46,6 +108,18 @@ type blockstore struct {
 	datastore ds.Batching
 	// cant be ThreadSafeDatastore cause namespace.Datastore doesnt support it.
 	// we do check it on `NewBlockstore` though.
+
+	// rehash is set by HashOnRead while Gets may be running, 1 when
+	// enabled
+	rehash int32
+}
+
+func (bs *blockstore) HashOnRead(enabled bool) {
+	var rehash int32
+	if enabled {
+		rehash = 1
+	}
+	atomic.StoreInt32(&bs.rehash, rehash)
 }
 
 func (bs *blockstore) Get(k key.Key) (*blocks.Block, error) {
@@ -61,6 +135,13 @@ func (bs *blockstore) Get(k key.Key) (*blocks.Block, error) {
 		return nil, ValueTypeMismatch
 	}
 
+	if atomic.LoadInt32(&bs.rehash) == 1 {
+		if err := blocks.VerifyHash(bdata, mh.Multihash(k)); err != nil {
+			log.Errorf("block %s in storage is corrupt: %s", k, err)
+			return nil, ErrHashMismatch
+		}
+	}
+
 	return blocks.NewBlockWithHash(bdata, mh.Multihash(k))
 }
 
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"testing"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
@@ -270,3 +271,76 @@ func (c *queryTestDS) Query(q dsq.Query) (dsq.Results, error) {
 func (c *queryTestDS) Batch() (ds.Batch, error) {
 	return ds.NewBasicBatch(c), nil
 }
+
+func TestHashOnRead(t *testing.T) {
+	d := ds_sync.MutexWrap(ds.NewMapDatastore())
+	bs := NewBlockstore(d)
+	block := blocks.NewBlock([]byte("some data"))
+	if err := bs.Put(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip the stored data behind the blockstore's back
+	if err := d.Put(BlockPrefix.Child(block.Key().DsKey()), []byte("other data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bs.Get(block.Key()); err != nil {
+		t.Fatal("without hash on read, corrupt data is returned as is")
+	}
+
+	bs.HashOnRead(true)
+	if _, err := bs.Get(block.Key()); err != ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+}
+
+func TestGCLockWaitsForPinLocks(t *testing.T) {
+	gcl := NewGCLocker()
+	unlock1 := gcl.PinLock()
+	unlock2 := gcl.PinLock() // pin locks are shared
+
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		gcl.GCLock()()
+	}()
+
+	select {
+	case <-gcDone:
+		t.Fatal("gc lock taken while pin locks are held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock1()
+	unlock2()
+	select {
+	case <-gcDone:
+	case <-time.After(time.Second):
+		t.Fatal("gc lock not taken after pin locks were released")
+	}
+}
+
+func TestPinLockWaitsForGCLock(t *testing.T) {
+	gcl := NewGCLocker()
+	unlock := gcl.GCLock()
+
+	pinDone := make(chan struct{})
+	go func() {
+		defer close(pinDone)
+		gcl.PinLock()()
+	}()
+
+	select {
+	case <-pinDone:
+		t.Fatal("pin lock taken during gc")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-pinDone:
+	case <-time.After(time.Second):
+		t.Fatal("pin lock not taken after gc was done")
+	}
+}
@@ -0,0 +1,130 @@
+package blockstore
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/codahale/metrics"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/blocks/bloom"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+)
+
+// bloomcache answers Has and Get for keys that are not in the blockstore
+// from a bloom filter of all the keys it holds. The filter is filled from
+// AllKeysChan in the background, and is only consulted once that is done.
+type bloomcache struct {
+	// bloom.Filter is not safe for concurrent use
+	lk     sync.Mutex
+	bloom  bloom.Filter
+	active int32 // set once the filter holds all keys, accessed atomically
+
+	blockstore Blockstore
+
+	hits  metrics.Counter
+	total metrics.Counter
+}
+
+func bloomCached(bs Blockstore, ctx context.Context, size int, metricsPrefix string) *bloomcache {
+	bc := &bloomcache{
+		bloom:      bloom.NewFilter(size),
+		blockstore: bs,
+		hits:       metrics.Counter(metricsPrefix + ".bloom.hits"),
+		total:      metrics.Counter(metricsPrefix + ".bloom.total"),
+	}
+	go func() {
+		if err := bc.build(ctx); err != nil {
+			log.Warningf("bloom filter for the blockstore was not built: %s", err)
+		}
+	}()
+	return bc
+}
+
+// build adds every key in the blockstore to the filter. Keys put while it
+// runs are added by Put, so nothing is missed.
+func (b *bloomcache) build(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys, err := b.blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		b.add(k)
+	}
+	// AllKeysChan stops early when ctx is done, the filter is incomplete then
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&b.active, 1)
+	return nil
+}
+
+func (b *bloomcache) add(k key.Key) {
+	b.lk.Lock()
+	b.bloom.Add([]byte(k))
+	b.lk.Unlock()
+}
+
+// definitelyMissing returns true if the filter is sure k is not stored.
+func (b *bloomcache) definitelyMissing(k key.Key) bool {
+	if atomic.LoadInt32(&b.active) == 0 {
+		return false
+	}
+	// Cleaning the datastore key can mangle a binary key, AllKeysChan then
+	// skips it and the filter never learns about it. Ask the blockstore.
+	if key.KeyFromDsKey(k.DsKey()) != k {
+		return false
+	}
+	b.total.Add()
+	b.lk.Lock()
+	found := b.bloom.Find([]byte(k))
+	b.lk.Unlock()
+	if !found {
+		b.hits.Add()
+	}
+	return !found
+}
+
+// DeleteBlock leaves the filter alone, bloom filters can't forget keys. The
+// deleted key only costs a datastore lookup on the next Has.
+func (b *bloomcache) DeleteBlock(k key.Key) error {
+	return b.blockstore.DeleteBlock(k)
+}
+
+func (b *bloomcache) Has(k key.Key) (bool, error) {
+	if b.definitelyMissing(k) {
+		return false, nil
+	}
+	return b.blockstore.Has(k)
+}
+
+func (b *bloomcache) Get(k key.Key) (*blocks.Block, error) {
+	if b.definitelyMissing(k) {
+		return nil, ErrNotFound
+	}
+	return b.blockstore.Get(k)
+}
+
+func (b *bloomcache) Put(bl *blocks.Block) error {
+	// add first, so a concurrent Has never misses a stored block
+	b.add(bl.Key())
+	return b.blockstore.Put(bl)
+}
+
+func (b *bloomcache) PutMany(bs []*blocks.Block) error {
+	for _, bl := range bs {
+		b.add(bl.Key())
+	}
+	return b.blockstore.PutMany(bs)
+}
+
+func (b *bloomcache) AllKeysChan(ctx context.Context) (<-chan key.Key, error) {
+	return b.blockstore.AllKeysChan(ctx)
+}
+
+func (b *bloomcache) HashOnRead(enabled bool) {
+	b.blockstore.HashOnRead(enabled)
+}
@@ -0,0 +1,50 @@
+package blockstore
+
+import (
+	"errors"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+)
+
+// CacheOpts configures the caches CachedBlockstore puts in front of a
+// Blockstore. A zero size disables the corresponding cache.
+type CacheOpts struct {
+	HasBloomFilterSize int // bytes in the bloom filter of stored keys
+	HasARCCacheSize    int // number of keys in the ARC of positive lookups
+
+	// MetricsPrefix is prepended to the names of the hit/miss counters.
+	MetricsPrefix string
+}
+
+// DefaultCacheOpts returns the cache settings used by a node.
+func DefaultCacheOpts() CacheOpts {
+	return CacheOpts{
+		HasBloomFilterSize: 768 << 10, // 6Mbit, ~1% false positives at 500k blocks with 3 hashes
+		HasARCCacheSize:    64 << 10,
+		MetricsPrefix:      "blockstore",
+	}
+}
+
+// CachedBlockstore wraps bs with a bloom filter answering lookups for keys
+// that are not stored, and an ARC cache of keys recently found to be
+// stored. The bloom filter is filled in the background; building it stops
+// when ctx is done. Hits and lookups are counted in the
+// <prefix>.bloom.{hits,total} and <prefix>.arc.{hits,total} metrics.
+func CachedBlockstore(bs Blockstore, ctx context.Context, opts CacheOpts) (Blockstore, error) {
+	if opts.HasBloomFilterSize < 0 || opts.HasARCCacheSize < 0 {
+		return nil, errors.New("blockstore cache sizes can't be negative")
+	}
+
+	cbs := bs
+	if opts.HasARCCacheSize > 0 {
+		arc, err := arcCached(cbs, opts.HasARCCacheSize, opts.MetricsPrefix)
+		if err != nil {
+			return nil, err
+		}
+		cbs = arc
+	}
+	if opts.HasBloomFilterSize > 0 {
+		cbs = bloomCached(cbs, ctx, opts.HasBloomFilterSize, opts.MetricsPrefix)
+	}
+	return cbs, nil
+}
@@ -0,0 +1,197 @@
+package blockstore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/codahale/metrics"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+)
+
+func testCachedBlockstore(t *testing.T, opts CacheOpts) (Blockstore, *callbackDatastore) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+	cbs, err := CachedBlockstore(bs, context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cbs, cd
+}
+
+func waitBloomActive(t *testing.T, bs Blockstore) {
+	bc := bs.(*bloomcache)
+	for i := 0; i < 100; i++ {
+		if atomic.LoadInt32(&bc.active) != 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("bloom filter was not built")
+}
+
+func TestCachedBlockstoreNegativeSize(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	_, err := CachedBlockstore(bs, context.Background(), CacheOpts{HasARCCacheSize: -1})
+	if err == nil {
+		t.Fatal("expected an error for a negative cache size")
+	}
+}
+
+func TestBloomFilterBuiltFromExistingKeys(t *testing.T) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+	b := blocks.NewBlock([]byte("existing"))
+	if err := bs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	cbs, err := CachedBlockstore(bs, context.Background(), CacheOpts{
+		HasBloomFilterSize: 1024,
+		MetricsPrefix:      "test.bloombuild",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitBloomActive(t, cbs)
+
+	has, err := cbs.Has(b.Key())
+	if err != nil || !has {
+		t.Fatal("block stored before the cache was built should be found")
+	}
+
+	cd.SetFunc(func() {
+		t.Fatal("lookup of a missing block hit the datastore")
+	})
+	has, err = cbs.Has(blocks.NewBlock([]byte("missing")).Key())
+	if err != nil || has {
+		t.Fatal("missing block should not be found")
+	}
+	if _, err := cbs.Get(blocks.NewBlock([]byte("missing")).Key()); err != ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+
+	counters, _ := metrics.Snapshot()
+	if counters["test.bloombuild.bloom.hits"] != 2 {
+		t.Fatal("expected two bloom hits, got", counters["test.bloombuild.bloom.hits"])
+	}
+}
+
+func TestBloomFilterMangledKey(t *testing.T) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+
+	// a multihash ending in '/' loses a byte to datastore key cleaning
+	var b *blocks.Block
+	for i := 0; ; i++ {
+		b = blocks.NewBlock([]byte(fmt.Sprint("mangled", i)))
+		if k := b.Key(); k[len(k)-1] == '/' {
+			break
+		}
+	}
+	if err := bs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	cbs, err := CachedBlockstore(bs, context.Background(), CacheOpts{
+		HasBloomFilterSize: 1024,
+		MetricsPrefix:      "test.bloommangled",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitBloomActive(t, cbs)
+
+	has, err := cbs.Has(b.Key())
+	if err != nil || !has {
+		t.Fatal("block with a mangled datastore key should be found")
+	}
+	if _, err := cbs.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArcCacheAnswersRepeatedHas(t *testing.T) {
+	cbs, cd := testCachedBlockstore(t, CacheOpts{
+		HasARCCacheSize: 10,
+		MetricsPrefix:   "test.archas",
+	})
+	b := blocks.NewBlock([]byte("foo"))
+	if err := cbs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	cd.SetFunc(func() {
+		t.Fatal("cached Has hit the datastore")
+	})
+	for i := 0; i < 3; i++ {
+		has, err := cbs.Has(b.Key())
+		if err != nil || !has {
+			t.Fatal("block should be found")
+		}
+	}
+
+	counters, _ := metrics.Snapshot()
+	if counters["test.archas.arc.hits"] != 3 || counters["test.archas.arc.total"] != 3 {
+		t.Fatal("unexpected arc metrics", counters["test.archas.arc.hits"], counters["test.archas.arc.total"])
+	}
+}
+
+func TestCacheInvalidatedOnDelete(t *testing.T) {
+	cbs, _ := testCachedBlockstore(t, CacheOpts{
+		HasBloomFilterSize: 1024,
+		HasARCCacheSize:    10,
+		MetricsPrefix:      "test.delete",
+	})
+	waitBloomActive(t, cbs)
+
+	b := blocks.NewBlock([]byte("foo"))
+	if err := cbs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := cbs.Has(b.Key()); !has {
+		t.Fatal("block should be found after Put")
+	}
+	if err := cbs.DeleteBlock(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := cbs.Has(b.Key()); has {
+		t.Fatal("block should not be found after DeleteBlock")
+	}
+	if _, err := cbs.Get(b.Key()); err != ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+}
+
+func TestCachedBlockstoreConcurrentPuts(t *testing.T) {
+	cbs, _ := testCachedBlockstore(t, CacheOpts{
+		HasBloomFilterSize: 1024,
+		HasARCCacheSize:    10,
+		MetricsPrefix:      "test.concurrent",
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				b := blocks.NewBlock([]byte(fmt.Sprintf("%d-%d", i, j)))
+				if err := cbs.Put(b); err != nil {
+					t.Error(err)
+					return
+				}
+				if has, err := cbs.Has(b.Key()); err != nil || !has {
+					t.Error("block should be found right after Put")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -58,3 +58,7 @@ func (w *writecache) PutMany(bs []*blocks.Block) error {
 func (w *writecache) AllKeysChan(ctx context.Context) (<-chan key.Key, error) {
 	return w.blockstore.AllKeysChan(ctx)
 }
+
+func (w *writecache) HashOnRead(enabled bool) {
+	w.blockstore.HashOnRead(enabled)
+}
@@ -72,17 +72,21 @@ func (s *BlockService) AddBlocks(bs []*blocks.Block) ([]key.Key, error) {
 // GetBlock retrieves a particular block from the service,
 // Getting it from the datastore using the key (hash).
 func (s *BlockService) GetBlock(ctx context.Context, k key.Key) (*blocks.Block, error) {
+	return getBlock(ctx, k, s.Blockstore, s.Exchange)
+}
+
+func getBlock(ctx context.Context, k key.Key, bs blockstore.Blockstore, f exchange.Fetcher) (*blocks.Block, error) {
 	log.Debugf("BlockService GetBlock: '%s'", k)
-	block, err := s.Blockstore.Get(k)
+	block, err := bs.Get(k)
 	if err == nil {
 		return block, nil
 	}
 
-	if err == blockstore.ErrNotFound && s.Exchange != nil {
+	if err == blockstore.ErrNotFound && f != nil {
 		// TODO be careful checking ErrNotFound. If the underlying
 		// implementation changes, this will break.
 		log.Debug("Blockservice: Searching bitswap.")
-		blk, err := s.Exchange.GetBlock(ctx, k)
+		blk, err := f.GetBlock(ctx, k)
 		if err != nil {
 			if err == blockstore.ErrNotFound {
 				return nil, ErrNotFound
@@ -104,12 +108,16 @@ func (s *BlockService) GetBlock(ctx context.Context, k key.Key) (*blocks.Block,
 // the returned channel.
 // NB: No guarantees are made about order.
 func (s *BlockService) GetBlocks(ctx context.Context, ks []key.Key) <-chan *blocks.Block {
+	return getBlocks(ctx, ks, s.Blockstore, s.Exchange)
+}
+
+func getBlocks(ctx context.Context, ks []key.Key, bs blockstore.Blockstore, f exchange.Fetcher) <-chan *blocks.Block {
 	out := make(chan *blocks.Block, 0)
 	go func() {
 		defer close(out)
 		var misses []key.Key
 		for _, k := range ks {
-			hit, err := s.Blockstore.Get(k)
+			hit, err := bs.Get(k)
 			if err != nil {
 				misses = append(misses, k)
 				continue
@@ -122,7 +130,11 @@ func (s *BlockService) GetBlocks(ctx context.Context, ks []key.Key) <-chan *bloc
 			}
 		}
 
-		rblocks, err := s.Exchange.GetBlocks(ctx, misses)
+		if len(misses) == 0 {
+			return
+		}
+
+		rblocks, err := f.GetBlocks(ctx, misses)
 		if err != nil {
 			log.Debugf("Error with GetBlocks: %s", err)
 			return
@@ -148,3 +160,33 @@ func (s *BlockService) Close() error {
 	log.Debug("blockservice is shutting down...")
 	return s.Exchange.Close()
 }
+
+// Session fetches the blocks of one DAG, or of a few related ones. When the
+// exchange supports sessions, its wants go to the peers that sent it blocks
+// rather than to every peer.
+type Session struct {
+	bs blockstore.Blockstore
+	f  exchange.Fetcher
+}
+
+// NewSession returns a session of s that lasts until ctx is done.
+func NewSession(ctx context.Context, s *BlockService) *Session {
+	var f exchange.Fetcher = s.Exchange
+	if sx, ok := s.Exchange.(exchange.SessionExchange); ok {
+		f = sx.NewSession(ctx)
+	}
+	return &Session{
+		bs: s.Blockstore,
+		f:  f,
+	}
+}
+
+// GetBlock gets a block through the session, as BlockService.GetBlock does.
+func (s *Session) GetBlock(ctx context.Context, k key.Key) (*blocks.Block, error) {
+	return getBlock(ctx, k, s.bs, s.f)
+}
+
+// GetBlocks gets blocks through the session, as BlockService.GetBlocks does.
+func (s *Session) GetBlocks(ctx context.Context, ks []key.Key) <-chan *blocks.Block {
+	return getBlocks(ctx, ks, s.bs, s.f)
+}
@@ -91,3 +91,38 @@ func TestGetBlocksSequential(t *testing.T) {
 		}
 	}
 }
+
+func TestSessionGetBlocks(t *testing.T) {
+	var servs = Mocks(3)
+	for _, s := range servs {
+		defer s.Close()
+	}
+	bg := blocksutil.NewBlockGenerator()
+	blks := bg.Blocks(20)
+
+	var keys []key.Key
+	for _, blk := range blks {
+		keys = append(keys, blk.Key())
+		servs[0].AddBlock(blk)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+	ses := NewSession(ctx, servs[1])
+
+	// the first block is fetched alone, the session then knows a peer
+	if _, err := ses.GetBlock(ctx, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	gotten := make(map[key.Key]*blocks.Block)
+	for blk := range ses.GetBlocks(ctx, keys) {
+		if _, ok := gotten[blk.Key()]; ok {
+			t.Fatal("Got duplicate block!")
+		}
+		gotten[blk.Key()] = blk
+	}
+	if len(gotten) != len(blks) {
+		t.Fatalf("Didnt get enough blocks back: %d/%d", len(gotten), len(blks))
+	}
+}
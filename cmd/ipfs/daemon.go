@@ -19,10 +19,12 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	commands "github.com/ipfs/go-ipfs/core/commands"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	"github.com/ipfs/go-ipfs/core/corerouting"
 	conn "github.com/ipfs/go-ipfs/p2p/net/conn"
 	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 	util "github.com/ipfs/go-ipfs/util"
 )
 
@@ -36,6 +38,9 @@ const (
 	ipnsMountKwd              = "mount-ipns"
 	unrestrictedApiAccessKwd  = "unrestricted-api"
 	unencryptTransportKwd     = "disable-transport-encryption"
+	enableGCKwd               = "enable-gc"
+	migrateKwd                = "migrate"
+	migrateDryRunKwd          = "migrate-dry-run"
 	// apiAddrKwd    = "address-api"
 	// swarmAddrKwd  = "address-swarm"
 )
@@ -93,6 +98,15 @@ You can setup CORS headers the same way:
 	ipfs config --json API.HTTPHeaders.Access-Control-Allow-Methods '["PUT", "GET", "POST"]'
 	ipfs config --json API.HTTPHeaders.Access-Control-Allow-Credentials '["true"]'
 
+Repo Migrations
+
+When the repo was created by an older version of ipfs, the daemon can
+upgrade it in place. It asks before doing so when run from a terminal;
+pass --migrate to upgrade without asking, or --migrate=false to refuse.
+The version file and config are backed up under migration-backups/ in the
+repo, and restored if a migration fails. --migrate-dry-run lists the
+migrations that would run without changing anything.
+
 
 DEPRECATION NOTICE
 
@@ -114,6 +128,9 @@ future version, along with this notice. Please move to setting the HTTP Headers.
 		cmds.StringOption(ipnsMountKwd, "Path to the mountpoint for IPNS (if using --mount)"),
 		cmds.BoolOption(unrestrictedApiAccessKwd, "Allow API access to unlisted hashes"),
 		cmds.BoolOption(unencryptTransportKwd, "Disable transport encryption (for debugging protocols)"),
+		cmds.BoolOption(enableGCKwd, "Enable automatic periodic repo garbage collection"),
+		cmds.BoolOption(migrateKwd, "If true, assume yes at the migrate prompt. If false, assume no"),
+		cmds.BoolOption(migrateDryRunKwd, "Print the repo migrations that would run, then exit"),
 
 		// TODO: add way to override addresses. tricky part: updating the config if also --init.
 		// cmds.StringOption(apiAddrKwd, "Address for the daemon rpc API (overrides config)"),
@@ -181,6 +198,17 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 	// acquire the repo lock _before_ constructing a node. we need to make
 	// sure we are permitted to access the resources (datastore, etc.)
 	repo, err := fsrepo.Open(req.InvocContext().ConfigRoot)
+	if merr, ok := err.(fsrepo.NeedMigrationError); ok {
+		var migrated bool
+		migrated, err = maybeMigrate(req, merr)
+		if err == nil && !migrated {
+			// dry run, nothing left to do
+			return
+		}
+		if err == nil {
+			repo, err = fsrepo.Open(req.InvocContext().ConfigRoot)
+		}
+	}
 	if err != nil {
 		res.SetError(err, cmds.ErrNormal)
 		return
@@ -277,10 +305,17 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		}
 	}
 
+	// repo blockstore GC - if --enable-gc flag is present
+	gcErrc, err := maybeRunGC(req, node)
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
 	fmt.Printf("Daemon is ready\n")
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesnt follow this pattern for graceful shutdown
-	for err := range merge(apiErrc, gwErrc) {
+	for err := range merge(apiErrc, gwErrc, gcErrc) {
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -478,6 +513,87 @@ func mountFuse(req cmds.Request) error {
 	return nil
 }
 
+// maybeRunGC starts the periodic repo garbage collection if the user
+// provided the --enable-gc flag.
+func maybeRunGC(req cmds.Request, node *core.IpfsNode) (<-chan error, error) {
+	enableGC, _, err := req.Option(enableGCKwd).Bool()
+	if err != nil {
+		return nil, err
+	}
+	if !enableGC {
+		return nil, nil
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- corerepo.PeriodicGC(req.Context(), node)
+		close(errc)
+	}()
+	return errc, nil
+}
+
+// maybeMigrate runs the repo migrations if the user asked for them with
+// --migrate or agrees to at the prompt. It returns false if it only did a
+// dry run.
+func maybeMigrate(req cmds.Request, merr fsrepo.NeedMigrationError) (bool, error) {
+	root := req.InvocContext().ConfigRoot
+
+	dryRun, _, err := req.Option(migrateDryRunKwd).Bool()
+	if err != nil {
+		return false, err
+	}
+	if dryRun {
+		fmt.Printf("Repo is at version %s, version %s is needed.\n", merr.Have, merr.Want)
+		return false, fsrepo.Migrate(root, mfsr.Options{DryRun: true, Out: os.Stdout})
+	}
+
+	migrate, found, err := req.Option(migrateKwd).Bool()
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		interactive, err := isTerminal(os.Stdin)
+		if err != nil {
+			return false, err
+		}
+		if interactive {
+			fmt.Printf("Repo is at version %s, version %s is needed.\n", merr.Have, merr.Want)
+			migrate = yesNoPrompt("Run the repo migrations now? [y/N]")
+		}
+	}
+	if !migrate {
+		return false, merr
+	}
+
+	fmt.Println("Migrating repo...")
+	if err := fsrepo.Migrate(root, mfsr.Options{Out: os.Stdout}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isTerminal returns true if f is attached to a terminal.
+func isTerminal(f *os.File) (bool, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return (stat.Mode() & os.ModeCharDevice) != 0, nil
+}
+
+// yesNoPrompt asks the user a question on the terminal, anything but a yes
+// is taken as a no.
+func yesNoPrompt(prompt string) bool {
+	var s string
+	fmt.Println(prompt)
+	fmt.Scanln(&s)
+	switch strings.ToLower(s) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
@@ -250,8 +250,9 @@ func parseArgs(inputs []string, stdin *os.File, argDefs []cmds.Argument, recursi
 	for i := 0; i < numInputs; i++ {
 		argDef := getArgDef(argDefIndex, argDefs)
 
-		// skip optional argument definitions if there aren't sufficient remaining inputs
-		for numInputs-i <= numRequired && !argDef.Required {
+		// skip optional argument definitions if there aren't sufficient remaining inputs,
+		// or if only stdin is left and they can't take it
+		for (numInputs-i <= numRequired || len(inputs) == 0 && !argDef.SupportsStdin) && !argDef.Required {
 			argDefIndex++
 			argDef = getArgDef(argDefIndex, argDefs)
 		}
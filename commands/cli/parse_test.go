@@ -178,6 +178,13 @@ func TestArgumentParsing(t *testing.T) {
 					commands.StringArg("b", true, false, "another arg").EnableStdin(),
 				},
 			},
+			"optionalstdin": &commands.Command{
+				Arguments: []commands.Argument{
+					commands.StringArg("a", true, false, "some arg"),
+					commands.StringArg("b", false, false, "another arg"),
+					commands.StringArg("c", false, true, "a third arg").EnableStdin(),
+				},
+			},
 		},
 	}
 
@@ -286,4 +293,8 @@ func TestArgumentParsing(t *testing.T) {
 
 	fstdin = fileToSimulateStdin(t, "stdin1")
 	test([]string{"optionalsecond", "value1", "value2"}, fstdin, []string{"value1", "value2"})
+
+	fstdin = fileToSimulateStdin(t, "stdin1")
+	test([]string{"optionalstdin", "value1"}, fstdin, []string{"value1", "stdin1"})
+	test([]string{"optionalstdin", "value1", "value2"}, fstdin, []string{"value1", "value2", "stdin1"})
 }
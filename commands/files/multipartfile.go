@@ -6,6 +6,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"time"
 )
 
 const (
@@ -15,6 +18,9 @@ const (
 	applicationSymlink = "application/symlink"
 
 	contentTypeHeader = "Content-Type"
+	abspathHeader     = "Abspath"
+	modeHeader        = "Mode"
+	mtimeHeader       = "Mtime"
 )
 
 // MultipartFile implements File, and is created from a `multipart.Part`.
@@ -94,7 +100,16 @@ func (f *MultipartFile) FileName() string {
 	return filename
 }
 
+// FullPath returns the path of the file on the client, if it sent one, and
+// its name otherwise.
 func (f *MultipartFile) FullPath() string {
+	if f != nil && f.Part != nil {
+		if abs := f.Part.Header.Get(abspathHeader); abs != "" {
+			if p, err := url.QueryUnescape(abs); err == nil {
+				return p
+			}
+		}
+	}
 	return f.FileName()
 }
 
@@ -111,3 +126,39 @@ func (f *MultipartFile) Close() error {
 	}
 	return f.Part.Close()
 }
+
+// Stat returns the mode and modification time the client sent for the
+// file, or nil if it sent neither.
+func (f *MultipartFile) Stat() os.FileInfo {
+	if f == nil || f.Part == nil {
+		return nil
+	}
+	fi := &partFileInfo{name: f.FileName()}
+	m := f.Part.Header.Get(modeHeader)
+	t := f.Part.Header.Get(mtimeHeader)
+	if m == "" && t == "" {
+		return nil
+	}
+	if mode, err := strconv.ParseUint(m, 8, 32); err == nil {
+		fi.mode = os.FileMode(mode)
+	}
+	if secs, err := strconv.ParseInt(t, 10, 64); err == nil {
+		fi.mtime = time.Unix(secs, 0)
+	}
+	return fi
+}
+
+// partFileInfo is the os.FileInfo of a file sent over multipart. It only
+// knows what the client sent in the part headers.
+type partFileInfo struct {
+	name  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (fi *partFileInfo) Name() string       { return fi.name }
+func (fi *partFileInfo) Size() int64        { return 0 }
+func (fi *partFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *partFileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *partFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *partFileInfo) Sys() interface{}   { return nil }
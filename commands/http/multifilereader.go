@@ -7,6 +7,8 @@ import (
 	"mime/multipart"
 	"net/textproto"
 	"net/url"
+	"path/filepath"
+	"strconv"
 	"sync"
 
 	files "github.com/ipfs/go-ipfs/commands/files"
@@ -93,6 +95,18 @@ func (mfr *MultiFileReader) Read(buf []byte) (written int, err error) {
 			}
 
 			header.Set("Content-Type", contentType)
+			if fpath := file.FullPath(); fpath != "" && contentType == "application/octet-stream" {
+				// lets the daemon reference the file, see 'ipfs add --nocopy'
+				if abs, err := filepath.Abs(fpath); err == nil {
+					header.Set("Abspath", url.QueryEscape(abs))
+				}
+			}
+
+			if sf, ok := file.(files.StatFile); ok && sf.Stat() != nil {
+				// lets the daemon record them, see 'ipfs add --preserve-mode'
+				header.Set("Mode", strconv.FormatUint(uint64(sf.Stat().Mode()), 8))
+				header.Set("Mtime", strconv.FormatInt(sf.Stat().ModTime().Unix(), 10))
+			}
 
 			_, err := mfr.mpWriter.CreatePart(header)
 			if err != nil {
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dsync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
@@ -13,6 +14,8 @@ import (
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	bserv "github.com/ipfs/go-ipfs/blockservice"
 	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	filestore "github.com/ipfs/go-ipfs/filestore"
+	ipnsfs "github.com/ipfs/go-ipfs/ipnsfs"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	ci "github.com/ipfs/go-ipfs/p2p/crypto"
 	peer "github.com/ipfs/go-ipfs/p2p/peer"
@@ -20,6 +23,7 @@ import (
 	pin "github.com/ipfs/go-ipfs/pin"
 	repo "github.com/ipfs/go-ipfs/repo"
 	cfg "github.com/ipfs/go-ipfs/repo/config"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 )
 
 type BuildCfg struct {
@@ -128,17 +132,28 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 		return err
 	}
 
-	var err error
-	n.Blockstore, err = bstore.WriteCached(bstore.NewBlockstore(n.Repo.Datastore()), kSizeBlockstoreWriteCache)
+	rcfg, err := n.Repo.Config()
 	if err != nil {
 		return err
 	}
 
+	bs := bstore.NewBlockstore(n.Repo.Datastore())
+	bs.HashOnRead(rcfg.Datastore.HashOnRead)
+	cbs, err := bstore.CachedBlockstore(bs, ctx, bstore.DefaultCacheOpts())
+	if err != nil {
+		return err
+	}
+	wbs, err := bstore.WriteCached(cbs, kSizeBlockstoreWriteCache)
+	if err != nil {
+		return err
+	}
+	n.Filestore, err = filestore.New(wbs, n.Repo.Datastore())
+	if err != nil {
+		return err
+	}
+	n.Blockstore = bstore.NewGCBlockstore(n.Filestore, bstore.NewGCLocker())
+
 	if cfg.Online {
-		rcfg, err := n.Repo.Config()
-		if err != nil {
-			return err
-		}
 		do := setupDiscoveryOption(rcfg.Discovery)
 		if err := n.startOnlineServices(ctx, cfg.Routing, cfg.Host, do); err != nil {
 			return err
@@ -159,5 +174,52 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 	}
 	n.Resolver = &path.Resolver{DAG: n.DAG}
 
-	return nil
+	// the reprovider strategies go through the pins
+	if cfg.Online {
+		if err := n.setupReprovider(ctx); err != nil {
+			return err
+		}
+	}
+
+	return setupFilesRoot(ctx, n)
+}
+
+// filesRootKey is the datastore key of the root of the 'ipfs files' tree.
+var filesRootKey = ds.NewKey("/local/filesroot")
+
+// setupFilesRoot loads the root of the 'ipfs files' tree, starting with an
+// empty directory if there is none yet.
+func setupFilesRoot(ctx context.Context, n *IpfsNode) error {
+	dstore := n.Repo.Datastore()
+	persist := func(ctx context.Context, k key.Key) error {
+		return dstore.Put(filesRootKey, []byte(k))
+	}
+
+	var nd *dag.Node
+	val, err := dstore.Get(filesRootKey)
+	switch {
+	case err == ds.ErrNotFound || val == nil:
+		nd = &dag.Node{Data: ft.FolderPBData()}
+		k, err := n.DAG.Add(nd)
+		if err != nil {
+			return err
+		}
+		if err := persist(ctx, k); err != nil {
+			return err
+		}
+	case err == nil:
+		b, ok := val.([]byte)
+		if !ok {
+			return errors.New("invalid files root key in datastore")
+		}
+		nd, err = n.DAG.Get(ctx, key.Key(b))
+		if err != nil {
+			return fmt.Errorf("cannot load the files root: %s", err)
+		}
+	default:
+		return err
+	}
+
+	n.FilesRoot, err = ipnsfs.NewLocalRoot(ctx, n.DAG, n.Pinning, n.Blockstore, nd, persist)
+	return err
 }
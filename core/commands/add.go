@@ -6,22 +6,22 @@ import (
 	"path"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cheggaaa/pb"
-	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
 	cxt "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
-	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
-	bserv "github.com/ipfs/go-ipfs/blockservice"
 	cmds "github.com/ipfs/go-ipfs/commands"
 	files "github.com/ipfs/go-ipfs/commands/files"
 	core "github.com/ipfs/go-ipfs/core"
-	offline "github.com/ipfs/go-ipfs/exchange/offline"
-	importer "github.com/ipfs/go-ipfs/importer"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+	bal "github.com/ipfs/go-ipfs/importer/balanced"
 	"github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	trickle "github.com/ipfs/go-ipfs/importer/trickle"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	dagutils "github.com/ipfs/go-ipfs/merkledag/utils"
 	pin "github.com/ipfs/go-ipfs/pin"
 	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -31,14 +31,21 @@ var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
 // how many bytes of progress to wait before sending a progress update message
 const progressReaderIncrement = 1024 * 256
 
+// how many bytes to read between two checks of the repo storage quota
+const storageCheckIncrement = 1024 * 1024
+
 const (
-	quietOptionName    = "quiet"
-	progressOptionName = "progress"
-	trickleOptionName  = "trickle"
-	wrapOptionName     = "wrap-with-directory"
-	hiddenOptionName   = "hidden"
-	onlyHashOptionName = "only-hash"
-	chunkerOptionName  = "chunker"
+	quietOptionName     = "quiet"
+	progressOptionName  = "progress"
+	trickleOptionName   = "trickle"
+	wrapOptionName      = "wrap-with-directory"
+	hiddenOptionName    = "hidden"
+	onlyHashOptionName  = "only-hash"
+	chunkerOptionName   = "chunker"
+	nocopyOptionName    = "nocopy"
+	modeOptionName      = "preserve-mode"
+	mtimeOptionName     = "preserve-mtime"
+	rawLeavesOptionName = "raw-leaves"
 )
 
 type AddedObject struct {
@@ -55,6 +62,22 @@ Adds contents of <path> to ipfs. Use -r to add directories.
 Note that directories are added recursively, to form the ipfs
 MerkleDAG. A smarter partial add with a staging area (like git)
 remains to be implemented.
+
+With --nocopy, the data of the files is not copied into the repo: the
+blockstore only records where in the files it can be found. The files
+must be readable by the daemon, and must stay in place unmodified for
+their contents to remain available. 'ipfs filestore verify' reports the
+files that have changed or disappeared since.
+
+With --preserve-mode and --preserve-mtime, the permission bits and the
+modification times of the files and directories are recorded along with
+them, and restored by 'ipfs get'. Recording them changes the hashes.
+
+With --raw-leaves, the leaves of the files are stored as raw blocks, which
+hold just the data without any framing. This changes the hashes too.
+
+The objects are hashed with sha2-256, unless another function is given
+with --hash.
 `,
 	},
 
@@ -70,6 +93,11 @@ remains to be implemented.
 		cmds.BoolOption(wrapOptionName, "w", "Wrap files with a directory object"),
 		cmds.BoolOption(hiddenOptionName, "H", "Include files that are hidden"),
 		cmds.StringOption(chunkerOptionName, "s", "chunking algorithm to use"),
+		cmds.BoolOption(nocopyOptionName, "Reference the files instead of copying their data into the repo"),
+		cmds.BoolOption(modeOptionName, "Record the permission bits of the files"),
+		cmds.BoolOption(mtimeOptionName, "Record the modification time of the files"),
+		cmds.BoolOption(rawLeavesOptionName, "Store the leaves of the files as raw blocks"),
+		cmds.StringOption(hashOptionName, "Hash function to use: sha2-256 (default), sha2-512, sha3 or sha1"),
 	},
 	PreRun: func(req cmds.Request) error {
 		if quiet, _, _ := req.Option(quietOptionName).Bool(); quiet {
@@ -107,8 +135,38 @@ remains to be implemented.
 		hash, _, _ := req.Option(onlyHashOptionName).Bool()
 		hidden, _, _ := req.Option(hiddenOptionName).Bool()
 		chunker, _, _ := req.Option(chunkerOptionName).String()
+		nocopy, _, _ := req.Option(nocopyOptionName).Bool()
+		preserveMode, _, _ := req.Option(modeOptionName).Bool()
+		preserveMtime, _, _ := req.Option(mtimeOptionName).Bool()
+		rawLeaves, _, _ := req.Option(rawLeavesOptionName).Bool()
+		hashFunc, err := hashFuncOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		var gc *corerepo.GC
+		if !hash && !nocopy {
+			gc, err = corerepo.NewGC(n)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			// the size is only known when the client could stat the
+			// files, otherwise just check we are not full already.
+			var size uint64
+			if s, ok := req.Values()["size"].(int64); ok {
+				size = uint64(s)
+			}
+			if err := gc.CheckStorage(size); err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
 
-		e := dagutils.NewDagEditor(NewMemoryDagService(), newDirNode())
+		root := newDirNode()
+		root.SetHashFunc(hashFunc)
+		e := dagutils.NewDagEditor(dagutils.NewMemoryDagService(), root)
 		if hash {
 			nilnode, err := core.NewNode(n.Context(), &core.BuildCfg{
 				//TODO: need this to be true or all files
@@ -126,15 +184,23 @@ remains to be implemented.
 		res.SetOutput((<-chan interface{})(outChan))
 
 		fileAdder := adder{
-			ctx:      req.Context(),
-			node:     n,
-			editor:   e,
-			out:      outChan,
-			chunker:  chunker,
-			progress: progress,
-			hidden:   hidden,
-			trickle:  trickle,
-			wrap:     wrap,
+			ctx:       req.Context(),
+			node:      n,
+			editor:    e,
+			out:       outChan,
+			chunker:   chunker,
+			progress:  progress,
+			hidden:    hidden,
+			trickle:   trickle,
+			wrap:      wrap,
+			nocopy:    nocopy && !hash,
+			rawLeaves: rawLeaves,
+			hashFunc:  hashFunc,
+
+			gc: gc,
+
+			preserveMode:  preserveMode,
+			preserveMtime: preserveMtime,
 		}
 
 		// addAllFiles loops over a convenience slice file to
@@ -161,9 +227,7 @@ remains to be implemented.
 				return err
 			}
 
-			mp := n.Pinning.GetManual()
-			mp.RemovePinWithMode(rnk, pin.Indirect)
-			mp.PinWithMode(rnk, pin.Recursive)
+			n.Pinning.GetManual().PinWithMode(rnk, pin.Recursive)
 			return n.Pinning.Flush()
 		}
 
@@ -191,6 +255,8 @@ remains to be implemented.
 
 		go func() {
 			defer close(outChan)
+			// the added blocks are only safe from GC once pinned
+			defer n.Blockstore.PinLock()()
 			if err := addAllAndPin(req.Files()); err != nil {
 				res.SetError(err, cmds.ErrNormal)
 				return
@@ -284,48 +350,51 @@ remains to be implemented.
 	Type: AddedObject{},
 }
 
-func NewMemoryDagService() dag.DAGService {
-	// build mem-datastore for editor's intermediary nodes
-	bs := bstore.NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
-	bsrv := bserv.New(bs, offline.Exchange(bs))
-	return dag.NewDAGService(bsrv)
-}
-
 // Internal structure for holding the switches passed to the `add` call
 type adder struct {
-	ctx      cxt.Context
-	node     *core.IpfsNode
-	editor   *dagutils.Editor
-	out      chan interface{}
-	progress bool
-	hidden   bool
-	trickle  bool
-	wrap     bool
-	chunker  string
+	ctx       cxt.Context
+	node      *core.IpfsNode
+	editor    *dagutils.Editor
+	out       chan interface{}
+	progress  bool
+	hidden    bool
+	trickle   bool
+	wrap      bool
+	nocopy    bool
+	rawLeaves bool
+	hashFunc  int
+	chunker   string
+	// gc stops the add when the repo goes over its quota, nil not to
+	gc *corerepo.GC
+
+	preserveMode  bool
+	preserveMtime bool
 
 	nextUntitled int
 }
 
 // Perform the actual add & pin locally, outputting results to reader
-func add(n *core.IpfsNode, reader io.Reader, useTrickle bool, chunker string) (*dag.Node, error) {
+func add(n *core.IpfsNode, reader io.Reader, useTrickle bool, chunker string, refs h.LeafRefs, rawLeaves bool, hashFunc int, info func([]byte) ([]byte, error)) (*dag.Node, error) {
 	chnk, err := chunk.FromString(reader, chunker)
 	if err != nil {
 		return nil, err
 	}
 
+	dbp := h.DagBuilderParams{
+		Dagserv:   n.DAG,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		LeafRefs:  refs,
+		RawLeaves: rawLeaves,
+		HashFunc:  hashFunc,
+		RootData:  info,
+	}
+	db := dbp.New(chunk.Chan(chnk))
+
 	var node *dag.Node
 	if useTrickle {
-		node, err = importer.BuildTrickleDagFromReader(
-			n.DAG,
-			chnk,
-			importer.PinIndirectCB(n.Pinning.GetManual()),
-		)
+		node, err = trickle.TrickleLayout(db)
 	} else {
-		node, err = importer.BuildDagFromReader(
-			n.DAG,
-			chnk,
-			importer.PinIndirectCB(n.Pinning.GetManual()),
-		)
+		node, err = bal.BalancedLayout(db)
 	}
 
 	if err != nil {
@@ -389,6 +458,7 @@ func (params *adder) addFile(file files.File) (*dag.Node, error) {
 		}
 
 		dagnode := &dag.Node{Data: sdata}
+		dagnode.SetHashFunc(params.hashFunc)
 		_, err = params.node.DAG.Add(dagnode)
 		if err != nil {
 			return nil, err
@@ -404,11 +474,35 @@ func (params *adder) addFile(file files.File) (*dag.Node, error) {
 	if params.progress {
 		reader = &progressReader{file: file, out: params.out}
 	}
+	var quota *quotaReader
+	if params.gc != nil && params.gc.StorageMax != 0 {
+		var err error
+		quota, err = newQuotaReader(reader, params.gc)
+		if err != nil {
+			return nil, err
+		}
+		reader = quota
+	}
 
-	dagnode, err := add(params.node, reader, params.trickle, params.chunker)
+	var refs h.LeafRefs
+	if params.nocopy {
+		var err error
+		refs, err = coreunix.FileRefs(params.node, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info := coreunix.FileInfo(file, params.preserveMode, params.preserveMtime)
+	dagnode, err := add(params.node, reader, params.trickle, params.chunker, refs, params.rawLeaves, params.hashFunc, info)
 	if err != nil {
 		return nil, err
 	}
+	// the chunkers end the file at a read error, the add has to be failed
+	// here
+	if quota != nil && quota.err != nil {
+		return nil, quota.err
+	}
 
 	// patch it into the root
 	log.Infof("adding file: %s", file.FileName())
@@ -417,7 +511,9 @@ func (params *adder) addFile(file files.File) (*dag.Node, error) {
 }
 
 func (params *adder) addDir(file files.File) (*dag.Node, error) {
-	tree := &dag.Node{Data: ft.FolderPBData()}
+	// large directories are sharded
+	dir := uio.NewDirectory(params.node.DAG)
+	dir.SetHashFunc(params.hashFunc)
 	log.Infof("adding directory: %s", file.FileName())
 
 	for {
@@ -440,23 +536,29 @@ func (params *adder) addDir(file files.File) (*dag.Node, error) {
 		if node != nil {
 			_, name := path.Split(file.FileName())
 
-			err = tree.AddNodeLink(name, node)
+			err = dir.AddChildNode(params.ctx, name, node)
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	if err := params.addNode(tree, file.FileName()); err != nil {
+	tree, err := dir.GetNode()
+	if err != nil {
 		return nil, err
 	}
-
-	k, err := params.node.DAG.Add(tree)
+	tree, err = coreunix.SetFileInfo(tree, file, params.preserveMode, params.preserveMtime)
 	if err != nil {
 		return nil, err
 	}
 
-	params.node.Pinning.GetManual().PinWithMode(k, pin.Indirect)
+	if err := params.addNode(tree, file.FileName()); err != nil {
+		return nil, err
+	}
+
+	if _, err := params.node.DAG.Add(tree); err != nil {
+		return nil, err
+	}
 
 	return tree, nil
 }
@@ -514,6 +616,58 @@ func (i *progressReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// quotaReader fails reading once the repo is over its storage quota, so a
+// large add or dag import can not go far past it.
+type quotaReader struct {
+	r  io.Reader
+	gc *corerepo.GC
+	// base is the repo usage before the file was read
+	base      uint64
+	read      uint64
+	unchecked int64
+	err       error
+}
+
+func newQuotaReader(r io.Reader, gc *corerepo.GC) (*quotaReader, error) {
+	base, err := gc.Repo.GetStorageUsage()
+	if err != nil {
+		return nil, err
+	}
+	return &quotaReader{r: r, gc: gc, base: base}, nil
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	n, err := q.r.Read(p)
+
+	q.read += uint64(n)
+	q.unchecked += int64(n)
+	if q.unchecked >= storageCheckIncrement {
+		q.unchecked = 0
+		if q.err = q.check(); q.err != nil {
+			return n, q.err
+		}
+	}
+
+	return n, err
+}
+
+func (q *quotaReader) check() error {
+	used, err := q.gc.Repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+	// the blocks are written in batches, count what was read even if it
+	// is not in the repo yet
+	var pending uint64
+	if q.base+q.read > used {
+		pending = q.base + q.read - used
+	}
+	return q.gc.CheckStorage(pending)
+}
+
 // TODO: generalize this to more than unix-fs nodes.
 func newDirNode() *dag.Node {
 	return &dag.Node{Data: ft.FolderPBData()}
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"time"
 
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
 	bitswap "github.com/ipfs/go-ipfs/exchange/bitswap"
+	decision "github.com/ipfs/go-ipfs/exchange/bitswap/decision"
+	reprovide "github.com/ipfs/go-ipfs/exchange/reprovide"
 	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	config "github.com/ipfs/go-ipfs/repo/config"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -18,9 +24,12 @@ var BitswapCmd = &cmds.Command{
 		ShortDescription: ``,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"wantlist": showWantlistCmd,
-		"stat":     bitswapStatCmd,
-		"unwant":   unwantCmd,
+		"wantlist":  showWantlistCmd,
+		"stat":      bitswapStatCmd,
+		"unwant":    unwantCmd,
+		"ledger":    ledgerCmd,
+		"config":    bitswapConfigCmd,
+		"reprovide": reprovideCmd,
 	},
 }
 
@@ -157,6 +166,12 @@ var bitswapStatCmd = &cmds.Command{
 			fmt.Fprintf(buf, "\tblocks received: %d\n", out.BlocksReceived)
 			fmt.Fprintf(buf, "\tdup blocks received: %d\n", out.DupBlksReceived)
 			fmt.Fprintf(buf, "\tdup data received: %d\n", out.DupDataReceived)
+			fmt.Fprintf(buf, "\tblocks sent: %d\n", out.BlocksSent)
+			fmt.Fprintf(buf, "\tdata sent: %d\n", out.DataSent)
+			fmt.Fprintf(buf, "\trate limit: %s\n", limitString(out.Limits.Rate, "/s"))
+			fmt.Fprintf(buf, "\tpeer rate limit: %s\n", limitString(out.Limits.PeerRate, "/s"))
+			fmt.Fprintf(buf, "\tpeer max outstanding: %s\n", limitString(out.Limits.PeerOutstanding, ""))
+			fmt.Fprintf(buf, "\tthrottled partners: %d\n", out.ThrottledPeers)
 			fmt.Fprintf(buf, "\twantlist [%d keys]\n", len(out.Wantlist))
 			for _, k := range out.Wantlist {
 				fmt.Fprintf(buf, "\t\t%s\n", k.B58String())
@@ -169,3 +184,268 @@ var bitswapStatCmd = &cmds.Command{
 		},
 	},
 }
+
+var ledgerCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the current ledger for a peer",
+		ShortDescription: `
+The Bitswap decision engine tracks the number of bytes exchanged between IPFS
+nodes, and stores this information as a collection of ledgers. This command
+prints the ledger associated with a given peer. Ledgers are kept across
+restarts of the daemon.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peer", true, false, "The PeerID (B58) of the ledger to inspect"),
+	},
+	Type: decision.Receipt{},
+	Run: func(req cmds.Request, res cmds.Response) {
+		nd, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if !nd.OnlineMode() {
+			res.SetError(errNotOnline, cmds.ErrClient)
+			return
+		}
+
+		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		if !ok {
+			res.SetError(u.ErrCast(), cmds.ErrNormal)
+			return
+		}
+
+		pid, err := peer.IDB58Decode(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		r := bs.LedgerForPeer(pid)
+		if r == nil {
+			r = &decision.Receipt{Peer: pid.Pretty()}
+		}
+		res.SetOutput(r)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*decision.Receipt)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Ledger for %s\n", out.Peer)
+			fmt.Fprintf(buf, "\tdebt ratio: %f\n", out.Value)
+			fmt.Fprintf(buf, "\texchanges: %d\n", out.Exchanged)
+			fmt.Fprintf(buf, "\tbytes sent: %d\n", out.Sent)
+			fmt.Fprintf(buf, "\tbytes received: %d\n", out.Recv)
+			fmt.Fprintf(buf, "\tlatency: %s\n", out.Latency)
+			if !out.LastExchange.IsZero() {
+				fmt.Fprintf(buf, "\tfirst exchange: %s\n", out.FirstExchange.Format(time.RFC3339))
+				fmt.Fprintf(buf, "\tlast exchange: %s\n", out.LastExchange.Format(time.RFC3339))
+			}
+			return buf, nil
+		},
+	},
+}
+
+func limitString(v uint64, unit string) string {
+	if v == 0 {
+		return "none"
+	}
+	return humanize.Bytes(v) + unit
+}
+
+// bitswapSettings are the settings of the config section Bitswap that
+// 'ipfs bitswap config' changes, by name.
+var bitswapSettings = map[string]func(*config.Bitswap) *string{
+	"Strategy":           func(c *config.Bitswap) *string { return &c.Strategy },
+	"RateLimit":          func(c *config.Bitswap) *string { return &c.RateLimit },
+	"PeerRateLimit":      func(c *config.Bitswap) *string { return &c.PeerRateLimit },
+	"PeerMaxOutstanding": func(c *config.Bitswap) *string { return &c.PeerMaxOutstanding },
+}
+
+var bitswapConfigCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show or change the bitswap settings",
+		ShortDescription: `
+'ipfs bitswap config' shows the settings of bitswap. Given a setting and a
+value, it changes the setting, on the running daemon as well as in the
+config, so it is kept across restarts.
+
+The bandwidth limits of serving blocks are in B, kB, kiB, MB, ...; an empty
+value means no limit:
+
+    RateLimit           - bytes per second sent to all peers
+    PeerRateLimit       - bytes per second sent to each peer
+    PeerMaxOutstanding  - bytes of blocks on their way to a peer at once
+
+Strategy ranks the peers wanting blocks, it is "fair" or "reciprocity".
+
+    ipfs bitswap config RateLimit 1MB
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("setting", false, false, "The setting to change"),
+		cmds.StringArg("value", false, false, "The new value of the setting"),
+	},
+	Type: config.Bitswap{},
+	Run: func(req cmds.Request, res cmds.Response) {
+		nd, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		cfg, err := nd.Repo.Config()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		args := req.Arguments()
+		if len(args) == 0 {
+			out := cfg.Bitswap
+			res.SetOutput(&out)
+			return
+		}
+		if len(args) == 1 {
+			res.SetError(fmt.Errorf("no value given for %s", args[0]), cmds.ErrClient)
+			return
+		}
+
+		setting, ok := bitswapSettings[args[0]]
+		if !ok {
+			res.SetError(fmt.Errorf("unknown bitswap setting %q", args[0]), cmds.ErrClient)
+			return
+		}
+		updated := *cfg
+		*setting(&updated.Bitswap) = args[1]
+
+		strategy, err := decision.StrategyByName(updated.Bitswap.Strategy)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		limits, err := core.BitswapLimits(updated.Bitswap)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		if nd.OnlineMode() {
+			bs, ok := nd.Exchange.(*bitswap.Bitswap)
+			if !ok {
+				res.SetError(u.ErrCast(), cmds.ErrNormal)
+				return
+			}
+			bs.SetStrategy(strategy)
+			bs.SetLimits(limits)
+		}
+
+		if err := nd.Repo.SetConfig(&updated); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		out := updated.Bitswap
+		res.SetOutput(&out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*config.Bitswap)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Strategy: %s\n", out.Strategy)
+			fmt.Fprintf(buf, "RateLimit: %s\n", out.RateLimit)
+			fmt.Fprintf(buf, "PeerRateLimit: %s\n", out.PeerRateLimit)
+			fmt.Fprintf(buf, "PeerMaxOutstanding: %s\n", out.PeerMaxOutstanding)
+			return buf, nil
+		},
+	},
+}
+
+var reprovideCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Announce the blocks of the repo to the network now",
+		ShortDescription: `
+'ipfs bitswap reprovide' starts announcing the blocks of the repo to the
+network, rather than waiting for the next periodic run, and shows how the
+runs go. Which blocks are announced, and how often, is set in the config
+section Reprovider.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("wait", "w", "Wait for the run to finish"),
+		cmds.BoolOption("stat", "s", "Only show how the runs go, without starting one"),
+	},
+	Type: reprovide.Stat{},
+	Run: func(req cmds.Request, res cmds.Response) {
+		nd, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if !nd.OnlineMode() || nd.Reprovider == nil {
+			res.SetError(errNotOnline, cmds.ErrClient)
+			return
+		}
+
+		wait, _, err := req.Option("wait").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		statOnly, _, err := req.Option("stat").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if !statOnly {
+			done := nd.Reprovider.Trigger()
+			if wait {
+				select {
+				case <-done:
+				case <-req.Context().Done():
+					res.SetError(req.Context().Err(), cmds.ErrNormal)
+					return
+				}
+			}
+		}
+
+		st := nd.Reprovider.Stat()
+		res.SetOutput(&st)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*reprovide.Stat)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			buf := new(bytes.Buffer)
+			fmt.Fprintln(buf, "reprovider status")
+			if out.Interval > 0 {
+				fmt.Fprintf(buf, "\tinterval: %s\n", out.Interval)
+			} else {
+				fmt.Fprintln(buf, "\tinterval: none")
+			}
+			if out.Running {
+				fmt.Fprintf(buf, "\trunning since %s: %d keys provided\n", out.Started.Format(time.RFC3339), out.Provided)
+			}
+			if !out.LastRun.IsZero() {
+				fmt.Fprintf(buf, "\tlast run: %s\n", out.LastRun.Format(time.RFC3339))
+				fmt.Fprintf(buf, "\t\tduration: %s\n", out.LastDuration)
+				fmt.Fprintf(buf, "\t\tkeys provided: %d\n", out.LastProvided)
+				if out.LastError != "" {
+					fmt.Fprintf(buf, "\t\terror: %s\n", out.LastError)
+				}
+			}
+			return buf, nil
+		},
+	},
+}
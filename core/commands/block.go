@@ -12,6 +12,7 @@ import (
 	"github.com/ipfs/go-ipfs/blocks"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	cmds "github.com/ipfs/go-ipfs/commands"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -107,12 +108,17 @@ var blockPutCmd = &cmds.Command{
 		ShortDescription: `
 ipfs block put is a plumbing command for storing raw ipfs blocks.
 It reads from stdin, and <key> is a base58 encoded multihash.
+The block is hashed with sha2-256, unless another function is given
+with --hash.
 `,
 	},
 
 	Arguments: []cmds.Argument{
 		cmds.FileArg("data", true, false, "The data to be stored as an IPFS block").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.StringOption(hashOptionName, "Hash function to use: sha2-256 (default), sha2-512, sha3 or sha1"),
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
 		if err != nil {
@@ -138,7 +144,25 @@ It reads from stdin, and <key> is a base58 encoded multihash.
 			return
 		}
 
+		if err := corerepo.CheckStorage(n, uint64(len(data))); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		hashFunc, err := hashFuncOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
 		b := blocks.NewBlock(data)
+		if hashFunc != 0 {
+			b, err = blocks.NewBlockWithHashFunc(data, hashFunc)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
 		log.Debugf("BlockPut key: '%q'", b.Key())
 
 		k, err := n.Blocks.AddBlock(b)
@@ -161,6 +185,26 @@ It reads from stdin, and <key> is a base58 encoded multihash.
 	Type: BlockStat{},
 }
 
+const hashOptionName = "hash"
+
+// hashFuncOption returns the code of the multihash function named by the
+// hash option, or zero if it is not given.
+func hashFuncOption(req cmds.Request) (int, error) {
+	name, found, err := req.Option(hashOptionName).String()
+	if err != nil || !found {
+		return 0, err
+	}
+	code, ok := mh.Names[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown hash function %q", name)
+	}
+	// the vendored multihash can't compute all the functions it names
+	if _, err := mh.Sum(nil, code, -1); err != nil {
+		return 0, fmt.Errorf("unsupported hash function %q", name)
+	}
+	return code, nil
+}
+
 func getBlockForKey(req cmds.Request, skey string) (*blocks.Block, error) {
 	n, err := req.InvocContext().GetNode()
 	if err != nil {
@@ -24,6 +24,9 @@ it contains.
 	Arguments: []cmds.Argument{
 		cmds.StringArg("ipfs-path", true, true, "The path to the IPFS object(s) to be outputted").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		prefetchOption,
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		node, err := req.InvocContext().GetNode()
 		if err != nil {
@@ -31,7 +34,13 @@ it contains.
 			return
 		}
 
-		readers, length, err := cat(req.Context(), node, req.Arguments())
+		prefetch, err := getPrefetch(req, node)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		readers, length, err := cat(req.Context(), node, req.Arguments(), prefetch)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -54,7 +63,24 @@ it contains.
 	},
 }
 
-func cat(ctx context.Context, node *core.IpfsNode, paths []string) ([]io.Reader, uint64, error) {
+var prefetchOption = cmds.IntOption("prefetch", "Number of blocks requested ahead of the one being read. Default: Bitswap.Prefetch config setting")
+
+// getPrefetch returns the --prefetch option, or the config setting when it
+// isn't given.
+func getPrefetch(req cmds.Request, node *core.IpfsNode) (int, error) {
+	prefetch, found, err := req.Option("prefetch").Int()
+	if err != nil || found {
+		return prefetch, err
+	}
+
+	cfg, err := node.Repo.Config()
+	if err != nil {
+		return 0, err
+	}
+	return core.ReaderPrefetch(cfg.Bitswap), nil
+}
+
+func cat(ctx context.Context, node *core.IpfsNode, paths []string, prefetch int) ([]io.Reader, uint64, error) {
 	readers := make([]io.Reader, 0, len(paths))
 	length := uint64(0)
 	for _, fpath := range paths {
@@ -62,6 +88,7 @@ func cat(ctx context.Context, node *core.IpfsNode, paths []string) ([]io.Reader,
 		if err != nil {
 			return nil, 0, err
 		}
+		read.SetPrefetch(prefetch)
 		readers = append(readers, read)
 		length += uint64(read.Size())
 	}
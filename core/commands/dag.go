@@ -0,0 +1,206 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	dagarchive "github.com/ipfs/go-ipfs/merkledag/archive"
+	path "github.com/ipfs/go-ipfs/path"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var DagCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move DAGs between repos as archives of blocks",
+		Synopsis: `
+ipfs dag export <root>... - Write an archive of DAGs to stdout
+ipfs dag import <file>    - Load the blocks of an archive
+`,
+		ShortDescription: `
+'ipfs dag' moves DAGs between repos that are not connected. 'ipfs dag export'
+writes the raw blocks of DAGs to an archive, keeping their exact structure,
+and 'ipfs dag import' loads the blocks of an archive into the repo.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"export": dagExportCmd,
+		"import": dagImportCmd,
+	},
+}
+
+var dagExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Write an archive of DAGs to stdout",
+		ShortDescription: `
+'ipfs dag export' streams the blocks of the DAGs under the given roots, in
+traversal order, as an archive that 'ipfs dag import' reads. The blocks
+missing from the repo are fetched from the network.
+
+    ipfs dag export <root> > dag.archive
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, true, "The path of a DAG to export").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		var roots []key.Key
+		for _, arg := range req.Arguments() {
+			p, err := path.ParsePath(arg)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			nd, err := core.Resolve(req.Context(), n, p)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			k, err := nd.Key()
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			roots = append(roots, k)
+		}
+
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(dagarchive.Export(req.Context(), w, n.Blocks, roots))
+		}()
+		res.SetOutput(r)
+	},
+}
+
+// DagImportOutput is the output of 'ipfs dag import'.
+type DagImportOutput struct {
+	Roots  []string
+	Blocks int
+	Pinned bool
+}
+
+var dagImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Load the blocks of an archive",
+		ShortDescription: `
+'ipfs dag import' reads an archive written by 'ipfs dag export' and puts its
+blocks in the repo. Every block is checked against its hash, the import
+stops at the first one that does not match, or once the repo goes over its
+Datastore.StorageMax. With --pin, the roots of the archive are pinned
+recursively.
+
+    ipfs dag import dag.archive
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.FileArg("file", true, false, "The archive to import").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("pin", "Pin the roots of the archive recursively"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		pin, _, err := req.Option("pin").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		fi, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		defer fi.Close()
+
+		gc, err := corerepo.NewGC(n)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if err := gc.CheckStorage(0); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		var r io.Reader = fi
+		if gc.StorageMax != 0 {
+			r, err = newQuotaReader(fi, gc)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+
+		// keep a GC from removing the blocks before they are pinned
+		defer n.Blockstore.PinLock()()
+
+		hdr, count, err := dagarchive.Import(r, n.Blockstore)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out := &DagImportOutput{Blocks: count, Pinned: pin}
+		for _, k := range hdr.Roots {
+			out.Roots = append(out.Roots, k.B58String())
+		}
+
+		if pin {
+			for _, k := range hdr.Roots {
+				nd, err := n.DAG.Get(req.Context(), k)
+				if err != nil {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+				if err := n.Pinning.Pin(req.Context(), nd, true); err != nil {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+			}
+			if err := n.Pinning.Flush(); err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*DagImportOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "imported %d blocks\n", out.Blocks)
+			verb := "root"
+			if out.Pinned {
+				verb = "pinned root"
+			}
+			for _, r := range out.Roots {
+				fmt.Fprintf(buf, "%s %s\n", verb, r)
+			}
+			return buf, nil
+		},
+	},
+	Type: DagImportOutput{},
+}
@@ -0,0 +1,699 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"strings"
+	"text/tabwriter"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	ipnsfs "github.com/ipfs/go-ipfs/ipnsfs"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	path "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+)
+
+var FilesCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manipulate a mutable tree of files",
+		Synopsis: `
+ipfs files ls [<path>]                - List a directory
+ipfs files mkdir <path>               - Make a directory
+ipfs files write <path> <data>        - Write to a file
+ipfs files read <path>                - Read a file
+ipfs files rm <path>                  - Remove a file or directory
+ipfs files mv <source> <dest>         - Move a file or directory
+ipfs files cp <source> <dest>         - Copy an object into the tree
+ipfs files stat <path>                - Show the hash and size of a node
+ipfs files flush                      - Persist the tree now
+`,
+		ShortDescription: `
+'ipfs files' manipulates a tree of files and directories kept by the
+local node, as if it was a regular filesystem, without having to mount
+it. The changes are written to ipfs objects as they happen, and the hash
+of the root of the tree is persisted in the repo shortly after.
+
+All paths are absolute within the tree, starting at '/'.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"ls":    filesLsCmd,
+		"mkdir": filesMkdirCmd,
+		"write": filesWriteCmd,
+		"read":  filesReadCmd,
+		"rm":    filesRmCmd,
+		"mv":    filesMvCmd,
+		"cp":    filesCpCmd,
+		"stat":  filesStatCmd,
+		"flush": filesFlushCmd,
+	},
+}
+
+// filesRoot returns the root directory of the node's files tree.
+func filesRoot(req cmds.Request) (*core.IpfsNode, *ipnsfs.Directory, error) {
+	n, err := req.InvocContext().GetNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	if n.FilesRoot == nil {
+		return nil, nil, errors.New("this node has no files tree")
+	}
+	return n, n.FilesRoot.GetValue(), nil
+}
+
+// checkFilesPath returns the cleaned up path p, or an error if p isn't
+// absolute.
+func checkFilesPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("paths must start with a '/': %s", p)
+	}
+	return gopath.Clean(p), nil
+}
+
+type FilesEntry struct {
+	Name string
+	Type string
+	Size uint64 `json:",omitempty"`
+	Hash string `json:",omitempty"`
+}
+
+type FilesLsOutput struct {
+	Entries []FilesEntry
+}
+
+// filesEntry describes the node nd of the files tree named name. The hash
+// and size are only filled out if long is set.
+func filesEntry(name string, nd ipnsfs.FSNode, long bool) (FilesEntry, error) {
+	e := FilesEntry{Name: name, Type: "file"}
+	if nd.Type() == ipnsfs.TDir {
+		e.Type = "directory"
+	}
+	if !long {
+		return e, nil
+	}
+
+	dagnd, err := nd.GetNode()
+	if err != nil {
+		return e, err
+	}
+	k, err := dagnd.Key()
+	if err != nil {
+		return e, err
+	}
+	e.Hash = k.B58String()
+	if fi, ok := nd.(*ipnsfs.File); ok {
+		size, err := fi.Size()
+		if err != nil {
+			return e, err
+		}
+		e.Size = uint64(size)
+	}
+	return e, nil
+}
+
+var filesLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List a directory of the files tree",
+		ShortDescription: `
+Lists the entries of the directory at <path>, or of '/' if no path is
+given. With -l, the hash and size of each entry are listed as well.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", false, false, "The directory to list"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("l", "Show the hash and size of the entries"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		_, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p := "/"
+		if len(req.Arguments()) > 0 {
+			p = req.Arguments()[0]
+		}
+		p, err = checkFilesPath(p)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		long, _, _ := req.Option("l").Bool()
+
+		nd, err := ipnsfs.Lookup(root, p)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out := &FilesLsOutput{}
+		dir, ok := nd.(*ipnsfs.Directory)
+		if !ok {
+			e, err := filesEntry(gopath.Base(p), nd, long)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			out.Entries = append(out.Entries, e)
+			res.SetOutput(out)
+			return
+		}
+
+		for _, name := range dir.List() {
+			child, err := dir.Child(name)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			e, err := filesEntry(name, child, long)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			out.Entries = append(out.Entries, e)
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out := res.Output().(*FilesLsOutput)
+			long, _, _ := res.Request().Option("l").Bool()
+
+			buf := new(bytes.Buffer)
+			w := tabwriter.NewWriter(buf, 1, 2, 1, ' ', 0)
+			for _, e := range out.Entries {
+				name := e.Name
+				if e.Type == "directory" {
+					name += "/"
+				}
+				if long {
+					fmt.Fprintf(w, "%s\t%s\t%d\n", name, e.Hash, e.Size)
+				} else {
+					fmt.Fprintln(w, name)
+				}
+			}
+			w.Flush()
+			return buf, nil
+		},
+	},
+	Type: FilesLsOutput{},
+}
+
+var filesMkdirCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Make a directory in the files tree",
+		ShortDescription: `
+Creates the directory at <path>. With -p, the missing parent directories
+are created as well, and it is not an error for the directory to exist.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The directory to create"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("parents", "p", "Make the parent directories as needed"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p, err := checkFilesPath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		parents, _, _ := req.Option("parents").Bool()
+
+		defer n.Blockstore.PinLock()()
+		_, err = ipnsfs.Mkdir(root, p, parents)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	},
+}
+
+var filesWriteCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Write to a file in the files tree",
+		ShortDescription: `
+Writes <data> to the file at <path>, starting at --offset, or at the
+beginning of the file if not given. The rest of the file is left as is,
+unless --truncate is given.
+
+The file must exist already, unless --create is given.
+
+Example:
+
+	echo "hello world" | ipfs files write --create /myfs/a/b/file
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The file to write to"),
+		cmds.FileArg("data", true, false, "The data to write").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption("offset", "o", "The byte offset to start writing at"),
+		cmds.BoolOption("create", "e", "Create the file if it does not exist"),
+		cmds.BoolOption("truncate", "t", "Truncate the file to size zero before writing"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p, err := checkFilesPath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		offset, _, _ := req.Option("offset").Int()
+		if offset < 0 {
+			res.SetError(errors.New("the offset must be positive"), cmds.ErrClient)
+			return
+		}
+		create, _, _ := req.Option("create").Bool()
+		trunc, _, _ := req.Option("truncate").Bool()
+
+		// the blocks written are only linked in the tree once the file is
+		// closed, a gc must not run before
+		defer n.Blockstore.PinLock()()
+
+		nd, err := ipnsfs.Lookup(root, p)
+		if err == os.ErrNotExist && create {
+			err = ipnsfs.PutNode(root, p, &dag.Node{Data: ft.FilePBData(nil, 0)})
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			nd, err = ipnsfs.Lookup(root, p)
+		}
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		fi, ok := nd.(*ipnsfs.File)
+		if !ok {
+			res.SetError(ipnsfs.ErrIsDirectory, cmds.ErrNormal)
+			return
+		}
+
+		input, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		defer input.Close()
+
+		err = writeFile(fi, input, int64(offset), trunc)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	},
+}
+
+// writeFile writes the data from r to fi at offset, and passes the new
+// contents of the file on to its parent directory.
+func writeFile(fi *ipnsfs.File, r io.Reader, offset int64, trunc bool) (err error) {
+	defer func() {
+		if cerr := fi.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if trunc {
+		if err := fi.Truncate(0); err != nil {
+			return err
+		}
+	}
+
+	size, err := fi.Size()
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		return fmt.Errorf("offset %d is past the end of the file (%d bytes)", offset, size)
+	}
+
+	if _, err := fi.Seek(offset, os.SEEK_SET); err != nil {
+		return err
+	}
+	_, err = io.Copy(fi, r)
+	return err
+}
+
+var filesReadCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Read a file in the files tree",
+		ShortDescription: `
+Outputs the contents of the file at <path>, from --offset and for at most
+--count bytes if given.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The file to read"),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption("offset", "o", "The byte offset to start reading at"),
+		cmds.IntOption("count", "n", "The maximum number of bytes to read"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p, err := checkFilesPath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		offset, _, _ := req.Option("offset").Int()
+		if offset < 0 {
+			res.SetError(errors.New("the offset must be positive"), cmds.ErrClient)
+			return
+		}
+		count, countFound, _ := req.Option("count").Int()
+		if countFound && count < 0 {
+			res.SetError(errors.New("the count must be positive"), cmds.ErrClient)
+			return
+		}
+
+		nd, err := ipnsfs.Lookup(root, p)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		fi, ok := nd.(*ipnsfs.File)
+		if !ok {
+			res.SetError(ipnsfs.ErrIsDirectory, cmds.ErrNormal)
+			return
+		}
+
+		// read from a snapshot of the file, so that the reads don't move
+		// the offset writes happen at
+		dagnd, err := fi.GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		dr, err := uio.NewDagReader(req.Context(), dagnd, n.DAG)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		size := int64(dr.Size())
+		if int64(offset) > size {
+			res.SetError(fmt.Errorf("offset %d is past the end of the file (%d bytes)", offset, size), cmds.ErrNormal)
+			return
+		}
+		if _, err := dr.Seek(int64(offset), os.SEEK_SET); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		length := size - int64(offset)
+		var r io.Reader = dr
+		if countFound && int64(count) < length {
+			length = int64(count)
+			r = io.LimitReader(dr, length)
+		}
+		res.SetLength(uint64(length))
+		res.SetOutput(r)
+	},
+}
+
+var filesRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove a file or directory from the files tree",
+		ShortDescription: `
+Removes the file at <path>. Directories are only removed with -r.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The file or directory to remove"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("recursive", "r", "Remove directories and their contents"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p, err := checkFilesPath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		recursive, _, _ := req.Option("recursive").Bool()
+
+		defer n.Blockstore.PinLock()()
+		err = ipnsfs.Remove(root, p, recursive)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	},
+}
+
+var filesMvCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move a file or directory in the files tree",
+		ShortDescription: `
+Moves the file or directory at <source> to <dest>. If <dest> is an
+existing directory, <source> is moved inside of it.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("source", true, false, "The file or directory to move"),
+		cmds.StringArg("dest", true, false, "Where to move it to"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		src, err := checkFilesPath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		dst, err := checkFilesPath(req.Arguments()[1])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		defer n.Blockstore.PinLock()()
+		err = ipnsfs.Mv(root, src, dst)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	},
+}
+
+var filesCpCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Copy an object into the files tree",
+		ShortDescription: `
+Copies the object at <source> to <dest>. <source> is either an
+/ipfs/ or /ipns/ path, or a path in the files tree. If <dest> is an
+existing directory, the object is copied inside of it.
+
+Example:
+
+	ipfs files cp /ipfs/QmWGeRAEgtsHW3ec7U4qW2CyVy7eA2mFRVbk1nb24jFyks /docs/readme
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("source", true, false, "The object to copy"),
+		cmds.StringArg("dest", true, false, "Where to copy it to"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		src := req.Arguments()[0]
+		dst, err := checkFilesPath(req.Arguments()[1])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		// held from the resolve on, so that a gc cannot drop the fetched
+		// root before it is linked in the tree
+		defer n.Blockstore.PinLock()()
+
+		var nd *dag.Node
+		if strings.HasPrefix(src, "/ipfs/") || strings.HasPrefix(src, "/ipns/") {
+			nd, err = core.Resolve(req.Context(), n, path.Path(src))
+		} else {
+			src, err = checkFilesPath(src)
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+			var fsnd ipnsfs.FSNode
+			fsnd, err = ipnsfs.Lookup(root, src)
+			if err == nil {
+				nd, err = fsnd.GetNode()
+			}
+		}
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if _, err := ipnsfs.LookupDir(root, dst); err == nil {
+			dst = gopath.Join(dst, gopath.Base(src))
+		}
+
+		err = ipnsfs.PutNode(root, dst, nd)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	},
+}
+
+type FilesStatOutput struct {
+	Hash           string
+	Size           uint64
+	CumulativeSize uint64
+	Blocks         int
+	Type           string
+}
+
+var filesStatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the hash and size of a node in the files tree",
+		ShortDescription: `
+Shows the hash of the object at <path>, the size of its contents if it is
+a file, the size of the object and all its descendants, and the number of
+objects it links to.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The file or directory to stat"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		_, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p, err := checkFilesPath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		nd, err := ipnsfs.Lookup(root, p)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		e, err := filesEntry(gopath.Base(p), nd, true)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		dagnd, err := nd.GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		ns, err := dagnd.Stat()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&FilesStatOutput{
+			Hash:           e.Hash,
+			Size:           e.Size,
+			CumulativeSize: uint64(ns.CumulativeSize),
+			Blocks:         ns.NumLinks,
+			Type:           e.Type,
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out := res.Output().(*FilesStatOutput)
+			buf := new(bytes.Buffer)
+			fmt.Fprintln(buf, out.Hash)
+			fmt.Fprintf(buf, "Size: %d\n", out.Size)
+			fmt.Fprintf(buf, "CumulativeSize: %d\n", out.CumulativeSize)
+			fmt.Fprintf(buf, "ChildBlocks: %d\n", out.Blocks)
+			fmt.Fprintf(buf, "Type: %s\n", out.Type)
+			return buf, nil
+		},
+	},
+	Type: FilesStatOutput{},
+}
+
+var filesFlushCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Persist the files tree now",
+		ShortDescription: `
+Writes the current state of the files tree to the repo right away,
+instead of shortly after the last change. Outputs the hash of the root.
+`,
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := filesRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		err = n.FilesRoot.Flush()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		nd, err := root.GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		k, err := nd.Key()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&MessageOutput{k.B58String() + "\n"})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: MessageTextMarshaler,
+	},
+	Type: MessageOutput{},
+}
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	filestore "github.com/ipfs/go-ipfs/filestore"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var FilestoreCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the blocks added with 'ipfs add --nocopy'",
+		ShortDescription: `
+Files added with 'ipfs add --nocopy' are not copied into the repo. The
+filestore only records where their data is, and reads it from the
+original files when needed.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls":     filestoreLsCmd,
+		"verify": filestoreVerifyCmd,
+	},
+}
+
+// FilestoreObject is a block referenced by the filestore.
+type FilestoreObject struct {
+	Key    string
+	Path   string
+	Offset uint64
+	Size   uint64
+	Status string `json:",omitempty"`
+	Err    string `json:",omitempty"`
+}
+
+var filestoreLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the blocks referenced by the filestore",
+		ShortDescription: `
+'ipfs filestore ls' lists the blocks stored as references to files, along
+with the file, offset and size of their data.
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		runFilestoreList(req, res, false)
+	},
+	Type: FilestoreObject{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: filestoreTextMarshaler,
+	},
+}
+
+var filestoreVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check the files referenced by the filestore",
+		ShortDescription: `
+'ipfs filestore verify' reads back every block referenced by the
+filestore, and reports its status:
+
+  ok       the block can be read from its file
+  changed  the file was modified since it was added
+  missing  the file was removed
+  error    the file could not be read
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		runFilestoreList(req, res, true)
+	},
+	Type: FilestoreObject{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: filestoreTextMarshaler,
+	},
+}
+
+func runFilestoreList(req cmds.Request, res cmds.Response, verify bool) {
+	n, err := req.InvocContext().GetNode()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	if n.Filestore == nil {
+		res.SetError(errors.New("node has no filestore"), cmds.ErrNormal)
+		return
+	}
+
+	list, err := n.Filestore.List(req.Context(), verify)
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
+	outChan := make(chan interface{})
+	res.SetOutput((<-chan interface{})(outChan))
+
+	go func() {
+		defer close(outChan)
+		for r := range list {
+			outChan <- toFilestoreObject(r)
+		}
+	}()
+}
+
+func toFilestoreObject(r *filestore.ListRes) *FilestoreObject {
+	return &FilestoreObject{
+		Key:    r.Key.B58String(),
+		Path:   r.Ref.Path,
+		Offset: r.Ref.Offset,
+		Size:   r.Ref.Size,
+		Status: r.Status,
+		Err:    r.Err,
+	}
+}
+
+func filestoreTextMarshaler(res cmds.Response) (io.Reader, error) {
+	outChan, ok := res.Output().(<-chan interface{})
+	if !ok {
+		return nil, u.ErrCast()
+	}
+
+	marshal := func(v interface{}) (io.Reader, error) {
+		obj, ok := v.(*FilestoreObject)
+		if !ok {
+			return nil, u.ErrCast()
+		}
+
+		buf := new(bytes.Buffer)
+		if obj.Status != "" {
+			fmt.Fprintf(buf, "%-7s ", obj.Status)
+		}
+		fmt.Fprintf(buf, "%s %s %d %d", obj.Key, obj.Path, obj.Offset, obj.Size)
+		if obj.Err != "" {
+			fmt.Fprintf(buf, " (%s)", obj.Err)
+		}
+		fmt.Fprintln(buf)
+		return buf, nil
+	}
+
+	return &cmds.ChannelMarshaler{
+		Channel:   outChan,
+		Marshaler: marshal,
+		Res:       res,
+	}, nil
+}
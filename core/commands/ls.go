@@ -11,6 +11,7 @@ import (
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	unixfs "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	unixfspb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
@@ -73,26 +74,36 @@ it contains, with the following format:
 
 		output := make([]LsObject, len(req.Arguments()))
 		for i, dagnode := range dagnodes {
+			// the entries of a sharded directory are spread over its shards
+			links, err := uio.Links(req.Context(), node.DAG, dagnode)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
 			output[i] = LsObject{
 				Hash:  paths[i],
-				Links: make([]LsLink, len(dagnode.Links)),
+				Links: make([]LsLink, len(links)),
 			}
-			for j, link := range dagnode.Links {
+			for j, link := range links {
 				link.Node, err = link.GetNode(req.Context(), node.DAG)
 				if err != nil {
 					res.SetError(err, cmds.ErrNormal)
 					return
 				}
-				d, err := unixfs.FromBytes(link.Node.Data)
-				if err != nil {
-					res.SetError(err, cmds.ErrNormal)
-					return
+				typ := unixfs.TRaw
+				if !link.Node.IsRaw() {
+					d, err := unixfs.FromBytes(link.Node.Data)
+					if err != nil {
+						res.SetError(err, cmds.ErrNormal)
+						return
+					}
+					typ = d.GetType()
 				}
 				output[i].Links[j] = LsLink{
 					Name: link.Name,
 					Hash: link.Hash.B58String(),
 					Size: link.Size,
-					Type: d.GetType(),
+					Type: typ,
 				}
 			}
 		}
@@ -114,7 +125,7 @@ it contains, with the following format:
 					fmt.Fprintln(w, "Hash\tSize\tName")
 				}
 				for _, link := range object.Links {
-					if link.Type == unixfspb.Data_Directory {
+					if link.Type == unixfspb.Data_Directory || link.Type == unixfspb.Data_HAMTShard {
 						link.Name += "/"
 					}
 					fmt.Fprintf(w, "%s\t%v\t%s\n", link.Hash, link.Size, link.Name)
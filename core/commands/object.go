@@ -8,13 +8,17 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"text/tabwriter"
 
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	cmds "github.com/ipfs/go-ipfs/commands"
+	files "github.com/ipfs/go-ipfs/commands/files"
 	core "github.com/ipfs/go-ipfs/core"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	dagutils "github.com/ipfs/go-ipfs/merkledag/utils"
@@ -57,6 +61,9 @@ ipfs object links <key>     - Outputs links pointed to by object
 ipfs object stat <key>      - Outputs statistics of object
 ipfs object new <template>  - Create new ipfs objects
 ipfs object patch <args>    - Create new object from old ones
+ipfs object diff <a> <b>    - Show the changes between two objects
+ipfs object merge <base> <a> <b>
+                            - Merge the changes of two objects to a base
 `,
 	},
 
@@ -68,6 +75,8 @@ ipfs object patch <args>    - Create new object from old ones
 		"stat":  objectStatCmd,
 		"new":   objectNewCmd,
 		"patch": objectPatchCmd,
+		"diff":  objectDiffCmd,
+		"merge": objectMergeCmd,
 	},
 }
 
@@ -306,6 +315,9 @@ Data should be in the format specified by the --inputenc flag.
 	* "protobuf"
 	* "json" (default)
 
+The object is hashed with sha2-256, unless another function is given
+with --hash.
+
 Examples:
 
 	echo '{ "Data": "abc" }' | ipfs object put
@@ -333,6 +345,7 @@ and then run
 	},
 	Options: []cmds.Option{
 		cmds.StringOption("inputenc", "Encoding type of input data, either \"protobuf\" or \"json\""),
+		cmds.StringOption(hashOptionName, "Hash function to use: sha2-256 (default), sha2-512, sha3 or sha1"),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -356,7 +369,13 @@ and then run
 			inputenc = "json"
 		}
 
-		output, err := objectPut(n, input, inputenc)
+		hashFunc, err := hashFuncOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		output, err := objectPut(n, input, inputenc, hashFunc)
 		if err != nil {
 			errType := cmds.ErrNormal
 			if err == ErrUnknownObjectEnc {
@@ -430,6 +449,233 @@ Available templates:
 	Type: Object{},
 }
 
+// Change is a change between two objects, as output by 'ipfs object diff'
+// and 'ipfs object merge'.
+type Change struct {
+	Type   string
+	Path   string
+	Before string `json:",omitempty"`
+	After  string `json:",omitempty"`
+}
+
+func (c Change) String() string {
+	at := ""
+	if c.Path != "" {
+		at = " at " + c.Path
+	}
+	switch c.Type {
+	case "add":
+		return fmt.Sprintf("Added %s%s", c.After, at)
+	case "remove":
+		return fmt.Sprintf("Removed %s%s", c.Before, at)
+	default:
+		return fmt.Sprintf("Changed %s to %s%s", c.Before, c.After, at)
+	}
+}
+
+func newChange(c *dagutils.Change) Change {
+	out := Change{Path: c.Path}
+	switch c.Type {
+	case dagutils.Add:
+		out.Type = "add"
+	case dagutils.Remove:
+		out.Type = "remove"
+	default:
+		out.Type = "mod"
+	}
+	if c.Before != "" {
+		out.Before = c.Before.B58String()
+	}
+	if c.After != "" {
+		out.After = c.After.B58String()
+	}
+	return out
+}
+
+type DiffOutput struct {
+	Changes []Change
+}
+
+var objectDiffCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the changes between two objects",
+		ShortDescription: `
+'ipfs object diff <a> <b>' lists the links added, removed or changed to
+turn the object <a> into <b>.
+`,
+		LongDescription: `
+'ipfs object diff <a> <b>' lists the links added, removed or changed to
+turn the object <a> into <b>.
+
+By default, only the links of <a> and <b> are compared. With --recursive,
+the objects linked to under the same name from both are compared as well,
+and the changes are reported by path. An object whose data changed is
+reported as changed as a whole.
+
+Example:
+
+	$ ipfs object diff -r $OLD_SITE $NEW_SITE
+	Added QmZ3G2Ypw7WNeEUMNcXf7nMhYDGZKCh3HaeRW1zqWTfNGh at blog/post.html
+	Changed QmUhiS2N1bREdfeL6i7ixXETAMgAd5dgCnZX3yCyJxn9vF to QmVCz8nvbyb1eJnMiHXyfzSVDBg6ajRt5UKQ3WSuw7fuvG at index.html
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("a", true, false, "The object to compare from"),
+		cmds.StringArg("b", true, false, "The object to compare to"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("recursive", "r", "Compare the objects linked to as well"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		a, err := core.Resolve(req.Context(), n, path.Path(req.Arguments()[0]))
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		b, err := core.Resolve(req.Context(), n, path.Path(req.Arguments()[1]))
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		recursive, _, _ := req.Option("recursive").Bool()
+		diff := dagutils.DiffLinks
+		if recursive {
+			diff = dagutils.Diff
+		}
+		changes, err := diff(req.Context(), n.DAG, a, b)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out := &DiffOutput{Changes: make([]Change, len(changes))}
+		for i, c := range changes {
+			out.Changes[i] = newChange(c)
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out := res.Output().(*DiffOutput)
+			buf := new(bytes.Buffer)
+			for _, c := range out.Changes {
+				fmt.Fprintln(buf, c)
+			}
+			return buf, nil
+		},
+	},
+	Type: DiffOutput{},
+}
+
+// MergeConflict is a pair of changes 'ipfs object merge' can't both make.
+type MergeConflict struct {
+	A Change
+	B Change
+}
+
+type MergeOutput struct {
+	Hash      string          `json:",omitempty"`
+	Conflicts []MergeConflict `json:",omitempty"`
+}
+
+var objectMergeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Merge the changes of two objects to a base",
+		ShortDescription: `
+'ipfs object merge <base> <a> <b>' applies to <base> the changes that
+turned it into <a> and into <b>, and outputs the merged object.
+`,
+		LongDescription: `
+'ipfs object merge <base> <a> <b>' applies to <base> the changes that
+turned it into <a> and into <b>, and outputs the merged object.
+
+The changes are found the way 'ipfs object diff -r' does. Changes that
+both make identically are applied once. When <a> and <b> change the same
+path differently, or one changes a path below one the other changes,
+nothing is merged: the command fails, with the conflicting changes as its
+error message, in JSON:
+
+    {"Conflicts":[{"A":<change>,"B":<change>}, ...]}
+
+where the changes are the ones 'ipfs object diff --encoding=json' lists.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("base", true, false, "The object <a> and <b> were changed from"),
+		cmds.StringArg("a", true, false, "The first changed object"),
+		cmds.StringArg("b", true, false, "The second changed object"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		nodes := make([]*dag.Node, 3)
+		for i, arg := range req.Arguments() {
+			nodes[i], err = core.Resolve(req.Context(), n, path.Path(arg))
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+		base, a, b := nodes[0], nodes[1], nodes[2]
+
+		da, err := dagutils.Diff(req.Context(), n.DAG, base, a)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		db, err := dagutils.Diff(req.Context(), n.DAG, base, b)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		changes, conflicts := dagutils.MergeDiffs(da, db)
+		if len(conflicts) > 0 {
+			out := &MergeOutput{Conflicts: make([]MergeConflict, len(conflicts))}
+			for i, c := range conflicts {
+				out.Conflicts[i] = MergeConflict{A: newChange(c.A), B: newChange(c.B)}
+			}
+			report, err := json.Marshal(out)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			res.SetError(errors.New(string(report)), cmds.ErrNormal)
+			return
+		}
+
+		merged, err := dagutils.ApplyChange(req.Context(), n.DAG, base.Copy(), changes)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		k, err := n.DAG.Add(merged)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&MergeOutput{Hash: k.B58String()})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out := res.Output().(*MergeOutput)
+			return strings.NewReader(out.Hash + "\n"), nil
+		},
+	},
+	Type: MergeOutput{},
+}
+
 var objectPatchCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Create a new merkledag object based on an existing one",
@@ -446,6 +692,11 @@ Patch commands:
     set-data                  - sets a nodes data from stdin
     append-data               - appends to a nodes data from stdin
 
+With --script, the operations are read from a JSON file holding a list of
+them instead, and applied in order. The intermediate nodes are kept in
+memory, only the nodes of the final object are written, and nothing is
+written if any operation fails. The patch command arguments are not used.
+
 Examples:
 
     EMPTY_DIR=$(ipfs object new unixfs-dir)
@@ -465,17 +716,51 @@ The data inside the node can be modified as well:
     ipfs object patch $FOO_BAR set-data < file.dat
     ipfs object patch $FOO_BAR append-data < file.dat
 
+A script doing several of these at once looks like:
+
+    [
+      {"op": "add-link", "path": "a/b/foo", "ref": "$BAR", "create": true},
+      {"op": "rm-link", "path": "bar"},
+      {"op": "set-data", "data": "some data"},
+      {"op": "append-data", "data": " and more"}
+    ]
+
+    ipfs object patch --script ops.json $FOO_BAR
+
+'create' makes the missing directories on the path of an add-link, as
+--create does for all of them.
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption("create", "p", "create intermediate directories on add-link"),
+		cmds.StringOption("script", "s", "apply the operations in this JSON file"),
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("root", true, false, "the hash of the node to modify"),
-		cmds.StringArg("command", true, false, "the operation to perform"),
-		cmds.StringArg("args", true, true, "extra arguments").EnableStdin(),
+		cmds.StringArg("command", false, false, "the operation to perform"),
+		cmds.StringArg("args", false, true, "extra arguments").EnableStdin(),
 	},
 	Type: Object{},
+	PreRun: func(req cmds.Request) error {
+		// the script is read here, so a daemon gets its content rather
+		// than a path relative to our working directory
+		script, found, err := req.Option("script").String()
+		if err != nil || !found {
+			return err
+		}
+
+		fi, err := os.Stat(script)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(script)
+		if err != nil {
+			return err
+		}
+		sf := files.NewReaderFile(filepath.Base(script), script, f, fi)
+		req.SetFiles(files.NewSliceFile("", "", []files.File{sf}))
+		return nil
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		nd, err := req.InvocContext().GetNode()
 		if err != nil {
@@ -483,6 +768,10 @@ The data inside the node can be modified as well:
 			return
 		}
 
+		// a GC could remove the new nodes before the caller gets to use
+		// or pin the result
+		defer nd.Blockstore.PinLock()()
+
 		rootarg := req.Arguments()[0]
 		if strings.HasPrefix(rootarg, "/ipfs/") {
 			rootarg = rootarg[6:]
@@ -499,6 +788,20 @@ The data inside the node can be modified as well:
 			return
 		}
 
+		if _, found, _ := req.Option("script").String(); found {
+			k, err := patchScriptCaller(req, rnode)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			res.SetOutput(&Object{Hash: k.B58String()})
+			return
+		}
+
+		if len(req.Arguments()) < 2 {
+			res.SetError(errors.New("no patch command given"), cmds.ErrClient)
+			return
+		}
 		action := req.Arguments()[1]
 
 		switch action {
@@ -547,6 +850,87 @@ The data inside the node can be modified as well:
 	},
 }
 
+// PatchOp is one operation of an 'object patch' script.
+type PatchOp struct {
+	Op     string // add-link, rm-link, set-data or append-data
+	Path   string // path of the link to add or remove
+	Ref    string // object the added link points to
+	Data   string // data to set or append
+	Create bool   // create the missing directories of an add-link path
+}
+
+func patchScriptCaller(req cmds.Request, root *dag.Node) (key.Key, error) {
+	nd, err := req.InvocContext().GetNode()
+	if err != nil {
+		return "", err
+	}
+
+	if req.Files() == nil {
+		return "", errors.New("no patch script given")
+	}
+	f, err := req.Files().NextFile()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var ops []PatchOp
+	if err := json.NewDecoder(f).Decode(&ops); err != nil {
+		return "", fmt.Errorf("invalid patch script: %s", err)
+	}
+
+	create, _, err := req.Option("create").Bool()
+	if err != nil {
+		return "", err
+	}
+
+	e := dagutils.NewMemoryDagEditor(nd.DAG, root)
+	for i, op := range ops {
+		if err := applyPatchOp(req.Context(), nd, e, op, create); err != nil {
+			return "", fmt.Errorf("patch operation %d (%s): %s", i, op.Op, err)
+		}
+	}
+
+	if err := e.WriteOutputTo(nd.DAG); err != nil {
+		return "", err
+	}
+	return e.GetNode().Key()
+}
+
+func applyPatchOp(ctx context.Context, nd *core.IpfsNode, e *dagutils.Editor, op PatchOp, create bool) error {
+	switch op.Op {
+	case "add-link":
+		if op.Path == "" || op.Ref == "" {
+			return errors.New("add-link needs a path and a ref")
+		}
+		child, err := core.Resolve(ctx, nd, path.Path(op.Ref))
+		if err != nil {
+			return err
+		}
+
+		var createfunc func() *dag.Node
+		if create || op.Create {
+			createfunc = func() *dag.Node {
+				return &dag.Node{Data: ft.FolderPBData()}
+			}
+		}
+		return e.InsertNodeAtPath(ctx, op.Path, child, createfunc)
+	case "rm-link":
+		if op.Path == "" {
+			return errors.New("rm-link needs a path")
+		}
+		return e.RmLink(ctx, op.Path)
+	case "set-data":
+		e.SetRootData([]byte(op.Data))
+		return nil
+	case "append-data":
+		e.SetRootData(append(e.GetNode().Data, op.Data...))
+		return nil
+	default:
+		return errors.New("unrecognized operation")
+	}
+}
+
 func appendDataCaller(req cmds.Request, root *dag.Node) (key.Key, error) {
 	if len(req.Arguments()) < 3 {
 		return "", fmt.Errorf("not enough arguments for set-data")
@@ -668,7 +1052,7 @@ func nodeFromTemplate(template string) (*dag.Node, error) {
 var ErrEmptyNode = errors.New("no data or links in this node")
 
 // objectPut takes a format option, serializes bytes from stdin and updates the dag with that data
-func objectPut(n *core.IpfsNode, input io.Reader, encoding string) (*Object, error) {
+func objectPut(n *core.IpfsNode, input io.Reader, encoding string, hashFunc int) (*Object, error) {
 
 	data, err := ioutil.ReadAll(io.LimitReader(input, inputLimit+10))
 	if err != nil {
@@ -728,6 +1112,7 @@ func objectPut(n *core.IpfsNode, input io.Reader, encoding string) (*Object, err
 		return nil, err
 	}
 
+	dagnode.SetHashFunc(hashFunc)
 	_, err = n.DAG.Add(dagnode)
 	if err != nil {
 		return nil, err
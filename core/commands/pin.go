@@ -163,10 +163,10 @@ or recursively pinned are not included in the list.
 Use --type=<type> to specify the type of pinned keys to list. Valid values are:
     * "direct": pin that specific object.
     * "recursive": pin that specific object, and indirectly pin all its decendants
-    * "indirect": pinned indirectly by an ancestor (like a refcount)
+    * "indirect": pinned indirectly by an ancestor
     * "all"
 
-To see the ref count on indirect pins, pass the -count option flag.
+To see the number of links to indirect pins, pass the -count option flag.
 Defaults to "direct".
 `,
 	},
@@ -209,7 +209,12 @@ Defaults to "direct".
 			}
 		}
 		if typeStr == "indirect" || typeStr == "all" {
-			for k, v := range n.Pinning.IndirectKeys() {
+			refs, err := n.Pinning.IndirectKeys(req.Context())
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			for k, v := range refs {
 				keys[k.B58String()] = RefKeyObject{
 					Type:  "indirect",
 					Count: v,
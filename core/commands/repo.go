@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	cmds "github.com/ipfs/go-ipfs/commands"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	u "github.com/ipfs/go-ipfs/util"
@@ -19,7 +20,9 @@ var RepoCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"gc": repoGcCmd,
+		"gc":     repoGcCmd,
+		"stat":   repoStatCmd,
+		"verify": repoVerifyCmd,
 	},
 }
 
@@ -95,3 +98,151 @@ order to reclaim hard disk space.
 		},
 	},
 }
+
+var repoStatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Print status information about the repo",
+		ShortDescription: `
+'ipfs repo stat' reports the number of blocks in the repo, how much
+space they take and how much of it is pinned, along with the space the
+repo takes on disk in total and per datastore.
+
+The pinned and unpinned totals are computed by reading every block, so
+this can take a while on large repos.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("human", "Print sizes in human readable format (e.g., 1K 234M 2G)"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		stat, err := corerepo.RepoStat(n, req.Context())
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(stat)
+	},
+	Type: corerepo.Stat{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			stat, ok := res.Output().(*corerepo.Stat)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			human, _, err := res.Request().Option("human").Bool()
+			if err != nil {
+				return nil, err
+			}
+			size := func(b uint64) string {
+				if human {
+					return humanize.Bytes(b)
+				}
+				return fmt.Sprint(b)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "NumObjects:\t%d\n", stat.NumObjects)
+			fmt.Fprintf(buf, "BlockBytes:\t%s\n", size(stat.BlockBytes))
+			fmt.Fprintf(buf, "FilestoreBytes:\t%s\n", size(stat.FilestoreBytes))
+			fmt.Fprintf(buf, "PinnedBytes:\t%s\n", size(stat.PinnedBytes))
+			fmt.Fprintf(buf, "UnpinnedBytes:\t%s\n", size(stat.UnpinnedBytes))
+			fmt.Fprintf(buf, "RepoSize:\t%s\n", size(stat.RepoSize))
+			fmt.Fprintf(buf, "DatastoreOverhead:\t%s\n", size(stat.DatastoreOverhead))
+			fmt.Fprintf(buf, "RepoPath:\t%s\n", stat.RepoPath)
+			fmt.Fprintf(buf, "Version:\t%s\n", stat.Version)
+			for _, ns := range stat.Namespaces {
+				if ns.Type == "s3" {
+					fmt.Fprintf(buf, "Namespace %s:\t%s (remote)\n", ns.Prefix, ns.Type)
+					continue
+				}
+				fmt.Fprintf(buf, "Namespace %s:\t%s %s\n", ns.Prefix, ns.Type, size(ns.Size))
+			}
+			return buf, nil
+		},
+	},
+}
+
+var repoVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Verify all blocks in repo are not corrupted",
+		ShortDescription: `
+'ipfs repo verify' re-hashes every block in the local blockstore and
+checks it against its key. Corrupt blocks are moved out of the
+blockstore into a quarantine area, so they are no longer served to
+other peers. With --refetch, a fresh copy of each corrupt block is
+requested from the network.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("refetch", "Fetch corrupt blocks again from the network"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		refetch, _, err := req.Option("refetch").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		progress, err := corerepo.Verify(req.Context(), n, refetch)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for p := range progress {
+				outChan <- p
+			}
+		}()
+	},
+	Type: corerepo.VerifyProgress{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			outChan, ok := res.Output().(<-chan interface{})
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			marshal := func(v interface{}) (io.Reader, error) {
+				obj, ok := v.(*corerepo.VerifyProgress)
+				if !ok {
+					return nil, u.ErrCast()
+				}
+
+				buf := new(bytes.Buffer)
+				if obj.Msg != "" {
+					fmt.Fprintf(buf, "\n%s\n", obj.Msg)
+				} else {
+					fmt.Fprintf(buf, "\r%d blocks processed.", obj.Progress)
+				}
+				return buf, nil
+			}
+
+			return &cmds.ChannelMarshaler{
+				Channel:   outChan,
+				Marshaler: marshal,
+				Res:       res,
+			}, nil
+		},
+	},
+}
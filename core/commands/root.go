@@ -41,6 +41,8 @@ DATA STRUCTURE COMMANDS
     block         Interact with raw blocks in the datastore
     object        Interact with raw dag nodes
     file          Interact with Unix filesystem objects
+    files         Manipulate a mutable tree of files
+    dag           Export and import archives of DAGs
 
 ADVANCED COMMANDS
 
@@ -51,6 +53,7 @@ ADVANCED COMMANDS
     dns           Resolve DNS links
     pin           Pin objects to local storage
     repo gc       Garbage collect unpinned objects
+    filestore     Manage files added without copying them
 
 NETWORK COMMANDS
 
@@ -91,9 +94,12 @@ var rootSubcommands = map[string]*cmds.Command{
 	"cat":       CatCmd,
 	"commands":  CommandsDaemonCmd,
 	"config":    ConfigCmd,
+	"dag":       DagCmd,
 	"dht":       DhtCmd,
 	"diag":      DiagCmd,
 	"dns":       DNSCmd,
+	"filestore": FilestoreCmd,
+	"files":     FilesCmd,
 	"get":       GetCmd,
 	"id":        IDCmd,
 	"log":       LogCmd,
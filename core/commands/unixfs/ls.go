@@ -11,6 +11,7 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	path "github.com/ipfs/go-ipfs/path"
 	unixfs "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	unixfspb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
@@ -94,17 +95,22 @@ size is the IPFS link size.
 
 			output.Objects[hash] = &LsObject{
 				Hash: key.String(),
-				Type: t.String(),
+				Type: typeName(t),
 				Size: unixFSNode.GetFilesize(),
 			}
 
 			switch t {
 			case unixfspb.Data_File:
 				break
-			case unixfspb.Data_Directory:
-				links := make([]LsLink, len(merkleNode.Links))
+			case unixfspb.Data_Directory, unixfspb.Data_HAMTShard:
+				dirLinks, err := uio.Links(ctx, node.DAG, merkleNode)
+				if err != nil {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+				links := make([]LsLink, len(dirLinks))
 				output.Objects[hash].Links = links
-				for i, link := range merkleNode.Links {
+				for i, link := range dirLinks {
 					link.Node, err = link.GetNode(ctx, node.DAG)
 					if err != nil {
 						res.SetError(err, cmds.ErrNormal)
@@ -119,7 +125,7 @@ size is the IPFS link size.
 					lsLink := LsLink{
 						Name: link.Name,
 						Hash: link.Hash.B58String(),
-						Type: t.String(),
+						Type: typeName(t),
 					}
 					if t == unixfspb.Data_File {
 						lsLink.Size = d.GetFilesize()
@@ -197,3 +203,12 @@ size is the IPFS link size.
 	},
 	Type: LsOutput{},
 }
+
+// typeName names the type t, sharded directories are listed as plain
+// ones.
+func typeName(t unixfspb.Data_DataType) string {
+	if t == unixfspb.Data_HAMTShard {
+		return unixfspb.Data_Directory.String()
+	}
+	return t.String()
+}
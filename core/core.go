@@ -16,6 +16,7 @@ import (
 	"net"
 	"time"
 
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	b58 "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-base58"
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	ma "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multiaddr"
@@ -44,8 +45,10 @@ import (
 	bserv "github.com/ipfs/go-ipfs/blockservice"
 	exchange "github.com/ipfs/go-ipfs/exchange"
 	bitswap "github.com/ipfs/go-ipfs/exchange/bitswap"
+	decision "github.com/ipfs/go-ipfs/exchange/bitswap/decision"
 	bsnet "github.com/ipfs/go-ipfs/exchange/bitswap/network"
 	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
+	filestore "github.com/ipfs/go-ipfs/filestore"
 
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	ipnsfs "github.com/ipfs/go-ipfs/ipnsfs"
@@ -56,6 +59,7 @@ import (
 	pin "github.com/ipfs/go-ipfs/pin"
 	repo "github.com/ipfs/go-ipfs/repo"
 	config "github.com/ipfs/go-ipfs/repo/config"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -90,7 +94,8 @@ type IpfsNode struct {
 
 	// Services
 	Peerstore  peer.Peerstore       // storage for other Peer instances
-	Blockstore bstore.Blockstore    // the block store (lower level)
+	Blockstore bstore.GCBlockstore  // the block store (lower level)
+	Filestore  *filestore.Filestore // blocks referencing files outside the repo
 	Blocks     *bserv.BlockService  // the block service, get/add blocks.
 	DAG        merkledag.DAGService // the merkle dag service, get/add objects.
 	Resolver   *path.Resolver       // the path resolution system
@@ -108,7 +113,8 @@ type IpfsNode struct {
 	Reprovider   *rp.Reprovider // the value reprovider system
 	IpnsRepub    *ipnsrp.Republisher
 
-	IpnsFs *ipnsfs.Filesystem
+	IpnsFs    *ipnsfs.Filesystem
+	FilesRoot *ipnsfs.LocalRoot // the root of the 'ipfs files' tree
 
 	proc goprocess.Process
 	ctx  context.Context
@@ -166,9 +172,6 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 		return err
 	}
 
-	n.Reprovider = rp.NewReprovider(n.Routing, n.Blockstore)
-	go n.Reprovider.ProvideEvery(ctx, kReprovideFrequency)
-
 	// setup local discovery
 	if do != nil {
 		service, err := do(n.PeerHost)
@@ -224,7 +227,11 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	// setup exchange service
 	const alwaysSendToPeer = true // use YesManStrategy
 	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, n.Routing)
-	n.Exchange = bitswap.New(ctx, n.Identity, bitswapNetwork, n.Blockstore, alwaysSendToPeer)
+	bswap := bitswap.New(ctx, n.Identity, bitswapNetwork, n.Blockstore, alwaysSendToPeer).(*bitswap.Bitswap)
+	n.Exchange = bswap
+	if err := n.setupBitswap(bswap); err != nil {
+		return err
+	}
 
 	// setup name system
 	n.Namesys = namesys.NewNameSystem(n.Routing, n.Repo.Datastore())
@@ -238,6 +245,88 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	return nil
 }
 
+// setupBitswap applies the bitswap settings of the config, and carries the
+// ledgers kept with peers over from the previous runs.
+func (n *IpfsNode) setupBitswap(bs *bitswap.Bitswap) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	s, err := decision.StrategyByName(cfg.Bitswap.Strategy)
+	if err != nil {
+		return fmt.Errorf("failure to parse config setting Bitswap.Strategy: %s", err)
+	}
+	bs.SetStrategy(s)
+
+	limits, err := BitswapLimits(cfg.Bitswap)
+	if err != nil {
+		return err
+	}
+	bs.SetLimits(limits)
+
+	return bs.LoadLedgers(n.Repo.Datastore())
+}
+
+// setupReprovider starts announcing the blocks the node holds, picked and
+// as often as the config says.
+func (n *IpfsNode) setupReprovider(ctx context.Context) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	keyProvider, err := rp.NewStrategy(cfg.Reprovider.Strategy, n.Pinning, n.Blockstore)
+	if err != nil {
+		return fmt.Errorf("failure to parse config setting Reprovider.Strategy: %s", err)
+	}
+
+	interval := kReprovideFrequency
+	if cfg.Reprovider.Interval != "" {
+		interval, err = time.ParseDuration(cfg.Reprovider.Interval)
+		if err != nil {
+			return fmt.Errorf("failure to parse config setting Reprovider.Interval: %s", err)
+		}
+	}
+
+	n.Reprovider = rp.NewReprovider(n.Routing, keyProvider)
+	go n.Reprovider.ProvideEvery(ctx, interval)
+	return nil
+}
+
+// BitswapLimits reads the bandwidth limits of the bitswap config.
+func BitswapLimits(cfg config.Bitswap) (decision.Limits, error) {
+	var l decision.Limits
+	for _, lim := range []struct {
+		name string
+		val  string
+		dst  *uint64
+	}{
+		{"RateLimit", cfg.RateLimit, &l.Rate},
+		{"PeerRateLimit", cfg.PeerRateLimit, &l.PeerRate},
+		{"PeerMaxOutstanding", cfg.PeerMaxOutstanding, &l.PeerOutstanding},
+	} {
+		if lim.val == "" {
+			continue
+		}
+		v, err := humanize.ParseBytes(lim.val)
+		if err != nil {
+			return l, fmt.Errorf("failure to parse config setting Bitswap.%s: %q is not a size", lim.name, lim.val)
+		}
+		*lim.dst = v
+	}
+	return l, nil
+}
+
+// ReaderPrefetch reads from the bitswap config the number of blocks of a
+// file requested ahead of the one being read.
+func ReaderPrefetch(cfg config.Bitswap) int {
+	if cfg.Prefetch == 0 {
+		return uio.DefaultPrefetch
+	}
+	return cfg.Prefetch
+}
+
 func (n *IpfsNode) setupIpnsRepublisher() error {
 	cfg, err := n.Repo.Config()
 	if err != nil {
@@ -298,13 +387,17 @@ func (n *IpfsNode) teardown() error {
 	log.Debug("core is shutting down...")
 	// owned objects are closed in this teardown to ensure that they're closed
 	// regardless of which constructor was used to add them to the node.
-	closers := []io.Closer{
-		n.Repo,
-	}
+	var closers []io.Closer
 
+	// the files root and the bitswap ledgers are saved to the repo, which
+	// must still be open
+	if n.FilesRoot != nil {
+		closers = append(closers, n.FilesRoot)
+	}
 	if n.Exchange != nil {
 		closers = append(closers, n.Exchange)
 	}
+	closers = append(closers, n.Repo)
 
 	if n.Mounts.Ipfs != nil {
 		closers = append(closers, mount.Closer(n.Mounts.Ipfs))
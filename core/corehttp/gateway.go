@@ -19,6 +19,7 @@ type GatewayConfig struct {
 	Headers   map[string][]string
 	BlockList *BlockList
 	Writable  bool
+	Prefetch  int
 }
 
 func NewGateway(conf GatewayConfig) *Gateway {
@@ -36,6 +37,7 @@ func (g *Gateway) ServeOption() ServeOption {
 		}
 
 		g.Config.Headers = cfg.Gateway.HTTPHeaders
+		g.Config.Prefetch = core.ReaderPrefetch(cfg.Bitswap)
 
 		gateway, err := newGatewayHandler(n, g.Config)
 		if err != nil {
@@ -19,6 +19,7 @@ import (
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	"github.com/ipfs/go-ipfs/routing"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 )
 
@@ -46,6 +47,7 @@ func newGatewayHandler(node *core.IpfsNode, conf GatewayConfig) (*gatewayHandler
 func (i *gatewayHandler) newDagFromReader(r io.Reader) (*dag.Node, error) {
 	// TODO(cryptix): change and remove this helper once PR1136 is merged
 	// return ufs.AddFromReader(i.node, r.Body)
+	defer i.node.Blockstore.PinLock()()
 	return importer.BuildDagFromReader(
 		i.node.DAG,
 		chunk.DefaultSplitter(r),
@@ -144,6 +146,9 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		internalWebError(w, err)
 		return
 	}
+	if dr != nil {
+		dr.SetPrefetch(i.config.Prefetch)
+	}
 
 	// set these headers _after_ the error, for we may just not have it
 	// and dont want the client to cache a 500 response...
@@ -160,16 +165,29 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	if err == nil {
 		defer dr.Close()
+		if pbdata, err := ft.FromBytes(nd.Data); err == nil {
+			// the file was added with its modification time
+			if mtime, ok := ft.ModTime(pbdata); ok {
+				modtime = mtime
+			}
+		}
 		_, name := gopath.Split(urlPath)
 		http.ServeContent(w, r, name, modtime, dr)
 		return
 	}
 
+	// the entries of a sharded directory are spread over its shards
+	links, err := uio.Links(ctx, i.node.DAG, nd)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
 	// storage for directory listing
 	var dirListing []directoryItem
 	// loop through files
 	foundIndex := false
-	for _, link := range nd.Links {
+	for _, link := range links {
 		if link.Name == "index.html" {
 			log.Debugf("found index.html link for %s", urlPath)
 			foundIndex = true
@@ -193,6 +211,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 				return
 			}
 			defer dr.Close()
+			dr.SetPrefetch(i.config.Prefetch)
 
 			// write to request
 			if r.Method != "HEAD" {
@@ -353,7 +372,7 @@ func (i *gatewayHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	if _, ok := err.(path.ErrNoLink); ok {
 		// Create empty directories, links will be made further down the code
 		for len(pathNodes) < len(components) {
-			pathNodes = append(pathNodes, uio.NewDirectory(i.node.DAG).GetNode())
+			pathNodes = append(pathNodes, uio.NewEmptyDirectory())
 		}
 	} else if err != nil {
 		webError(w, "Could not resolve parent object", err, http.StatusBadRequest)
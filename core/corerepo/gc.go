@@ -1,28 +1,177 @@
 package corerepo
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
+	"github.com/ipfs/go-ipfs/blocks/set"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/filestore"
+	"github.com/ipfs/go-ipfs/merkledag"
+	repo "github.com/ipfs/go-ipfs/repo"
 
 	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 )
 
 var log = logging.Logger("corerepo")
 
+var ErrMaxStorageExceeded = errors.New("Maximum storage limit exceeded. Maybe unpin some files?")
+
 type KeyRemoved struct {
 	Key key.Key
 }
 
+// GC holds the storage quota settings of a node's repo.
+type GC struct {
+	Node       *core.IpfsNode
+	Repo       repo.Repo
+	StorageMax uint64 // zero means no limit
+	StorageGC  uint64 // usage above which a periodic GC collects garbage, zero without a StorageMax
+	Period     time.Duration
+}
+
+// NewGC reads the storage quota settings from the node's config.
+func NewGC(n *core.IpfsNode) (*GC, error) {
+	r := n.Repo
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	// repos initialized before these settings existed leave them empty
+	gcPeriod := cfg.Datastore.GCPeriod
+	if gcPeriod == "" {
+		gcPeriod = "1h"
+	}
+
+	var storageMax uint64
+	if cfg.Datastore.StorageMax != "" {
+		storageMax, err = humanize.ParseBytes(cfg.Datastore.StorageMax)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	watermark := cfg.Datastore.StorageGCWatermark
+	if watermark <= 0 || watermark > 100 {
+		watermark = 90
+	}
+	storageGC := storageMax * uint64(watermark) / 100
+
+	period, err := time.ParseDuration(gcPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GC{
+		Node:       n,
+		Repo:       r,
+		StorageMax: storageMax,
+		StorageGC:  storageGC,
+		Period:     period,
+	}, nil
+}
+
+// PinnedSet returns the keys of every block a garbage collection keeps:
+// the pinned objects, all the descendants of the recursive pins, the
+// objects the pin sets are stored in, and the 'ipfs files' tree. Only the
+// local blockstore is read. The files tree may link to objects that were
+// never fetched, its missing blocks are skipped.
+func PinnedSet(ctx context.Context, n *core.IpfsNode) (set.BlockSet, error) {
+	pinned := set.NewSimpleBlockSet()
+
+	// with bestEffort, the blocks missing from the blockstore are skipped
+	// rather than failing the walk. raw is set for raw blocks, which have
+	// nothing below.
+	var walk func(k key.Key, raw, bestEffort bool) error
+	walk = func(k key.Key, raw, bestEffort bool) error {
+		if pinned.HasKey(k) {
+			return nil
+		}
+		pinned.AddBlock(k)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b, err := n.Blockstore.Get(k)
+		switch err {
+		case nil:
+		case filestore.ErrFileChanged, filestore.ErrFileMissing:
+			// filestore blocks are leaves, there is nothing below
+			log.Warningf("pinned block %s is unreadable: %s", k, err)
+			return nil
+		case blockstore.ErrNotFound:
+			if bestEffort {
+				return nil
+			}
+			fallthrough
+		default:
+			return fmt.Errorf("cannot read pinned block %s: %s", k, err)
+		}
+		if raw {
+			return nil
+		}
+		nd, err := merkledag.Decoded(b.Data)
+		if err != nil {
+			return fmt.Errorf("cannot decode pinned block %s: %s", k, err)
+		}
+		for _, l := range nd.Links {
+			if err := walk(key.Key(l.Hash), l.Raw, bestEffort); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, k := range n.Pinning.RecursiveKeys() {
+		if err := walk(k, false, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range n.Pinning.DirectKeys() {
+		pinned.AddBlock(k)
+	}
+	for _, k := range n.Pinning.InternalPins() {
+		pinned.AddBlock(k)
+	}
+
+	// the 'ipfs files' tree isn't pinned, but is kept as well
+	if n.FilesRoot != nil {
+		nd, err := n.FilesRoot.GetValue().GetNode()
+		if err != nil {
+			return nil, err
+		}
+		k, err := n.DAG.Add(nd)
+		if err != nil {
+			return nil, err
+		}
+		if err := walk(k, false, true); err != nil {
+			return nil, err
+		}
+	}
+	return pinned, nil
+}
+
 func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel() // in case error occurs during operation
+	defer n.Blockstore.GCLock()()
+
+	pinned, err := PinnedSet(ctx, n)
+	if err != nil {
+		return err
+	}
 	keychan, err := n.Blockstore.AllKeysChan(ctx)
 	if err != nil {
 		return err
 	}
 	for k := range keychan { // rely on AllKeysChan to close chan
-		if !n.Pinning.IsPinned(k) {
+		if !pinned.HasKey(k) {
 			err := n.Blockstore.DeleteBlock(k)
 			if err != nil {
 				return err
@@ -32,23 +181,34 @@ func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	return nil
 }
 
+// GarbageCollectAsync removes the unpinned blocks, sending their keys on
+// the returned channel. The blockstore stays locked for garbage collection
+// until the channel is closed.
 func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemoved, error) {
+	unlock := n.Blockstore.GCLock()
 
+	pinned, err := PinnedSet(ctx, n)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
 	keychan, err := n.Blockstore.AllKeysChan(ctx)
 	if err != nil {
+		unlock()
 		return nil, err
 	}
 
 	output := make(chan *KeyRemoved)
 	go func() {
 		defer close(output)
+		defer unlock()
 		for {
 			select {
 			case k, ok := <-keychan:
 				if !ok {
 					return
 				}
-				if !n.Pinning.IsPinned(k) {
+				if !pinned.HasKey(k) {
 					err := n.Blockstore.DeleteBlock(k)
 					if err != nil {
 						log.Debugf("Error removing key from blockstore: %s", err)
@@ -66,3 +226,94 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemo
 	}()
 	return output, nil
 }
+
+// PeriodicGC runs a garbage collection every GCPeriod whenever the repo
+// has grown past the StorageGCWatermark. It returns when ctx is done, or
+// right away if no StorageMax is set.
+func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
+	gc, err := NewGC(node)
+	if err != nil {
+		return err
+	}
+	if gc.StorageMax == 0 {
+		log.Warning("Datastore.StorageMax is not set, periodic GC is off")
+		return nil
+	}
+	if gc.Period == 0 {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(gc.Period):
+			if err := gc.maybeGC(ctx); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// CheckStorage returns ErrMaxStorageExceeded if writing offset more bytes
+// would take the repo over its StorageMax. Commands checking more than once
+// use GC.CheckStorage, not to read the config every time.
+func CheckStorage(node *core.IpfsNode, offset uint64) error {
+	gc, err := NewGC(node)
+	if err != nil {
+		return err
+	}
+	return gc.CheckStorage(offset)
+}
+
+// CheckStorage returns ErrMaxStorageExceeded if writing offset more bytes
+// would take the repo over its StorageMax.
+func (gc *GC) CheckStorage(offset uint64) error {
+	if gc.StorageMax == 0 {
+		return nil
+	}
+
+	storage, err := gc.Repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+	if storage+offset > gc.StorageMax {
+		return ErrMaxStorageExceeded
+	}
+	return nil
+}
+
+func (gc *GC) maybeGC(ctx context.Context) error {
+	storage, err := gc.Repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+
+	if storage <= gc.StorageGC {
+		return nil
+	}
+
+	// Do GC here
+	log.Info("Storage watermark crossed, starting garbage collection...")
+	start := time.Now()
+	if err := GarbageCollect(gc.Node, ctx); err != nil {
+		return err
+	}
+
+	newStorage, err := gc.Repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+	var freed uint64
+	if newStorage < storage {
+		freed = storage - newStorage
+	}
+	log.Infof("Repo GC done in %s, freed %s (now using %s)",
+		time.Since(start),
+		humanize.Bytes(freed),
+		humanize.Bytes(newStorage))
+	if newStorage > gc.StorageGC {
+		log.Warningf("Repo usage is still above the GC watermark after collection, pin fewer objects or raise Datastore.StorageMax")
+	}
+	return nil
+}
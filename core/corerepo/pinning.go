@@ -25,6 +25,10 @@ import (
 )
 
 func Pin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool) ([]key.Key, error) {
+	// the objects may still be fetched, don't let a GC remove them before
+	// they are pinned
+	defer n.Blockstore.PinLock()()
+
 	dagnodes := make([]*merkledag.Node, 0)
 	for _, fpath := range paths {
 		dagnode, err := core.Resolve(ctx, n, path.Path(fpath))
@@ -59,6 +63,9 @@ func Pin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool)
 }
 
 func Unpin(n *core.IpfsNode, ctx context.Context, paths []string, recursive bool) ([]key.Key, error) {
+	// flushing writes the new pin sets, which are unknown to a GC running
+	// meanwhile
+	defer n.Blockstore.PinLock()()
 
 	dagnodes := make([]*merkledag.Node, 0)
 	for _, fpath := range paths {
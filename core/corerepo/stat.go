@@ -0,0 +1,119 @@
+package corerepo
+
+import (
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/core"
+	repo "github.com/ipfs/go-ipfs/repo"
+)
+
+// Stat summarizes the contents of a node's repo.
+type Stat struct {
+	NumObjects     uint64
+	BlockBytes     uint64 // total size of the blocks stored in the datastore
+	FilestoreBytes uint64 // size of the filestore blocks, kept outside the repo
+	PinnedBytes    uint64 // size of the blocks protected from GC
+	UnpinnedBytes  uint64 // size of the blocks a GC would remove
+	RepoSize       uint64 // space taken by the repo on disk
+	// DatastoreOverhead is the space taken by anything that is not block
+	// data: indexes, pin sets, filesystem slack, ... Blocks kept outside
+	// the repo directory, in the filestore or on S3, are not part of it.
+	DatastoreOverhead uint64
+	RepoPath          string
+	Version           string
+	Namespaces        []repo.NamespaceStat
+}
+
+// RepoStat walks every block in the blockstore to compute the repo
+// statistics. It reads all block data, so it can take a while. RepoSize
+// is walked on disk right before, the blocks written meanwhile may make
+// the overhead look smaller than it is.
+func RepoStat(n *core.IpfsNode, ctx context.Context) (*Stat, error) {
+	rs, err := n.Repo.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st := &Stat{
+		RepoSize:   rs.StorageUsage,
+		RepoPath:   rs.Path,
+		Version:    "fs-repo@" + rs.Version,
+		Namespaces: rs.Namespaces,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pinned, err := PinnedSet(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := n.Repo.Datastore()
+	for k := range keys {
+		// sizes are taken from the datastore, so corrupt blocks are
+		// accounted for too.
+		var size uint64
+		v, err := d.Get(bstore.BlockPrefix.Child(k.DsKey()))
+		switch err {
+		case nil:
+			data, ok := v.([]byte)
+			if !ok {
+				return nil, bstore.ValueTypeMismatch
+			}
+			size = uint64(len(data))
+			st.BlockBytes += size
+		case ds.ErrNotFound:
+			if n.Filestore == nil {
+				continue
+			}
+			ref, err := n.Filestore.Ref(k)
+			if err != nil {
+				// the block may have been removed since we listed it.
+				continue
+			}
+			size = ref.Size
+			st.FilestoreBytes += size
+		default:
+			return nil, err
+		}
+
+		st.NumObjects++
+		if pinned.HasKey(k) {
+			st.PinnedBytes += size
+		} else {
+			st.UnpinnedBytes += size
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	inRepo := st.BlockBytes
+	if blocksRemote(st.Namespaces) {
+		inRepo = 0
+	}
+	if st.RepoSize > inRepo {
+		st.DatastoreOverhead = st.RepoSize - inRepo
+	}
+	return st, nil
+}
+
+// blocksRemote returns true if the blocks are kept in a datastore outside
+// the repo directory.
+func blocksRemote(namespaces []repo.NamespaceStat) bool {
+	var mount repo.NamespaceStat
+	for _, ns := range namespaces {
+		p := ds.NewKey(ns.Prefix)
+		if !p.Equal(bstore.BlockPrefix) && !p.IsAncestorOf(bstore.BlockPrefix) {
+			continue
+		}
+		if len(ns.Prefix) >= len(mount.Prefix) {
+			mount = ns
+		}
+	}
+	return mount.Type == "s3"
+}
@@ -0,0 +1,115 @@
+package corerepo
+
+import (
+	"fmt"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	"github.com/ipfs/go-ipfs/core"
+)
+
+// QuarantinePrefix is where corrupt blocks found by Verify are moved to, so
+// they can be inspected later without being served to anyone.
+var QuarantinePrefix = ds.NewKey("/local/quarantine")
+
+// refetchTimeout bounds how long Verify waits for the network to deliver a
+// fresh copy of a corrupt block.
+var refetchTimeout = time.Minute
+
+// VerifyProgress reports on a running Verify. Key and Msg are set for
+// corrupt blocks, Progress counts the blocks checked so far.
+type VerifyProgress struct {
+	Key      string `json:",omitempty"`
+	Msg      string `json:",omitempty"`
+	Progress int
+}
+
+// Verify re-hashes every block in the blockstore and moves the ones whose
+// data does not match their key into quarantine. If refetch is true, a
+// fresh copy of each corrupt block is requested from the exchange.
+func Verify(ctx context.Context, n *core.IpfsNode, refetch bool) (<-chan *VerifyProgress, error) {
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := n.Repo.Datastore()
+	out := make(chan *VerifyProgress)
+	go func() {
+		defer close(out)
+		send := func(p *VerifyProgress) bool {
+			select {
+			case out <- p:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var checked int
+		for k := range keys {
+			checked++
+			msg, err := verifyBlock(ctx, n, d, k, refetch)
+			if err != nil {
+				msg = fmt.Sprintf("could not check block %s: %s", k, err)
+			}
+			if msg != "" && !send(&VerifyProgress{Key: k.B58String(), Msg: msg}) {
+				return
+			}
+			if checked%100 == 0 && !send(&VerifyProgress{Progress: checked}) {
+				return
+			}
+		}
+		send(&VerifyProgress{Progress: checked})
+	}()
+	return out, nil
+}
+
+// verifyBlock checks a single block, returning a message describing what was
+// done if it turned out to be corrupt.
+func verifyBlock(ctx context.Context, n *core.IpfsNode, d ds.Datastore, k key.Key, refetch bool) (string, error) {
+	// read straight from the datastore, the blockstore may refuse to hand
+	// out a corrupt block when it hashes on read.
+	dsk := bstore.BlockPrefix.Child(k.DsKey())
+	v, err := d.Get(dsk)
+	if err == ds.ErrNotFound && n.Filestore != nil {
+		// blocks referencing files are checked by 'ipfs filestore verify'
+		if _, ferr := n.Filestore.Ref(k); ferr == nil {
+			return "", nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	data, ok := v.([]byte)
+	if !ok {
+		return "", bstore.ValueTypeMismatch
+	}
+
+	if err := blocks.VerifyHash(data, mh.Multihash(k)); err == nil {
+		return "", nil
+	}
+
+	if err := d.Put(QuarantinePrefix.Child(k.DsKey()), data); err != nil {
+		return "", err
+	}
+	if err := n.Blockstore.DeleteBlock(k); err != nil {
+		return "", err
+	}
+	msg := fmt.Sprintf("block %s was corrupt, moved to quarantine", k)
+
+	if !refetch {
+		return msg, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, refetchTimeout)
+	defer cancel()
+	if _, err := n.Blocks.GetBlock(ctx, k); err != nil {
+		return msg + fmt.Sprintf(", refetch failed: %s", err), nil
+	}
+	return msg + ", refetched", nil
+}
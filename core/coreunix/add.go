@@ -1,21 +1,28 @@
 package coreunix
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	gopath "path"
+	"path/filepath"
+	"time"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	"github.com/ipfs/go-ipfs/commands/files"
 	core "github.com/ipfs/go-ipfs/core"
 	importer "github.com/ipfs/go-ipfs/importer"
+	balanced "github.com/ipfs/go-ipfs/importer/balanced"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	helpers "github.com/ipfs/go-ipfs/importer/helpers"
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/pin"
 	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 	unixfs "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 )
 
 var log = logging.Logger("coreunix")
@@ -24,6 +31,7 @@ var log = logging.Logger("coreunix")
 // datastore. Returns a key representing the root node.
 func Add(n *core.IpfsNode, r io.Reader) (string, error) {
 	// TODO more attractive function signature importer.BuildDagFromReader
+	defer n.Blockstore.PinLock()()
 
 	dagNode, err := importer.BuildDagFromReader(
 		n.DAG,
@@ -41,8 +49,42 @@ func Add(n *core.IpfsNode, r io.Reader) (string, error) {
 	return k.String(), nil
 }
 
+// AddOptions selects how AddROptions adds files. The zero value adds them
+// the way AddR does.
+type AddOptions struct {
+	// NoCopy leaves the data of the files where it is, the node's
+	// filestore only references it.
+	NoCopy bool
+
+	// PreserveMode and PreserveMtime record the permission bits and the
+	// modification time of the files and directories. They change the
+	// hashes, so they are off unless asked for.
+	PreserveMode  bool
+	PreserveMtime bool
+}
+
 // AddR recursively adds files in |path|.
 func AddR(n *core.IpfsNode, root string) (key string, err error) {
+	return AddROptions(n, root, AddOptions{})
+}
+
+// AddRNoCopy recursively adds files in |path| like AddR, but leaves their
+// data where it is: the node's filestore only references it.
+func AddRNoCopy(n *core.IpfsNode, root string) (key string, err error) {
+	return AddROptions(n, root, AddOptions{NoCopy: true})
+}
+
+// AddROptions recursively adds files in |path| as selected by opts.
+func AddROptions(n *core.IpfsNode, root string, opts AddOptions) (key string, err error) {
+	if opts.NoCopy {
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	defer n.Blockstore.PinLock()()
+
 	stat, err := os.Lstat(root)
 	if err != nil {
 		return "", err
@@ -54,7 +96,7 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 	}
 	defer f.Close()
 
-	dagnode, err := addFile(n, f)
+	dagnode, err := addFile(n, f, opts)
 	if err != nil {
 		return "", err
 	}
@@ -64,7 +106,7 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 		return "", err
 	}
 
-	n.Pinning.GetManual().RemovePinWithMode(k, pin.Indirect)
+	n.Pinning.GetManual().PinWithMode(k, pin.Recursive)
 	if err := n.Pinning.Flush(); err != nil {
 		return "", err
 	}
@@ -77,9 +119,11 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 // Returns the path of the added file ("<dir hash>/filename"), the DAG node of
 // the directory, and and error if any.
 func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *merkledag.Node, error) {
+	defer n.Blockstore.PinLock()()
+
 	file := files.NewReaderFile(filename, filename, ioutil.NopCloser(r), nil)
 	dir := files.NewSliceFile("", "", []files.File{file})
-	dagnode, err := addDir(n, dir)
+	dagnode, err := addDir(n, dir, AddOptions{})
 	if err != nil {
 		return "", nil, err
 	}
@@ -90,14 +134,32 @@ func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *merkle
 	return gopath.Join(k.String(), filename), dagnode, nil
 }
 
-func add(n *core.IpfsNode, reader io.Reader) (*merkledag.Node, error) {
-	mp := n.Pinning.GetManual()
+func add(n *core.IpfsNode, reader io.Reader, refs helpers.LeafRefs, info func([]byte) ([]byte, error)) (*merkledag.Node, error) {
+	dbp := helpers.DagBuilderParams{
+		Dagserv:  n.DAG,
+		Maxlinks: helpers.DefaultLinksPerBlock,
+		LeafRefs: refs,
+		RootData: info,
+	}
+	return balanced.BalancedLayout(dbp.New(chunk.Chan(chunk.DefaultSplitter(reader))))
+}
 
-	return importer.BuildDagFromReader(
-		n.DAG,
-		chunk.DefaultSplitter(reader),
-		importer.PinIndirectCB(mp),
-	)
+// FileRefs returns the LeafRefs to add file without copying its data into
+// the blockstore. The file has to be on the node's filesystem, and stay
+// there unmodified for its blocks to remain readable.
+func FileRefs(n *core.IpfsNode, file files.File) (helpers.LeafRefs, error) {
+	if n.Filestore == nil {
+		return nil, errors.New("node has no filestore, cannot add without copying")
+	}
+	fpath := file.FullPath()
+	if fpath == "" {
+		return nil, fmt.Errorf("cannot add %q without copying, its path is unknown", file.FileName())
+	}
+	abs, err := filepath.Abs(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return n.Filestore.NewFileRefs(abs)
 }
 
 func addNode(n *core.IpfsNode, node *merkledag.Node) error {
@@ -110,16 +172,69 @@ func addNode(n *core.IpfsNode, node *merkledag.Node) error {
 	return err
 }
 
-func addFile(n *core.IpfsNode, file files.File) (*merkledag.Node, error) {
+// SetFileInfo records the mode and the modification time of file in nd,
+// as asked for, and returns the resulting node. The node is not added.
+// Files without stat information, like the ones read from stdin, are left
+// as they are.
+func SetFileInfo(nd *merkledag.Node, file files.File, mode, mtime bool) (*merkledag.Node, error) {
+	info := FileInfo(file, mode, mtime)
+	if info == nil {
+		return nd, nil
+	}
+	data, err := info(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+	out := nd.Copy()
+	out.Data = data
+	return out, nil
+}
+
+// FileInfo returns the function recording the mode and the modification
+// time of file in unixfs data, as asked for, to set on the root of the file
+// while it is built. It returns nil when there is nothing to record.
+func FileInfo(file files.File, mode, mtime bool) func([]byte) ([]byte, error) {
+	sf, ok := file.(files.StatFile)
+	if !ok || sf.Stat() == nil || !(mode || mtime) {
+		return nil
+	}
+	if _, link := file.(*files.Symlink); link {
+		return nil
+	}
+
+	var m os.FileMode
+	var t time.Time
+	if mode {
+		m = sf.Stat().Mode()
+	}
+	if mtime {
+		t = sf.Stat().ModTime()
+	}
+	return func(data []byte) ([]byte, error) {
+		return unixfs.SetFileInfo(data, m, t)
+	}
+}
+
+func addFile(n *core.IpfsNode, file files.File, opts AddOptions) (*merkledag.Node, error) {
 	if file.IsDirectory() {
-		return addDir(n, file)
+		return addDir(n, file, opts)
 	}
-	return add(n, file)
+
+	var refs helpers.LeafRefs
+	if _, link := file.(*files.Symlink); !link && opts.NoCopy {
+		var err error
+		refs, err = FileRefs(n, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return add(n, file, refs, FileInfo(file, opts.PreserveMode, opts.PreserveMtime))
 }
 
-func addDir(n *core.IpfsNode, dir files.File) (*merkledag.Node, error) {
+func addDir(n *core.IpfsNode, dir files.File, opts AddOptions) (*merkledag.Node, error) {
 
-	tree := &merkledag.Node{Data: unixfs.FolderPBData()}
+	// large directories are sharded
+	tree := uio.NewDirectory(n.DAG)
 
 Loop:
 	for {
@@ -131,20 +246,28 @@ Loop:
 			break Loop
 		}
 
-		node, err := addFile(n, file)
+		node, err := addFile(n, file, opts)
 		if err != nil {
 			return nil, err
 		}
 
 		_, name := gopath.Split(file.FileName())
 
-		if err := tree.AddNodeLink(name, node); err != nil {
+		if err := tree.AddChildNode(n.Context(), name, node); err != nil {
 			return nil, err
 		}
 	}
 
-	if err := addNode(n, tree); err != nil {
+	nd, err := tree.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	nd, err = SetFileInfo(nd, dir, opts.PreserveMode, opts.PreserveMtime)
+	if err != nil {
+		return nil, err
+	}
+	if err := addNode(n, nd); err != nil {
 		return nil, err
 	}
-	return tree, nil
+	return nd, nil
 }
@@ -1,22 +1,32 @@
 package coreunix
 
 import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks/key"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/config"
+	"github.com/ipfs/go-ipfs/thirdparty/tar"
+	"github.com/ipfs/go-ipfs/unixfs"
+	"github.com/ipfs/go-ipfs/unixfs/archive"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	u "github.com/ipfs/go-ipfs/util"
 	"github.com/ipfs/go-ipfs/util/testutil"
 )
 
-func TestAddRecursive(t *testing.T) {
-	here, err := os.Getwd()
-	if err != nil {
-		t.Fatal(err)
-	}
+func testNode(t *testing.T) *core.IpfsNode {
 	r := &repo.Mock{
 		C: config.Config{
 			Identity: config.Identity{
@@ -29,9 +39,245 @@ func TestAddRecursive(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	return node
+}
+
+func TestAddRecursive(t *testing.T) {
+	here, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := testNode(t)
 	if k, err := AddR(node, path.Join(here, "test_data")); err != nil {
 		t.Fatal(err)
 	} else if k != "QmWCCga8AbTyfAQ7pTnGT6JgmRMAB3Qp8ZmTEFi5q5o8jC" {
 		t.Fatal("keys do not match")
 	}
 }
+
+func TestAddGCLive(t *testing.T) {
+	node := testNode(t)
+
+	// an add stuck halfway through its input
+	pr, pw := io.Pipe()
+	type result struct {
+		k   string
+		err error
+	}
+	added := make(chan result)
+	go func() {
+		k, err := Add(node, pr)
+		added <- result{k, err}
+	}()
+
+	data := make([]byte, 1024*1024)
+	u.NewTimeSeededRand().Read(data)
+	if _, err := pw.Write(data[:len(data)/2]); err != nil {
+		t.Fatal(err)
+	}
+
+	gcDone := make(chan error)
+	go func() {
+		gcDone <- corerepo.GarbageCollect(node, context.Background())
+	}()
+
+	select {
+	case <-gcDone:
+		t.Fatal("gc ran while an add was in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := pw.Write(data[len(data)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+
+	res := <-added
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if err := <-gcDone; err != nil {
+		t.Fatal(err)
+	}
+
+	// everything the add wrote must have survived the gc
+	var check func(k key.Key)
+	check = func(k key.Key) {
+		b, err := node.Blockstore.Get(k)
+		if err != nil {
+			t.Fatalf("block %s of the added file was removed: %s", k, err)
+		}
+		nd, err := merkledag.Decoded(b.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range nd.Links {
+			check(key.Key(l.Hash))
+		}
+	}
+	check(key.B58KeyDecode(res.k))
+}
+
+func TestAddPreserveFileInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coreunix-add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0750); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(src, "run.sh")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ro := filepath.Join(src, "ro")
+	if err := os.Mkdir(ro, 0500); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1234567890, 0)
+	for _, p := range []string{exe, ro, src} {
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := testNode(t)
+	plain, err := AddR(node, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := AddROptions(node, src, AddOptions{PreserveMode: true, PreserveMtime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k == plain {
+		t.Fatal("recording the mode and mtime should change the hash")
+	}
+	again, err := AddR(node, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != plain {
+		t.Fatal("adding without recording them should not change the hash")
+	}
+
+	nd, err := node.DAG.Get(context.Background(), key.B58KeyDecode(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := archive.DagArchive(context.Background(), nd, "out", node.DAG, false, gzip.NoCompression, uio.DefaultPrefetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := (&tar.Extractor{Path: out}).Extract(r); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, mode := range map[string]os.FileMode{
+		out:                          0750,
+		filepath.Join(out, "run.sh"): 0700,
+		filepath.Join(out, "ro"):     0500,
+	} {
+		st, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != mode {
+			t.Fatalf("%s: expected mode %s, got %s", p, mode, st.Mode().Perm())
+		}
+		if !st.ModTime().Equal(mtime) {
+			t.Fatalf("%s: expected mtime %s, got %s", p, mtime, st.ModTime())
+		}
+	}
+}
+
+func TestAddFileInfoNoPlainRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coreunix-add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("some data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := AddR(testNode(t), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := testNode(t)
+	k, err := AddROptions(node, file, AddOptions{PreserveMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k == plain {
+		t.Fatal("recording the mode should change the hash")
+	}
+	has, err := node.Blockstore.Has(key.B58KeyDecode(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("the root without the file info should not be stored")
+	}
+}
+
+func TestAddSharded(t *testing.T) {
+	old := uio.ShardSplitThreshold
+	uio.ShardSplitThreshold = 5
+	defer func() { uio.ShardSplitThreshold = old }()
+
+	dir, err := ioutil.TempDir("", "coreunix-add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		name := filepath.Join(src, fmt.Sprintf("file%d", i))
+		if err := ioutil.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := testNode(t)
+	k, err := AddR(node, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := node.DAG.Get(context.Background(), key.B58KeyDecode(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbd, err := unixfs.FromBytes(nd.Data); err != nil || pbd.GetType() != unixfs.THAMTShard {
+		t.Fatal("large directory was not sharded")
+	}
+
+	r, err := archive.DagArchive(context.Background(), nd, "out", node.DAG, false, gzip.NoCompression, uio.DefaultPrefetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := (&tar.Extractor{Path: out}).Extract(r); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		data, err := ioutil.ReadFile(filepath.Join(out, fmt.Sprintf("file%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != filepath.Join(src, fmt.Sprintf("file%d", i)) {
+			t.Fatal("file content differs after get")
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	process "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/goprocess"
 	procctx "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/goprocess/context"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
@@ -64,16 +66,17 @@ func New(parent context.Context, p peer.ID, network bsnet.BitSwapNetwork,
 	ctx, cancelFunc := context.WithCancel(parent)
 
 	notif := notifications.New()
+	engine := decision.NewEngine(ctx, bstore)
 	px := process.WithTeardown(func() error {
 		notif.Shutdown()
-		return nil
+		return engine.Close()
 	})
 
 	bs := &Bitswap{
 		self:          p,
 		blockstore:    bstore,
 		notifications: notif,
-		engine:        decision.NewEngine(ctx, bstore), // TODO close the engine with Close() method
+		engine:        engine,
 		network:       network,
 		findKeys:      make(chan *blockRequest, sizeBatchRequestChan),
 		process:       px,
@@ -132,6 +135,12 @@ type Bitswap struct {
 	blocksRecvd    int
 	dupBlocksRecvd int
 	dupDataRecvd   uint64
+	blocksSent     int
+	dataSent       uint64
+
+	// the running sessions, told about the blocks they want
+	sessLk   sync.Mutex
+	sessions []*Session
 }
 
 type blockRequest struct {
@@ -142,6 +151,11 @@ type blockRequest struct {
 // GetBlock attempts to retrieve a particular block from peers within the
 // deadline enforced by the context.
 func (bs *Bitswap) GetBlock(parent context.Context, k key.Key) (*blocks.Block, error) {
+	return getBlock(parent, k, bs.GetBlocks)
+}
+
+// getBlock gets the block for k through getBlocks, as GetBlock does.
+func getBlock(parent context.Context, k key.Key, getBlocks func(context.Context, []key.Key) (<-chan *blocks.Block, error)) (*blocks.Block, error) {
 
 	// Any async work initiated by this function must end when this function
 	// returns. To ensure this, derive a new context. Note that it is okay to
@@ -160,7 +174,7 @@ func (bs *Bitswap) GetBlock(parent context.Context, k key.Key) (*blocks.Block, e
 		cancelFunc()
 	}()
 
-	promise, err := bs.GetBlocks(ctx, []key.Key{k})
+	promise, err := getBlocks(ctx, []key.Key{k})
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +195,28 @@ func (bs *Bitswap) GetBlock(parent context.Context, k key.Key) (*blocks.Block, e
 	}
 }
 
+// SetStrategy sets how the peers wanting blocks from us are ranked.
+func (bs *Bitswap) SetStrategy(s decision.Strategy) {
+	bs.engine.SetStrategy(s)
+}
+
+// SetLimits sets the bandwidth limits of serving blocks to peers.
+func (bs *Bitswap) SetLimits(l decision.Limits) {
+	bs.engine.SetLimits(l)
+}
+
+// LoadLedgers reads the ledgers kept with peers from d, and saves them to
+// it from then on.
+func (bs *Bitswap) LoadLedgers(d ds.Datastore) error {
+	return bs.engine.LoadLedgers(d)
+}
+
+// LedgerForPeer returns a summary of the ledger kept with p, nil if there
+// was no exchange with p.
+func (bs *Bitswap) LedgerForPeer(p peer.ID) *decision.Receipt {
+	return bs.engine.LedgerForPeer(p)
+}
+
 func (bs *Bitswap) WantlistForPeer(p peer.ID) []key.Key {
 	var out []key.Key
 	for _, e := range bs.engine.WantlistForPeer(p) {
@@ -199,7 +235,7 @@ func (bs *Bitswap) WantlistForPeer(p peer.ID) []key.Key {
 func (bs *Bitswap) GetBlocks(ctx context.Context, keys []key.Key) (<-chan *blocks.Block, error) {
 	select {
 	case <-bs.process.Closing():
-		return nil, errors.New("bitswap is closed")
+		return nil, errBitswapClosed
 	default:
 	}
 	promise := bs.notifications.Subscribe(ctx, keys...)
@@ -232,7 +268,7 @@ func (bs *Bitswap) CancelWants(ks []key.Key) {
 func (bs *Bitswap) HasBlock(blk *blocks.Block) error {
 	select {
 	case <-bs.process.Closing():
-		return errors.New("bitswap is closed")
+		return errBitswapClosed
 	default:
 	}
 
@@ -298,7 +334,12 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 	// TODO: this is bad, and could be easily abused.
 	// Should only track *useful* messages in ledger
 
-	iblocks := incoming.Blocks()
+	bs.answerWantHaves(p, incoming)
+	if haves, dontHaves := incoming.Haves(), incoming.DontHaves(); len(haves) > 0 || len(dontHaves) > 0 {
+		bs.wm.ReceivePresences(p, haves, dontHaves)
+	}
+
+	iblocks := bs.rehashWanted(incoming.Blocks())
 
 	if len(iblocks) == 0 {
 		return
@@ -311,6 +352,9 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 			log.Info("received un-asked-for block: %s", block)
 			continue
 		}
+		if t, ok := bs.wm.requestedAt(p, block.Key()); ok {
+			bs.engine.RecordLatency(p, time.Since(t))
+		}
 		keys = append(keys, block.Key())
 	}
 	bs.wm.CancelWants(keys)
@@ -329,16 +373,82 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 			log.Event(ctx, "Bitswap.GetBlockRequest.End", &k)
 
 			log.Debugf("got block %s from %s", b, p)
+			sessions := bs.sessionsWanting(k)
 			if err := bs.HasBlock(b); err != nil {
 				log.Warningf("ReceiveMessage HasBlock error: %s", err)
+				return
+			}
+			for _, s := range sessions {
+				s.receiveFrom(p, b)
 			}
 		}(block)
 	}
 	wg.Wait()
 }
 
+// answerWantHaves tells p which of the blocks it asked about we have. The
+// engine only serves want-block entries.
+func (bs *Bitswap) answerWantHaves(p peer.ID, m bsmsg.BitSwapMessage) {
+	var haves, dontHaves []key.Key
+	for _, e := range m.Wantlist() {
+		if e.Cancel || !e.WantHave {
+			continue
+		}
+		has, err := bs.blockstore.Has(e.Key)
+		switch {
+		case err == nil && has:
+			haves = append(haves, e.Key)
+		case e.SendDontHave:
+			dontHaves = append(dontHaves, e.Key)
+		}
+	}
+	if len(haves) > 0 || len(dontHaves) > 0 {
+		bs.wm.SendPresences(p, haves, dontHaves)
+	}
+}
+
+// rehashWanted returns in, with the blocks we want under another hash
+// function than sha2-256 keyed by it. Messages carry just the data of
+// the blocks, which are keyed by its sha2-256 hash when they arrive. A
+// block is only rekeyed when hashing it with the function of a key we
+// want gives that key, so it is verified as well.
+func (bs *Bitswap) rehashWanted(in []*blocks.Block) []*blocks.Block {
+	type hashFunc struct{ code, length int }
+	funcs := make(map[hashFunc]struct{})
+	for _, e := range bs.wm.wl.Entries() {
+		dec, err := mh.Decode(mh.Multihash(e.Key))
+		if err != nil || dec.Code == mh.SHA2_256 && dec.Length == 32 {
+			continue
+		}
+		funcs[hashFunc{dec.Code, dec.Length}] = struct{}{}
+	}
+	if len(funcs) == 0 {
+		return in
+	}
+
+	out := make([]*blocks.Block, 0, len(in))
+	for _, b := range in {
+		if _, found := bs.wm.wl.Contains(b.Key()); !found {
+			for f := range funcs {
+				h, err := mh.Sum(b.Data, f.code, f.length)
+				if err != nil {
+					continue
+				}
+				if _, found := bs.wm.wl.Contains(key.Key(h)); found {
+					b = &blocks.Block{Data: b.Data, Multihash: h}
+					break
+				}
+			}
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
 var ErrAlreadyHaveBlock = errors.New("already have block")
 
+var errBitswapClosed = errors.New("bitswap is closed")
+
 func (bs *Bitswap) updateReceiveCounters(b *blocks.Block) error {
 	bs.counterLk.Lock()
 	defer bs.counterLk.Unlock()
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	detectrace "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-detect-race"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	travis "github.com/ipfs/go-ipfs/util/testutil/ci/travis"
 
@@ -283,3 +284,96 @@ func TestBasicBitswap(t *testing.T) {
 		}
 	}
 }
+
+func TestGetBlockOtherHashFunc(t *testing.T) {
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(kNetworkDelay))
+	sg := NewTestSessionGenerator(net)
+	defer sg.Close()
+
+	instances := sg.Instances(2)
+	blk, err := blocks.NewBlockWithHashFunc([]byte("hashed with sha3"), mh.SHA3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := instances[0].Exchange.HasBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	out, err := instances[1].Exchange.GetBlock(ctx, blk.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Key() != blk.Key() || !bytes.Equal(out.Data, blk.Data) {
+		t.Fatal("got a different block")
+	}
+	if has, _ := instances[1].Blockstore().Has(blk.Key()); !has {
+		t.Fatal("received block not stored under its sha3 key")
+	}
+}
+
+func TestWantHaveAvoidsDuplicates(t *testing.T) {
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(kNetworkDelay))
+	sg := NewTestSessionGenerator(net)
+	defer sg.Close()
+	bg := blocksutil.NewBlockGenerator()
+
+	instances := sg.Instances(4)
+	blks := bg.Blocks(5)
+	for _, inst := range instances[:3] {
+		for _, b := range blks {
+			if err := inst.Exchange.HasBlock(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for _, b := range blks {
+		if _, err := instances[3].Exchange.GetBlock(ctx, b.Key()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := instances[3].Exchange.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.BlocksReceived != len(blks) {
+		t.Fatalf("received %d blocks, wanted %d", st.BlocksReceived, len(blks))
+	}
+	if st.DupBlksReceived != 0 {
+		t.Fatalf("received %d duplicate blocks", st.DupBlksReceived)
+	}
+}
+
+func TestOneZeroPeers(t *testing.T) {
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(kNetworkDelay))
+	sg := NewTestSessionGenerator(net)
+	defer sg.Close()
+	bg := blocksutil.NewBlockGenerator()
+
+	instances := sg.Instances(2)
+	tn.SetOneZero(net, instances[0].Peer)
+	blks := bg.Blocks(2)
+
+	if err := instances[0].Exchange.HasBlock(blks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := instances[1].Exchange.HasBlock(blks[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// each side gets the block of the other over the old protocol
+	if _, err := instances[1].Exchange.GetBlock(ctx, blks[0].Key()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instances[0].Exchange.GetBlock(ctx, blks[1].Key()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,8 +2,13 @@
 package decision
 
 import (
+	"encoding/json"
+	"errors"
 	"sync"
+	"time"
 
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	blocks "github.com/ipfs/go-ipfs/blocks"
 	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
@@ -50,6 +55,21 @@ const (
 	outboxChanBuffer = 0
 )
 
+// LedgerPrefix is the datastore prefix under which ledgers are saved.
+var LedgerPrefix = ds.NewKey("/local/bitswap/ledgers")
+
+// ledgerSaveInterval is how often the ledgers that changed are saved, and
+// the idle ones dropped.
+var ledgerSaveInterval = time.Minute
+
+// ledgerIdleTimeout is how long the ledger of a peer that wants nothing is
+// kept after the last exchange with it.
+var ledgerIdleTimeout = 10 * time.Minute
+
+// ledgerExpiry is how long a saved ledger is kept after the last exchange
+// with its peer.
+var ledgerExpiry = 30 * 24 * time.Hour
+
 // Envelope contains a message for a Peer
 type Envelope struct {
 	// Peer is the intended recipient
@@ -81,9 +101,21 @@ type Engine struct {
 
 	bs bstore.Blockstore
 
+	// closing stops saving and dropping the ledgers
+	closing chan struct{}
+
 	lock sync.RWMutex // protects the fields immediatly below
 	// ledgerMap lists Ledgers by their Partner key.
 	ledgerMap map[peer.ID]*ledger
+
+	// strategy ranks the peers the engine serves
+	strategy Strategy
+
+	// ledgerStore is where ledgers are saved, nil if they are not
+	ledgerStore ds.Datastore
+
+	// limiter holds back the sending of blocks to stay within limits
+	limiter *limiter
 }
 
 func NewEngine(ctx context.Context, bs bstore.Blockstore) *Engine {
@@ -93,11 +125,211 @@ func NewEngine(ctx context.Context, bs bstore.Blockstore) *Engine {
 		peerRequestQueue: newPRQ(),
 		outbox:           make(chan (<-chan *Envelope), outboxChanBuffer),
 		workSignal:       make(chan struct{}, 1),
+		closing:          make(chan struct{}),
+		strategy:         Fair,
 	}
+	e.limiter = newLimiter(e.peerRequestQueue.SetBlocked, e.signalNewWork)
 	go e.taskWorker(ctx)
+	go e.ledgerSaver(ctx)
 	return e
 }
 
+// SetStrategy sets how the engine ranks the peers it serves. It applies
+// to a peer from the next message exchanged with it.
+func (e *Engine) SetStrategy(s Strategy) {
+	e.lock.Lock()
+	e.strategy = s
+	e.lock.Unlock()
+}
+
+// SetLimits sets the bandwidth limits of serving blocks.
+func (e *Engine) SetLimits(l Limits) {
+	e.limiter.set(l)
+}
+
+// Limits returns the bandwidth limits of serving blocks.
+func (e *Engine) Limits() Limits {
+	return e.limiter.get()
+}
+
+// ThrottledPeers returns how many peers are held back for being over
+// their limits.
+func (e *Engine) ThrottledPeers() int {
+	return e.limiter.throttled()
+}
+
+// LoadLedgers keeps the ledgers in d, so the accounting with peers carries
+// over restarts. The ledger of a peer is read from d when the peer is first
+// seen, and saved to d periodically, when the peer goes away and when the
+// engine is closed. The ledgers saved for peers not seen for ledgerExpiry
+// are deleted.
+func (e *Engine) LoadLedgers(d ds.Datastore) error {
+	res, err := d.Query(dsq.Query{Prefix: LedgerPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, en := range entries {
+		r, err := decodeReceipt(en.Value)
+		if err != nil {
+			log.Warningf("invalid ledger at %s: %s", en.Key, err)
+			continue
+		}
+		if time.Since(r.LastExchange) > ledgerExpiry {
+			if err := d.Delete(ds.NewKey(en.Key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.ledgerStore = d
+	for _, l := range e.ledgerMap {
+		if l.exchangeCount == 0 {
+			e.restoreLedger(l)
+		}
+	}
+	return nil
+}
+
+func decodeReceipt(v interface{}) (*Receipt, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, errors.New("not a byte slice")
+	}
+	r := new(Receipt)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	if _, err := peer.IDB58Decode(r.Peer); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// savedReceipt reads the ledger saved for p. e.ledgerStore must be set.
+func (e *Engine) savedReceipt(p peer.ID) (*Receipt, error) {
+	v, err := e.ledgerStore.Get(LedgerPrefix.ChildString(p.Pretty()))
+	if err != nil {
+		return nil, err
+	}
+	return decodeReceipt(v)
+}
+
+// restoreLedger sets the accounting of l from the ledger saved for its
+// peer, if any. e.lock must be held.
+func (e *Engine) restoreLedger(l *ledger) {
+	r, err := e.savedReceipt(l.Partner)
+	switch err {
+	case nil:
+		l.restore(r)
+	case ds.ErrNotFound:
+	default:
+		log.Warningf("invalid ledger for %s: %s", l.Partner, err)
+	}
+}
+
+func (e *Engine) ledgerSaver(ctx context.Context) {
+	tick := time.NewTicker(ledgerSaveInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			e.dropIdleLedgers()
+			if err := e.SaveLedgers(); err != nil {
+				log.Errorf("saving bitswap ledgers: %s", err)
+			}
+		case <-e.closing:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dropIdleLedgers drops the ledgers of the peers that want nothing and
+// exchanged nothing for ledgerIdleTimeout.
+func (e *Engine) dropIdleLedgers() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, l := range e.ledgerMap {
+		if l.wantList.Len() == 0 && time.Since(l.lastExchange) > ledgerIdleTimeout {
+			e.dropLedger(l)
+		}
+	}
+}
+
+// dropLedger forgets l, and the tasks and the bandwidth usage of its peer,
+// after saving l if it changed. e.lock must be held.
+func (e *Engine) dropLedger(l *ledger) {
+	if e.ledgerStore != nil && l.dirty {
+		if err := saveReceipt(e.ledgerStore, l.Receipt()); err != nil {
+			log.Errorf("saving bitswap ledger of %s: %s", l.Partner, err)
+			return
+		}
+	}
+	delete(e.ledgerMap, l.Partner)
+	e.forgetPeer(l.Partner)
+}
+
+// SaveLedgers saves the ledgers that changed since they were last saved.
+// It does nothing unless LoadLedgers was called.
+func (e *Engine) SaveLedgers() error {
+	// saved under the lock, for a ledger dropped meanwhile not to be read
+	// back from the store before it is saved
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.ledgerStore == nil {
+		return nil
+	}
+	for _, l := range e.ledgerMap {
+		if !l.dirty {
+			continue
+		}
+		if err := saveReceipt(e.ledgerStore, l.Receipt()); err != nil {
+			return err
+		}
+		l.dirty = false
+	}
+	return nil
+}
+
+func saveReceipt(d ds.Datastore, r *Receipt) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return d.Put(LedgerPrefix.ChildString(r.Peer), data)
+}
+
+// Close stops saving the ledgers, after saving them a last time.
+func (e *Engine) Close() error {
+	close(e.closing)
+	return e.SaveLedgers()
+}
+
+// LedgerForPeer returns a summary of the ledger kept with p, nil if there
+// was no exchange with p.
+func (e *Engine) LedgerForPeer(p peer.ID) *Receipt {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if l, ok := e.ledgerMap[p]; ok {
+		return l.Receipt()
+	}
+	if e.ledgerStore == nil {
+		return nil
+	}
+	r, err := e.savedReceipt(p)
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
 func (e *Engine) WantlistForPeer(p peer.ID) (out []wl.Entry) {
 	e.lock.Lock()
 	partner, ok := e.ledgerMap[p]
@@ -153,10 +385,18 @@ func (e *Engine) nextEnvelope(ctx context.Context) (*Envelope, error) {
 			continue
 		}
 
+		// the global rate holds back every peer
+		if err := e.limiter.waitGlobal(ctx); err != nil {
+			return nil, err
+		}
+		size := len(block.Data)
+		e.limiter.sending(nextTask.Target, size)
+
 		return &Envelope{
 			Peer:  nextTask.Target,
 			Block: block,
 			Sent: func() {
+				e.limiter.sent(nextTask.Target, size)
 				nextTask.Done()
 				select {
 				case e.workSignal <- struct{}{}:
@@ -213,6 +453,9 @@ func (e *Engine) MessageReceived(p peer.ID, m bsmsg.BitSwapMessage) error {
 			log.Debugf("cancel %s", entry.Key)
 			l.CancelWant(entry.Key)
 			e.peerRequestQueue.Remove(entry.Key, p)
+		} else if entry.WantHave {
+			// answered by bitswap, only the blocks wanted are served
+			continue
 		} else {
 			log.Debugf("wants %s - %d", entry.Key, entry.Priority)
 			l.Wants(entry.Key, entry.Priority)
@@ -233,6 +476,7 @@ func (e *Engine) MessageReceived(p peer.ID, m bsmsg.BitSwapMessage) error {
 			}
 		}
 	}
+	e.updateScore(l)
 	return nil
 }
 
@@ -248,16 +492,59 @@ func (e *Engine) MessageSent(p peer.ID, m bsmsg.BitSwapMessage) error {
 
 	l := e.findOrCreate(p)
 	for _, block := range m.Blocks() {
-		l.SentBytes(len(block.Data))
-		l.wantList.Remove(block.Key())
-		e.peerRequestQueue.Remove(block.Key(), p)
+		e.blockSent(l, block)
 	}
+	e.updateScore(l)
 
 	return nil
 }
 
+// BlockSent records that the block was sent to p.
+func (e *Engine) BlockSent(p peer.ID, b *blocks.Block) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	l := e.findOrCreate(p)
+	e.blockSent(l, b)
+	e.updateScore(l)
+}
+
+func (e *Engine) blockSent(l *ledger, b *blocks.Block) {
+	l.SentBytes(len(b.Data))
+	l.wantList.Remove(b.Key())
+	e.peerRequestQueue.Remove(b.Key(), l.Partner)
+}
+
+// RecordLatency records that p took d to send us a block, from when it was
+// asked for it.
+func (e *Engine) RecordLatency(p peer.ID, d time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	l := e.findOrCreate(p)
+	l.RecordLatency(d)
+	e.updateScore(l)
+}
+
+// updateScore ranks the peer of l again, after its ledger changed.
+func (e *Engine) updateScore(l *ledger) {
+	e.peerRequestQueue.SetScore(l.Partner, e.strategy.Score(l.Receipt()))
+}
+
+// PeerDisconnected drops the ledger, the tasks and the bandwidth usage of p.
 func (e *Engine) PeerDisconnected(p peer.ID) {
-	// TODO: release ledger
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if l, ok := e.ledgerMap[p]; ok {
+		e.dropLedger(l)
+	} else {
+		e.forgetPeer(p)
+	}
+}
+
+// forgetPeer drops the tasks and the bandwidth usage of p.
+func (e *Engine) forgetPeer(p peer.ID) {
+	e.peerRequestQueue.Forget(p)
+	e.limiter.forget(p)
 }
 
 func (e *Engine) numBytesSentTo(p peer.ID) uint64 {
@@ -275,6 +562,9 @@ func (e *Engine) findOrCreate(p peer.ID) *ledger {
 	l, ok := e.ledgerMap[p]
 	if !ok {
 		l = newLedger(p)
+		if e.ledgerStore != nil {
+			e.restoreLedger(l)
+		}
 		e.ledgerMap[p] = l
 	}
 	return l
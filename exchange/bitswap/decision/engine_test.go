@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
@@ -207,3 +208,177 @@ func stringsComplement(set, subset []string) []string {
 	}
 	return complement
 }
+
+func TestReciprocityServesGiversFirst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	for _, letter := range []string{"a", "b"} {
+		if err := bs.Put(blocks.NewBlock([]byte(letter))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := NewEngine(ctx, bs)
+	e.SetStrategy(Reciprocity)
+
+	taker := testutil.RandPeerIDFatal(t)
+	giver := testutil.RandPeerIDFatal(t)
+
+	gift := message.New(false)
+	gift.AddBlock(blocks.NewBlock([]byte("a gift")))
+	e.MessageReceived(giver, gift)
+
+	partnerWants(e, []string{"a"}, taker)
+	partnerWants(e, []string{"b"}, giver)
+
+	envelope := <-<-e.Outbox()
+	if envelope.Peer != giver {
+		t.Fatal("the peer that sent us blocks should be served first")
+	}
+}
+
+func TestLedgersPersist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	partner := testutil.RandPeerIDFatal(t)
+
+	e := NewEngine(ctx, bs)
+	if err := e.LoadLedgers(d); err != nil {
+		t.Fatal(err)
+	}
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock([]byte("some data")))
+	e.MessageReceived(partner, m)
+	e.RecordLatency(partner, time.Second)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e = NewEngine(ctx, bs)
+	if err := e.LoadLedgers(d); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	r := e.LedgerForPeer(partner)
+	if r == nil {
+		t.Fatal("ledger was not loaded")
+	}
+	if r.Recv != uint64(len("some data")) || r.Exchanged != 1 || r.Latency != time.Second {
+		t.Fatalf("loaded the wrong ledger: %+v", r)
+	}
+	if r.FirstExchange.IsZero() {
+		t.Fatal("first exchange time was not kept")
+	}
+}
+
+func TestPeerDisconnectedDropsLedger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	partner := testutil.RandPeerIDFatal(t)
+
+	e := NewEngine(ctx, bs)
+	defer e.Close()
+	if err := e.LoadLedgers(d); err != nil {
+		t.Fatal(err)
+	}
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock([]byte("some data")))
+	e.MessageReceived(partner, m)
+
+	e.PeerDisconnected(partner)
+	if len(e.Peers()) != 0 {
+		t.Fatal("ledger was not dropped")
+	}
+	if r := e.LedgerForPeer(partner); r == nil || r.Recv != uint64(len("some data")) {
+		t.Fatalf("ledger was not saved: %+v", r)
+	}
+
+	partnerWants(e, []string{"a"}, partner)
+	if r := e.LedgerForPeer(partner); r == nil || r.Exchanged != 1 {
+		t.Fatalf("ledger was not read back: %+v", r)
+	}
+}
+
+func TestIdleLedgersDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	e := NewEngine(ctx, bs)
+	idle := testutil.RandPeerIDFatal(t)
+	wanting := testutil.RandPeerIDFatal(t)
+	partnerWants(e, []string{"a"}, wanting)
+	e.MessageReceived(idle, message.New(false))
+
+	e.dropIdleLedgers()
+	peers := e.Peers()
+	if len(peers) != 1 || peers[0] != wanting {
+		t.Fatal("expected only the ledger of the peer wanting blocks, got", peers)
+	}
+}
+
+func TestReciprocityScore(t *testing.T) {
+	newcomer := &Receipt{Recv: 1000, Exchanged: 1}
+	regular := &Receipt{Recv: 1000, Exchanged: 20}
+	if Reciprocity.Score(newcomer) >= Reciprocity.Score(regular) {
+		t.Fatal("a few exchanges should count for less than many")
+	}
+
+	slow := &Receipt{Recv: 1000, Exchanged: 20, Latency: time.Second}
+	if Reciprocity.Score(slow) >= Reciprocity.Score(regular) {
+		t.Fatal("a slow peer should rank lower")
+	}
+
+	taker := &Receipt{Sent: 1000, Exchanged: 20}
+	if Reciprocity.Score(taker) >= Reciprocity.Score(&Receipt{}) {
+		t.Fatal("a peer that only takes should rank below a new one")
+	}
+}
+
+func TestPeerOutstandingLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	for _, letter := range []string{"a", "b", "c"} {
+		if err := bs.Put(blocks.NewBlock([]byte(letter))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := NewEngine(ctx, bs)
+	e.SetLimits(Limits{PeerOutstanding: 1})
+
+	greedy := testutil.RandPeerIDFatal(t)
+	other := testutil.RandPeerIDFatal(t)
+	partnerWants(e, []string{"a", "b"}, greedy)
+
+	first := <-<-e.Outbox()
+	if first.Peer != greedy {
+		t.Fatal("expected a block for the peer wanting them")
+	}
+	if e.ThrottledPeers() != 1 {
+		t.Fatal("the peer with a block in flight was not held back")
+	}
+
+	// the other peer is served while the first block is in flight
+	partnerWants(e, []string{"c"}, other)
+	next := <-<-e.Outbox()
+	if next.Peer != other {
+		t.Fatal("served a peer over its outstanding bytes")
+	}
+	next.Sent()
+
+	first.Sent()
+	next = <-<-e.Outbox()
+	if next.Peer != greedy || next.Block.Key() != blocks.NewBlock([]byte("b")).Key() {
+		t.Fatal("the peer was not served once its block was sent")
+	}
+}
@@ -38,6 +38,13 @@ type ledger struct {
 	// exchangeCount is the number of exchanges with this peer
 	exchangeCount uint64
 
+	// latency is an average of how long the peer took to send us the
+	// blocks we asked it for.
+	latency time.Duration
+
+	// dirty is set when the ledger changed since it was last saved.
+	dirty bool
+
 	// wantList is a (bounded, small) set of keys that Partner desires.
 	wantList *wl.Wantlist
 
@@ -56,15 +63,60 @@ func (dr *debtRatio) Value() float64 {
 }
 
 func (l *ledger) SentBytes(n int) {
-	l.exchangeCount++
-	l.lastExchange = time.Now()
+	l.exchanged()
 	l.Accounting.BytesSent += uint64(n)
 }
 
 func (l *ledger) ReceivedBytes(n int) {
+	l.exchanged()
+	l.Accounting.BytesRecv += uint64(n)
+}
+
+func (l *ledger) exchanged() {
 	l.exchangeCount++
 	l.lastExchange = time.Now()
-	l.Accounting.BytesRecv += uint64(n)
+	if l.firstExchange.IsZero() {
+		l.firstExchange = l.lastExchange
+	}
+	l.dirty = true
+}
+
+// latencySmoothing is the weight of a new measure in the latency average.
+const latencySmoothing = 0.1
+
+// RecordLatency adds a measure of the time the peer took to send a block
+// to the latency average.
+func (l *ledger) RecordLatency(d time.Duration) {
+	if l.latency == 0 {
+		l.latency = d
+	} else {
+		l.latency = time.Duration((1-latencySmoothing)*float64(l.latency) + latencySmoothing*float64(d))
+	}
+	l.dirty = true
+}
+
+// Receipt returns a summary of the ledger.
+func (l *ledger) Receipt() *Receipt {
+	return &Receipt{
+		Peer:          l.Partner.Pretty(),
+		Value:         l.Accounting.Value(),
+		Sent:          l.Accounting.BytesSent,
+		Recv:          l.Accounting.BytesRecv,
+		Exchanged:     l.exchangeCount,
+		Latency:       l.latency,
+		FirstExchange: l.firstExchange,
+		LastExchange:  l.lastExchange,
+	}
+}
+
+// restore sets the accounting of the ledger from a saved receipt.
+func (l *ledger) restore(r *Receipt) {
+	l.Accounting.BytesSent = r.Sent
+	l.Accounting.BytesRecv = r.Recv
+	l.exchangeCount = r.Exchanged
+	l.latency = r.Latency
+	l.firstExchange = r.FirstExchange
+	l.lastExchange = r.LastExchange
 }
 
 // TODO: this needs to be different. We need timeouts.
@@ -0,0 +1,230 @@
+package decision
+
+import (
+	"sync"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+)
+
+// Limits bound the bandwidth the engine spends serving blocks. A zero
+// field means no limit.
+type Limits struct {
+	// Rate is the bytes per second sent to all peers together, PeerRate
+	// the bytes per second sent to each of them.
+	Rate     uint64
+	PeerRate uint64
+
+	// PeerOutstanding is how many bytes of blocks can be on their way to a
+	// peer at once. A peer with that much in flight is not served until
+	// some of it is sent.
+	PeerOutstanding uint64
+}
+
+// bucket is a token bucket of bytes, refilled at rate bytes per second up
+// to a second worth of them. Taking more than it holds puts it in debt,
+// which has to be paid back before the next take.
+type bucket struct {
+	rate   uint64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) setRate(rate uint64, now time.Time) {
+	b.refill(now)
+	if b.rate == 0 || rate == 0 {
+		b.tokens = float64(rate)
+		b.last = now
+	}
+	b.rate = rate
+	if max := float64(rate); b.tokens > max {
+		b.tokens = max
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.rate == 0 {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if max := float64(b.rate); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+// wait returns how long until the bucket is out of debt.
+func (b *bucket) wait(now time.Time) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+func (b *bucket) take(n int, now time.Time) {
+	if b.rate == 0 {
+		return
+	}
+	b.refill(now)
+	b.tokens -= float64(n)
+}
+
+// limiter enforces the Limits of an engine. Peers over their limits are
+// held back in the peer request queue, the global rate holds back the
+// engine itself.
+type limiter struct {
+	lk     sync.Mutex
+	limits Limits
+	global bucket
+	peers  map[peer.ID]*peerUsage
+
+	// setBlocked holds back or resumes serving a peer, wake tells the
+	// engine there may be work again
+	setBlocked func(p peer.ID, blocked bool)
+	wake       func()
+}
+
+type peerUsage struct {
+	bucket
+	outstanding uint64
+	blocked     bool
+	timer       *time.Timer
+}
+
+func newLimiter(setBlocked func(peer.ID, bool), wake func()) *limiter {
+	return &limiter{
+		peers:      make(map[peer.ID]*peerUsage),
+		setBlocked: setBlocked,
+		wake:       wake,
+	}
+}
+
+func (l *limiter) set(lim Limits) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	now := time.Now()
+	l.limits = lim
+	l.global.setRate(lim.Rate, now)
+	for p, u := range l.peers {
+		u.setRate(lim.PeerRate, now)
+		l.update(p, u)
+	}
+	l.wake()
+}
+
+func (l *limiter) get() Limits {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.limits
+}
+
+// throttled returns how many peers are held back.
+func (l *limiter) throttled() int {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	n := 0
+	for _, u := range l.peers {
+		if u.blocked {
+			n++
+		}
+	}
+	return n
+}
+
+// waitGlobal returns once the global rate allows sending.
+func (l *limiter) waitGlobal(ctx context.Context) error {
+	for {
+		l.lk.Lock()
+		w := l.global.wait(time.Now())
+		l.lk.Unlock()
+		if w == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(w):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sending accounts for n bytes about to be sent to p.
+func (l *limiter) sending(p peer.ID, n int) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	now := time.Now()
+	l.global.take(n, now)
+	u := l.usage(p, now)
+	u.take(n, now)
+	u.outstanding += uint64(n)
+	l.update(p, u)
+}
+
+// sent accounts for the n bytes sent to p.
+func (l *limiter) sent(p peer.ID, n int) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	u, ok := l.peers[p]
+	if !ok {
+		// forgotten while the bytes were on their way
+		return
+	}
+	u.outstanding -= uint64(n)
+	l.update(p, u)
+}
+
+// forget drops the usage of p, for a peer that went away.
+func (l *limiter) forget(p peer.ID) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	u, ok := l.peers[p]
+	if !ok {
+		return
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	delete(l.peers, p)
+}
+
+func (l *limiter) usage(p peer.ID, now time.Time) *peerUsage {
+	u, ok := l.peers[p]
+	if !ok {
+		u = new(peerUsage)
+		u.setRate(l.limits.PeerRate, now)
+		l.peers[p] = u
+	}
+	return u
+}
+
+// update holds back p while it is over its limits. l.lk must be held.
+func (l *limiter) update(p peer.ID, u *peerUsage) {
+	wait := u.wait(time.Now())
+	if wait > 0 && u.timer == nil {
+		u.timer = time.AfterFunc(wait, func() {
+			l.lk.Lock()
+			defer l.lk.Unlock()
+			if l.peers[p] != u {
+				return // forgotten
+			}
+			u.timer = nil
+			l.update(p, u)
+		})
+	}
+
+	max := l.limits.PeerOutstanding
+	blocked := wait > 0 || max > 0 && u.outstanding >= max
+	if blocked == u.blocked {
+		return
+	}
+	u.blocked = blocked
+	l.setBlocked(p, blocked)
+	if !blocked {
+		l.wake()
+	}
+}
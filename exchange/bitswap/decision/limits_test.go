@@ -0,0 +1,64 @@
+package decision
+
+import (
+	"testing"
+	"time"
+
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	var b bucket
+	b.setRate(1000, now)
+
+	// a full second worth of bytes can be sent right away
+	b.take(1000, now)
+	if w := b.wait(now); w != 0 {
+		t.Fatalf("waiting %s with an empty bucket", w)
+	}
+
+	// going in debt holds back until it is paid
+	b.take(500, now)
+	if w := b.wait(now); w != time.Millisecond*500 {
+		t.Fatalf("waiting %s, wanted 500ms", w)
+	}
+	if w := b.wait(now.Add(time.Millisecond * 500)); w != 0 {
+		t.Fatalf("still waiting %s after paying the debt", w)
+	}
+
+	// no more than a second worth of bytes is saved up
+	later := now.Add(time.Hour)
+	b.take(1500, later)
+	if w := b.wait(later); w != time.Millisecond*500 {
+		t.Fatalf("waiting %s, wanted 500ms", w)
+	}
+
+	b.setRate(0, later)
+	if w := b.wait(later); w != 0 {
+		t.Fatalf("waiting %s without a limit", w)
+	}
+}
+
+func TestLimiterForget(t *testing.T) {
+	blocked := make(map[peer.ID]bool)
+	l := newLimiter(func(p peer.ID, b bool) { blocked[p] = b }, func() {})
+	l.set(Limits{PeerOutstanding: 10})
+
+	p := peer.ID("partner")
+	l.sending(p, 10)
+	if !blocked[p] || l.throttled() != 1 {
+		t.Fatal("expected the peer to be held back")
+	}
+
+	l.forget(p)
+	if len(l.peers) != 0 || l.throttled() != 0 {
+		t.Fatal("the usage of the peer was kept")
+	}
+
+	// the bytes in flight when the peer went away are not counted again
+	l.sent(p, 10)
+	if len(l.peers) != 0 {
+		t.Fatal("the usage of the peer came back")
+	}
+}
@@ -15,6 +15,15 @@ type peerRequestQueue interface {
 	Pop() *peerRequestTask
 	Push(entry wantlist.Entry, to peer.ID)
 	Remove(k key.Key, p peer.ID)
+	// SetScore sets the score the strategy gave a peer. The peers with the
+	// highest score get their tasks popped first.
+	SetScore(p peer.ID, score float64)
+	// SetBlocked holds back or resumes serving a peer. No task of a peer
+	// held back is popped.
+	SetBlocked(p peer.ID, blocked bool)
+	// Forget drops p and its tasks, for a peer that went away. The tasks
+	// of p already popped can still be marked done.
+	Forget(p peer.ID)
 	// NB: cannot expose simply expose taskQueue.Len because trashed elements
 	// may exist. These trashed elements should not contribute to the count.
 }
@@ -30,9 +39,8 @@ func newPRQ() peerRequestQueue {
 // verify interface implementation
 var _ peerRequestQueue = &prq{}
 
-// TODO: at some point, the strategy needs to plug in here
-// to help decide how to sort tasks (on add) and how to select
-// tasks (on getnext). For now, we are assuming a dumb/nice strategy.
+// prq serves the partners by the score the strategy gave them, and in turn
+// among the ones with the same score.
 type prq struct {
 	lock     sync.Mutex
 	pQueue   pq.PQ
@@ -71,7 +79,9 @@ func (tl *prq) Push(entry wantlist.Entry, to peer.ID) {
 		Done: func() {
 			tl.lock.Lock()
 			partner.TaskDone(entry.Key)
-			tl.pQueue.Update(partner.Index())
+			if !partner.gone {
+				tl.pQueue.Update(partner.Index())
+			}
 			tl.lock.Unlock()
 		},
 	}
@@ -82,14 +92,75 @@ func (tl *prq) Push(entry wantlist.Entry, to peer.ID) {
 	tl.pQueue.Update(partner.Index())
 }
 
+// SetScore sets the score of a partner.
+func (tl *prq) SetScore(p peer.ID, score float64) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner, ok := tl.partners[p]
+	if !ok {
+		partner = newActivePartner()
+		tl.pQueue.Push(partner)
+		tl.partners[p] = partner
+	}
+	partner.score = score
+	tl.pQueue.Update(partner.Index())
+}
+
+// SetBlocked holds back or resumes serving a partner.
+func (tl *prq) SetBlocked(p peer.ID, blocked bool) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner, ok := tl.partners[p]
+	if !ok {
+		partner = newActivePartner()
+		tl.pQueue.Push(partner)
+		tl.partners[p] = partner
+	}
+	partner.blocked = blocked
+	tl.pQueue.Update(partner.Index())
+}
+
+// Forget drops a partner and its tasks.
+func (tl *prq) Forget(p peer.ID) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner, ok := tl.partners[p]
+	if !ok {
+		return
+	}
+	delete(tl.partners, p)
+	for partner.taskQueue.Len() > 0 {
+		task := partner.taskQueue.Pop().(*peerRequestTask)
+		delete(tl.taskMap, task.Key())
+	}
+	partner.requests = 0
+
+	// remove the partner "lazily" as well, it comes first in the queue and
+	// is dropped by the next Pop.
+	partner.gone = true
+	tl.pQueue.Update(partner.Index())
+}
+
 // Pop 'pops' the next task to be performed. Returns nil if no task exists.
 func (tl *prq) Pop() *peerRequestTask {
 	tl.lock.Lock()
 	defer tl.lock.Unlock()
-	if tl.pQueue.Len() == 0 {
+	var partner *activePartner
+	for tl.pQueue.Len() > 0 {
+		partner = tl.pQueue.Pop().(*activePartner)
+		if !partner.gone {
+			break
+		}
+		partner = nil
+	}
+	if partner == nil {
+		return nil
+	}
+	if partner.blocked {
+		// the partners held back come last, so all of them are
+		tl.pQueue.Push(partner)
 		return nil
 	}
-	partner := tl.pQueue.Pop().(*activePartner)
 
 	var out *peerRequestTask
 	for partner.taskQueue.Len() > 0 {
@@ -193,6 +264,18 @@ type activePartner struct {
 	// the peerRequestQueue's locks
 	requests int
 
+	// score is the rank the strategy gave this peer, also only modified
+	// under the peerRequestQueue's locks
+	score float64
+
+	// blocked is set while the peer is over its bandwidth limits, under
+	// the peerRequestQueue's locks as well
+	blocked bool
+
+	// gone is set once the peer is forgotten, until it is popped off the
+	// queue
+	gone bool
+
 	// for the PQ interface
 	index int
 
@@ -212,6 +295,16 @@ func partnerCompare(a, b pq.Elem) bool {
 	pa := a.(*activePartner)
 	pb := b.(*activePartner)
 
+	// the partners forgotten come first, to be dropped
+	if pa.gone != pb.gone {
+		return pa.gone
+	}
+
+	// the partners held back are not served
+	if pa.blocked != pb.blocked {
+		return pb.blocked
+	}
+
 	// having no blocks in their wantlist means lowest priority
 	// having both of these checks ensures stability of the sort
 	if pa.requests == 0 {
@@ -220,6 +313,9 @@ func partnerCompare(a, b pq.Elem) bool {
 	if pb.requests == 0 {
 		return true
 	}
+	if pa.score != pb.score {
+		return pa.score > pb.score
+	}
 	if pa.active == pb.active {
 		// sorting by taskQueue.Len() aids in cleaning out trash entries faster
 		// if we sorted instead by requests, one peer could potentially build up
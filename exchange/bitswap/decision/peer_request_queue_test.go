@@ -1,6 +1,7 @@
 package decision
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
 	"sort"
@@ -117,3 +118,76 @@ func TestPeerRepeats(t *testing.T) {
 		}
 	}
 }
+
+func TestScoreOrder(t *testing.T) {
+	prq := newPRQ()
+	low := testutil.RandPeerIDFatal(t)
+	high := testutil.RandPeerIDFatal(t)
+
+	for i := 0; i < 3; i++ {
+		prq.Push(wantlist.Entry{Key: key.Key(fmt.Sprint("low", i))}, low)
+		prq.Push(wantlist.Entry{Key: key.Key(fmt.Sprint("high", i))}, high)
+	}
+	prq.SetScore(low, 1)
+	prq.SetScore(high, 2)
+
+	// the peer with the highest score is served while it wants blocks
+	for i := 0; i < 6; i++ {
+		task := prq.Pop()
+		if task == nil {
+			t.Fatal("expected a task")
+		}
+		if want := i < 3; (task.Target == high) != want {
+			t.Fatalf("task %d was for the wrong peer", i)
+		}
+	}
+}
+
+func TestBlockedPartnerSkipped(t *testing.T) {
+	prq := newPRQ()
+	blocked := testutil.RandPeerIDFatal(t)
+	other := testutil.RandPeerIDFatal(t)
+
+	prq.Push(wantlist.Entry{Key: key.Key("a")}, blocked)
+	prq.SetBlocked(blocked, true)
+	if task := prq.Pop(); task != nil {
+		t.Fatal("popped a task of a peer held back")
+	}
+
+	prq.Push(wantlist.Entry{Key: key.Key("b")}, other)
+	if task := prq.Pop(); task == nil || task.Target != other {
+		t.Fatal("expected the task of the peer not held back")
+	}
+
+	prq.SetBlocked(blocked, false)
+	if task := prq.Pop(); task == nil || task.Target != blocked {
+		t.Fatal("expected the task of the peer no longer held back")
+	}
+}
+
+func TestForget(t *testing.T) {
+	q := newPRQ()
+	gone := testutil.RandPeerIDFatal(t)
+	other := testutil.RandPeerIDFatal(t)
+
+	q.Push(wantlist.Entry{Key: key.Key("a")}, gone)
+	q.Push(wantlist.Entry{Key: key.Key("b")}, gone)
+	q.SetScore(gone, 2)
+	q.Push(wantlist.Entry{Key: key.Key("c")}, other)
+	running := q.Pop()
+	if running.Target != gone {
+		t.Fatal("expected the task of the peer with the highest score")
+	}
+
+	q.Forget(gone)
+	running.Done()
+	if task := q.Pop(); task == nil || task.Target != other {
+		t.Fatal("expected the task of the peer not forgotten")
+	}
+	if task := q.Pop(); task != nil {
+		t.Fatal("popped a task of a forgotten peer")
+	}
+	if n := len(q.(*prq).taskMap); n != 0 {
+		t.Fatalf("%d tasks left behind", n)
+	}
+}
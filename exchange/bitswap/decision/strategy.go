@@ -0,0 +1,80 @@
+package decision
+
+import (
+	"fmt"
+	"time"
+)
+
+// Receipt is a summary of the ledger kept with a peer.
+type Receipt struct {
+	Peer      string
+	Value     float64 // debt ratio, bytes sent to the peer over bytes received
+	Sent      uint64
+	Recv      uint64
+	Exchanged uint64
+
+	// Latency is how long the peer takes to send a block from when it is
+	// asked for it, averaged over the blocks it sent.
+	Latency time.Duration
+
+	FirstExchange time.Time
+	LastExchange  time.Time
+}
+
+// Strategy decides in which order the peers wanting blocks are served.
+type Strategy interface {
+	// Score rates a peer from its ledger. Peers with a higher score are
+	// served first, peers with the same score are served in turn.
+	Score(r *Receipt) float64
+}
+
+var (
+	// Fair serves the peers in turn, whatever they sent us.
+	Fair Strategy = fairStrategy{}
+
+	// Reciprocity serves first the peers that sent us the most compared to
+	// what we sent them, so peers that only take get served last. What a
+	// peer sent counts for more as the exchanges with it add up, and peers
+	// slow to send us blocks rank lower.
+	Reciprocity Strategy = reciprocityStrategy{}
+)
+
+// Strategies lists the strategies by the names used in the config.
+var Strategies = map[string]Strategy{
+	"fair":        Fair,
+	"reciprocity": Reciprocity,
+}
+
+// StrategyByName returns the strategy called name, the empty name being
+// the default one.
+func StrategyByName(name string) (Strategy, error) {
+	if name == "" {
+		return Fair, nil
+	}
+	s, ok := Strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown bitswap strategy %q", name)
+	}
+	return s, nil
+}
+
+type fairStrategy struct{}
+
+func (fairStrategy) Score(r *Receipt) float64 {
+	return 0
+}
+
+// reciprocityTrust is the number of exchanges after which half of the
+// ratio of bytes received over bytes sent counts.
+const reciprocityTrust = 4
+
+type reciprocityStrategy struct{}
+
+func (reciprocityStrategy) Score(r *Receipt) float64 {
+	ratio := float64(r.Recv+1) / float64(r.Sent+1)
+	trust := float64(r.Exchanged) / float64(r.Exchanged+reciprocityTrust)
+	score := 1 + trust*(ratio-1)
+
+	// a second of latency halves the score
+	return score / (1 + r.Latency.Seconds())
+}
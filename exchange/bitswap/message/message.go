@@ -27,8 +27,21 @@ type BitSwapMessage interface {
 	// AddEntry adds an entry to the Wantlist.
 	AddEntry(key key.Key, priority int)
 
+	// AddWantHave adds an entry asking whether the receiver has the block,
+	// rather than for the block. With sendDontHave, the receiver answers
+	// DONT_HAVE when it lacks the block.
+	AddWantHave(key key.Key, priority int, sendDontHave bool)
+
 	Cancel(key key.Key)
 
+	// AddHave and AddDontHave answer a want-have entry.
+	AddHave(key key.Key)
+	AddDontHave(key key.Key)
+
+	// Haves and DontHaves return the answers to our want-have entries.
+	Haves() []key.Key
+	DontHaves() []key.Key
+
 	Empty() bool
 
 	// A full wantlist is an authoritative copy, a 'non-full' wantlist is a patch-set
@@ -43,12 +56,19 @@ type BitSwapMessage interface {
 type Exportable interface {
 	ToProto() *pb.Message
 	ToNet(w io.Writer) error
+
+	// ToProtoV0 and ToNetV0 encode the message for the peers speaking the
+	// protocol of before HAVE and DONT_HAVE. Want-have entries become
+	// want-block entries, the answers to want-have entries are dropped.
+	ToProtoV0() *pb.Message
+	ToNetV0(w io.Writer) error
 }
 
 type impl struct {
-	full     bool
-	wantlist map[key.Key]Entry
-	blocks   map[key.Key]*blocks.Block
+	full      bool
+	wantlist  map[key.Key]Entry
+	blocks    map[key.Key]*blocks.Block
+	presences map[key.Key]pb.Message_BlockPresenceType
 }
 
 func New(full bool) BitSwapMessage {
@@ -57,26 +77,36 @@ func New(full bool) BitSwapMessage {
 
 func newMsg(full bool) *impl {
 	return &impl{
-		blocks:   make(map[key.Key]*blocks.Block),
-		wantlist: make(map[key.Key]Entry),
-		full:     full,
+		blocks:    make(map[key.Key]*blocks.Block),
+		wantlist:  make(map[key.Key]Entry),
+		presences: make(map[key.Key]pb.Message_BlockPresenceType),
+		full:      full,
 	}
 }
 
 type Entry struct {
 	wantlist.Entry
 	Cancel bool
+
+	// WantHave asks whether the peer has the block, rather than for the
+	// block. SendDontHave asks it to answer when it does not.
+	WantHave     bool
+	SendDontHave bool
 }
 
 func newMessageFromProto(pbm pb.Message) BitSwapMessage {
 	m := newMsg(pbm.GetWantlist().GetFull())
 	for _, e := range pbm.GetWantlist().GetEntries() {
-		m.addEntry(key.Key(e.GetBlock()), int(e.GetPriority()), e.GetCancel())
+		wantHave := e.GetWantType() == pb.Message_Wantlist_Have
+		m.addEntry(key.Key(e.GetBlock()), int(e.GetPriority()), e.GetCancel(), wantHave, e.GetSendDontHave())
 	}
 	for _, d := range pbm.GetBlocks() {
 		b := blocks.NewBlock(d)
 		m.AddBlock(b)
 	}
+	for _, bp := range pbm.GetBlockPresences() {
+		m.presences[key.Key(bp.GetBlock())] = bp.GetType()
+	}
 	return m
 }
 
@@ -85,7 +115,7 @@ func (m *impl) Full() bool {
 }
 
 func (m *impl) Empty() bool {
-	return len(m.blocks) == 0 && len(m.wantlist) == 0
+	return len(m.blocks) == 0 && len(m.wantlist) == 0 && len(m.presences) == 0
 }
 
 func (m *impl) Wantlist() []Entry {
@@ -106,27 +136,57 @@ func (m *impl) Blocks() []*blocks.Block {
 
 func (m *impl) Cancel(k key.Key) {
 	delete(m.wantlist, k)
-	m.addEntry(k, 0, true)
+	m.addEntry(k, 0, true, false, false)
 }
 
 func (m *impl) AddEntry(k key.Key, priority int) {
-	m.addEntry(k, priority, false)
-}
-
-func (m *impl) addEntry(k key.Key, priority int, cancel bool) {
-	e, exists := m.wantlist[k]
-	if exists {
-		e.Priority = priority
-		e.Cancel = cancel
-	} else {
-		m.wantlist[k] = Entry{
-			Entry: wantlist.Entry{
-				Key:      k,
-				Priority: priority,
-			},
-			Cancel: cancel,
+	m.addEntry(k, priority, false, false, false)
+}
+
+func (m *impl) AddWantHave(k key.Key, priority int, sendDontHave bool) {
+	// wanting the block already tells whether the peer has it
+	if e, exists := m.wantlist[k]; exists && !e.Cancel && !e.WantHave {
+		return
+	}
+	m.addEntry(k, priority, false, true, sendDontHave)
+}
+
+func (m *impl) addEntry(k key.Key, priority int, cancel, wantHave, sendDontHave bool) {
+	m.wantlist[k] = Entry{
+		Entry: wantlist.Entry{
+			Key:      k,
+			Priority: priority,
+		},
+		Cancel:       cancel,
+		WantHave:     wantHave,
+		SendDontHave: sendDontHave,
+	}
+}
+
+func (m *impl) AddHave(k key.Key) {
+	m.presences[k] = pb.Message_Have
+}
+
+func (m *impl) AddDontHave(k key.Key) {
+	m.presences[k] = pb.Message_DontHave
+}
+
+func (m *impl) Haves() []key.Key {
+	return m.presencesOf(pb.Message_Have)
+}
+
+func (m *impl) DontHaves() []key.Key {
+	return m.presencesOf(pb.Message_DontHave)
+}
+
+func (m *impl) presencesOf(t pb.Message_BlockPresenceType) []key.Key {
+	var out []key.Key
+	for k, pt := range m.presences {
+		if pt == t {
+			out = append(out, k)
 		}
 	}
+	return out
 }
 
 func (m *impl) AddBlock(b *blocks.Block) {
@@ -146,6 +206,33 @@ func FromNet(r io.Reader) (BitSwapMessage, error) {
 }
 
 func (m *impl) ToProto() *pb.Message {
+	pbm := new(pb.Message)
+	pbm.Wantlist = new(pb.Message_Wantlist)
+	for _, e := range m.wantlist {
+		pe := &pb.Message_Wantlist_Entry{
+			Block:    proto.String(string(e.Key)),
+			Priority: proto.Int32(int32(e.Priority)),
+			Cancel:   proto.Bool(e.Cancel),
+		}
+		if e.WantHave {
+			pe.WantType = pb.Message_Wantlist_Have.Enum()
+			pe.SendDontHave = proto.Bool(e.SendDontHave)
+		}
+		pbm.Wantlist.Entries = append(pbm.Wantlist.Entries, pe)
+	}
+	for _, b := range m.Blocks() {
+		pbm.Blocks = append(pbm.Blocks, b.Data)
+	}
+	for k, t := range m.presences {
+		pbm.BlockPresences = append(pbm.BlockPresences, &pb.Message_BlockPresence{
+			Block: proto.String(string(k)),
+			Type:  t.Enum(),
+		})
+	}
+	return pbm
+}
+
+func (m *impl) ToProtoV0() *pb.Message {
 	pbm := new(pb.Message)
 	pbm.Wantlist = new(pb.Message_Wantlist)
 	for _, e := range m.wantlist {
@@ -170,13 +257,30 @@ func (m *impl) ToNet(w io.Writer) error {
 	return nil
 }
 
+func (m *impl) ToNetV0(w io.Writer) error {
+	pbw := ggio.NewDelimitedWriter(w)
+
+	if err := pbw.WriteMsg(m.ToProtoV0()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AsV0 returns the message a peer speaking the protocol of before HAVE
+// and DONT_HAVE gets for m.
+func AsV0(m BitSwapMessage) BitSwapMessage {
+	return newMessageFromProto(*m.ToProtoV0())
+}
+
 func (m *impl) Loggable() map[string]interface{} {
 	var blocks []string
 	for _, v := range m.blocks {
 		blocks = append(blocks, v.Key().Pretty())
 	}
 	return map[string]interface{}{
-		"blocks": blocks,
-		"wants":  m.Wantlist(),
+		"blocks":    blocks,
+		"wants":     m.Wantlist(),
+		"haves":     m.Haves(),
+		"donthaves": m.DontHaves(),
 	}
 }
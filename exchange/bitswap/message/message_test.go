@@ -186,3 +186,77 @@ func TestDuplicates(t *testing.T) {
 		t.Fatal("Duplicate in BitSwapMessage")
 	}
 }
+
+func TestPresencesToAndFromNet(t *testing.T) {
+	original := New(false)
+	original.AddWantHave(key.Key("wanthave"), 1, true)
+	original.AddEntry(key.Key("wantblock"), 2)
+	original.AddHave(key.Key("have"))
+	original.AddDontHave(key.Key("donthave"))
+
+	buf := new(bytes.Buffer)
+	if err := original.ToNet(buf); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := FromNet(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range m2.Wantlist() {
+		switch e.Key {
+		case "wanthave":
+			if !e.WantHave || !e.SendDontHave {
+				t.Fatal("want-have entry lost its type")
+			}
+		case "wantblock":
+			if e.WantHave || e.SendDontHave {
+				t.Fatal("want-block entry became a want-have")
+			}
+		default:
+			t.Fatalf("unexpected entry %s", e.Key)
+		}
+	}
+	if hs := m2.Haves(); len(hs) != 1 || hs[0] != "have" {
+		t.Fatalf("wrong haves: %v", hs)
+	}
+	if dhs := m2.DontHaves(); len(dhs) != 1 || dhs[0] != "donthave" {
+		t.Fatalf("wrong dont-haves: %v", dhs)
+	}
+}
+
+func TestWantHaveDoesNotReplaceWantBlock(t *testing.T) {
+	msg := New(false)
+	msg.AddEntry(key.Key("foo"), 1)
+	msg.AddWantHave(key.Key("foo"), 1, true)
+	if msg.Wantlist()[0].WantHave {
+		t.Fatal("want-block entry became a want-have")
+	}
+
+	msg.AddWantHave(key.Key("bar"), 1, true)
+	msg.AddEntry(key.Key("bar"), 1)
+	for _, e := range msg.Wantlist() {
+		if e.WantHave {
+			t.Fatal("want-have entry was not turned into a want-block")
+		}
+	}
+}
+
+func TestAsV0(t *testing.T) {
+	msg := New(false)
+	msg.AddWantHave(key.Key("foo"), 1, true)
+	msg.AddHave(key.Key("bar"))
+	msg.AddBlock(blocks.NewBlock([]byte("baz")))
+
+	old := AsV0(msg)
+	wl := old.Wantlist()
+	if len(wl) != 1 || wl[0].Key != "foo" || wl[0].WantHave {
+		t.Fatal("want-have entry was not turned into a want-block")
+	}
+	if len(old.Haves()) != 0 || len(old.DontHaves()) != 0 {
+		t.Fatal("presences sent to an old peer")
+	}
+	if len(old.Blocks()) != 1 {
+		t.Fatal("block lost")
+	}
+}
@@ -8,7 +8,14 @@ import (
 	protocol "github.com/ipfs/go-ipfs/p2p/protocol"
 )
 
-var ProtocolBitswap protocol.ID = "/ipfs/bitswap"
+var (
+	// ProtocolBitswap is the protocol with HAVE and DONT_HAVE.
+	ProtocolBitswap protocol.ID = "/ipfs/bitswap/1.1.0"
+
+	// ProtocolBitswapOneZero is the protocol of the peers that only
+	// exchange blocks.
+	ProtocolBitswapOneZero protocol.ID = "/ipfs/bitswap"
+)
 
 // BitSwapNetwork provides network connectivity for BitSwap sessions
 type BitSwapNetwork interface {
@@ -25,6 +32,10 @@ type BitSwapNetwork interface {
 
 	ConnectTo(context.Context, peer.ID) error
 
+	// SupportsHave tells whether the peer answers want-have entries. The
+	// messages sent to the peers that don't are converted with bsmsg.AsV0.
+	SupportsHave(peer.ID) bool
+
 	Routing
 }
 
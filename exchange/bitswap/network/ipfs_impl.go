@@ -21,6 +21,7 @@ func NewFromIpfsHost(host host.Host, r routing.IpfsRouting) BitSwapNetwork {
 		routing: r,
 	}
 	host.SetStreamHandler(ProtocolBitswap, bitswapNetwork.handleNewStream)
+	host.SetStreamHandler(ProtocolBitswapOneZero, bitswapNetwork.handleNewStream)
 	host.Network().Notify((*netNotifiee)(&bitswapNetwork))
 	// TODO: StopNotify.
 
@@ -37,16 +38,49 @@ type impl struct {
 	receiver Receiver
 }
 
-func (bsnet *impl) newStreamToPeer(ctx context.Context, p peer.ID) (inet.Stream, error) {
+func (bsnet *impl) newStreamToPeer(ctx context.Context, p peer.ID) (inet.Stream, bool, error) {
 
 	// first, make sure we're connected.
 	// if this fails, we cannot connect to given peer.
 	//TODO(jbenet) move this into host.NewStream?
 	if err := bsnet.host.Connect(ctx, peer.PeerInfo{ID: p}); err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	// streams don't negotiate the protocol, so pick the one the peer
+	// announced
+	if bsnet.SupportsHave(p) {
+		s, err := bsnet.host.NewStream(ProtocolBitswap, p)
+		return s, true, err
+	}
+	s, err := bsnet.host.NewStream(ProtocolBitswapOneZero, p)
+	return s, false, err
+}
+
+// SupportsHave tells whether the peer announced ProtocolBitswap when it
+// identified itself.
+func (bsnet *impl) SupportsHave(p peer.ID) bool {
+	v, err := bsnet.host.Peerstore().Get(p, "Protocols")
+	if err != nil {
+		return false
+	}
+	protos, ok := v.([]string)
+	if !ok {
+		return false
+	}
+	for _, proto := range protos {
+		if proto == string(ProtocolBitswap) {
+			return true
+		}
 	}
+	return false
+}
 
-	return bsnet.host.NewStream(ProtocolBitswap, p)
+func writeMessage(s inet.Stream, haves bool, outgoing bsmsg.BitSwapMessage) error {
+	if haves {
+		return outgoing.ToNet(s)
+	}
+	return outgoing.ToNetV0(s)
 }
 
 func (bsnet *impl) SendMessage(
@@ -54,13 +88,13 @@ func (bsnet *impl) SendMessage(
 	p peer.ID,
 	outgoing bsmsg.BitSwapMessage) error {
 
-	s, err := bsnet.newStreamToPeer(ctx, p)
+	s, haves, err := bsnet.newStreamToPeer(ctx, p)
 	if err != nil {
 		return err
 	}
 	defer s.Close()
 
-	if err := outgoing.ToNet(s); err != nil {
+	if err := writeMessage(s, haves, outgoing); err != nil {
 		log.Debugf("error: %s", err)
 		return err
 	}
@@ -73,13 +107,13 @@ func (bsnet *impl) SendRequest(
 	p peer.ID,
 	outgoing bsmsg.BitSwapMessage) (bsmsg.BitSwapMessage, error) {
 
-	s, err := bsnet.newStreamToPeer(ctx, p)
+	s, haves, err := bsnet.newStreamToPeer(ctx, p)
 	if err != nil {
 		return nil, err
 	}
 	defer s.Close()
 
-	if err := outgoing.ToNet(s); err != nil {
+	if err := writeMessage(s, haves, outgoing); err != nil {
 		log.Debugf("error: %s", err)
 		return nil, err
 	}
@@ -0,0 +1,259 @@
+package bitswap
+
+import (
+	"sync"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	exchange "github.com/ipfs/go-ipfs/exchange"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+)
+
+// searchDelay is how long a session waits for a block from its peers
+// before it asks every peer and looks for providers.
+var searchDelay = time.Second
+
+// Session fetches related blocks, like the ones of a DAG. Once a peer has
+// sent it a block, it only sends its wants to the peers that did. When they
+// don't deliver for searchDelay, it falls back on asking every peer and on
+// looking for providers.
+type Session struct {
+	bs  *Bitswap
+	ctx context.Context
+
+	newReqs  chan []key.Key
+	incoming chan blkRecv
+	tick     *time.Timer
+	delay    time.Duration
+
+	// only touched by the run loop
+	activePeers    map[peer.ID]struct{}
+	activePeersArr []peer.ID
+
+	// the keys the session is waiting for
+	lk        sync.Mutex
+	liveWants map[key.Key]struct{}
+}
+
+type blkRecv struct {
+	from peer.ID
+	blk  *blocks.Block
+}
+
+// NewSession returns a session fetching blocks until ctx is done.
+func (bs *Bitswap) NewSession(ctx context.Context) exchange.Fetcher {
+	s := &Session{
+		bs:          bs,
+		ctx:         ctx,
+		newReqs:     make(chan []key.Key),
+		incoming:    make(chan blkRecv),
+		delay:       searchDelay,
+		activePeers: make(map[peer.ID]struct{}),
+		liveWants:   make(map[key.Key]struct{}),
+	}
+
+	bs.sessLk.Lock()
+	bs.sessions = append(bs.sessions, s)
+	bs.sessLk.Unlock()
+
+	go s.run(ctx)
+	return s
+}
+
+func (bs *Bitswap) removeSession(s *Session) {
+	bs.sessLk.Lock()
+	defer bs.sessLk.Unlock()
+	for i, ses := range bs.sessions {
+		if ses == s {
+			bs.sessions[i] = bs.sessions[len(bs.sessions)-1]
+			bs.sessions = bs.sessions[:len(bs.sessions)-1]
+			return
+		}
+	}
+}
+
+// sessionsWanting returns the sessions waiting for k. They are looked up
+// before a block is published, as that ends the wait.
+func (bs *Bitswap) sessionsWanting(k key.Key) []*Session {
+	bs.sessLk.Lock()
+	defer bs.sessLk.Unlock()
+	var out []*Session
+	for _, s := range bs.sessions {
+		if s.wants(k) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// receiveFrom credits p for sending the session blk.
+func (s *Session) receiveFrom(p peer.ID, blk *blocks.Block) {
+	select {
+	case s.incoming <- blkRecv{from: p, blk: blk}:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Session) wants(k key.Key) bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	_, ok := s.liveWants[k]
+	return ok
+}
+
+func (s *Session) liveKeys() []key.Key {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	ks := make([]key.Key, 0, len(s.liveWants))
+	for k := range s.liveWants {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func (s *Session) run(ctx context.Context) {
+	s.tick = time.NewTimer(s.delay)
+	defer s.tick.Stop()
+
+	for {
+		select {
+		case rcv := <-s.incoming:
+			s.addActivePeer(rcv.from)
+			s.resetTick()
+
+		case keys := <-s.newReqs:
+			s.lk.Lock()
+			idle := len(s.liveWants) == 0
+			for _, k := range keys {
+				s.liveWants[k] = struct{}{}
+			}
+			s.lk.Unlock()
+			if idle {
+				s.resetTick()
+			}
+
+			if len(s.activePeersArr) == 0 {
+				s.broadcast(ctx, keys)
+			} else {
+				s.bs.wm.WantBlocksFrom(keys, s.activePeersArr)
+			}
+
+		case <-s.tick.C:
+			// the peers we asked are not delivering, ask everyone
+			if live := s.liveKeys(); len(live) > 0 {
+				s.broadcast(ctx, live)
+			}
+			s.tick.Reset(s.delay)
+
+		case <-ctx.Done():
+			s.bs.removeSession(s)
+			if live := s.liveKeys(); len(live) > 0 {
+				s.bs.CancelWants(live)
+			}
+			return
+		}
+	}
+}
+
+func (s *Session) resetTick() {
+	if !s.tick.Stop() {
+		select {
+		case <-s.tick.C:
+		default:
+		}
+	}
+	s.tick.Reset(s.delay)
+}
+
+// broadcast wants keys from every peer, and looks for providers of them.
+func (s *Session) broadcast(ctx context.Context, keys []key.Key) {
+	s.bs.wm.WantBlocks(keys)
+
+	req := &blockRequest{
+		keys: keys,
+		ctx:  ctx,
+	}
+	select {
+	case s.bs.findKeys <- req:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Session) addActivePeer(p peer.ID) {
+	if _, ok := s.activePeers[p]; ok {
+		return
+	}
+	s.activePeers[p] = struct{}{}
+	s.activePeersArr = append(s.activePeersArr, p)
+}
+
+// GetBlock fetches a block through the session.
+func (s *Session) GetBlock(parent context.Context, k key.Key) (*blocks.Block, error) {
+	return getBlock(parent, k, s.GetBlocks)
+}
+
+// GetBlocks fetches blocks through the session. The returned channel is
+// closed once all of them are received or ctx is done.
+func (s *Session) GetBlocks(ctx context.Context, keys []key.Key) (<-chan *blocks.Block, error) {
+	select {
+	case <-s.bs.process.Closing():
+		return nil, errBitswapClosed
+	default:
+	}
+
+	promise := s.bs.notifications.Subscribe(ctx, keys...)
+	select {
+	case s.newReqs <- keys:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+
+	// blocks can arrive another way than from a peer, so the wants are
+	// done with when the caller gets them
+	out := make(chan *blocks.Block)
+	go func() {
+		defer close(out)
+		defer s.cancelWants(keys)
+		for {
+			select {
+			case b, ok := <-promise:
+				if !ok {
+					return
+				}
+				s.lk.Lock()
+				delete(s.liveWants, b.Key())
+				s.lk.Unlock()
+
+				select {
+				case out <- b:
+				case <-ctx.Done():
+					return
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// cancelWants stops wanting the keys that were not received yet.
+func (s *Session) cancelWants(keys []key.Key) {
+	var live []key.Key
+	s.lk.Lock()
+	for _, k := range keys {
+		if _, ok := s.liveWants[k]; ok {
+			delete(s.liveWants, k)
+			live = append(live, k)
+		}
+	}
+	s.lk.Unlock()
+
+	if len(live) > 0 {
+		s.bs.CancelWants(live)
+	}
+}
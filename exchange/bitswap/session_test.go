@@ -0,0 +1,116 @@
+package bitswap
+
+import (
+	"testing"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	blocksutil "github.com/ipfs/go-ipfs/blocks/blocksutil"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	tn "github.com/ipfs/go-ipfs/exchange/bitswap/testnet"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	delay "github.com/ipfs/go-ipfs/thirdparty/delay"
+)
+
+// hasWant tells whether inst knows that p wants k.
+func hasWant(inst Instance, p peer.ID, k key.Key) bool {
+	for _, e := range inst.Exchange.engine.WantlistForPeer(p) {
+		if e.Key == k {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSessionTargetsActivePeers(t *testing.T) {
+	prev := searchDelay
+	searchDelay = time.Minute
+	defer func() { searchDelay = prev }()
+
+	// the peer that gets the block late serves it on the next rebroadcast
+	prevrb := rebroadcastDelay.Set(time.Millisecond * 200)
+	defer func() { rebroadcastDelay.Set(prevrb) }()
+
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(kNetworkDelay))
+	sg := NewTestSessionGenerator(net)
+	defer sg.Close()
+	bg := blocksutil.NewBlockGenerator()
+
+	inst := sg.Instances(3)
+	blks := bg.Blocks(2)
+	if err := inst[0].Exchange.HasBlock(blks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	ses := inst[2].Exchange.NewSession(ctx)
+
+	if _, err := ses.GetBlock(ctx, blks[0].Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	// inst[0] sent the first block, it is the only one asked for the next
+	promise, err := ses.GetBlocks(ctx, []key.Key{blks[1].Key()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !hasWant(inst[0], inst[2].Peer, blks[1].Key()) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("the active peer never got the want")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	if hasWant(inst[1], inst[2].Peer, blks[1].Key()) {
+		t.Fatal("the want was sent to a peer outside of the session")
+	}
+
+	if err := inst[0].Exchange.HasBlock(blks[1]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b, ok := <-promise:
+		if !ok || b.Key() != blks[1].Key() {
+			t.Fatal("did not get the second block")
+		}
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
+
+func TestSessionFallsBackOnAllPeers(t *testing.T) {
+	prev := searchDelay
+	searchDelay = time.Millisecond * 50
+	defer func() { searchDelay = prev }()
+
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(kNetworkDelay))
+	sg := NewTestSessionGenerator(net)
+	defer sg.Close()
+	bg := blocksutil.NewBlockGenerator()
+
+	inst := sg.Instances(3)
+	blks := bg.Blocks(2)
+	if err := inst[0].Exchange.HasBlock(blks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst[1].Exchange.HasBlock(blks[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	ses := inst[2].Exchange.NewSession(ctx)
+
+	for _, b := range blks {
+		got, err := ses.GetBlock(ctx, b.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Key() != b.Key() {
+			t.Fatal("got the wrong block")
+		}
+	}
+}
@@ -2,6 +2,7 @@ package bitswap
 
 import (
 	key "github.com/ipfs/go-ipfs/blocks/key"
+	decision "github.com/ipfs/go-ipfs/exchange/bitswap/decision"
 	"sort"
 )
 
@@ -12,6 +13,13 @@ type Stat struct {
 	BlocksReceived  int
 	DupBlksReceived int
 	DupDataReceived uint64
+	BlocksSent      int
+	DataSent        uint64
+
+	// Limits are the bandwidth limits of serving blocks, ThrottledPeers
+	// how many peers are held back for being over them.
+	Limits         decision.Limits
+	ThrottledPeers int
 }
 
 func (bs *Bitswap) Stat() (*Stat, error) {
@@ -22,8 +30,13 @@ func (bs *Bitswap) Stat() (*Stat, error) {
 	st.BlocksReceived = bs.blocksRecvd
 	st.DupBlksReceived = bs.dupBlocksRecvd
 	st.DupDataReceived = bs.dupDataRecvd
+	st.BlocksSent = bs.blocksSent
+	st.DataSent = bs.dataSent
 	bs.counterLk.Unlock()
 
+	st.Limits = bs.engine.Limits()
+	st.ThrottledPeers = bs.engine.ThrottledPeers()
+
 	for _, p := range bs.engine.Peers() {
 		st.Peers = append(st.Peers, p.Pretty())
 	}
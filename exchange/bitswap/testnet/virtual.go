@@ -2,6 +2,7 @@ package bitswap
 
 import (
 	"errors"
+	"sync"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	key "github.com/ipfs/go-ipfs/blocks/key"
@@ -19,6 +20,7 @@ func VirtualNetwork(rs mockrouting.Server, d delay.D) Network {
 		clients:       make(map[peer.ID]bsnet.Receiver),
 		delay:         d,
 		routingserver: rs,
+		oneZero:       make(map[peer.ID]bool),
 	}
 }
 
@@ -26,6 +28,26 @@ type network struct {
 	clients       map[peer.ID]bsnet.Receiver
 	routingserver mockrouting.Server
 	delay         delay.D
+
+	// peers speaking the protocol of before HAVE and DONT_HAVE
+	ozLk    sync.Mutex
+	oneZero map[peer.ID]bool
+}
+
+// SetOneZero makes p a peer of n speaking the protocol of before HAVE and
+// DONT_HAVE: it neither gets nor sends want-have entries and their answers.
+// n must be a virtual network.
+func SetOneZero(n Network, p peer.ID) {
+	net := n.(*network)
+	net.ozLk.Lock()
+	defer net.ozLk.Unlock()
+	net.oneZero[p] = true
+}
+
+func (n *network) isOneZero(p peer.ID) bool {
+	n.ozLk.Lock()
+	defer n.ozLk.Unlock()
+	return n.oneZero[p]
 }
 
 func (n *network) Adapter(p testutil.Identity) bsnet.BitSwapNetwork {
@@ -59,6 +81,9 @@ func (n *network) SendMessage(
 	// nb: terminate the context since the context wouldn't actually be passed
 	// over the network in a real scenario
 
+	if n.isOneZero(from) || n.isOneZero(to) {
+		message = bsmsg.AsV0(message)
+	}
 	go n.deliver(receiver, from, message)
 
 	return nil
@@ -121,6 +146,10 @@ func (nc *networkClient) SetDelegate(r bsnet.Receiver) {
 	nc.Receiver = r
 }
 
+func (nc *networkClient) SupportsHave(p peer.ID) bool {
+	return !nc.network.isOneZero(p) && !nc.network.isOneZero(nc.local)
+}
+
 func (nc *networkClient) ConnectTo(_ context.Context, p peer.ID) error {
 	if !nc.network.HasPeer(p) {
 		return errors.New("no such peer in network")
@@ -15,13 +15,28 @@ import (
 
 type WantManager struct {
 	// sync channels for Run loop
-	incoming   chan []*bsmsg.Entry
-	connect    chan peer.ID // notification channel for new peers connecting
-	disconnect chan peer.ID // notification channel for peers disconnecting
+	incoming   chan *wantSet
+	connect    chan peer.ID   // notification channel for new peers connecting
+	disconnect chan peer.ID   // notification channel for peers disconnecting
+	presences  chan *presence // HAVE and DONT_HAVE received from peers
 
 	// synchronized by Run loop, only touch inside there
 	peers map[peer.ID]*msgQueue
-	wl    *wantlist.ThreadSafe
+
+	// asked is the peer each block was wanted from after it answered HAVE,
+	// haves the other peers that answered HAVE, to ask next. Synchronized by
+	// the Run loop as well.
+	asked map[key.Key]peer.ID
+	haves map[key.Key][]peer.ID
+
+	// wl holds everything we want, bcwl the part of it wanted from every
+	// peer. The rest is only wanted from the peers of a session.
+	wl   *wantlist.ThreadSafe
+	bcwl *wantlist.ThreadSafe
+
+	// requests is when each peer was asked for the blocks, to measure how
+	// long it takes to send them.
+	requests *requestTimes
 
 	network bsnet.BitSwapNetwork
 	ctx     context.Context
@@ -29,11 +44,16 @@ type WantManager struct {
 
 func NewWantManager(ctx context.Context, network bsnet.BitSwapNetwork) *WantManager {
 	return &WantManager{
-		incoming:   make(chan []*bsmsg.Entry, 10),
+		incoming:   make(chan *wantSet, 10),
 		connect:    make(chan peer.ID, 10),
 		disconnect: make(chan peer.ID, 10),
+		presences:  make(chan *presence, 10),
 		peers:      make(map[peer.ID]*msgQueue),
+		asked:      make(map[key.Key]peer.ID),
+		haves:      make(map[key.Key][]peer.ID),
 		wl:         wantlist.NewThreadSafe(),
+		bcwl:       wantlist.NewThreadSafe(),
+		requests:   &requestTimes{m: make(map[peer.ID]map[key.Key]time.Time)},
 		network:    network,
 		ctx:        ctx,
 	}
@@ -49,6 +69,24 @@ type cancellation struct {
 	blk key.Key
 }
 
+// wantSet is a change to the wantlist, sent to targets, or to every peer if
+// targets is nil. The HAVE and DONT_HAVE it carries are only sent to the
+// targets.
+type wantSet struct {
+	entries []*bsmsg.Entry
+	targets []peer.ID
+
+	haves     []key.Key
+	dontHaves []key.Key
+}
+
+// presence is what a peer answered to our want-have entries.
+type presence struct {
+	from      peer.ID
+	haves     []key.Key
+	dontHaves []key.Key
+}
+
 type msgQueue struct {
 	p peer.ID
 
@@ -56,26 +94,65 @@ type msgQueue struct {
 	out     bsmsg.BitSwapMessage
 	network bsnet.BitSwapNetwork
 
+	// wl is what the peer was told we want, blockWants the part of it the
+	// peer was asked the blocks of rather than whether it has them. Only
+	// touched by the Run loop.
+	wl         *wantlist.Wantlist
+	blockWants map[key.Key]struct{}
+
+	requests *requestTimes
+
 	refcnt int
 
 	work chan struct{}
 	done chan struct{}
 }
 
+// WantBlocks wants the blocks from every peer. The peers that support it
+// are asked whether they have the blocks, and the block is then wanted
+// from the first one answering HAVE.
 func (pm *WantManager) WantBlocks(ks []key.Key) {
 	log.Infof("want blocks: %s", ks)
-	pm.addEntries(ks, false)
+	pm.addEntries(ks, false, nil)
+}
+
+// WantBlocksFrom asks only the given peers for the blocks, the ones it is
+// not connected to are skipped. They already sent blocks of the session,
+// so they are asked for the blocks straight away.
+func (pm *WantManager) WantBlocksFrom(ks []key.Key, peers []peer.ID) {
+	log.Infof("want blocks from %d peers: %s", len(peers), ks)
+	if peers == nil {
+		peers = []peer.ID{}
+	}
+	pm.addEntries(ks, false, peers)
 }
 
 func (pm *WantManager) CancelWants(ks []key.Key) {
-	pm.addEntries(ks, true)
+	pm.addEntries(ks, true, nil)
+}
+
+// SendPresences answers the want-have entries of p.
+func (pm *WantManager) SendPresences(p peer.ID, haves, dontHaves []key.Key) {
+	select {
+	case pm.incoming <- &wantSet{targets: []peer.ID{p}, haves: haves, dontHaves: dontHaves}:
+	case <-pm.ctx.Done():
+	}
+}
+
+// ReceivePresences handles what p answered to our want-have entries.
+func (pm *WantManager) ReceivePresences(p peer.ID, haves, dontHaves []key.Key) {
+	select {
+	case pm.presences <- &presence{from: p, haves: haves, dontHaves: dontHaves}:
+	case <-pm.ctx.Done():
+	}
 }
 
-func (pm *WantManager) addEntries(ks []key.Key, cancel bool) {
+func (pm *WantManager) addEntries(ks []key.Key, cancel bool, targets []peer.ID) {
 	var entries []*bsmsg.Entry
 	for i, k := range ks {
 		entries = append(entries, &bsmsg.Entry{
-			Cancel: cancel,
+			Cancel:   cancel,
+			WantHave: !cancel && targets == nil,
 			Entry: wantlist.Entry{
 				Key:      k,
 				Priority: kMaxPriority - i,
@@ -83,12 +160,58 @@ func (pm *WantManager) addEntries(ks []key.Key, cancel bool) {
 		})
 	}
 	select {
-	case pm.incoming <- entries:
+	case pm.incoming <- &wantSet{entries: entries, targets: targets}:
 	case <-pm.ctx.Done():
 	}
 }
 
-func (pm *WantManager) SendBlock(ctx context.Context, env *engine.Envelope) {
+// requestedAt returns when p was asked for the block k, if it was.
+func (pm *WantManager) requestedAt(p peer.ID, k key.Key) (time.Time, bool) {
+	return pm.requests.get(p, k)
+}
+
+// requestTimes is when each peer was asked for blocks.
+type requestTimes struct {
+	lk sync.Mutex
+	m  map[peer.ID]map[key.Key]time.Time
+}
+
+func (rt *requestTimes) add(p peer.ID, k key.Key, t time.Time) {
+	rt.lk.Lock()
+	defer rt.lk.Unlock()
+	ks, ok := rt.m[p]
+	if !ok {
+		ks = make(map[key.Key]time.Time)
+		rt.m[p] = ks
+	}
+	ks[k] = t
+}
+
+func (rt *requestTimes) get(p peer.ID, k key.Key) (time.Time, bool) {
+	rt.lk.Lock()
+	defer rt.lk.Unlock()
+	t, ok := rt.m[p][k]
+	return t, ok
+}
+
+func (rt *requestTimes) remove(p peer.ID, k key.Key) {
+	rt.lk.Lock()
+	defer rt.lk.Unlock()
+	if ks, ok := rt.m[p]; ok {
+		delete(ks, k)
+		if len(ks) == 0 {
+			delete(rt.m, p)
+		}
+	}
+}
+
+func (rt *requestTimes) forget(p peer.ID) {
+	rt.lk.Lock()
+	defer rt.lk.Unlock()
+	delete(rt.m, p)
+}
+
+func (pm *WantManager) SendBlock(ctx context.Context, env *engine.Envelope) error {
 	// Blocks need to be sent synchronously to maintain proper backpressure
 	// throughout the network stack
 	defer env.Sent()
@@ -100,6 +223,7 @@ func (pm *WantManager) SendBlock(ctx context.Context, env *engine.Envelope) {
 	if err != nil {
 		log.Infof("sendblock error: %s", err)
 	}
+	return err
 }
 
 func (pm *WantManager) startPeerHandler(p peer.ID) *msgQueue {
@@ -112,12 +236,12 @@ func (pm *WantManager) startPeerHandler(p peer.ID) *msgQueue {
 	mq = pm.newMsgQueue(p)
 
 	// new peer, we will want to give them our full wantlist
-	fullwantlist := bsmsg.New(true)
-	for _, e := range pm.wl.Entries() {
-		fullwantlist.AddEntry(e.Key, e.Priority)
+	var es []*bsmsg.Entry
+	for _, e := range pm.bcwl.Entries() {
+		es = append(es, &bsmsg.Entry{Entry: e, WantHave: true})
 	}
-	mq.out = fullwantlist
-	mq.work <- struct{}{}
+	mq.out = bsmsg.New(true)
+	mq.addMessage(es)
 
 	pm.peers[p] = mq
 	go mq.runQueue(pm.ctx)
@@ -138,6 +262,85 @@ func (pm *WantManager) stopPeerHandler(p peer.ID) {
 
 	close(pq.done)
 	delete(pm.peers, p)
+	pm.requests.forget(p)
+
+	// the blocks it was asked for are wanted from the next peer having them
+	for k, asked := range pm.asked {
+		if asked == p {
+			delete(pm.asked, k)
+			pm.askNext(k)
+		}
+	}
+}
+
+// receiveHave wants k from p if no other peer was asked for it yet, or
+// keeps p to ask next.
+func (pm *WantManager) receiveHave(p peer.ID, k key.Key) {
+	if _, ok := pm.wl.Contains(k); !ok {
+		return
+	}
+	if asked, ok := pm.asked[k]; ok {
+		if asked == p {
+			return
+		}
+		for _, h := range pm.haves[k] {
+			if h == p {
+				return
+			}
+		}
+		pm.haves[k] = append(pm.haves[k], p)
+		return
+	}
+	pm.wantBlockFrom(k, p)
+}
+
+// receiveDontHave moves on to the next peer having k when p was asked for
+// it.
+func (pm *WantManager) receiveDontHave(p peer.ID, k key.Key) {
+	if asked, ok := pm.asked[k]; ok && asked == p {
+		delete(pm.asked, k)
+		pm.askNext(k)
+		return
+	}
+	hs := pm.haves[k]
+	for i, h := range hs {
+		if h == p {
+			pm.haves[k] = append(hs[:i], hs[i+1:]...)
+			break
+		}
+	}
+}
+
+// askNext wants k from the first peer that answered HAVE and is still
+// connected.
+func (pm *WantManager) askNext(k key.Key) {
+	hs := pm.haves[k]
+	for len(hs) > 0 {
+		p := hs[0]
+		hs = hs[1:]
+		if pm.wantBlockFrom(k, p) {
+			break
+		}
+	}
+	if len(hs) == 0 {
+		delete(pm.haves, k)
+	} else {
+		pm.haves[k] = hs
+	}
+}
+
+func (pm *WantManager) wantBlockFrom(k key.Key, p peer.ID) bool {
+	mq, ok := pm.peers[p]
+	if !ok {
+		return false
+	}
+	e, ok := pm.wl.Contains(k)
+	if !ok {
+		return false
+	}
+	pm.asked[k] = p
+	mq.addMessage([]*bsmsg.Entry{{Entry: e}})
+	return true
 }
 
 func (mq *msgQueue) runQueue(ctx context.Context) {
@@ -209,33 +412,60 @@ func (pm *WantManager) Run() {
 	defer tock.Stop()
 	for {
 		select {
-		case entries := <-pm.incoming:
+		case ws := <-pm.incoming:
 
 			// add changes to our wantlist
-			for _, e := range entries {
+			for _, e := range ws.entries {
 				if e.Cancel {
 					pm.wl.Remove(e.Key)
+					pm.bcwl.Remove(e.Key)
+					delete(pm.asked, e.Key)
+					delete(pm.haves, e.Key)
 				} else {
 					pm.wl.Add(e.Key, e.Priority)
+					if ws.targets == nil {
+						pm.bcwl.Add(e.Key, e.Priority)
+					}
 				}
 			}
 
-			// broadcast those wantlist changes
-			for _, p := range pm.peers {
-				p.addMessage(entries)
+			// broadcast those wantlist changes, or send them to the targets
+			if ws.targets == nil {
+				for _, p := range pm.peers {
+					p.addMessage(ws.entries)
+				}
+			} else {
+				for _, t := range ws.targets {
+					if p, ok := pm.peers[t]; ok {
+						p.addMessage(ws.entries)
+						p.addPresences(ws.haves, ws.dontHaves)
+					}
+				}
+			}
+
+		case pr := <-pm.presences:
+			for _, k := range pr.haves {
+				pm.receiveHave(pr.from, k)
+			}
+			for _, k := range pr.dontHaves {
+				pm.receiveDontHave(pr.from, k)
 			}
 
 		case <-tock.C:
+			// the peers asked for blocks may not deliver, start over with
+			// whoever answers HAVE to the resent wantlist
+			pm.asked = make(map[key.Key]peer.ID)
+			pm.haves = make(map[key.Key][]peer.ID)
+
 			// resend entire wantlist every so often (REALLY SHOULDNT BE NECESSARY)
-			var es []*bsmsg.Entry
-			for _, e := range pm.wl.Entries() {
-				es = append(es, &bsmsg.Entry{Entry: e})
-			}
 			for _, p := range pm.peers {
-				p.outlk.Lock()
-				p.out = bsmsg.New(true)
-				p.outlk.Unlock()
+				var es []*bsmsg.Entry
+				for _, e := range p.wl.Entries() {
+					_, block := p.blockWants[e.Key]
+					es = append(es, &bsmsg.Entry{Entry: e, WantHave: !block})
+				}
 
+				p.startFull()
 				p.addMessage(es)
 			}
 		case p := <-pm.connect:
@@ -255,6 +485,9 @@ func (wm *WantManager) newMsgQueue(p peer.ID) *msgQueue {
 	mq.network = wm.network
 	mq.p = p
 	mq.refcnt = 1
+	mq.wl = wantlist.New()
+	mq.blockWants = make(map[key.Key]struct{})
+	mq.requests = wm.requests
 
 	return mq
 }
@@ -279,10 +512,73 @@ func (mq *msgQueue) addMessage(entries []*bsmsg.Entry) {
 	// otherwise, combine the one we are holding with the
 	// one passed in
 	for _, e := range entries {
-		if e.Cancel {
+		_, block := mq.blockWants[e.Key]
+		switch {
+		case e.Cancel:
+			mq.wl.Remove(e.Key)
+			delete(mq.blockWants, e.Key)
+			mq.requests.remove(mq.p, e.Key)
 			mq.out.Cancel(e.Key)
-		} else {
+		case e.WantHave && !block && mq.network.SupportsHave(mq.p):
+			mq.wl.Add(e.Key, e.Priority)
+			mq.out.AddWantHave(e.Key, e.Priority, true)
+		default:
+			// never take back a want-block, the peer may be sending it
+			if !block {
+				mq.requests.add(mq.p, e.Key, time.Now())
+			}
+			mq.wl.Add(e.Key, e.Priority)
+			mq.blockWants[e.Key] = struct{}{}
 			mq.out.AddEntry(e.Key, e.Priority)
 		}
 	}
 }
+
+// startFull turns the pending message into a full wantlist, keeping the
+// cancels and the answers to the want-have entries of the peer it holds.
+func (mq *msgQueue) startFull() {
+	mq.outlk.Lock()
+	defer mq.outlk.Unlock()
+
+	full := bsmsg.New(true)
+	if mq.out != nil {
+		for _, e := range mq.out.Wantlist() {
+			if e.Cancel {
+				full.Cancel(e.Key)
+			}
+		}
+		for _, k := range mq.out.Haves() {
+			full.AddHave(k)
+		}
+		for _, k := range mq.out.DontHaves() {
+			full.AddDontHave(k)
+		}
+	}
+	mq.out = full
+}
+
+// addPresences adds answers to the want-have entries of the peer.
+func (mq *msgQueue) addPresences(haves, dontHaves []key.Key) {
+	if len(haves) == 0 && len(dontHaves) == 0 {
+		return
+	}
+
+	mq.outlk.Lock()
+	defer func() {
+		mq.outlk.Unlock()
+		select {
+		case mq.work <- struct{}{}:
+		default:
+		}
+	}()
+
+	if mq.out == nil {
+		mq.out = bsmsg.New(false)
+	}
+	for _, k := range haves {
+		mq.out.AddHave(k)
+	}
+	for _, k := range dontHaves {
+		mq.out.AddDontHave(k)
+	}
+}
@@ -0,0 +1,32 @@
+package bitswap
+
+import (
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+)
+
+func TestStartFullKeepsPending(t *testing.T) {
+	wm := NewWantManager(context.Background(), nil)
+	mq := wm.newMsgQueue(peer.ID("partner"))
+	mq.addPresences([]key.Key{"have"}, []key.Key{"donthave"})
+	mq.out.Cancel(key.Key("cancelled"))
+
+	mq.startFull()
+	if !mq.out.Full() {
+		t.Fatal("expected a full wantlist")
+	}
+	if hs := mq.out.Haves(); len(hs) != 1 || hs[0] != "have" {
+		t.Fatal("lost the pending HAVE:", hs)
+	}
+	if dhs := mq.out.DontHaves(); len(dhs) != 1 || dhs[0] != "donthave" {
+		t.Fatal("lost the pending DONT_HAVE:", dhs)
+	}
+	es := mq.out.Wantlist()
+	if len(es) != 1 || es[0].Key != "cancelled" || !es[0].Cancel {
+		t.Fatal("lost the pending cancel:", es)
+	}
+}
@@ -62,7 +62,14 @@ func (bs *Bitswap) taskWorker(ctx context.Context, id int) {
 					"Block":  envelope.Block.Multihash.B58String(),
 				})
 
-				bs.wm.SendBlock(ctx, envelope)
+				if err := bs.wm.SendBlock(ctx, envelope); err == nil {
+					bs.engine.BlockSent(envelope.Peer, envelope.Block)
+
+					bs.counterLk.Lock()
+					bs.blocksSent++
+					bs.dataSent += uint64(len(envelope.Block.Data))
+					bs.counterLk.Unlock()
+				}
 			case <-ctx.Done():
 				return
 			}
@@ -12,10 +12,7 @@ import (
 // Any type that implements exchange.Interface may be used as an IPFS block
 // exchange protocol.
 type Interface interface {
-	// GetBlock returns the block associated with a given key.
-	GetBlock(context.Context, key.Key) (*blocks.Block, error)
-
-	GetBlocks(context.Context, []key.Key) (<-chan *blocks.Block, error)
+	Fetcher
 
 	// TODO Should callers be concerned with whether the block was made
 	// available on the network?
@@ -23,3 +20,21 @@ type Interface interface {
 
 	io.Closer
 }
+
+// Fetcher retrieves blocks from the network.
+type Fetcher interface {
+	// GetBlock returns the block associated with a given key.
+	GetBlock(context.Context, key.Key) (*blocks.Block, error)
+
+	GetBlocks(context.Context, []key.Key) (<-chan *blocks.Block, error)
+}
+
+// SessionExchange is an exchange that can scope the requests fetching
+// related blocks, like the ones of a DAG, to a session.
+type SessionExchange interface {
+	Interface
+
+	// NewSession returns a Fetcher for related blocks, running until ctx
+	// is done.
+	NewSession(context.Context) Fetcher
+}
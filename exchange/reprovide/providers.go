@@ -0,0 +1,112 @@
+package reprovide
+
+import (
+	"fmt"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	pin "github.com/ipfs/go-ipfs/pin"
+)
+
+// KeyChanFunc lists the keys a reprovider announces. It is the strategy
+// of the reprovider.
+type KeyChanFunc func(context.Context) (<-chan key.Key, error)
+
+// NewBlockstoreProvider announces every block of bstore.
+func NewBlockstoreProvider(bstore blocks.Blockstore) KeyChanFunc {
+	return func(ctx context.Context) (<-chan key.Key, error) {
+		return bstore.AllKeysChan(ctx)
+	}
+}
+
+// NewPinnedProvider announces the pinned blocks: the direct pins and the
+// DAGs under the recursive pins, or only the roots of the pins with
+// onlyRoots. The DAGs are walked in bstore, the blocks missing from it are
+// not announced.
+func NewPinnedProvider(pinning pin.Pinner, bstore blocks.Blockstore, onlyRoots bool) KeyChanFunc {
+	return func(ctx context.Context) (<-chan key.Key, error) {
+		out := make(chan key.Key)
+		go func() {
+			defer close(out)
+
+			// visited holds a key per pinned block, like the walk of the
+			// pins in a GC does, so the blocks shared by DAGs are only
+			// announced once.
+			visited := make(map[key.Key]struct{})
+			visit := func(k key.Key) bool {
+				if _, ok := visited[k]; ok {
+					return false
+				}
+				visited[k] = struct{}{}
+				return true
+			}
+			send := func(k key.Key) bool {
+				select {
+				case out <- k:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+			sendIfHas := func(k key.Key) {
+				if has, err := bstore.Has(k); err == nil && has {
+					send(k)
+				}
+			}
+
+			// raw is set for raw blocks, which have nothing below
+			var walk func(k key.Key, raw bool)
+			walk = func(k key.Key, raw bool) {
+				if !visit(k) {
+					return
+				}
+				if raw {
+					sendIfHas(k)
+					return
+				}
+				b, err := bstore.Get(k)
+				if err != nil || !send(k) {
+					return
+				}
+				nd, err := merkledag.Decoded(b.Data)
+				if err != nil {
+					return
+				}
+				for _, l := range nd.Links {
+					walk(key.Key(l.Hash), l.Raw)
+				}
+			}
+
+			for _, k := range pinning.RecursiveKeys() {
+				if !onlyRoots {
+					walk(k, false)
+				} else if visit(k) {
+					sendIfHas(k)
+				}
+			}
+			for _, k := range pinning.DirectKeys() {
+				if visit(k) {
+					sendIfHas(k)
+				}
+			}
+		}()
+		return out, nil
+	}
+}
+
+// NewStrategy returns the strategy called name, the empty name being
+// "all".
+func NewStrategy(name string, pinning pin.Pinner, bstore blocks.Blockstore) (KeyChanFunc, error) {
+	switch name {
+	case "", "all":
+		return NewBlockstoreProvider(bstore), nil
+	case "pinned":
+		return NewPinnedProvider(pinning, bstore, false), nil
+	case "roots":
+		return NewPinnedProvider(pinning, bstore, true), nil
+	default:
+		return nil, fmt.Errorf("unknown reprovider strategy %q", name)
+	}
+}
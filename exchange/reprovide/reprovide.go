@@ -2,11 +2,11 @@ package reprovide
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	backoff "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cenkalti/backoff"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
-	blocks "github.com/ipfs/go-ipfs/blocks/blockstore"
 	routing "github.com/ipfs/go-ipfs/routing"
 	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 )
@@ -17,40 +17,145 @@ type Reprovider struct {
 	// The routing system to provide values through
 	rsys routing.IpfsRouting
 
-	// The backing store for blocks to be provided
-	bstore blocks.Blockstore
+	// keyProvider lists the keys to provide
+	keyProvider KeyChanFunc
+
+	// trigger asks ProvideEvery for a run
+	trigger chan struct{}
+
+	lk   sync.Mutex
+	stat Stat
+	// done is closed when the run going on or asked for ends, nil if
+	// there is none
+	done chan struct{}
+}
+
+// Stat reports on the runs of a reprovider.
+type Stat struct {
+	// Interval is the time between two runs, zero if they are only
+	// triggered.
+	Interval time.Duration
+
+	// Running is set during a run, which provided Provided keys since
+	// Started.
+	Running  bool
+	Started  time.Time
+	Provided int
+
+	// LastRun is when the last run ended, after LastDuration, having
+	// provided LastProvided keys. LastError is why it failed, if it did.
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastProvided int
+	LastError    string
 }
 
-func NewReprovider(rsys routing.IpfsRouting, bstore blocks.Blockstore) *Reprovider {
+func NewReprovider(rsys routing.IpfsRouting, keyProvider KeyChanFunc) *Reprovider {
 	return &Reprovider{
-		rsys:   rsys,
-		bstore: bstore,
+		rsys:        rsys,
+		keyProvider: keyProvider,
+		trigger:     make(chan struct{}, 1),
 	}
 }
 
+// ProvideEvery runs Reprovide every tick, and when triggered, until ctx is
+// done. With a zero tick, the runs are only triggered.
 func (rp *Reprovider) ProvideEvery(ctx context.Context, tick time.Duration) {
+	rp.lk.Lock()
+	rp.stat.Interval = tick
+	rp.lk.Unlock()
+
 	// dont reprovide immediately.
 	// may have just started the daemon and shutting it down immediately.
 	// probability( up another minute | uptime ) increases with uptime.
-	after := time.After(time.Minute)
+	var after <-chan time.Time
+	if tick > 0 {
+		after = time.After(time.Minute)
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-after:
-			err := rp.Reprovide(ctx)
-			if err != nil {
-				log.Debug(err)
-			}
+		case <-rp.trigger:
+		}
+		err := rp.Reprovide(ctx)
+		if err != nil {
+			log.Debug(err)
+		}
+		if tick > 0 {
 			after = time.After(tick)
 		}
 	}
 }
 
+// Trigger asks ProvideEvery for a run, unless one is going on already. It
+// returns a channel closed when that run ends.
+func (rp *Reprovider) Trigger() <-chan struct{} {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+	if rp.done == nil {
+		rp.done = make(chan struct{})
+	}
+	if !rp.stat.Running {
+		select {
+		case rp.trigger <- struct{}{}:
+		default:
+			// a run was asked for already
+		}
+	}
+	return rp.done
+}
+
+// Stat returns the progress of the current run, and how the last one went.
+func (rp *Reprovider) Stat() Stat {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+	return rp.stat
+}
+
+// Reprovide provides every key of the strategy.
 func (rp *Reprovider) Reprovide(ctx context.Context) error {
-	keychan, err := rp.bstore.AllKeysChan(ctx)
+	rp.lk.Lock()
+	if rp.done == nil {
+		rp.done = make(chan struct{})
+	}
+	done := rp.done
+	rp.stat.Running = true
+	rp.stat.Started = time.Now()
+	rp.stat.Provided = 0
+	// the run asked for is this one
+	select {
+	case <-rp.trigger:
+	default:
+	}
+	rp.lk.Unlock()
+
+	err := rp.provideKeys(ctx)
+
+	rp.lk.Lock()
+	rp.stat.Running = false
+	rp.stat.LastRun = time.Now()
+	rp.stat.LastDuration = rp.stat.LastRun.Sub(rp.stat.Started)
+	rp.stat.LastProvided = rp.stat.Provided
+	rp.stat.LastError = ""
 	if err != nil {
-		return fmt.Errorf("Failed to get key chan from blockstore: %s", err)
+		rp.stat.LastError = err.Error()
+	}
+	rp.done = nil
+	close(done)
+	rp.lk.Unlock()
+	return err
+}
+
+func (rp *Reprovider) provideKeys(ctx context.Context) error {
+	// stops listing the keys when providing one fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keychan, err := rp.keyProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get keys to provide: %s", err)
 	}
 	for k := range keychan {
 		op := func() error {
@@ -68,6 +173,10 @@ func (rp *Reprovider) Reprovide(ctx context.Context) error {
 			log.Debugf("Providing failed after number of retries: %s", err)
 			return err
 		}
+
+		rp.lk.Lock()
+		rp.stat.Provided++
+		rp.lk.Unlock()
 	}
-	return nil
+	return ctx.Err()
 }
@@ -2,12 +2,18 @@ package reprovide_test
 
 import (
 	"testing"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	blocks "github.com/ipfs/go-ipfs/blocks"
 	blockstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	blockservice "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	pin "github.com/ipfs/go-ipfs/pin"
 	mock "github.com/ipfs/go-ipfs/routing/mock"
 	testutil "github.com/ipfs/go-ipfs/util/testutil"
 
@@ -31,7 +37,7 @@ func TestReprovide(t *testing.T) {
 	blk := blocks.NewBlock([]byte("this is a test"))
 	bstore.Put(blk)
 
-	reprov := NewReprovider(clA, bstore)
+	reprov := NewReprovider(clA, NewBlockstoreProvider(bstore))
 	err := reprov.Reprovide(ctx)
 	if err != nil {
 		t.Fatal(err)
@@ -50,3 +56,122 @@ func TestReprovide(t *testing.T) {
 		t.Fatal("Somehow got the wrong peer back as a provider.")
 	}
 }
+
+func TestPinnedStrategies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore)))
+
+	leaf := &merkledag.Node{Data: []byte("leaf")}
+	goneLeaf := &merkledag.Node{Data: []byte("gone leaf")}
+	root := &merkledag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("gone", goneLeaf); err != nil {
+		t.Fatal(err)
+	}
+	direct := &merkledag.Node{Data: []byte("direct")}
+	goneDirect := &merkledag.Node{Data: []byte("gone direct")}
+	var ks []key.Key
+	for _, nd := range []*merkledag.Node{root, leaf, direct, goneLeaf, goneDirect} {
+		k, err := dserv.Add(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks = append(ks, k)
+	}
+	if err := bstore.Put(blocks.NewBlock([]byte("not pinned"))); err != nil {
+		t.Fatal(err)
+	}
+
+	pinner := pin.NewPinner(dstore, dserv)
+	if err := pinner.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Pin(ctx, goneDirect, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blocks missing from the blockstore are not announced
+	for _, k := range ks[3:] {
+		if err := bstore.DeleteBlock(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ks = ks[:3]
+
+	for _, c := range []struct {
+		strategy string
+		want     []key.Key
+	}{
+		{"pinned", ks},
+		{"roots", []key.Key{ks[0], ks[2]}},
+	} {
+		keyProvider, err := NewStrategy(c.strategy, pinner, bstore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keychan, err := keyProvider(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[key.Key]bool)
+		for k := range keychan {
+			if got[k] {
+				t.Fatalf("%s: %s listed twice", c.strategy, k)
+			}
+			got[k] = true
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: listed %d keys, wanted %d", c.strategy, len(got), len(c.want))
+		}
+		for _, k := range c.want {
+			if !got[k] {
+				t.Fatalf("%s: %s was not listed", c.strategy, k)
+			}
+		}
+	}
+
+	if _, err := NewStrategy("bogus", pinner, bstore); err == nil {
+		t.Fatal("expected an error for an unknown strategy")
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mrserv := mock.NewServer()
+	clA := mrserv.Client(testutil.RandIdentityOrFatal(t))
+
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	for _, s := range []string{"a", "b"} {
+		if err := bstore.Put(blocks.NewBlock([]byte(s))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reprov := NewReprovider(clA, NewBlockstoreProvider(bstore))
+	go reprov.ProvideEvery(ctx, 0)
+
+	select {
+	case <-reprov.Trigger():
+	case <-time.After(time.Second * 5):
+		t.Fatal("the run never ended")
+	}
+
+	st := reprov.Stat()
+	if st.Running || st.LastRun.IsZero() {
+		t.Fatal("the run was not recorded")
+	}
+	if st.LastProvided != 2 || st.LastError != "" {
+		t.Fatalf("wrong stats: %+v", st)
+	}
+}
@@ -0,0 +1,443 @@
+// package filestore implements a Blockstore that can keep the leaves of
+// added files as references to the original files, instead of copying
+// their data into the repo.
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
+	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
+)
+
+var log = logging.Logger("filestore")
+
+// RefPrefix namespaces the references in the repo datastore.
+var RefPrefix = ds.NewKey("/filestore")
+
+// ErrFileChanged is returned when a referenced file no longer holds the
+// data it had when it was added.
+var ErrFileChanged = errors.New("filestore: referenced file has changed")
+
+// ErrFileMissing is returned when a referenced file has been removed.
+var ErrFileMissing = errors.New("filestore: referenced file is missing")
+
+// DataRef locates the data of a leaf block in a file outside the repo.
+type DataRef struct {
+	Path   string
+	Offset uint64
+	Size   uint64
+
+	// Type is the unixfs type of the leaf, needed to rebuild the block
+	// around the data.
+	Type ftpb.Data_DataType
+
+	// Raw is set for a raw leaf, whose block is the data itself.
+	Raw bool
+
+	// ModTime (in unix nanoseconds) and FileSize of the file when it was
+	// added, used to notice it changed.
+	ModTime  int64
+	FileSize int64
+}
+
+// Filestore is a Blockstore that reads blocks it holds references for from
+// the referenced files, and everything else from the Blockstore it wraps.
+type Filestore struct {
+	bs   bstore.Blockstore
+	refs ds.Batching
+
+	// hasRefs is set once any reference is known to exist, so the
+	// blockstore lookups of nodes not using the filestore don't pay for
+	// it. Accessed atomically.
+	hasRefs int32
+}
+
+var _ bstore.Blockstore = (*Filestore)(nil)
+
+// New returns a Filestore wrapping bs, which keeps its references in d.
+func New(bs bstore.Blockstore, d ds.ThreadSafeDatastore) (*Filestore, error) {
+	f := &Filestore{
+		bs:   bs,
+		refs: dsns.Wrap(d, RefPrefix),
+	}
+
+	// the mount datastore can't limit queries, stop at the first result
+	res, err := f.refs.Query(dsq.Query{Prefix: RefPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	if e, ok := <-res.Next(); ok {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		f.hasRefs = 1
+	}
+	return f, nil
+}
+
+func (f *Filestore) mayHaveRefs() bool {
+	return atomic.LoadInt32(&f.hasRefs) != 0
+}
+
+// refKey returns the datastore key of the reference to k. It is b58
+// encoded, as a binary multihash may hold a '/' that datastore keys clean
+// away.
+func refKey(k key.Key) ds.Key {
+	return ds.NewKey(k.B58String())
+}
+
+// PutRef records that the block k can be read from the file at ref.
+func (f *Filestore) PutRef(k key.Key, ref *DataRef) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	if err := f.refs.Put(refKey(k), data); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&f.hasRefs, 1)
+	return nil
+}
+
+// Ref returns the reference recorded for k, or ds.ErrNotFound.
+func (f *Filestore) Ref(k key.Key) (*DataRef, error) {
+	if !f.mayHaveRefs() {
+		return nil, ds.ErrNotFound
+	}
+	v, err := f.refs.Get(refKey(k))
+	if err != nil {
+		return nil, err
+	}
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, bstore.ValueTypeMismatch
+	}
+	ref := new(DataRef)
+	if err := json.Unmarshal(data, ref); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// ReadRef reads the block k from the file at ref, checking that the file
+// did not change since it was added.
+func ReadRef(k key.Key, ref *DataRef) (*blocks.Block, error) {
+	file, err := os.Open(ref.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrFileMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != ref.FileSize || fi.ModTime().UnixNano() != ref.ModTime {
+		return nil, ErrFileChanged
+	}
+
+	buf := make([]byte, ref.Size)
+	if _, err := file.ReadAt(buf, int64(ref.Offset)); err != nil {
+		if err == io.EOF {
+			return nil, ErrFileChanged
+		}
+		return nil, err
+	}
+
+	data := buf
+	if !ref.Raw {
+		data, err = leafBytes(ref.Type, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the modification time is no guarantee, always check the data
+	if err := blocks.VerifyHash(data, mh.Multihash(k)); err != nil {
+		return nil, ErrFileChanged
+	}
+	return blocks.NewBlockWithHash(data, mh.Multihash(k))
+}
+
+// leafBytes encodes a leaf node holding data the way the importer does.
+func leafBytes(typ ftpb.Data_DataType, data []byte) ([]byte, error) {
+	fsn := &ft.FSNode{Type: typ, Data: data}
+	fsdata, err := fsn.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+	nd := &dag.Node{Data: fsdata}
+	return nd.Marshal()
+}
+
+func (f *Filestore) Get(k key.Key) (*blocks.Block, error) {
+	b, err := f.bs.Get(k)
+	if err != bstore.ErrNotFound {
+		return b, err
+	}
+
+	ref, err := f.Ref(k)
+	if err == ds.ErrNotFound {
+		return nil, bstore.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err = ReadRef(k, ref)
+	if err != nil {
+		log.Errorf("block %s can't be read from %s: %s", k, ref.Path, err)
+		return nil, err
+	}
+	return b, nil
+}
+
+func (f *Filestore) Has(k key.Key) (bool, error) {
+	has, err := f.bs.Has(k)
+	if err != nil || has || !f.mayHaveRefs() {
+		return has, err
+	}
+	return f.refs.Has(refKey(k))
+}
+
+// Put stores b in the wrapped Blockstore, unless it is already referenced.
+func (f *Filestore) Put(b *blocks.Block) error {
+	if f.mayHaveRefs() {
+		has, err := f.refs.Has(refKey(b.Key()))
+		if err == nil && has {
+			return nil
+		}
+	}
+	return f.bs.Put(b)
+}
+
+func (f *Filestore) PutMany(bs []*blocks.Block) error {
+	if !f.mayHaveRefs() {
+		return f.bs.PutMany(bs)
+	}
+
+	var todo []*blocks.Block
+	for _, b := range bs {
+		has, err := f.refs.Has(refKey(b.Key()))
+		if err == nil && has {
+			continue
+		}
+		todo = append(todo, b)
+	}
+	return f.bs.PutMany(todo)
+}
+
+// DeleteBlock removes the block and any reference to it. The referenced
+// file is left alone.
+func (f *Filestore) DeleteBlock(k key.Key) error {
+	err := f.bs.DeleteBlock(k)
+	if !f.mayHaveRefs() {
+		return err
+	}
+
+	rerr := f.refs.Delete(refKey(k))
+	switch {
+	case rerr == nil:
+		return nil
+	case rerr == ds.ErrNotFound:
+		return err
+	default:
+		return rerr
+	}
+}
+
+// AllKeysChan lists the blocks of the wrapped Blockstore, followed by the
+// referenced ones.
+func (f *Filestore) AllKeysChan(ctx context.Context) (<-chan key.Key, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	bskeys, err := f.bs.AllKeysChan(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	refs, err := f.refKeys(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	out := make(chan key.Key)
+	go func() {
+		defer cancel()
+		defer close(out)
+		for _, keys := range []<-chan key.Key{bskeys, refs} {
+			for k := range keys {
+				select {
+				case out <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (f *Filestore) refKeys(ctx context.Context) (<-chan key.Key, error) {
+	q := dsq.Query{Prefix: RefPrefix.String(), KeysOnly: true}
+	res, err := f.refs.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan key.Key)
+	go func() {
+		defer close(out)
+		defer res.Close()
+		for e := range res.Next() {
+			if e.Error != nil {
+				log.Debug("filestore: query got err:", e.Error)
+				return
+			}
+			k := key.B58KeyDecode(ds.NewKey(e.Key).BaseNamespace())
+			if _, err := mh.Cast([]byte(k)); err != nil {
+				log.Debugf("filestore: skipping reference with a bad key %q", e.Key)
+				continue
+			}
+			select {
+			case out <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (f *Filestore) HashOnRead(enabled bool) {
+	f.bs.HashOnRead(enabled)
+}
+
+// Status of a reference, as reported by List.
+const (
+	StatusOk      = "ok"
+	StatusChanged = "changed"
+	StatusMissing = "missing"
+	StatusError   = "error"
+)
+
+// ListRes describes a reference found by List.
+type ListRes struct {
+	Key    key.Key
+	Ref    DataRef
+	Status string `json:",omitempty"`
+	Err    string `json:",omitempty"`
+}
+
+// List sends every reference in the filestore. With verify, each one is
+// read back from its file, and its Status set accordingly.
+func (f *Filestore) List(ctx context.Context, verify bool) (<-chan *ListRes, error) {
+	keys, err := f.refKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *ListRes)
+	go func() {
+		defer close(out)
+		for k := range keys {
+			res := &ListRes{Key: k}
+			ref, err := f.Ref(k)
+			switch {
+			case err == ds.ErrNotFound:
+				// removed since we listed it
+				continue
+			case err != nil:
+				res.Status = StatusError
+				res.Err = err.Error()
+			default:
+				res.Ref = *ref
+				if verify {
+					res.Status, res.Err = check(k, ref)
+				}
+			}
+
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func check(k key.Key, ref *DataRef) (string, string) {
+	_, err := ReadRef(k, ref)
+	switch err {
+	case nil:
+		return StatusOk, ""
+	case ErrFileChanged:
+		return StatusChanged, ""
+	case ErrFileMissing:
+		return StatusMissing, ""
+	default:
+		return StatusError, err.Error()
+	}
+}
+
+// FileRefs adds the leaves of a single file to a Filestore as references.
+// It implements helpers.LeafRefs.
+type FileRefs struct {
+	fs   *Filestore
+	path string
+	fi   os.FileInfo
+}
+
+// NewFileRefs prepares to reference the leaves of the file at path, which
+// must be absolute.
+func (f *Filestore) NewFileRefs(path string) (*FileRefs, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("filestore: path %q is not absolute", path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("filestore: %s is not a regular file", path)
+	}
+	return &FileRefs{fs: f, path: path, fi: fi}, nil
+}
+
+// AddLeafRef records that the data of the leaf nd is found at offset in the
+// file.
+func (r *FileRefs) AddLeafRef(nd *dag.Node, typ ftpb.Data_DataType, offset, size uint64) error {
+	k, err := nd.Key()
+	if err != nil {
+		return err
+	}
+	return r.fs.PutRef(k, &DataRef{
+		Path:     r.path,
+		Offset:   offset,
+		Size:     size,
+		Type:     typ,
+		Raw:      nd.IsRaw(),
+		ModTime:  r.fi.ModTime().UnixNano(),
+		FileSize: r.fi.Size(),
+	})
+}
@@ -0,0 +1,350 @@
+package filestore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	importer "github.com/ipfs/go-ipfs/importer"
+	bal "github.com/ipfs/go-ipfs/importer/balanced"
+	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+type testEnv struct {
+	dir   string
+	inner bstore.Blockstore
+	fs    *Filestore
+	dserv dag.DAGService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	dir, err := ioutil.TempDir("", "filestore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := syncds.MutexWrap(ds.NewMapDatastore())
+	inner := bstore.NewBlockstore(d)
+	fs, err := New(inner, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{
+		dir:   dir,
+		inner: inner,
+		fs:    fs,
+		dserv: dag.NewDAGService(bserv.New(fs, offline.Exchange(fs))),
+	}
+}
+
+func (e *testEnv) writeFile(t *testing.T, name string, size int) (string, []byte) {
+	data := make([]byte, size)
+	u.NewTimeSeededRand().Read(data)
+	fpath := filepath.Join(e.dir, name)
+	if err := ioutil.WriteFile(fpath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fpath, data
+}
+
+func (e *testEnv) add(t *testing.T, fpath string, trickle bool) *dag.Node {
+	f, err := os.Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	refs, err := e.fs.NewFileRefs(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := importer.BuildDagFromReaderRefs
+	if trickle {
+		build = importer.BuildTrickleDagFromReaderRefs
+	}
+	nd, err := build(e.dserv, chunk.NewSizeSplitter(f, 1000), nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func (e *testEnv) read(t *testing.T, nd *dag.Node) ([]byte, error) {
+	// the reader waits for blocks it can't get until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := uio.NewDagReader(ctx, nd, e.dserv)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (e *testEnv) list(t *testing.T, verify bool) []*ListRes {
+	ch, err := e.fs.List(context.Background(), verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []*ListRes
+	for r := range ch {
+		out = append(out, r)
+	}
+	return out
+}
+
+func testAddNoCopy(t *testing.T, size int, trickle bool) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	fpath, data := e.writeFile(t, "file", size)
+	nd := e.add(t, fpath, trickle)
+
+	out, err := e.read(t, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("data read back through the filestore differs")
+	}
+
+	refs := e.list(t, false)
+	if len(refs) != (size+999)/1000 {
+		t.Fatalf("expected %d references, got %d", (size+999)/1000, len(refs))
+	}
+	var stored uint64
+	for _, r := range refs {
+		if has, _ := e.inner.Has(r.Key); has {
+			t.Fatal("referenced leaf was copied into the blockstore")
+		}
+		stored += r.Ref.Size
+	}
+	if stored != uint64(size) {
+		t.Fatal("references should cover the whole file")
+	}
+}
+
+func TestAddNoCopyBalanced(t *testing.T) {
+	testAddNoCopy(t, 10500, false)
+}
+
+func TestAddNoCopyTrickle(t *testing.T) {
+	testAddNoCopy(t, 10500, true)
+}
+
+func TestAddNoCopySingleBlock(t *testing.T) {
+	testAddNoCopy(t, 500, false)
+}
+
+func TestAddNoCopyRawLeaves(t *testing.T) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	fpath, data := e.writeFile(t, "file", 10500)
+	f, err := os.Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	refs, err := e.fs.NewFileRefs(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbp := h.DagBuilderParams{
+		Dagserv:   e.dserv,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		LeafRefs:  refs,
+		RawLeaves: true,
+	}
+	nd, err := bal.BalancedLayout(dbp.New(chunk.Chan(chunk.NewSizeSplitter(f, 1000))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := e.read(t, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("data read back through the filestore differs")
+	}
+	res := e.list(t, true)
+	if len(res) != 11 {
+		t.Fatalf("expected 11 references, got %d", len(res))
+	}
+	for _, r := range res {
+		if !r.Ref.Raw {
+			t.Fatal("reference to a raw leaf not marked raw")
+		}
+		if r.Status != StatusOk {
+			t.Fatalf("raw leaf %s does not verify", r.Key)
+		}
+	}
+}
+
+func TestNilLeafRefsCopies(t *testing.T) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	var refs h.LeafRefs
+	nd, err := importer.BuildDagFromReaderRefs(e.dserv, chunk.NewSizeSplitter(bytes.NewReader(make([]byte, 3000)), 1000), nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.list(t, false)) != 0 {
+		t.Fatal("no references expected without LeafRefs")
+	}
+	if _, err := e.read(t, nd); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModifiedFile(t *testing.T) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	fpath, data := e.writeFile(t, "file", 5000)
+	nd := e.add(t, fpath, false)
+
+	data[0]++
+	if err := ioutil.WriteFile(fpath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.read(t, nd); err == nil {
+		t.Fatal("reading a changed file should fail")
+	}
+	for _, r := range e.list(t, true) {
+		if r.Status != StatusChanged {
+			t.Fatalf("expected %s to be reported as changed, got %s", r.Key, r.Status)
+		}
+	}
+}
+
+func TestMissingFile(t *testing.T) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	fpath, _ := e.writeFile(t, "file", 3000)
+	e.add(t, fpath, false)
+	if err := os.Remove(fpath); err != nil {
+		t.Fatal(err)
+	}
+
+	refs := e.list(t, true)
+	if len(refs) != 3 {
+		t.Fatal("expected 3 references, got", len(refs))
+	}
+	for _, r := range refs {
+		if r.Status != StatusMissing {
+			t.Fatalf("expected %s to be reported as missing, got %s", r.Key, r.Status)
+		}
+	}
+}
+
+func TestDeleteRef(t *testing.T) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	fpath, _ := e.writeFile(t, "file", 3000)
+	e.add(t, fpath, false)
+
+	var keys []key.Key
+	ch, err := e.fs.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range ch {
+		keys = append(keys, k)
+	}
+	// three leaves and the root
+	if len(keys) != 4 {
+		t.Fatal("expected 4 keys, got", len(keys))
+	}
+
+	for _, k := range keys {
+		if err := e.fs.DeleteBlock(k); err != nil {
+			t.Fatal(err)
+		}
+		if has, _ := e.fs.Has(k); has {
+			t.Fatal("block still there after DeleteBlock")
+		}
+	}
+	if _, err := os.Stat(fpath); err != nil {
+		t.Fatal("deleting references should leave the file alone")
+	}
+}
+
+func TestRefKeyEndingInSlash(t *testing.T) {
+	e := newTestEnv(t)
+	defer os.RemoveAll(e.dir)
+
+	// a multihash ending in '/' would lose a byte in a datastore key
+	var data []byte
+	var k key.Key
+	for i := 0; ; i++ {
+		data = []byte(fmt.Sprint("slash", i))
+		k = key.Key(u.Hash(data))
+		if k[len(k)-1] == '/' {
+			break
+		}
+	}
+	fpath := filepath.Join(e.dir, "file")
+	if err := ioutil.WriteFile(fpath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	refs, err := e.fs.NewFileRefs(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.fs.PutRef(k, &DataRef{
+		Path:     fpath,
+		Size:     uint64(len(data)),
+		Raw:      true,
+		ModTime:  refs.fi.ModTime().UnixNano(),
+		FileSize: refs.fi.Size(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := e.fs.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []key.Key
+	for k := range ch {
+		keys = append(keys, k)
+	}
+	if len(keys) != 1 || keys[0] != k {
+		t.Fatalf("expected to list %s, got %v", k, keys)
+	}
+	res := e.list(t, true)
+	if len(res) != 1 || res[0].Key != k || res[0].Status != StatusOk {
+		t.Fatal("reference should be listed under its key and verify")
+	}
+
+	// deleting the key cut short must leave the reference alone
+	if err := e.fs.DeleteBlock(k[:len(k)-1]); err == nil {
+		t.Fatal("deleting a missing block should fail")
+	}
+	if has, _ := e.fs.Has(k); !has {
+		t.Fatal("reference removed by deleting another key")
+	}
+	if _, err := e.fs.Get(k); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	core "github.com/ipfs/go-ipfs/core"
 	nsfs "github.com/ipfs/go-ipfs/ipnsfs"
@@ -86,9 +87,9 @@ func CreateRoot(ipfs *core.IpfsNode, keys []ci.PrivKey, ipfspath, ipnspath strin
 
 		switch val := root.GetValue().(type) {
 		case *nsfs.Directory:
-			ldirs[name] = &Directory{dir: val}
+			ldirs[name] = &Directory{dir: val, gcl: ipfs.Blockstore}
 		case *nsfs.File:
-			ldirs[name] = &File{fi: val}
+			ldirs[name] = &File{fi: val, gcl: ipfs.Blockstore}
 		default:
 			return nil, errors.New("unrecognized type")
 		}
@@ -205,6 +206,8 @@ func (r *Root) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 // Directory is wrapper over an ipnsfs directory to satisfy the fuse fs interface
 type Directory struct {
 	dir *nsfs.Directory
+	// gcl keeps a GC out while the directory changes
+	gcl bstore.GCLocker
 
 	fs.NodeRef
 }
@@ -212,6 +215,8 @@ type Directory struct {
 // File is wrapper over an ipnsfs file to satisfy the fuse fs interface
 type File struct {
 	fi *nsfs.File
+	// gcl keeps a GC out while the file is written and synced
+	gcl bstore.GCLocker
 
 	fs.NodeRef
 }
@@ -254,9 +259,9 @@ func (s *Directory) Lookup(ctx context.Context, name string) (fs.Node, error) {
 
 	switch child := child.(type) {
 	case *nsfs.Directory:
-		return &Directory{dir: child}, nil
+		return &Directory{dir: child, gcl: s.gcl}, nil
 	case *nsfs.File:
-		return &File{fi: child}, nil
+		return &File{fi: child, gcl: s.gcl}, nil
 	default:
 		// NB: if this happens, we do not want to continue, unpredictable behaviour
 		// may occur.
@@ -317,6 +322,7 @@ func (fi *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.Read
 
 func (fi *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
 	// TODO: at some point, ensure that WriteAt here respects the context
+	defer fi.gcl.PinLock()()
 	wrote, err := fi.fi.WriteAt(req.Data, req.Offset)
 	if err != nil {
 		return err
@@ -328,6 +334,7 @@ func (fi *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.Wr
 func (fi *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
 	errs := make(chan error, 1)
 	go func() {
+		defer fi.gcl.PinLock()()
 		errs <- fi.fi.Close()
 	}()
 	select {
@@ -344,6 +351,7 @@ func (fi *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fus
 		return err
 	}
 	if cursize != int64(req.Size) {
+		defer fi.gcl.PinLock()()
 		err := fi.fi.Truncate(int64(req.Size))
 		if err != nil {
 			return err
@@ -357,6 +365,7 @@ func (fi *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fus
 func (fi *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 	errs := make(chan error, 1)
 	go func() {
+		defer fi.gcl.PinLock()()
 		errs <- fi.fi.Sync()
 	}()
 	select {
@@ -368,25 +377,31 @@ func (fi *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 }
 
 func (fi *File) Forget() {
+	unlock := fi.gcl.PinLock()
 	err := fi.fi.Sync()
+	unlock()
 	if err != nil {
 		log.Debug("Forget file error: ", err)
 	}
 }
 
 func (dir *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	unlock := dir.gcl.PinLock()
 	child, err := dir.dir.Mkdir(req.Name)
+	unlock()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Directory{dir: child}, nil
+	return &Directory{dir: child, gcl: dir.gcl}, nil
 }
 
 func (fi *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	if req.Flags&fuse.OpenTruncate != 0 {
 		log.Info("Need to truncate file!")
+		unlock := fi.gcl.PinLock()
 		err := fi.fi.Truncate(0)
+		unlock()
 		if err != nil {
 			return nil, err
 		}
@@ -405,13 +420,16 @@ func (fi *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.Open
 }
 
 func (fi *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	defer fi.gcl.PinLock()()
 	return fi.fi.Close()
 }
 
 func (dir *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
 	// New 'empty' file
 	nd := &dag.Node{Data: ft.FilePBData(nil, 0)}
+	unlock := dir.gcl.PinLock()
 	err := dir.dir.AddChild(req.Name, nd)
+	unlock()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -426,12 +444,14 @@ func (dir *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp
 		return nil, nil, errors.New("child creation failed")
 	}
 
-	nodechild := &File{fi: fi}
+	nodechild := &File{fi: fi, gcl: dir.gcl}
 	return nodechild, nodechild, nil
 }
 
 func (dir *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	unlock := dir.gcl.PinLock()
 	err := dir.dir.Unlink(req.Name)
+	unlock()
 	if err != nil {
 		return fuse.ENOENT
 	}
@@ -440,6 +460,8 @@ func (dir *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error
 
 // Rename implements NodeRenamer
 func (dir *Directory) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	defer dir.gcl.PinLock()()
+
 	cur, err := dir.dir.Child(req.OldName)
 	if err != nil {
 		return err
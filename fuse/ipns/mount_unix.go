@@ -19,7 +19,7 @@ func Mount(ipfs *core.IpfsNode, ipnsmp, ipfsmp string) (mount.Mount, error) {
 	allow_other := cfg.Mounts.FuseAllowOther
 
 	if ipfs.IpnsFs == nil {
-		fs, err := ipnsfs.NewFilesystem(ipfs.Context(), ipfs.DAG, ipfs.Namesys, ipfs.Pinning, ipfs.PrivateKey)
+		fs, err := ipnsfs.NewFilesystem(ipfs.Context(), ipfs.DAG, ipfs.Namesys, ipfs.Pinning, ipfs.Blockstore, ipfs.PrivateKey)
 		if err != nil {
 			return nil, err
 		}
@@ -141,7 +141,10 @@ func TestIpfsStressRead(t *testing.T) {
 				t.Fatal(err)
 			}
 		}
-		newdir := db.GetNode()
+		newdir, err := db.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
 		k, err := nd.DAG.Add(newdir)
 		if err != nil {
 			t.Fatal(err)
@@ -209,7 +212,10 @@ func TestIpfsBasicDirRead(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	d1nd := db.GetNode()
+	d1nd, err := db.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
 	d1ndk, err := nd.DAG.Add(d1nd)
 	if err != nil {
 		t.Fatal(err)
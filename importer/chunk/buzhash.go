@@ -0,0 +1,159 @@
+package chunk
+
+import (
+	"io"
+	"math"
+)
+
+// buzhashWindow is the number of bytes the rolling hash is computed over.
+const buzhashWindow = 32
+
+// buzhashTable maps each byte value to a random 32 bit word. It is
+// generated from a fixed seed, the chunk boundaries (and so the hashes of
+// the objects added) depend on it and must never change.
+var buzhashTable [256]uint32
+
+func init() {
+	// splitmix64
+	x := uint64(0x49f6428a6c1cd7e5)
+	for i := range buzhashTable {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		buzhashTable[i] = uint32(z >> 32)
+	}
+}
+
+// Buzhash is a content defined splitter, like Rabin, cutting the data
+// where a buzhash of the last 32 bytes falls below a threshold. It is
+// several times faster than Rabin.
+type Buzhash struct {
+	r   io.Reader
+	buf []byte
+	n   int
+	err error
+
+	min    int
+	max    int
+	thresh uint32
+}
+
+func NewBuzhash(r io.Reader, avgBlkSize uint64) *Buzhash {
+	min := avgBlkSize / 3
+	max := avgBlkSize + (avgBlkSize / 2)
+
+	return NewBuzhashMinMax(r, min, avgBlkSize, max)
+}
+
+// NewBuzhashMinMax returns a Buzhash making blocks of at least min and at
+// most max bytes, and of around avg bytes on random data.
+func NewBuzhashMinMax(r io.Reader, min, avg, max uint64) *Buzhash {
+	if min < buzhashWindow {
+		min = buzhashWindow
+	}
+	if max < min {
+		max = min
+	}
+
+	var dist uint64
+	if avg > min {
+		dist = avg - min
+	}
+
+	return &Buzhash{
+		r:      r,
+		buf:    make([]byte, max),
+		min:    int(min),
+		max:    int(max),
+		thresh: buzhashThreshold(dist, max-min),
+	}
+}
+
+// buzhashThreshold returns the threshold below which the hash cuts a
+// block, so that on random data the blocks go on for dist bytes past min on
+// average, knowing they are cut short span bytes past min. It sticks to
+// integers to give the same threshold, and so the same boundaries, on
+// every platform.
+func buzhashThreshold(dist, span uint64) uint32 {
+	if dist == 0 {
+		return math.MaxUint32
+	}
+	if dist >= span {
+		return 0
+	}
+
+	// with a threshold of t, a boundary is found at each byte with a
+	// probability p of t/2^32, and the blocks go on for
+	// (1 - (1-p)^span) / p bytes past min. That shrinks as t grows.
+	expected := func(t uint64) uint64 {
+		return (1<<32 - pow32(1<<32-t, span)) / t
+	}
+	lo, hi := uint64(1), uint64(math.MaxUint32)
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if expected(mid) >= dist {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return uint32(lo)
+}
+
+// pow32 returns x to the power of n, x and the result being fixed point
+// numbers with 32 fractional bits, at most 1.
+func pow32(x, n uint64) uint64 {
+	r := uint64(1 << 32)
+	for n > 0 {
+		if n&1 == 1 {
+			r = r * x >> 32
+		}
+		x = x * x >> 32
+		n >>= 1
+	}
+	return r
+}
+
+func (b *Buzhash) NextBytes() ([]byte, error) {
+	if b.err == nil && b.n < len(b.buf) {
+		n, err := io.ReadFull(b.r, b.buf[b.n:])
+		b.n += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		b.err = err
+	}
+	if b.err != nil && (b.n == 0 || b.err != io.EOF) {
+		return nil, b.err
+	}
+
+	cut := b.n
+	if b.n > b.min {
+		cut = b.boundary()
+	}
+
+	out := make([]byte, cut)
+	copy(out, b.buf[:cut])
+	b.n = copy(b.buf, b.buf[cut:b.n])
+	return out, nil
+}
+
+// boundary returns the end of the next block in buf.
+func (b *Buzhash) boundary() int {
+	var h uint32
+	for _, c := range b.buf[b.min-buzhashWindow : b.min] {
+		h = (h<<1 | h>>31) ^ buzhashTable[c]
+	}
+
+	for i := b.min; i < b.n; i++ {
+		if h < b.thresh {
+			return i
+		}
+		// with a 32 byte window, the outgoing byte's word is rotated by
+		// 32 bits, that is, not at all
+		h = (h<<1 | h>>31) ^ buzhashTable[b.buf[i-buzhashWindow]] ^ buzhashTable[b.buf[i]]
+	}
+	return b.n
+}
@@ -0,0 +1,183 @@
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/blocks/key"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+func splitAll(t testing.TB, s Splitter) [][]byte {
+	var chunks [][]byte
+	for {
+		chunk, err := s.NextBytes()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestBuzhashChunking(t *testing.T) {
+	// fixed data, so the averages checked below don't vary between runs
+	data := make([]byte, 1024*1024*64)
+	util.NewSeededRand(42).Read(data)
+
+	chunks := splitAll(t, NewBuzhashMinMax(bytes.NewReader(data), 64*1024, 256*1024, 512*1024))
+	for i, c := range chunks {
+		if len(c) > 512*1024 || (len(c) < 64*1024 && i != len(chunks)-1) {
+			t.Fatalf("block %d has a size out of bounds: %d", i, len(c))
+		}
+	}
+	checkAverage(t, 256*1024, len(data)/len(chunks))
+
+	unchunked := bytes.Join(chunks, nil)
+	if !bytes.Equal(unchunked, data) {
+		t.Fatal("data was chunked incorrectly")
+	}
+
+	for _, avg := range []int{8 * 1024, 64 * 1024, 256 * 1024} {
+		chunks := splitAll(t, NewBuzhash(bytes.NewReader(data), uint64(avg)))
+		checkAverage(t, avg, len(data)/len(chunks))
+	}
+}
+
+func checkAverage(t *testing.T, want, got int) {
+	t.Logf("average block size: %d, asked for %d", got, want)
+	if got < want*95/100 || got > want*105/100 {
+		t.Fatalf("average block size %d is too far from %d", got, want)
+	}
+}
+
+func TestBuzhashSmallInput(t *testing.T) {
+	for _, size := range []int{0, 1, 31, 32, 100} {
+		data := make([]byte, size)
+		util.NewTimeSeededRand().Read(data)
+
+		chunks := splitAll(t, NewBuzhash(bytes.NewReader(data), 256))
+		if !bytes.Equal(bytes.Join(chunks, nil), data) {
+			t.Fatalf("data of size %d was chunked incorrectly", size)
+		}
+	}
+}
+
+func TestBuzhashIsDeterministic(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	util.NewTimeSeededRand().Read(data)
+
+	a := splitAll(t, NewBuzhash(bytes.NewReader(data), 16*1024))
+	// reading in small pieces must not change the boundaries
+	b := splitAll(t, NewBuzhash(&clipReader{r: bytes.NewReader(data), size: 1000}, 16*1024))
+	if len(a) != len(b) {
+		t.Fatalf("got %d and %d blocks", len(a), len(b))
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			t.Fatalf("block %d differs", i)
+		}
+	}
+}
+
+func blockKeys(t testing.TB, s Splitter) map[key.Key]struct{} {
+	keys := make(map[key.Key]struct{})
+	for _, c := range splitAll(t, s) {
+		keys[blocks.NewBlock(c).Key()] = struct{}{}
+	}
+	return keys
+}
+
+// dedupRatio returns the fraction of the blocks of the data shifted by
+// offset bytes that are also blocks of the original data.
+func dedupRatio(t testing.TB, data []byte, offset int, split func(io.Reader) Splitter) float64 {
+	orig := blockKeys(t, split(bytes.NewReader(data)))
+
+	shifted := make([]byte, offset, offset+len(data))
+	util.NewTimeSeededRand().Read(shifted)
+	shifted = append(shifted, data...)
+
+	var shared int
+	keys := blockKeys(t, split(bytes.NewReader(shifted)))
+	for k := range keys {
+		if _, ok := orig[k]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(keys))
+}
+
+func TestBuzhashDedupShifted(t *testing.T) {
+	data := make([]byte, 1024*1024*8)
+	util.NewTimeSeededRand().Read(data)
+
+	splitters := map[string]func(io.Reader) Splitter{
+		"buzhash": func(r io.Reader) Splitter { return NewBuzhash(r, 64*1024) },
+		"rabin":   func(r io.Reader) Splitter { return NewRabin(r, 64*1024) },
+		"size":    func(r io.Reader) Splitter { return NewSizeSplitter(r, 64*1024) },
+	}
+	for _, offset := range []int{1, 1000, 100000} {
+		for name, split := range splitters {
+			ratio := dedupRatio(t, data, offset, split)
+			t.Logf("%s, shifted by %d: %.1f%% of blocks reused", name, offset, ratio*100)
+			if name != "size" && ratio < 0.9 {
+				t.Fatalf("%s only reused %.1f%% of blocks after a shift of %d", name, ratio*100, offset)
+			}
+		}
+	}
+}
+
+func TestParseBuzhash(t *testing.T) {
+	for _, s := range []string{"buzhash", "buzhash-1024", "buzhash-256-1024-4096", "buzhash-min:256-avg:1024-max:4096"} {
+		spl, err := FromString(bytes.NewReader(nil), s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if _, ok := spl.(*Buzhash); !ok {
+			t.Fatalf("%s: expected a buzhash splitter", s)
+		}
+	}
+
+	for _, s := range []string{"buzhash-x", "buzhash-1-2", "buzhash-4096-1024-256", "buzhash-avg:256-min:1024-max:4096"} {
+		if _, err := FromString(bytes.NewReader(nil), s); err == nil {
+			t.Fatalf("%s: expected an error", s)
+		}
+	}
+}
+
+func benchmarkSplitter(b *testing.B, split func(io.Reader) Splitter) {
+	data := make([]byte, 1024*1024*16)
+	util.NewTimeSeededRand().Read(data)
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := split(bytes.NewReader(data))
+		for {
+			_, err := s.NextBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBuzhash(b *testing.B) {
+	benchmarkSplitter(b, func(r io.Reader) Splitter { return NewBuzhash(r, uint64(DefaultBlockSize)) })
+}
+
+func BenchmarkRabin(b *testing.B) {
+	benchmarkSplitter(b, func(r io.Reader) Splitter { return NewRabin(r, uint64(DefaultBlockSize)) })
+}
+
+func BenchmarkSizeSplitter(b *testing.B) {
+	benchmarkSplitter(b, func(r io.Reader) Splitter { return NewSizeSplitter(r, DefaultBlockSize) })
+}
@@ -24,6 +24,9 @@ func FromString(r io.Reader, chunker string) (Splitter, error) {
 	case strings.HasPrefix(chunker, "rabin"):
 		return parseRabinString(r, chunker)
 
+	case strings.HasPrefix(chunker, "buzhash"):
+		return parseBuzhashString(r, chunker)
+
 	default:
 		return nil, fmt.Errorf("unrecognized chunker option: %s", chunker)
 	}
@@ -41,36 +44,71 @@ func parseRabinString(r io.Reader, chunker string) (Splitter, error) {
 		}
 		return NewRabin(r, uint64(size)), nil
 	case 4:
-		sub := strings.Split(parts[1], ":")
-		if len(sub) > 1 && sub[0] != "min" {
-			return nil, errors.New("first label must be min")
-		}
-		min, err := strconv.Atoi(sub[len(sub)-1])
+		min, avg, max, err := parseMinAvgMax(parts[1:])
 		if err != nil {
 			return nil, err
 		}
+		return NewRabinMinMax(r, uint64(min), uint64(avg), uint64(max)), nil
+	default:
+		return nil, errors.New("incorrect format (expected 'rabin' 'rabin-[avg]' or 'rabin-[min]-[avg]-[max]'")
+	}
+}
 
-		sub = strings.Split(parts[2], ":")
-		if len(sub) > 1 && sub[0] != "avg" {
-			log.Error("sub == ", sub)
-			return nil, errors.New("second label must be avg")
-		}
-		avg, err := strconv.Atoi(sub[len(sub)-1])
+func parseBuzhashString(r io.Reader, chunker string) (Splitter, error) {
+	parts := strings.Split(chunker, "-")
+	switch len(parts) {
+	case 1:
+		return NewBuzhash(r, uint64(DefaultBlockSize)), nil
+	case 2:
+		size, err := strconv.Atoi(parts[1])
 		if err != nil {
 			return nil, err
 		}
-
-		sub = strings.Split(parts[3], ":")
-		if len(sub) > 1 && sub[0] != "max" {
-			return nil, errors.New("final label must be max")
-		}
-		max, err := strconv.Atoi(sub[len(sub)-1])
+		return NewBuzhash(r, uint64(size)), nil
+	case 4:
+		min, avg, max, err := parseMinAvgMax(parts[1:])
 		if err != nil {
 			return nil, err
 		}
+		if min < 0 || min > avg || avg > max {
+			return nil, errors.New("sizes must be ordered as 0 <= min <= avg <= max")
+		}
 
-		return NewRabinMinMax(r, uint64(min), uint64(avg), uint64(max)), nil
+		return NewBuzhashMinMax(r, uint64(min), uint64(avg), uint64(max)), nil
 	default:
-		return nil, errors.New("incorrect format (expected 'rabin' 'rabin-[avg]' or 'rabin-[min]-[avg]-[max]'")
+		return nil, errors.New("incorrect format (expected 'buzhash' 'buzhash-[avg]' or 'buzhash-[min]-[avg]-[max]'")
+	}
+}
+
+// parseMinAvgMax parses the '[min]-[avg]-[max]' part of a chunker string,
+// where each size may be labeled, as in 'min:1024'.
+func parseMinAvgMax(parts []string) (min, avg, max int, err error) {
+	sub := strings.Split(parts[0], ":")
+	if len(sub) > 1 && sub[0] != "min" {
+		return 0, 0, 0, errors.New("first label must be min")
+	}
+	min, err = strconv.Atoi(sub[len(sub)-1])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	sub = strings.Split(parts[1], ":")
+	if len(sub) > 1 && sub[0] != "avg" {
+		log.Error("sub == ", sub)
+		return 0, 0, 0, errors.New("second label must be avg")
+	}
+	avg, err = strconv.Atoi(sub[len(sub)-1])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	sub = strings.Split(parts[2], ":")
+	if len(sub) > 1 && sub[0] != "max" {
+		return 0, 0, 0, errors.New("final label must be max")
+	}
+	max, err = strconv.Atoi(sub[len(sub)-1])
+	if err != nil {
+		return 0, 0, 0, err
 	}
+	return min, avg, max, nil
 }
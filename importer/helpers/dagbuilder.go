@@ -2,7 +2,7 @@ package helpers
 
 import (
 	dag "github.com/ipfs/go-ipfs/merkledag"
-	"github.com/ipfs/go-ipfs/pin"
+	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
 // NodeCB is callback function for dag generation
@@ -13,17 +13,28 @@ type NodeCB func(node *dag.Node, last bool) error
 
 var nilFunc NodeCB = func(_ *dag.Node, _ bool) error { return nil }
 
+// LeafRefs stores the leaves of the file being imported as references to
+// their data in the file, instead of adding them to the DAGService. offset
+// and size locate the data of the leaf in the input.
+type LeafRefs interface {
+	AddLeafRef(nd *dag.Node, typ ftpb.Data_DataType, offset, size uint64) error
+}
+
 // DagBuilderHelper wraps together a bunch of objects needed to
 // efficiently create unixfs dag trees
 type DagBuilderHelper struct {
-	dserv    dag.DAGService
-	mp       pin.ManualPinner
-	in       <-chan []byte
-	errs     <-chan error
-	recvdErr error
-	nextData []byte // the next item to return.
-	maxlinks int
-	ncb      NodeCB
+	dserv     dag.DAGService
+	in        <-chan []byte
+	errs      <-chan error
+	recvdErr  error
+	nextData  []byte // the next item to return.
+	maxlinks  int
+	ncb       NodeCB
+	leafRefs  LeafRefs
+	rawLeaves bool
+	hashFunc  int
+	rootData  func([]byte) ([]byte, error)
+	offset    uint64 // position in the input of the next data
 
 	batch *dag.Batch
 }
@@ -37,6 +48,21 @@ type DagBuilderParams struct {
 
 	// Callback for each block added
 	NodeCB NodeCB
+
+	// LeafRefs, if set, receives the leaves instead of Dagserv
+	LeafRefs LeafRefs
+
+	// RawLeaves stores the leaves as raw blocks, holding just the data
+	// instead of unixfs nodes
+	RawLeaves bool
+
+	// HashFunc is the multihash function code the nodes are hashed with,
+	// zero for the default
+	HashFunc int
+
+	// RootData, if set, rewrites the unixfs data of the root before the
+	// root is added, to record the file info along with the file
+	RootData func([]byte) ([]byte, error)
 }
 
 // Generate a new DagBuilderHelper from the given params, using 'in' as a
@@ -48,12 +74,16 @@ func (dbp *DagBuilderParams) New(in <-chan []byte, errs <-chan error) *DagBuilde
 	}
 
 	return &DagBuilderHelper{
-		dserv:    dbp.Dagserv,
-		in:       in,
-		errs:     errs,
-		maxlinks: dbp.Maxlinks,
-		ncb:      ncb,
-		batch:    dbp.Dagserv.Batch(),
+		dserv:     dbp.Dagserv,
+		in:        in,
+		errs:      errs,
+		maxlinks:  dbp.Maxlinks,
+		ncb:       ncb,
+		leafRefs:  dbp.LeafRefs,
+		rawLeaves: dbp.RawLeaves,
+		hashFunc:  dbp.HashFunc,
+		rootData:  dbp.RootData,
+		batch:     dbp.Dagserv.Batch(),
 	}
 }
 
@@ -136,16 +166,58 @@ func (db *DagBuilderHelper) FillNodeWithData(node *UnixfsNode) error {
 	}
 
 	node.SetData(data)
+	node.offset = db.offset
+	db.offset += uint64(len(data))
 	return nil
 }
 
+// isLeaf returns true if node holds data and no children.
+func isLeaf(node *UnixfsNode) bool {
+	return node.NumChildren() == 0 && len(node.ufmt.Data) > 0
+}
+
+// isRef returns true if node should be stored as a reference to the input.
+func (db *DagBuilderHelper) isRef(node *UnixfsNode) bool {
+	return db.leafRefs != nil && isLeaf(node)
+}
+
+// isRawLeaf returns true if node should be stored as a raw block.
+func (db *DagBuilderHelper) isRawLeaf(node *UnixfsNode) bool {
+	return node.raw || db.rawLeaves && isLeaf(node)
+}
+
+func (db *DagBuilderHelper) addRef(node *UnixfsNode, dn *dag.Node) error {
+	return db.leafRefs.AddLeafRef(dn, node.ufmt.Type, node.offset, uint64(len(node.ufmt.Data)))
+}
+
+// Add adds node as the root of the dag.
 func (db *DagBuilderHelper) Add(node *UnixfsNode) (*dag.Node, error) {
+	if db.rawLeaves && isLeaf(node) || db.rootData != nil && db.isRef(node) {
+		// the root stays a unixfs node, with the data in a raw or
+		// referenced child
+		root := NewUnixfsNode()
+		if err := root.AddChild(node, db); err != nil {
+			return nil, err
+		}
+		node = root
+	}
+
 	dn, err := node.GetDagNode()
 	if err != nil {
 		return nil, err
 	}
+	dn.SetHashFunc(db.hashFunc)
+	if db.rootData != nil {
+		if dn.Data, err = db.rootData(dn.Data); err != nil {
+			return nil, err
+		}
+	}
 
-	_, err = db.dserv.Add(dn)
+	if db.isRef(node) {
+		err = db.addRef(node, dn)
+	} else {
+		_, err = db.dserv.Add(dn)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -4,10 +4,8 @@ import (
 	"fmt"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
-	key "github.com/ipfs/go-ipfs/blocks/key"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
 	dag "github.com/ipfs/go-ipfs/merkledag"
-	"github.com/ipfs/go-ipfs/pin"
 	ft "github.com/ipfs/go-ipfs/unixfs"
 )
 
@@ -41,6 +39,10 @@ var ErrSizeLimitExceeded = fmt.Errorf("object size limit exceeded")
 type UnixfsNode struct {
 	node *dag.Node
 	ufmt *ft.FSNode
+
+	offset uint64 // position of the data in the input
+
+	raw bool // stored as a raw block
 }
 
 // NewUnixfsNode creates a new Unixfs node to represent a file
@@ -82,6 +84,14 @@ func (n *UnixfsNode) GetChild(ctx context.Context, i int, ds dag.DAGService) (*U
 		return nil, err
 	}
 
+	if nd.IsRaw() {
+		return &UnixfsNode{
+			node: nd,
+			ufmt: &ft.FSNode{Type: ft.TRaw, Data: nd.Data},
+			raw:  true,
+		}, nil
+	}
+
 	return NewUnixfsNodeFromDag(nd)
 }
 
@@ -90,11 +100,13 @@ func (n *UnixfsNode) GetChild(ctx context.Context, i int, ds dag.DAGService) (*U
 // pin it locally so it doesnt get lost
 func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 	n.ufmt.AddBlockSize(child.ufmt.FileSize())
+	child.raw = db.isRawLeaf(child)
 
 	childnode, err := child.GetDagNode()
 	if err != nil {
 		return err
 	}
+	childnode.SetHashFunc(db.hashFunc)
 
 	// Add a link to this node without storing a reference to the memory
 	// This way, we avoid nodes building up and consuming all of our RAM
@@ -103,12 +115,15 @@ func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 		return err
 	}
 
-	_, err = db.batch.Add(childnode)
+	if db.isRef(child) {
+		err = db.addRef(child, childnode)
+	} else {
+		_, err = db.batch.Add(childnode)
+	}
 	if err != nil {
 		return err
 	}
 
-	// Pin the child node indirectly
 	err = db.ncb(childnode, false)
 	if err != nil {
 		return err
@@ -119,10 +134,6 @@ func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 
 // Removes the child node at the given index
 func (n *UnixfsNode) RemoveChild(index int, dbh *DagBuilderHelper) {
-	k := key.Key(n.node.Links[index].Hash)
-	if dbh.mp != nil {
-		dbh.mp.RemovePinWithMode(k, pin.Indirect)
-	}
 	n.ufmt.RemoveBlockSize(index)
 	n.node.Links = append(n.node.Links[:index], n.node.Links[index+1:]...)
 }
@@ -134,6 +145,10 @@ func (n *UnixfsNode) SetData(data []byte) {
 // getDagNode fills out the proper formatting for the unixfs node
 // inside of a DAG node and returns the dag node
 func (n *UnixfsNode) GetDagNode() (*dag.Node, error) {
+	if n.raw {
+		n.node = dag.NewRawNode(n.ufmt.Data)
+		return n.node, nil
+	}
 	data, err := n.ufmt.GetBytes()
 	if err != nil {
 		return nil, err
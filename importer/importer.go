@@ -40,6 +40,12 @@ func BuildDagFromFile(fpath string, ds dag.DAGService, mp pin.ManualPinner) (*da
 }
 
 func BuildDagFromReader(ds dag.DAGService, spl chunk.Splitter, ncb h.NodeCB) (*dag.Node, error) {
+	return BuildDagFromReaderRefs(ds, spl, ncb, nil)
+}
+
+// BuildDagFromReaderRefs is like BuildDagFromReader, but hands the leaves
+// to refs instead of adding them to ds, if refs is not nil.
+func BuildDagFromReaderRefs(ds dag.DAGService, spl chunk.Splitter, ncb h.NodeCB, refs h.LeafRefs) (*dag.Node, error) {
 	// Start the splitter
 	blkch, errch := chunk.Chan(spl)
 
@@ -47,12 +53,19 @@ func BuildDagFromReader(ds dag.DAGService, spl chunk.Splitter, ncb h.NodeCB) (*d
 		Dagserv:  ds,
 		Maxlinks: h.DefaultLinksPerBlock,
 		NodeCB:   ncb,
+		LeafRefs: refs,
 	}
 
 	return bal.BalancedLayout(dbp.New(blkch, errch))
 }
 
 func BuildTrickleDagFromReader(ds dag.DAGService, spl chunk.Splitter, ncb h.NodeCB) (*dag.Node, error) {
+	return BuildTrickleDagFromReaderRefs(ds, spl, ncb, nil)
+}
+
+// BuildTrickleDagFromReaderRefs is like BuildTrickleDagFromReader, but
+// hands the leaves to refs instead of adding them to ds, if refs is not nil.
+func BuildTrickleDagFromReaderRefs(ds dag.DAGService, spl chunk.Splitter, ncb h.NodeCB, refs h.LeafRefs) (*dag.Node, error) {
 	// Start the splitter
 	blkch, errch := chunk.Chan(spl)
 
@@ -60,36 +73,25 @@ func BuildTrickleDagFromReader(ds dag.DAGService, spl chunk.Splitter, ncb h.Node
 		Dagserv:  ds,
 		Maxlinks: h.DefaultLinksPerBlock,
 		NodeCB:   ncb,
+		LeafRefs: refs,
 	}
 
 	return trickle.TrickleLayout(dbp.New(blkch, errch))
 }
 
+// BasicPinnerCB pins the root of the DAG recursively. The nodes below are
+// kept by that pin, the builder has to be run under the blockstore's
+// PinLock for them to survive a concurrent GC until then.
 func BasicPinnerCB(p pin.ManualPinner) h.NodeCB {
 	return func(n *dag.Node, last bool) error {
-		k, err := n.Key()
-		if err != nil {
-			return err
-		}
-
-		if last {
-			p.PinWithMode(k, pin.Recursive)
-			return p.Flush()
-		} else {
-			p.PinWithMode(k, pin.Indirect)
+		if !last {
 			return nil
 		}
-	}
-}
-
-func PinIndirectCB(p pin.ManualPinner) h.NodeCB {
-	return func(n *dag.Node, last bool) error {
 		k, err := n.Key()
 		if err != nil {
 			return err
 		}
-
-		p.PinWithMode(k, pin.Indirect)
-		return nil
+		p.PinWithMode(k, pin.Recursive)
+		return p.Flush()
 	}
 }
@@ -6,10 +6,15 @@ import (
 	"io/ioutil"
 	"testing"
 
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bal "github.com/ipfs/go-ipfs/importer/balanced"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	trickle "github.com/ipfs/go-ipfs/importer/trickle"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
@@ -60,6 +65,158 @@ func TestBalancedDag(t *testing.T) {
 	}
 }
 
+// rawLeavesData returns size bytes of input for 100 byte chunks, every
+// other one of them happening to decode as a merkledag node.
+func rawLeavesData(size int) []byte {
+	buf := make([]byte, size)
+	u.NewTimeSeededRand().Read(buf)
+	for i := 0; i+100 <= size; i += 200 {
+		buf[i] = 0x0a // field 1, the node Data
+		buf[i+1] = 98
+	}
+	return buf
+}
+
+func testRawLeaves(t *testing.T, size int, useTrickle bool) {
+	ds := mdtest.Mock()
+	buf := rawLeavesData(size)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:   ds,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		RawLeaves: true,
+	}
+	db := dbp.New(chunk.Chan(chunk.NewSizeSplitter(bytes.NewReader(buf), 100)))
+	layout := bal.BalancedLayout
+	if useTrickle {
+		layout = trickle.TrickleLayout
+	}
+	nd, err := layout(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ft.FromBytes(nd.Data); err != nil {
+		t.Fatal("the root should be a unixfs node")
+	}
+
+	// every leaf is stored as the chunk itself
+	var leaves int
+	var walk func(nd *dag.Node)
+	walk = func(nd *dag.Node) {
+		if _, err := ft.FromBytes(nd.Data); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range nd.Links {
+			if l.Raw {
+				off := leaves * 100
+				end := off + 100
+				if end > len(buf) {
+					end = len(buf)
+				}
+				if !bytes.Equal(l.Hash, u.Hash(buf[off:end])) {
+					t.Fatalf("leaf %d is not the raw chunk", leaves)
+				}
+				leaves++
+				continue
+			}
+			child, err := l.GetNode(context.Background(), ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			walk(child)
+		}
+	}
+	walk(nd)
+	if leaves != (size+99)/100 {
+		t.Fatalf("expected %d raw leaves, got %d", (size+99)/100, leaves)
+	}
+
+	dr, err := uio.NewDagReader(context.Background(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("bad read")
+	}
+
+	if _, err := dr.Seek(int64(size/2), 0); err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf[size/2:]) {
+		t.Fatal("bad read after seek")
+	}
+}
+
+func TestRawLeavesBalanced(t *testing.T) {
+	testRawLeaves(t, 50050, false)
+}
+
+func TestRawLeavesTrickle(t *testing.T) {
+	testRawLeaves(t, 50050, true)
+}
+
+func TestRawLeavesSingleBlock(t *testing.T) {
+	testRawLeaves(t, 100, false)
+}
+
+func TestHashFunc(t *testing.T) {
+	ds := mdtest.Mock()
+	buf := make([]byte, 50000)
+	u.NewTimeSeededRand().Read(buf)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:   ds,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		RawLeaves: true,
+		HashFunc:  mh.SHA3,
+	}
+	db := dbp.New(chunk.Chan(chunk.NewSizeSplitter(bytes.NewReader(buf), 1000)))
+	nd, err := bal.BalancedLayout(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []mh.Multihash{}
+	if h, err := nd.Multihash(); err != nil {
+		t.Fatal(err)
+	} else {
+		keys = append(keys, h)
+	}
+	for _, l := range nd.Links {
+		keys = append(keys, l.Hash)
+	}
+	for _, k := range keys {
+		dec, err := mh.Decode(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec.Code != mh.SHA3 {
+			t.Fatalf("node hashed with %s", dec.Name)
+		}
+	}
+
+	dr, err := uio.NewDagReader(context.Background(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("bad read")
+	}
+}
+
 func BenchmarkBalancedReadSmallBlock(b *testing.B) {
 	b.StopTimer()
 	nbytes := int64(10000000)
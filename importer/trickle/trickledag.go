@@ -272,6 +272,13 @@ func verifyTDagRec(nd *dag.Node, depth, direct, layerRepeat int, ds dag.DAGServi
 			return err
 		}
 
+		if child.IsRaw() {
+			if i >= direct {
+				return errors.New("expected a branch, got a raw block")
+			}
+			continue
+		}
+
 		if i < direct {
 			// Direct blocks
 			err := verifyTDagRec(child, 0, direct, layerRepeat, ds)
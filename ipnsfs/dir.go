@@ -10,6 +10,7 @@ import (
 
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	ufspb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
@@ -24,22 +25,27 @@ type Directory struct {
 	files     map[string]*File
 
 	lock sync.Mutex
-	node *dag.Node
+	dir  *uio.Directory
 	ctx  context.Context
 
 	name string
 }
 
-func NewDirectory(ctx context.Context, name string, node *dag.Node, parent childCloser, fs *Filesystem) *Directory {
+// NewDirectory returns the directory stored in node, which may be sharded.
+func NewDirectory(ctx context.Context, name string, node *dag.Node, parent childCloser, fs *Filesystem) (*Directory, error) {
+	dir, err := uio.NewDirectoryFromNode(fs.dserv, node)
+	if err != nil {
+		return nil, err
+	}
 	return &Directory{
 		ctx:       ctx,
 		fs:        fs,
 		name:      name,
-		node:      node,
+		dir:       dir,
 		parent:    parent,
 		childDirs: make(map[string]*Directory),
 		files:     make(map[string]*File),
-	}
+	}, nil
 }
 
 // closeChild updates the child by the given name to the dag node 'nd'
@@ -52,17 +58,21 @@ func (d *Directory) closeChild(name string, nd *dag.Node) error {
 
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	err = d.node.RemoveNodeLink(name)
-	if err != nil && err != dag.ErrNotFound {
+	err = d.dir.AddChildNode(d.ctx, name, nd)
+	if err != nil {
 		return err
 	}
 
-	err = d.node.AddNodeLinkClean(name, nd)
+	return d.closeSelf()
+}
+
+// closeSelf passes the changed node of this directory on to its parent.
+func (d *Directory) closeSelf() error {
+	nd, err := d.dir.GetNode()
 	if err != nil {
 		return err
 	}
-
-	return d.parent.closeChild(d.name, d.node)
+	return d.parent.closeChild(d.name, nd)
 }
 
 func (d *Directory) Type() NodeType {
@@ -86,7 +96,7 @@ func (d *Directory) childFile(name string) (*File, error) {
 	}
 
 	switch i.GetType() {
-	case ufspb.Data_Directory:
+	case ufspb.Data_Directory, ufspb.Data_HAMTShard:
 		return nil, ErrIsDirectory
 	case ufspb.Data_File:
 		nfi, err := NewFile(name, nd, d, d.fs)
@@ -121,8 +131,11 @@ func (d *Directory) childDir(name string) (*Directory, error) {
 	}
 
 	switch i.GetType() {
-	case ufspb.Data_Directory:
-		ndir := NewDirectory(d.ctx, name, nd, d, d.fs)
+	case ufspb.Data_Directory, ufspb.Data_HAMTShard:
+		ndir, err := NewDirectory(d.ctx, name, nd, d, d.fs)
+		if err != nil {
+			return nil, err
+		}
 		d.childDirs[name] = ndir
 		return ndir, nil
 	case ufspb.Data_File:
@@ -137,13 +150,7 @@ func (d *Directory) childDir(name string) (*Directory, error) {
 // childFromDag searches through this directories dag node for a child link
 // with the given name
 func (d *Directory) childFromDag(name string) (*dag.Node, error) {
-	for _, lnk := range d.node.Links {
-		if lnk.Name == name {
-			return lnk.GetNode(d.ctx, d.fs.dserv)
-		}
-	}
-
-	return nil, os.ErrNotExist
+	return d.dir.Find(d.ctx, name)
 }
 
 // Child returns the child of this directory by the given name
@@ -172,8 +179,14 @@ func (d *Directory) List() []string {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
+	links, err := d.dir.Links(d.ctx)
+	if err != nil {
+		log.Errorf("listing %s: %s", d.name, err)
+		return nil
+	}
+
 	var out []string
-	for _, lnk := range d.node.Links {
+	for _, lnk := range links {
 		out = append(out, lnk.Name)
 	}
 	return out
@@ -193,12 +206,17 @@ func (d *Directory) Mkdir(name string) (*Directory, error) {
 	}
 
 	ndir := &dag.Node{Data: ft.FolderPBData()}
-	err = d.node.AddNodeLinkClean(name, ndir)
+	_, err = d.fs.dserv.Add(ndir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.dir.AddChildNode(d.ctx, name, ndir)
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.parent.closeChild(d.name, d.node)
+	err = d.closeSelf()
 	if err != nil {
 		return nil, err
 	}
@@ -213,12 +231,12 @@ func (d *Directory) Unlink(name string) error {
 	delete(d.childDirs, name)
 	delete(d.files, name)
 
-	err := d.node.RemoveNodeLink(name)
+	err := d.dir.RemoveChild(d.ctx, name)
 	if err != nil {
 		return err
 	}
 
-	return d.parent.closeChild(d.name, d.node)
+	return d.closeSelf()
 }
 
 // AddChild adds the node 'nd' under this directory giving it the name 'name'
@@ -235,14 +253,23 @@ func (d *Directory) AddChild(name string, nd *dag.Node) error {
 		return errors.New("directory already has entry by that name")
 	}
 
-	err = d.node.AddNodeLinkClean(name, nd)
+	_, err = d.fs.dserv.Add(nd)
+	if err != nil {
+		return err
+	}
+
+	err = d.dir.AddChildNode(d.ctx, name, nd)
 	if err != nil {
 		return err
 	}
 
 	switch pbn.GetType() {
-	case ft.TDirectory:
-		d.childDirs[name] = NewDirectory(d.ctx, name, nd, d, d.fs)
+	case ft.TDirectory, ft.THAMTShard:
+		ndir, err := NewDirectory(d.ctx, name, nd, d, d.fs)
+		if err != nil {
+			return err
+		}
+		d.childDirs[name] = ndir
 	case ft.TFile, ft.TMetadata, ft.TRaw:
 		nfi, err := NewFile(name, nd, d, d.fs)
 		if err != nil {
@@ -252,11 +279,11 @@ func (d *Directory) AddChild(name string, nd *dag.Node) error {
 	default:
 		return ErrInvalidChild
 	}
-	return d.parent.closeChild(d.name, d.node)
+	return d.closeSelf()
 }
 
 func (d *Directory) GetNode() (*dag.Node, error) {
-	return d.node, nil
+	return d.dir.GetNode()
 }
 
 func (d *Directory) Lock() {
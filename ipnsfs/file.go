@@ -10,6 +10,10 @@ import (
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 )
 
+// File is a file of the tree. Writing to it puts blocks that nothing links
+// to until it is closed, so callers keep a GC out with the blockstore's
+// PinLock from the first write to the Close. The File does not take that
+// lock itself: it is not reentrant, and a caller may hold it already.
 type File struct {
 	parent childCloser
 	fs     *Filesystem
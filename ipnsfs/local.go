@@ -0,0 +1,112 @@
+package ipnsfs
+
+import (
+	"sync"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	path "github.com/ipfs/go-ipfs/path"
+	pin "github.com/ipfs/go-ipfs/pin"
+)
+
+// PersistFunc records the key of a LocalRoot's directory after it changed.
+type PersistFunc func(context.Context, key.Key) error
+
+// LocalRoot is the root of a mutable directory tree that, unlike a KeyRoot,
+// isn't published to ipns: its changes are handed to a PersistFunc instead.
+type LocalRoot struct {
+	fs *Filesystem
+
+	// the directory the root points to
+	val *Directory
+
+	persist PersistFunc
+
+	// lock keeps concurrent flushes from persisting out of order
+	lock sync.Mutex
+
+	repub  *Republisher
+	cancel func()
+}
+
+// NewLocalRoot returns a LocalRoot for the directory stored in node. The
+// changes made under it are persisted shortly after they happen, and
+// whenever Flush or Close is called.
+func NewLocalRoot(parent context.Context, ds dag.DAGService, pins pin.Pinner, gcl bstore.GCLocker, node *dag.Node, persist PersistFunc) (*LocalRoot, error) {
+	ctx, cancel := context.WithCancel(parent)
+	fs := &Filesystem{
+		ctx:      ctx,
+		dserv:    ds,
+		pins:     pins,
+		gcl:      gcl,
+		resolver: &path.Resolver{DAG: ds},
+		roots:    make(map[string]*KeyRoot),
+	}
+
+	lr := &LocalRoot{
+		fs:      fs,
+		persist: persist,
+		cancel:  cancel,
+	}
+	dir, err := NewDirectory(ctx, "", node, lr, fs)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	lr.val = dir
+
+	lr.repub = NewRepublisher(lr, time.Millisecond*300, time.Second*3)
+	go lr.repub.Run(ctx)
+	return lr, nil
+}
+
+// GetValue returns the root directory.
+func (lr *LocalRoot) GetValue() *Directory {
+	return lr.val
+}
+
+// closeChild implements the childCloser interface, and signals to the
+// republisher that there are changes ready to be persisted
+func (lr *LocalRoot) closeChild(name string, nd *dag.Node) error {
+	lr.repub.Touch()
+	return nil
+}
+
+// Publish adds the root directory to the dag service, and persists its key.
+// It takes the blockstore's pin lock, callers must not hold it.
+func (lr *LocalRoot) Publish(ctx context.Context) error {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+
+	unlock := lr.fs.gcl.PinLock()
+	lr.val.Lock()
+	nd, err := lr.val.GetNode()
+	if err != nil {
+		lr.val.Unlock()
+		unlock()
+		return err
+	}
+	k, err := lr.fs.dserv.Add(nd)
+	lr.val.Unlock()
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	return lr.persist(ctx, k)
+}
+
+// Flush persists the current state of the tree right away.
+func (lr *LocalRoot) Flush() error {
+	return lr.Publish(lr.fs.ctx)
+}
+
+// Close stops the republisher, and flushes the tree a last time.
+func (lr *LocalRoot) Close() error {
+	lr.cancel()
+	return lr.Publish(context.Background())
+}
@@ -0,0 +1,154 @@
+package ipnsfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	pin "github.com/ipfs/go-ipfs/pin"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+)
+
+func setupLocalRoot(t *testing.T, ctx context.Context, dserv dag.DAGService, nd *dag.Node) (*LocalRoot, *key.Key) {
+	pins := pin.NewPinner(dssync.MutexWrap(ds.NewMapDatastore()), dserv)
+	persisted := new(key.Key)
+	persist := func(ctx context.Context, k key.Key) error {
+		*persisted = k
+		return nil
+	}
+
+	lr, err := NewLocalRoot(ctx, dserv, pins, bstore.NewGCLocker(), nd, persist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lr, persisted
+}
+
+func writeTestFile(t *testing.T, root *Directory, p string, data []byte) {
+	err := PutNode(root, p, &dag.Node{Data: ft.FilePBData(nil, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := Lookup(root, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := nd.(*File)
+	if _, err := fi.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, dserv dag.DAGService, root *Directory, p string) []byte {
+	nd, err := Lookup(root, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dagnd, err := nd.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := uio.NewDagReader(context.Background(), dagnd, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestLocalRootOps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dserv := mdtest.Mock()
+
+	lr, _ := setupLocalRoot(t, ctx, dserv, &dag.Node{Data: ft.FolderPBData()})
+	root := lr.GetValue()
+
+	if _, err := Mkdir(root, "/a/b", false); err == nil {
+		t.Fatal("expected mkdir without parents to fail")
+	}
+	if _, err := Mkdir(root, "/a/b", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Mkdir(root, "/a/b", true); err != nil {
+		t.Fatal("mkdir -p of an existing directory failed: ", err)
+	}
+	if _, err := Mkdir(root, "/a/b", false); err != os.ErrExist {
+		t.Fatal("expected mkdir of an existing directory to fail")
+	}
+
+	data := []byte("some test data")
+	writeTestFile(t, root, "/a/b/file", data)
+	if out := readTestFile(t, dserv, root, "/a/b/file"); !bytes.Equal(out, data) {
+		t.Fatal("read back wrong data")
+	}
+
+	if err := Mv(root, "/a/b/file", "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lookup(root, "/a/b/file"); err != os.ErrNotExist {
+		t.Fatal("file still at its old path after mv")
+	}
+	if out := readTestFile(t, dserv, root, "/a/file"); !bytes.Equal(out, data) {
+		t.Fatal("read back wrong data after mv")
+	}
+	if err := Mv(root, "/a", "/a/b/c"); err == nil {
+		t.Fatal("expected moving a directory inside of itself to fail")
+	}
+
+	if err := Remove(root, "/a", false); err == nil {
+		t.Fatal("expected removing a directory without recursive to fail")
+	}
+	if err := Remove(root, "/a/file", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(root, "/a", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.List()) != 0 {
+		t.Fatal("root directory should be empty")
+	}
+}
+
+func TestLocalRootPersist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dserv := mdtest.Mock()
+
+	lr, persisted := setupLocalRoot(t, ctx, dserv, &dag.Node{Data: ft.FolderPBData()})
+	data := []byte("persist me")
+	writeTestFile(t, lr.GetValue(), "/file", data)
+
+	if err := lr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if *persisted == "" {
+		t.Fatal("flush did not persist the root")
+	}
+
+	// load the tree back from the persisted key
+	nd, err := dserv.Get(ctx, *persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr2, _ := setupLocalRoot(t, ctx, dserv, nd)
+	if out := readTestFile(t, dserv, lr2.GetValue(), "/file"); !bytes.Equal(out, data) {
+		t.Fatal("read back wrong data from the persisted root")
+	}
+}
@@ -0,0 +1,164 @@
+package ipnsfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	gopath "path"
+	"strings"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// splitPath returns the names along the slash separated path p.
+func splitPath(p string) []string {
+	var out []string
+	for _, name := range strings.Split(p, "/") {
+		if name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// Lookup returns the node at path p under the directory root.
+func Lookup(root *Directory, p string) (FSNode, error) {
+	var cur FSNode = root
+	for i, name := range splitPath(p) {
+		dir, ok := cur.(*Directory)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a directory", gopath.Join(splitPath(p)[:i]...))
+		}
+		child, err := dir.Child(name)
+		if err != nil {
+			return nil, err
+		}
+		cur = child
+	}
+	return cur, nil
+}
+
+// LookupDir returns the directory at path p under root.
+func LookupDir(root *Directory, p string) (*Directory, error) {
+	nd, err := Lookup(root, p)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := nd.(*Directory)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	return dir, nil
+}
+
+// Mkdir creates the directory at path p under root. With parents set, the
+// missing directories along p are created as well, and it is not an error
+// for the directory to exist already.
+func Mkdir(root *Directory, p string, parents bool) (*Directory, error) {
+	names := splitPath(p)
+	if len(names) == 0 {
+		if parents {
+			return root, nil
+		}
+		return nil, os.ErrExist
+	}
+
+	cur := root
+	for i, name := range names {
+		child, err := cur.Child(name)
+		switch {
+		case err == os.ErrNotExist && (parents || i == len(names)-1):
+			child, err = cur.Mkdir(name)
+			if err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		case i == len(names)-1 && !parents:
+			return nil, os.ErrExist
+		}
+
+		dir, ok := child.(*Directory)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a directory", gopath.Join(names[:i+1]...))
+		}
+		cur = dir
+	}
+	return cur, nil
+}
+
+// PutNode adds nd at path p under root. The parent directory of p must
+// exist, and p must not.
+func PutNode(root *Directory, p string, nd *dag.Node) error {
+	dir, name := gopath.Split(gopath.Clean("/" + p))
+	if name == "" {
+		return errors.New("cannot replace the root directory")
+	}
+	parent, err := LookupDir(root, dir)
+	if err != nil {
+		return err
+	}
+	return parent.AddChild(name, nd)
+}
+
+// Remove removes the node at path p under root. Directories are only
+// removed if recursive is set.
+func Remove(root *Directory, p string, recursive bool) error {
+	dir, name := gopath.Split(gopath.Clean("/" + p))
+	if name == "" {
+		return errors.New("cannot remove the root directory")
+	}
+	parent, err := LookupDir(root, dir)
+	if err != nil {
+		return err
+	}
+	child, err := parent.Child(name)
+	if err != nil {
+		return err
+	}
+	if child.Type() == TDir && !recursive {
+		return fmt.Errorf("%s is a directory, use -r to remove directories", p)
+	}
+	return parent.Unlink(name)
+}
+
+// Mv moves the node at path src under root to dst. When dst is an existing
+// directory, the node is moved inside of it.
+func Mv(root *Directory, src, dst string) error {
+	srcDir, srcName := gopath.Split(gopath.Clean("/" + src))
+	if srcName == "" {
+		return errors.New("cannot move the root directory")
+	}
+	srcParent, err := LookupDir(root, srcDir)
+	if err != nil {
+		return err
+	}
+	child, err := srcParent.Child(srcName)
+	if err != nil {
+		return err
+	}
+	nd, err := child.GetNode()
+	if err != nil {
+		return err
+	}
+
+	dst = gopath.Clean("/" + dst)
+	if dir, err := LookupDir(root, dst); err == nil {
+		dst = gopath.Join(dst, srcName)
+		if _, err := dir.Child(srcName); err == nil {
+			return os.ErrExist
+		}
+	}
+	if dst == gopath.Clean("/"+src) {
+		return nil
+	}
+	if strings.HasPrefix(dst, gopath.Clean("/"+src)+"/") {
+		return errors.New("cannot move a directory inside of itself")
+	}
+
+	err = PutNode(root, dst, nd)
+	if err != nil {
+		return err
+	}
+	return srcParent.Unlink(srcName)
+}
@@ -1,5 +1,5 @@
 // package ipnsfs implements an in memory model of a mutable ipns filesystem,
-// to be used by the fuse filesystem.
+// to be used by the fuse filesystem and the 'ipfs files' commands.
 //
 // It consists of four main structs:
 // 1) The Filesystem
@@ -16,6 +16,7 @@ import (
 	"sync"
 	"time"
 
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	namesys "github.com/ipfs/go-ipfs/namesys"
@@ -44,11 +45,14 @@ type Filesystem struct {
 
 	pins pin.Pinner
 
+	// gcl keeps garbage collection from running while changes are flushed
+	gcl bstore.GCLocker
+
 	roots map[string]*KeyRoot
 }
 
 // NewFilesystem instantiates an ipns filesystem using the given parameters and locally owned keys
-func NewFilesystem(ctx context.Context, ds dag.DAGService, nsys namesys.NameSystem, pins pin.Pinner, keys ...ci.PrivKey) (*Filesystem, error) {
+func NewFilesystem(ctx context.Context, ds dag.DAGService, nsys namesys.NameSystem, pins pin.Pinner, gcl bstore.GCLocker, keys ...ci.PrivKey) (*Filesystem, error) {
 	roots := make(map[string]*KeyRoot)
 	fs := &Filesystem{
 		ctx:      ctx,
@@ -56,6 +60,7 @@ func NewFilesystem(ctx context.Context, ds dag.DAGService, nsys namesys.NameSyst
 		nsys:     nsys,
 		dserv:    ds,
 		pins:     pins,
+		gcl:      gcl,
 		resolver: &path.Resolver{DAG: ds},
 	}
 	for _, k := range keys {
@@ -156,7 +161,9 @@ func (fs *Filesystem) newKeyRoot(parent context.Context, k ci.PrivKey) (*KeyRoot
 
 	pointsTo, err := fs.nsys.Resolve(ctx, name)
 	if err != nil {
+		unlock := fs.gcl.PinLock()
 		err = namesys.InitializeKeyspace(ctx, fs.dserv, fs.nsys, fs.pins, k)
+		unlock()
 		if err != nil {
 			return nil, err
 		}
@@ -185,8 +192,12 @@ func (fs *Filesystem) newKeyRoot(parent context.Context, k ci.PrivKey) (*KeyRoot
 	}
 
 	switch pbn.GetType() {
-	case ft.TDirectory:
-		root.val = NewDirectory(ctx, pointsTo.String(), mnode, root, fs)
+	case ft.TDirectory, ft.THAMTShard:
+		dir, err := NewDirectory(ctx, pointsTo.String(), mnode, root, fs)
+		if err != nil {
+			return nil, err
+		}
+		root.val = dir
 	case ft.TFile, ft.TMetadata, ft.TRaw:
 		fi, err := NewFile(pointsTo.String(), mnode, root, fs)
 		if err != nil {
@@ -217,8 +228,10 @@ func (kr *KeyRoot) Publish(ctx context.Context) error {
 		return errors.New("child of key root not valid type")
 	}
 
+	unlock := kr.fs.gcl.PinLock()
 	nd, err := child.GetNode()
 	if err != nil {
+		unlock()
 		return err
 	}
 
@@ -227,9 +240,11 @@ func (kr *KeyRoot) Publish(ctx context.Context) error {
 	k, err := kr.fs.dserv.Add(nd)
 	if err != nil {
 		child.Unlock()
+		unlock()
 		return err
 	}
 	child.Unlock()
+	unlock()
 	// Dont want to hold the lock while we publish
 	// otherwise we are holding the lock through a costly
 	// network operation
@@ -243,17 +258,23 @@ func (kr *KeyRoot) Publish(ctx context.Context) error {
 	return kr.fs.nsys.Publish(ctx, kr.key, kp)
 }
 
+// publisher is a root the Republisher publishes the changes of, either a
+// KeyRoot or a LocalRoot
+type publisher interface {
+	Publish(context.Context) error
+}
+
 // Republisher manages when to publish the ipns entry associated with a given key
 type Republisher struct {
 	TimeoutLong  time.Duration
 	TimeoutShort time.Duration
 	Publish      chan struct{}
-	root         *KeyRoot
+	root         publisher
 }
 
-// NewRepublisher creates a new Republisher object to republish the given keyroot
+// NewRepublisher creates a new Republisher object to republish the given root
 // using the given short and long time intervals
-func NewRepublisher(root *KeyRoot, tshort, tlong time.Duration) *Republisher {
+func NewRepublisher(root publisher, tshort, tlong time.Duration) *Republisher {
 	return &Republisher{
 		TimeoutShort: tshort,
 		TimeoutLong:  tlong,
@@ -0,0 +1,190 @@
+// Package dagarchive reads and writes archives of the raw blocks of DAGs,
+// to move them between repos that are not connected.
+//
+// An archive is the magic line "ipfs-dag-archive\n", a header, then the
+// blocks. The header is a uvarint length followed by that many bytes of a
+// JSON encoded Header. Each block is a uvarint length and the block's
+// multihash, then a uvarint length and the block's data. Blocks come in the
+// order of a depth first traversal from the roots, each one once.
+package dagarchive
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	"github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// Version is the version of the archive format written by Export.
+const Version = 1
+
+const magic = "ipfs-dag-archive\n"
+
+// MaxBlockSize is the size of the largest block Import accepts.
+var MaxBlockSize = 4 << 20
+
+var (
+	// ErrNotArchive is returned by Import for input that is not an archive.
+	ErrNotArchive = errors.New("dagarchive: not a dag archive")
+
+	// ErrBlockTooLarge is returned by Import for a block larger than
+	// MaxBlockSize.
+	ErrBlockTooLarge = errors.New("dagarchive: block too large")
+)
+
+// Header describes the content of an archive.
+type Header struct {
+	Version int
+	Roots   []key.Key
+}
+
+// Export writes an archive of the DAGs under roots to w. Blocks are fetched
+// from bs and written one at a time, so the archive is streamed.
+func Export(ctx context.Context, w io.Writer, bs *bserv.BlockService, roots []key.Key) error {
+	bw := bufio.NewWriter(w)
+	hdr, err := json.Marshal(&Header{Version: Version, Roots: roots})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(bw, magic); err != nil {
+		return err
+	}
+	if err := writeChunk(bw, hdr); err != nil {
+		return err
+	}
+
+	seen := make(map[key.Key]struct{})
+	for _, k := range roots {
+		if err := exportBlock(ctx, bw, bs, k, false, seen); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// exportBlock writes the block k then the ones below it. raw is set for raw
+// blocks, which have nothing below.
+func exportBlock(ctx context.Context, w io.Writer, bs *bserv.BlockService, k key.Key, raw bool, seen map[key.Key]struct{}) error {
+	if _, ok := seen[k]; ok {
+		return nil
+	}
+	seen[k] = struct{}{}
+
+	b, err := bs.GetBlock(ctx, k)
+	if err != nil {
+		return fmt.Errorf("getting block %s: %s", k, err)
+	}
+	if err := writeChunk(w, b.Multihash); err != nil {
+		return err
+	}
+	if err := writeChunk(w, b.Data); err != nil {
+		return err
+	}
+
+	if raw {
+		return nil
+	}
+	nd, err := dag.Decoded(b.Data)
+	if err != nil {
+		return fmt.Errorf("decoding block %s: %s", k, err)
+	}
+	for _, l := range nd.Links {
+		if err := exportBlock(ctx, w, bs, key.Key(l.Hash), l.Raw, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeChunk(w io.Writer, data []byte) error {
+	var lbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lbuf[:], uint64(len(data)))
+	if _, err := w.Write(lbuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// Import reads an archive from r, checks every block against its hash and
+// puts it in bs. It returns the header and the number of blocks read.
+func Import(r io.Reader, bs bstore.Blockstore) (*Header, int, error) {
+	br := bufio.NewReader(r)
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, m); err != nil || string(m) != magic {
+		return nil, 0, ErrNotArchive
+	}
+
+	hdrb, err := readChunk(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	hdr := new(Header)
+	if err := json.Unmarshal(hdrb, hdr); err != nil {
+		return nil, 0, fmt.Errorf("dagarchive: invalid header: %s", err)
+	}
+	if hdr.Version != Version {
+		return nil, 0, fmt.Errorf("dagarchive: unsupported version %d", hdr.Version)
+	}
+
+	count := 0
+	for {
+		h, err := readChunk(br)
+		if err == io.EOF {
+			return hdr, count, nil
+		}
+		if err != nil {
+			return nil, count, err
+		}
+		data, err := readChunk(br)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, count, err
+		}
+
+		if err := blocks.VerifyHash(data, mh.Multihash(h)); err != nil {
+			return nil, count, fmt.Errorf("dagarchive: block %s: %s", key.Key(h), err)
+		}
+		b, err := blocks.NewBlockWithHash(data, h)
+		if err != nil {
+			return nil, count, err
+		}
+		if err := bs.Put(b); err != nil {
+			return nil, count, err
+		}
+		count++
+	}
+}
+
+// readChunk reads a length prefixed chunk. It returns io.EOF only if r
+// ends right before the chunk.
+func readChunk(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(MaxBlockSize) {
+		return nil, ErrBlockTooLarge
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,165 @@
+package dagarchive
+
+import (
+	"bytes"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+func newService() (bstore.Blockstore, *bserv.BlockService, dag.DAGService) {
+	bs := bstore.NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	return bs, bsrv, dag.NewDAGService(bsrv)
+}
+
+// buildDag adds a root with two children sharing a raw leaf.
+func buildDag(t *testing.T, dserv dag.DAGService) (*dag.Node, []key.Key) {
+	leaf := dag.NewRawNode([]byte("shared leaf"))
+	a := &dag.Node{Data: []byte("a")}
+	b := &dag.Node{Data: []byte("b")}
+	for _, nd := range []*dag.Node{a, b} {
+		if err := nd.AddNodeLink("leaf", leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := new(dag.Node)
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []key.Key
+	for _, nd := range []*dag.Node{root, a, b, leaf} {
+		k, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	return root, keys
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	_, srcserv, srcdag := newService()
+	_, keys := buildDag(t, srcdag)
+
+	buf := new(bytes.Buffer)
+	if err := Export(ctx, buf, srcserv, keys[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, _, _ := newService()
+	hdr, n, err := Import(bytes.NewReader(buf.Bytes()), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(keys) {
+		t.Fatalf("expected %d blocks, got %d", len(keys), n)
+	}
+	if len(hdr.Roots) != 1 || hdr.Roots[0] != keys[0] {
+		t.Fatal("wrong roots in header:", hdr.Roots)
+	}
+	for _, k := range keys {
+		b, err := dst.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb, err := srcserv.Blockstore.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Data, sb.Data) {
+			t.Fatal("imported block differs from the exported one")
+		}
+	}
+}
+
+func TestExportRawLeafLikeNode(t *testing.T) {
+	ctx := context.Background()
+	_, srcserv, srcdag := newService()
+
+	// a raw leaf whose data decodes as a node linking to a missing block
+	inner := new(dag.Node)
+	if err := inner.AddNodeLinkClean("missing", &dag.Node{Data: []byte("never added")}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := inner.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := new(dag.Node)
+	if err := root.AddNodeLink("leaf", dag.NewRawNode(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srcdag.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+	rk, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Export(ctx, buf, srcserv, []key.Key{rk}); err != nil {
+		t.Fatal(err)
+	}
+	dst, _, _ := newService()
+	if _, n, err := Import(buf, dst); err != nil || n != 2 {
+		t.Fatalf("expected 2 blocks, got %d (%v)", n, err)
+	}
+}
+
+func TestImportBadHash(t *testing.T) {
+	ctx := context.Background()
+	_, srcserv, srcdag := newService()
+	_, keys := buildDag(t, srcdag)
+
+	buf := new(bytes.Buffer)
+	if err := Export(ctx, buf, srcserv, keys[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the raw leaf is the last block, change a byte of its data
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+
+	dst, _, _ := newService()
+	if _, _, err := Import(bytes.NewReader(data), dst); err == nil {
+		t.Fatal("expected an error importing a corrupted block")
+	}
+}
+
+func TestImportTruncated(t *testing.T) {
+	ctx := context.Background()
+	_, srcserv, srcdag := newService()
+	_, keys := buildDag(t, srcdag)
+
+	buf := new(bytes.Buffer)
+	if err := Export(ctx, buf, srcserv, keys[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, _, _ := newService()
+	data := buf.Bytes()
+	if _, _, err := Import(bytes.NewReader(data[:len(data)-3]), dst); err == nil {
+		t.Fatal("expected an error importing a truncated archive")
+	}
+	if _, _, err := Import(bytes.NewReader([]byte("not an archive")), dst); err != ErrNotArchive {
+		t.Fatal("expected ErrNotArchive, got", err)
+	}
+}
@@ -24,7 +24,7 @@ func (n *Node) Unmarshal(encoded []byte) error {
 	pbnl := pbn.GetLinks()
 	n.Links = make([]*Link, len(pbnl))
 	for i, l := range pbnl {
-		n.Links[i] = &Link{Name: l.GetName(), Size: l.GetTsize()}
+		n.Links[i] = &Link{Name: l.GetName(), Size: l.GetTsize(), Raw: l.GetRaw()}
 		h, err := mh.Cast(l.GetHash())
 		if err != nil {
 			return fmt.Errorf("Link hash is not valid multihash. %v", err)
@@ -38,8 +38,11 @@ func (n *Node) Unmarshal(encoded []byte) error {
 }
 
 // Marshal encodes a *Node instance into a new byte slice.
-// The conversion uses an intermediate PBNode.
+// The conversion uses an intermediate PBNode, raw nodes are their Data.
 func (n *Node) Marshal() ([]byte, error) {
+	if n.raw {
+		return n.Data, nil
+	}
 	pbn := n.getPBNode()
 	data, err := pbn.Marshal()
 	if err != nil {
@@ -58,6 +61,10 @@ func (n *Node) getPBNode() *pb.PBNode {
 		pbn.Links[i].Name = &l.Name
 		pbn.Links[i].Tsize = &l.Size
 		pbn.Links[i].Hash = []byte(l.Hash)
+		if l.Raw {
+			// only then, so links to nodes keep their encoding
+			pbn.Links[i].Raw = &l.Raw
+		}
 	}
 
 	pbn.Data = n.Data
@@ -69,12 +76,19 @@ func (n *Node) getPBNode() *pb.PBNode {
 func (n *Node) Encoded(force bool) ([]byte, error) {
 	sort.Stable(LinkSlice(n.Links)) // keep links sorted
 	if n.encoded == nil || force {
-		var err error
-		n.encoded, err = n.Marshal()
+		encoded, err := n.Marshal()
 		if err != nil {
 			return nil, err
 		}
-		n.cached = u.Hash(n.encoded)
+		if n.hashFunc == 0 {
+			n.cached = u.Hash(encoded)
+		} else {
+			n.cached, err = mh.Sum(encoded, n.hashFunc, -1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		n.encoded = encoded
 	}
 
 	return n.encoded, nil
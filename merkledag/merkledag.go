@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"sync"
 
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	blocks "github.com/ipfs/go-ipfs/blocks"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
 	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 )
 
@@ -22,16 +24,51 @@ type DAGService interface {
 	Get(context.Context, key.Key) (*Node, error)
 	Remove(*Node) error
 
+	// GetRaw retrieves the raw block k as a raw node.
+	GetRaw(context.Context, key.Key) (*Node, error)
+
 	// GetDAG returns, in order, all the single leve child
 	// nodes of the passed in node.
 	GetDAG(context.Context, *Node) []NodeGetter
 	GetNodes(context.Context, []key.Key) []NodeGetter
 
+	// GetLinks is like GetNodes, for the targets of links, which are
+	// returned as raw nodes for the links to raw blocks.
+	GetLinks(context.Context, []*Link) []NodeGetter
+
 	Batch() *Batch
 }
 
 func NewDAGService(bs *bserv.BlockService) DAGService {
-	return &dagService{bs}
+	return &dagService{Blocks: bs, fetch: bs}
+}
+
+// NewSession returns a DAGService fetching the nodes it gets through a
+// session of ds' BlockService, for walking one DAG. The session lasts until
+// ctx is done.
+func NewSession(ctx context.Context, ds DAGService) DAGService {
+	n, ok := ds.(*dagService)
+	if !ok {
+		return ds
+	}
+	return &dagService{Blocks: n.Blocks, fetch: bserv.NewSession(ctx, n.Blocks)}
+}
+
+// NewOffline returns a DAGService getting the nodes of ds from its local
+// blockstore only, never waiting on the network for them.
+func NewOffline(ds DAGService) DAGService {
+	n, ok := ds.(*dagService)
+	if !ok {
+		return ds
+	}
+	bs := n.Blocks.Blockstore
+	return &dagService{Blocks: n.Blocks, fetch: bserv.New(bs, offline.Exchange(bs))}
+}
+
+// blockFetcher is what dagService gets blocks from.
+type blockFetcher interface {
+	GetBlock(context.Context, key.Key) (*blocks.Block, error)
+	GetBlocks(context.Context, []key.Key) <-chan *blocks.Block
 }
 
 // dagService is an IPFS Merkle DAG service.
@@ -41,6 +78,7 @@ func NewDAGService(bs *bserv.BlockService) DAGService {
 //       able to free some of them when vm pressure is high
 type dagService struct {
 	Blocks *bserv.BlockService
+	fetch  blockFetcher
 }
 
 // Add adds a node to the dagService, storing the block in the BlockService
@@ -90,13 +128,21 @@ func (n *dagService) AddRecursive(nd *Node) error {
 
 // Get retrieves a node from the dagService, fetching the block in the BlockService
 func (n *dagService) Get(ctx context.Context, k key.Key) (*Node, error) {
+	return n.get(ctx, k, false)
+}
+
+func (n *dagService) GetRaw(ctx context.Context, k key.Key) (*Node, error) {
+	return n.get(ctx, k, true)
+}
+
+func (n *dagService) get(ctx context.Context, k key.Key, raw bool) (*Node, error) {
 	if n == nil {
 		return nil, fmt.Errorf("dagService is nil")
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	b, err := n.Blocks.GetBlock(ctx, k)
+	b, err := n.fetch.GetBlock(ctx, k)
 	if err != nil {
 		if err == bserv.ErrNotFound {
 			return nil, ErrNotFound
@@ -104,7 +150,30 @@ func (n *dagService) Get(ctx context.Context, k key.Key) (*Node, error) {
 		return nil, err
 	}
 
-	return Decoded(b.Data)
+	return decodeBlock(b, raw)
+}
+
+// decodeBlock returns the node stored in b, or the raw node holding its
+// data if b is a raw block. Which one it is comes from the link to b.
+func decodeBlock(b *blocks.Block, raw bool) (*Node, error) {
+	var nd *Node
+	if raw {
+		nd = NewRawNode(b.Data)
+	} else {
+		var err error
+		nd, err = Decoded(b.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// hash the node again the way it was
+	dec, err := mh.Decode(b.Multihash)
+	if err != nil {
+		return nil, err
+	}
+	nd.SetHashFunc(dec.Code)
+	return nd, nil
 }
 
 // Remove deletes the given node and all of its children from the BlockService
@@ -174,17 +243,27 @@ func FindLinks(links []key.Key, k key.Key, start int) []int {
 // It returns a channel of nodes, which the caller can receive
 // all the child nodes of 'root' on, in proper order.
 func (ds *dagService) GetDAG(ctx context.Context, root *Node) []NodeGetter {
-	var keys []key.Key
-	for _, lnk := range root.Links {
-		keys = append(keys, key.Key(lnk.Hash))
-	}
+	return ds.GetLinks(ctx, root.Links)
+}
 
-	return ds.GetNodes(ctx, keys)
+func (ds *dagService) GetLinks(ctx context.Context, links []*Link) []NodeGetter {
+	keys := make([]key.Key, len(links))
+	raw := make([]bool, len(links))
+	for i, lnk := range links {
+		keys[i] = key.Key(lnk.Hash)
+		raw[i] = lnk.Raw
+	}
+	return ds.getNodes(ctx, keys, raw)
 }
 
 // GetNodes returns an array of 'NodeGetter' promises, with each corresponding
 // to the key with the same index as the passed in keys
 func (ds *dagService) GetNodes(ctx context.Context, keys []key.Key) []NodeGetter {
+	return ds.getNodes(ctx, keys, make([]bool, len(keys)))
+}
+
+// getNodes is GetNodes, getting the keys i with raw[i] set as raw nodes.
+func (ds *dagService) getNodes(ctx context.Context, keys []key.Key, raw []bool) []NodeGetter {
 
 	// Early out if no work to do
 	if len(keys) == 0 {
@@ -202,7 +281,7 @@ func (ds *dagService) GetNodes(ctx context.Context, keys []key.Key) []NodeGetter
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		blkchan := ds.Blocks.GetBlocks(ctx, dedupedKeys)
+		blkchan := ds.fetch.GetBlocks(ctx, dedupedKeys)
 
 		for count := 0; count < len(keys); {
 			select {
@@ -211,14 +290,14 @@ func (ds *dagService) GetNodes(ctx context.Context, keys []key.Key) []NodeGetter
 					return
 				}
 
-				nd, err := Decoded(blk.Data)
-				if err != nil {
-					// NB: can happen with improperly formatted input data
-					log.Debug("Got back bad block!")
-					return
-				}
 				is := FindLinks(keys, blk.Key(), 0)
 				for _, i := range is {
+					nd, err := decodeBlock(blk, raw[i])
+					if err != nil {
+						// NB: can happen with improperly formatted input data
+						log.Debug("Got back bad block!")
+						return
+					}
 					count++
 					sendChans[i] <- nd
 				}
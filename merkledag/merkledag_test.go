@@ -11,6 +11,7 @@ import (
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
@@ -286,3 +287,143 @@ func TestCantGet(t *testing.T) {
 		t.Fatal("expected err not found, got: ", err)
 	}
 }
+
+func TestHashFunc(t *testing.T) {
+	dsp := getDagservAndPinner(t)
+	child := &Node{Data: []byte("child")}
+	child.SetHashFunc(mh.SHA3)
+	if _, err := dsp.ds.Add(child); err != nil {
+		t.Fatal(err)
+	}
+	root := &Node{Data: []byte("root")}
+	root.SetHashFunc(mh.SHA2_512)
+	if err := root.AddNodeLinkClean("child", child); err != nil {
+		t.Fatal(err)
+	}
+	k, err := dsp.ds.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := mh.Decode(mh.Multihash(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Code != mh.SHA2_512 {
+		t.Fatalf("root hashed with %s", dec.Name)
+	}
+
+	// fetched nodes keep their hash function
+	out, err := dsp.ds.Get(context.Background(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outk, err := out.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outk != k {
+		t.Fatal("fetched root has a different key")
+	}
+	c, err := out.Links[0].GetNode(context.Background(), dsp.ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HashFunc() != mh.SHA3 {
+		t.Fatal("fetched child lost its hash function")
+	}
+}
+
+func TestRawLinks(t *testing.T) {
+	dsp := getDagservAndPinner(t)
+
+	// raw data that happens to decode as a node with a link
+	inner := &Node{Data: []byte("inner")}
+	if err := inner.AddNodeLinkClean("x", &Node{Data: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := inner.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := NewRawNode(data)
+	if _, err := dsp.ds.Add(raw); err != nil {
+		t.Fatal(err)
+	}
+	root := &Node{Data: []byte("root")}
+	if err := root.AddNodeLinkClean("raw", raw); err != nil {
+		t.Fatal(err)
+	}
+	k, err := dsp.ds.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := dsp.ds.Get(context.Background(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Links[0].Raw {
+		t.Fatal("the link lost its raw marker")
+	}
+	c, err := out.Links[0].GetNode(context.Background(), dsp.ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsRaw() || len(c.Links) != 0 || !bytes.Equal(c.Data, data) {
+		t.Fatal("the raw block was not returned as a raw node")
+	}
+	c, err = dsp.ds.GetDAG(context.Background(), out)[0].Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsRaw() {
+		t.Fatal("GetDAG did not return the raw block as a raw node")
+	}
+}
+
+func TestDecodeInvalidLength(t *testing.T) {
+	// a Data field claiming a length that overflows
+	data := []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+	if _, err := Decoded(data); err == nil {
+		t.Fatal("decoded a node with an invalid length")
+	}
+}
+
+func TestSessionFetch(t *testing.T) {
+	var dagservs []DAGService
+	for _, bsi := range bstest.Mocks(3) {
+		dagservs = append(dagservs, NewDAGService(bsi))
+	}
+
+	spl := chunk.NewSizeSplitter(io.LimitReader(u.NewTimeSeededRand(), 1024*16), 512)
+	root, err := imp.BuildDagFromReader(dagservs[0], spl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ses := NewSession(ctx, dagservs[1])
+
+	nd, err := ses.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ng := range ses.GetDAG(ctx, nd) {
+		if _, err := ng.Get(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fetched nodes are in the repo of the session's DAGService
+	for _, l := range nd.Links {
+		if _, err := dagservs[1].Get(ctx, key.Key(l.Hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -19,6 +19,43 @@ type Node struct {
 	encoded []byte
 
 	cached mh.Multihash
+
+	// raw nodes are stored as their Data, without any framing
+	raw bool
+
+	// multihash function code, zero for the default sha2-256
+	hashFunc int
+}
+
+// NewRawNode returns a raw node holding data. It has no links, and is
+// stored as data itself instead of as an encoded node.
+func NewRawNode(data []byte) *Node {
+	return &Node{Data: data, raw: true}
+}
+
+// SetHashFunc sets the multihash function n is hashed with. Zero selects
+// the default, sha2-256.
+func (n *Node) SetHashFunc(code int) {
+	if code == mh.SHA2_256 {
+		code = 0
+	}
+	if code != n.hashFunc {
+		n.hashFunc = code
+		n.encoded = nil
+	}
+}
+
+// HashFunc returns the multihash function code n is hashed with.
+func (n *Node) HashFunc() int {
+	if n.hashFunc == 0 {
+		return mh.SHA2_256
+	}
+	return n.hashFunc
+}
+
+// IsRaw returns whether n is a raw node.
+func (n *Node) IsRaw() bool {
+	return n.raw
 }
 
 // NodeStat is a statistics object for a Node. Mostly sizes.
@@ -47,6 +84,9 @@ type Link struct {
 	// multihash of the target object
 	Hash mh.Multihash
 
+	// whether the target is a raw block, its data without any encoding
+	Raw bool
+
 	// a ptr to the actual node for graph manipulation
 	Node *Node
 }
@@ -71,6 +111,7 @@ func MakeLink(n *Node) (*Link, error) {
 	return &Link{
 		Size: s,
 		Hash: h,
+		Raw:  n.raw,
 	}, nil
 }
 
@@ -80,6 +121,9 @@ func (l *Link) GetNode(ctx context.Context, serv DAGService) (*Node, error) {
 		return l.Node, nil
 	}
 
+	if l.Raw {
+		return serv.GetRaw(ctx, key.Key(l.Hash))
+	}
 	return serv.Get(ctx, key.Key(l.Hash))
 }
 
@@ -120,6 +164,7 @@ func (n *Node) AddRawLink(name string, l *Link) error {
 		Name: name,
 		Size: l.Size,
 		Hash: l.Hash,
+		Raw:  l.Raw,
 		Node: l.Node,
 	})
 
@@ -156,6 +201,7 @@ func (n *Node) GetNodeLink(name string) (*Link, error) {
 				Name: l.Name,
 				Size: l.Size,
 				Hash: l.Hash,
+				Raw:  l.Raw,
 				Node: l.Node,
 			}, nil
 		}
@@ -181,6 +227,8 @@ func (n *Node) Copy() *Node {
 
 	nnode.Links = make([]*Link, len(n.Links))
 	copy(nnode.Links, n.Links)
+	nnode.raw = n.raw
+	nnode.hashFunc = n.hashFunc
 	return nnode
 }
 
@@ -42,8 +42,10 @@ type PBLink struct {
 	// utf string name. should be unique per object
 	Name *string `protobuf:"bytes,2,opt" json:"Name,omitempty"`
 	// cumulative size of target object
-	Tsize            *uint64 `protobuf:"varint,3,opt" json:"Tsize,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	Tsize *uint64 `protobuf:"varint,3,opt" json:"Tsize,omitempty"`
+	// set if the target is a raw block, its data without any encoding
+	Raw              *bool  `protobuf:"varint,4,opt" json:"Raw,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
 }
 
 func (m *PBLink) Reset()      { *m = PBLink{} }
@@ -70,6 +72,13 @@ func (m *PBLink) GetTsize() uint64 {
 	return 0
 }
 
+func (m *PBLink) GetRaw() bool {
+	if m != nil && m.Raw != nil {
+		return *m.Raw
+	}
+	return false
+}
+
 // An IPFS MerkleDAG Node
 type PBNode struct {
 	// refs to other objects
@@ -98,6 +107,9 @@ func (m *PBNode) GetData() []byte {
 
 func init() {
 }
+
+var ErrInvalidLengthMerkledag = fmt.Errorf("proto: negative length found during unmarshaling")
+
 func (m *PBLink) Unmarshal(data []byte) error {
 	l := len(data)
 	index := 0
@@ -133,6 +145,9 @@ func (m *PBLink) Unmarshal(data []byte) error {
 					break
 				}
 			}
+			if byteLen < 0 {
+				return ErrInvalidLengthMerkledag
+			}
 			postIndex := index + byteLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
@@ -155,7 +170,11 @@ func (m *PBLink) Unmarshal(data []byte) error {
 					break
 				}
 			}
-			postIndex := index + int(stringLen)
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMerkledag
+			}
+			postIndex := index + intStringLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -179,6 +198,24 @@ func (m *PBLink) Unmarshal(data []byte) error {
 				}
 			}
 			m.Tsize = &v
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Raw", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.Raw = &b
 		default:
 			var sizeOfWire int
 			for {
@@ -193,6 +230,9 @@ func (m *PBLink) Unmarshal(data []byte) error {
 			if err != nil {
 				return err
 			}
+			if skippy < 0 {
+				return ErrInvalidLengthMerkledag
+			}
 			if (index + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -237,12 +277,17 @@ func (m *PBNode) Unmarshal(data []byte) error {
 					break
 				}
 			}
+			if msglen < 0 {
+				return ErrInvalidLengthMerkledag
+			}
 			postIndex := index + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
 			m.Links = append(m.Links, &PBLink{})
-			m.Links[len(m.Links)-1].Unmarshal(data[index:postIndex])
+			if err := m.Links[len(m.Links)-1].Unmarshal(data[index:postIndex]); err != nil {
+				return err
+			}
 			index = postIndex
 		case 1:
 			if wireType != 2 {
@@ -260,6 +305,9 @@ func (m *PBNode) Unmarshal(data []byte) error {
 					break
 				}
 			}
+			if byteLen < 0 {
+				return ErrInvalidLengthMerkledag
+			}
 			postIndex := index + byteLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
@@ -280,6 +328,9 @@ func (m *PBNode) Unmarshal(data []byte) error {
 			if err != nil {
 				return err
 			}
+			if skippy < 0 {
+				return ErrInvalidLengthMerkledag
+			}
 			if (index + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -297,6 +348,7 @@ func (this *PBLink) String() string {
 		`Hash:` + valueToStringMerkledag(this.Hash) + `,`,
 		`Name:` + valueToStringMerkledag(this.Name) + `,`,
 		`Tsize:` + valueToStringMerkledag(this.Tsize) + `,`,
+		`Raw:` + valueToStringMerkledag(this.Raw) + `,`,
 		`XXX_unrecognized:` + fmt.Sprintf("%v", this.XXX_unrecognized) + `,`,
 		`}`,
 	}, "")
@@ -336,6 +388,9 @@ func (m *PBLink) Size() (n int) {
 	if m.Tsize != nil {
 		n += 1 + sovMerkledag(uint64(*m.Tsize))
 	}
+	if m.Raw != nil {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -391,8 +446,12 @@ func NewPopulatedPBLink(r randyMerkledag, easy bool) *PBLink {
 		v3 := uint64(r.Uint32())
 		this.Tsize = &v3
 	}
+	if r.Intn(10) != 0 {
+		v8 := bool(r.Intn(2) == 0)
+		this.Raw = &v8
+	}
 	if !easy && r.Intn(10) != 0 {
-		this.XXX_unrecognized = randUnrecognizedMerkledag(r, 4)
+		this.XXX_unrecognized = randUnrecognizedMerkledag(r, 5)
 	}
 	return this
 }
@@ -517,6 +576,16 @@ func (m *PBLink) MarshalTo(data []byte) (n int, err error) {
 		i++
 		i = encodeVarintMerkledag(data, i, uint64(*m.Tsize))
 	}
+	if m.Raw != nil {
+		data[i] = 0x20
+		i++
+		if *m.Raw {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -597,6 +666,7 @@ func (this *PBLink) GoString() string {
 		`Hash:` + valueToGoStringMerkledag(this.Hash, "byte"),
 		`Name:` + valueToGoStringMerkledag(this.Name, "string"),
 		`Tsize:` + valueToGoStringMerkledag(this.Tsize, "uint64"),
+		`Raw:` + valueToGoStringMerkledag(this.Raw, "bool"),
 		`XXX_unrecognized:` + fmt.Sprintf("%#v", this.XXX_unrecognized) + `}`}, ", ")
 	return s
 }
@@ -676,6 +746,15 @@ func (this *PBLink) VerboseEqual(that interface{}) error {
 	} else if that1.Tsize != nil {
 		return fmt.Errorf("Tsize this(%v) Not Equal that(%v)", this.Tsize, that1.Tsize)
 	}
+	if this.Raw != nil && that1.Raw != nil {
+		if *this.Raw != *that1.Raw {
+			return fmt.Errorf("Raw this(%v) Not Equal that(%v)", *this.Raw, *that1.Raw)
+		}
+	} else if this.Raw != nil {
+		return fmt.Errorf("this.Raw == nil && that.Raw != nil")
+	} else if that1.Raw != nil {
+		return fmt.Errorf("Raw this(%v) Not Equal that(%v)", this.Raw, that1.Raw)
+	}
 	if !bytes.Equal(this.XXX_unrecognized, that1.XXX_unrecognized) {
 		return fmt.Errorf("XXX_unrecognized this(%v) Not Equal that(%v)", this.XXX_unrecognized, that1.XXX_unrecognized)
 	}
@@ -722,6 +801,15 @@ func (this *PBLink) Equal(that interface{}) bool {
 	} else if that1.Tsize != nil {
 		return false
 	}
+	if this.Raw != nil && that1.Raw != nil {
+		if *this.Raw != *that1.Raw {
+			return false
+		}
+	} else if this.Raw != nil {
+		return false
+	} else if that1.Raw != nil {
+		return false
+	}
 	if !bytes.Equal(this.XXX_unrecognized, that1.XXX_unrecognized) {
 		return false
 	}
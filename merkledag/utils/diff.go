@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"path"
+	"sort"
+	"strings"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	key "github.com/ipfs/go-ipfs/blocks/key"
@@ -36,9 +38,24 @@ func (c *Change) String() string {
 	}
 }
 
+// ApplyChange applies the changes cs to nd, and returns the new root. The
+// nodes the changes add have to be available from ds.
 func ApplyChange(ctx context.Context, ds dag.DAGService, nd *dag.Node, cs []*Change) (*dag.Node, error) {
 	e := NewDagEditor(ds, nd)
 	for _, c := range cs {
+		if c.Path == "" {
+			// the root itself was replaced
+			if c.Type != Mod {
+				return nil, fmt.Errorf("cannot apply %s to the root", c)
+			}
+			root, err := ds.Get(ctx, c.After)
+			if err != nil {
+				return nil, err
+			}
+			e = NewDagEditor(ds, root)
+			continue
+		}
+
 		switch c.Type {
 		case Add:
 			child, err := ds.Get(ctx, c.After)
@@ -74,17 +91,39 @@ func ApplyChange(ctx context.Context, ds dag.DAGService, nd *dag.Node, cs []*Cha
 	return e.GetNode(), nil
 }
 
-func Diff(ctx context.Context, ds dag.DAGService, a, b *dag.Node) []*Change {
-	if len(a.Links) == 0 && len(b.Links) == 0 {
-		ak, _ := a.Key()
-		bk, _ := b.Key()
+// Diff returns the changes that turn a into b. It descends into the nodes
+// linked to under the same name from both, unless their data differs: a
+// node whose data changed is reported as modified as a whole.
+func Diff(ctx context.Context, ds dag.DAGService, a, b *dag.Node) ([]*Change, error) {
+	return diff(ctx, ds, a, b, true)
+}
+
+// DiffLinks is like Diff, but only compares the links of a and b, without
+// descending into the nodes they link to.
+func DiffLinks(ctx context.Context, ds dag.DAGService, a, b *dag.Node) ([]*Change, error) {
+	return diff(ctx, ds, a, b, false)
+}
+
+func diff(ctx context.Context, ds dag.DAGService, a, b *dag.Node, recursive bool) ([]*Change, error) {
+	if len(a.Links) == 0 || len(b.Links) == 0 || !bytes.Equal(a.Data, b.Data) {
+		ak, err := a.Key()
+		if err != nil {
+			return nil, err
+		}
+		bk, err := b.Key()
+		if err != nil {
+			return nil, err
+		}
+		if ak == bk {
+			return nil, nil
+		}
 		return []*Change{
 			&Change{
 				Type:   Mod,
 				Before: ak,
 				After:  bk,
 			},
-		}
+		}, nil
 	}
 
 	var out []*Change
@@ -97,10 +136,26 @@ func Diff(ctx context.Context, ds dag.DAGService, a, b *dag.Node) []*Change {
 		if err == nil {
 			if bytes.Equal(l.Hash, lnk.Hash) {
 				// no change... ignore it
+			} else if !recursive {
+				out = append(out, &Change{
+					Type:   Mod,
+					Path:   lnk.Name,
+					Before: key.Key(lnk.Hash),
+					After:  key.Key(l.Hash),
+				})
 			} else {
-				anode, _ := lnk.GetNode(ctx, ds)
-				bnode, _ := l.GetNode(ctx, ds)
-				sub := Diff(ctx, ds, anode, bnode)
+				anode, err := lnk.GetNode(ctx, ds)
+				if err != nil {
+					return nil, err
+				}
+				bnode, err := l.GetNode(ctx, ds)
+				if err != nil {
+					return nil, err
+				}
+				sub, err := diff(ctx, ds, anode, bnode, recursive)
+				if err != nil {
+					return nil, err
+				}
 
 				for _, subc := range sub {
 					subc.Path = path.Join(lnk.Name, subc.Path)
@@ -127,34 +182,109 @@ func Diff(ctx context.Context, ds dag.DAGService, a, b *dag.Node) []*Change {
 		})
 	}
 
-	return out
+	return out, nil
 }
 
+// Conflict is a pair of changes from two diffs that can't both be applied:
+// they change the same path differently, or one changes a path below the
+// one the other changes.
 type Conflict struct {
 	A *Change
 	B *Change
 }
 
+// MergeDiffs merges the diffs a and b, taken from the same base. It
+// returns the changes to apply to the base, ordered by path, and the
+// conflicts between a and b. The changes in conflict are left out.
 func MergeDiffs(a, b []*Change) ([]*Change, []Conflict) {
+	bi := newPathIndex(b)
+
 	var out []*Change
 	var conflicts []Conflict
-	paths := make(map[string]*Change)
-	for _, c := range a {
-		paths[c.Path] = c
-	}
-
-	for _, c := range b {
-		if ca, ok := paths[c.Path]; ok {
+	inConflict := make(map[*Change]bool)
+	for _, ca := range a {
+		for _, i := range bi.overlapping(ca.Path) {
+			cb := b[i]
+			if ca.Path == cb.Path && ca.Type == cb.Type && ca.After == cb.After {
+				// both made the same change, apply it once
+				inConflict[cb] = true
+				continue
+			}
 			conflicts = append(conflicts, Conflict{
 				A: ca,
-				B: c,
+				B: cb,
 			})
-		} else {
-			out = append(out, c)
+			inConflict[ca] = true
+			inConflict[cb] = true
 		}
 	}
-	for _, c := range paths {
-		out = append(out, c)
+
+	for _, cs := range [][]*Change{a, b} {
+		for _, c := range cs {
+			if !inConflict[c] {
+				out = append(out, c)
+			}
+		}
 	}
+	sort.Stable(changesByPath(out))
 	return out, conflicts
 }
+
+// pathIndex indexes changes by path, to find the ones overlapping a path
+// without going through all of them.
+type pathIndex struct {
+	byPath map[string][]int // the indexes of the changes at each path
+	paths  []string         // the paths, sorted
+}
+
+func newPathIndex(cs []*Change) *pathIndex {
+	pi := &pathIndex{byPath: make(map[string][]int)}
+	for i, c := range cs {
+		if _, ok := pi.byPath[c.Path]; !ok {
+			pi.paths = append(pi.paths, c.Path)
+		}
+		pi.byPath[c.Path] = append(pi.byPath[c.Path], i)
+	}
+	sort.Strings(pi.paths)
+	return pi
+}
+
+// overlapping returns, in order, the indexes of the changes at p, above p
+// or below p.
+func (pi *pathIndex) overlapping(p string) []int {
+	var out []int
+	for q := p; ; {
+		out = append(out, pi.byPath[q]...)
+		if q == "" {
+			break
+		}
+		if i := strings.LastIndex(q, "/"); i >= 0 {
+			q = q[:i]
+		} else {
+			q = ""
+		}
+	}
+
+	// the paths below p follow it in order, sharing its prefix
+	prefix := p + "/"
+	if p == "" {
+		prefix = ""
+	}
+	for i := sort.SearchStrings(pi.paths, prefix); i < len(pi.paths); i++ {
+		q := pi.paths[i]
+		if !strings.HasPrefix(q, prefix) {
+			break
+		}
+		if q != p {
+			out = append(out, pi.byPath[q]...)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+type changesByPath []*Change
+
+func (cs changesByPath) Len() int           { return len(cs) }
+func (cs changesByPath) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+func (cs changesByPath) Less(i, j int) bool { return cs[i].Path < cs[j].Path }
@@ -0,0 +1,219 @@
+package dagutils
+
+import (
+	"fmt"
+	"testing"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+)
+
+func buildTree(t *testing.T, ds dag.DAGService, files map[string]string) *dag.Node {
+	e := NewDagEditor(ds, new(dag.Node))
+	for p, data := range files {
+		err := e.InsertNodeAtPath(context.Background(), p, &dag.Node{Data: []byte(data)}, func() *dag.Node { return new(dag.Node) })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	nd := e.GetNode()
+	if _, err := ds.Add(nd); err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func TestDiff(t *testing.T) {
+	ds := mdtest.Mock()
+	a := buildTree(t, ds, map[string]string{
+		"a":   "apple",
+		"b/c": "cherry",
+		"b/d": "date",
+		"e":   "elderberry",
+	})
+	b := buildTree(t, ds, map[string]string{
+		"a":   "apricot",
+		"b/c": "cherry",
+		"b/f": "fig",
+		"g":   "grape",
+	})
+
+	changes, err := Diff(context.Background(), ds, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]int{
+		"a":   Mod,
+		"b/d": Remove,
+		"b/f": Add,
+		"e":   Remove,
+		"g":   Add,
+	}
+	if len(changes) != len(exp) {
+		t.Fatalf("expected %d changes, got %d: %v", len(exp), len(changes), changes)
+	}
+	for _, c := range changes {
+		typ, ok := exp[c.Path]
+		if !ok || typ != c.Type {
+			t.Fatalf("unexpected change %s", c)
+		}
+	}
+
+	// only the top level links
+	changes, err = DiffLinks(context.Background(), ds, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string]int{
+		"a": Mod,
+		"b": Mod,
+		"e": Remove,
+		"g": Add,
+	}
+	if len(changes) != len(exp) {
+		t.Fatalf("expected %d changes, got %d: %v", len(exp), len(changes), changes)
+	}
+	for _, c := range changes {
+		typ, ok := exp[c.Path]
+		if !ok || typ != c.Type {
+			t.Fatalf("unexpected change %s", c)
+		}
+	}
+
+	changes, err = Diff(context.Background(), ds, a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+}
+
+func TestMergeDiffs(t *testing.T) {
+	ds := mdtest.Mock()
+	base := buildTree(t, ds, map[string]string{
+		"a":   "apple",
+		"b/c": "cherry",
+		"e":   "elderberry",
+	})
+	a := buildTree(t, ds, map[string]string{
+		"a":   "apricot",
+		"b/c": "cherry",
+		"e":   "elderberry",
+		"g":   "grape",
+	})
+	b := buildTree(t, ds, map[string]string{
+		"a":   "apricot",
+		"b/c": "cranberry",
+		"b/d": "date",
+	})
+
+	da, err := Diff(context.Background(), ds, base, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Diff(context.Background(), ds, base, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, conflicts := MergeDiffs(da, db)
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", conflicts)
+	}
+
+	merged, err := ApplyChange(context.Background(), ds, base.Copy(), changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := buildTree(t, ds, map[string]string{
+		"a":   "apricot",
+		"b/c": "cranberry",
+		"b/d": "date",
+		"g":   "grape",
+	})
+	mk, err := merged.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := exp.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mk != ek {
+		t.Fatal("merged tree is not the expected one")
+	}
+}
+
+func TestMergeDiffsConflicts(t *testing.T) {
+	ds := mdtest.Mock()
+	base := buildTree(t, ds, map[string]string{
+		"a":   "apple",
+		"b/c": "cherry",
+		"e":   "elderberry",
+	})
+	a := buildTree(t, ds, map[string]string{
+		"a":   "apricot",
+		"b/c": "cranberry",
+		"e":   "elderberry",
+	})
+	b := buildTree(t, ds, map[string]string{
+		"a": "avocado",
+		"e": "elderberry",
+		"g": "grape",
+	})
+
+	da, err := Diff(context.Background(), ds, base, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Diff(context.Background(), ds, base, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, conflicts := MergeDiffs(da, db)
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %v", conflicts)
+	}
+	paths := map[string]string{}
+	for _, c := range conflicts {
+		paths[c.A.Path] = c.B.Path
+	}
+	if paths["a"] != "a" || paths["b/c"] != "b" {
+		t.Fatalf("wrong conflicts: %v", conflicts)
+	}
+
+	// the change that doesn't conflict is kept
+	if len(changes) != 1 || changes[0].Path != "g" || changes[0].Type != Add {
+		t.Fatalf("wrong changes: %v", changes)
+	}
+}
+
+func TestPathIndexOverlapping(t *testing.T) {
+	cs := []*Change{
+		{Path: "a/b"},
+		{Path: "a"},
+		{Path: "ab"},
+		{Path: "a/b/c"},
+		{Path: "c"},
+		{Path: "a/b"},
+	}
+	pi := newPathIndex(cs)
+	for p, exp := range map[string][]int{
+		"a/b":   {0, 1, 3, 5},
+		"a":     {0, 1, 3, 5},
+		"ab":    {2},
+		"a/b/c": {0, 1, 3, 5},
+		"a/d":   {1},
+		"d":     nil,
+		"":      {0, 1, 2, 3, 4, 5},
+	} {
+		got := pi.overlapping(p)
+		if fmt.Sprint(got) != fmt.Sprint(exp) {
+			t.Fatalf("%q: expected %v, got %v", p, exp, got)
+		}
+	}
+}
@@ -4,14 +4,22 @@ import (
 	"errors"
 	"strings"
 
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 )
 
 type Editor struct {
 	root *dag.Node
 	ds   dag.DAGService
+
+	// src holds the nodes that are not in ds, nil if ds has them all
+	src dag.DAGService
 }
 
 func NewDagEditor(ds dag.DAGService, root *dag.Node) *Editor {
@@ -21,6 +29,25 @@ func NewDagEditor(ds dag.DAGService, root *dag.Node) *Editor {
 	}
 }
 
+// NewMemoryDagEditor returns an Editor keeping the nodes it makes in memory,
+// and reading the ones it did not make from src. Nothing is written to src
+// until WriteOutputTo is called with it, so an edit that fails half way
+// leaves src untouched.
+func NewMemoryDagEditor(src dag.DAGService, root *dag.Node) *Editor {
+	return &Editor{
+		root: root,
+		ds:   NewMemoryDagService(),
+		src:  src,
+	}
+}
+
+// NewMemoryDagService returns a DAGService storing its nodes in memory.
+func NewMemoryDagService() dag.DAGService {
+	bs := bstore.NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	return dag.NewDAGService(bsrv)
+}
+
 func (e *Editor) GetNode() *dag.Node {
 	return e.root.Copy()
 }
@@ -29,6 +56,26 @@ func (e *Editor) GetDagService() dag.DAGService {
 	return e.ds
 }
 
+// SetRootData replaces the data of the root node.
+func (e *Editor) SetRootData(data []byte) {
+	e.root.Data = data
+}
+
+// getLinkedNode gets the child of nd named name from the editor's nodes,
+// falling back on src.
+func (e *Editor) getLinkedNode(ctx context.Context, nd *dag.Node, name string) (*dag.Node, error) {
+	lnk, err := nd.GetNodeLink(name)
+	if err != nil {
+		return nil, err
+	}
+
+	child, err := lnk.GetNode(ctx, e.ds)
+	if err == dag.ErrNotFound && e.src != nil {
+		return lnk.GetNode(ctx, e.src)
+	}
+	return child, err
+}
+
 func addLink(ctx context.Context, ds dag.DAGService, root *dag.Node, childname string, childnd *dag.Node) (*dag.Node, error) {
 	if childname == "" {
 		return nil, errors.New("cannot create link with no name!")
@@ -55,7 +102,7 @@ func addLink(ctx context.Context, ds dag.DAGService, root *dag.Node, childname s
 
 func (e *Editor) InsertNodeAtPath(ctx context.Context, path string, toinsert *dag.Node, create func() *dag.Node) error {
 	splpath := strings.Split(path, "/")
-	nd, err := insertNodeAtPath(ctx, e.ds, e.root, splpath, toinsert, create)
+	nd, err := e.insertNodeAtPath(ctx, e.root, splpath, toinsert, create)
 	if err != nil {
 		return err
 	}
@@ -63,12 +110,12 @@ func (e *Editor) InsertNodeAtPath(ctx context.Context, path string, toinsert *da
 	return nil
 }
 
-func insertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, path []string, toinsert *dag.Node, create func() *dag.Node) (*dag.Node, error) {
+func (e *Editor) insertNodeAtPath(ctx context.Context, root *dag.Node, path []string, toinsert *dag.Node, create func() *dag.Node) (*dag.Node, error) {
 	if len(path) == 1 {
-		return addLink(ctx, ds, root, path[0], toinsert)
+		return addLink(ctx, e.ds, root, path[0], toinsert)
 	}
 
-	nd, err := root.GetLinkedNode(ctx, ds, path[0])
+	nd, err := e.getLinkedNode(ctx, root, path[0])
 	if err != nil {
 		// if 'create' is true, we create directories on the way down as needed
 		if err == dag.ErrNotFound && create != nil {
@@ -78,7 +125,7 @@ func insertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, pa
 		}
 	}
 
-	ndprime, err := insertNodeAtPath(ctx, ds, nd, path[1:], toinsert, create)
+	ndprime, err := e.insertNodeAtPath(ctx, nd, path[1:], toinsert, create)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +136,7 @@ func insertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, pa
 		return nil, err
 	}
 
-	_, err = ds.Add(root)
+	_, err = e.ds.Add(root)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +146,7 @@ func insertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, pa
 
 func (e *Editor) RmLink(ctx context.Context, path string) error {
 	splpath := strings.Split(path, "/")
-	nd, err := rmLink(ctx, e.ds, e.root, splpath)
+	nd, err := e.rmLink(ctx, e.root, splpath)
 	if err != nil {
 		return err
 	}
@@ -107,7 +154,7 @@ func (e *Editor) RmLink(ctx context.Context, path string) error {
 	return nil
 }
 
-func rmLink(ctx context.Context, ds dag.DAGService, root *dag.Node, path []string) (*dag.Node, error) {
+func (e *Editor) rmLink(ctx context.Context, root *dag.Node, path []string) (*dag.Node, error) {
 	if len(path) == 1 {
 		// base case, remove node in question
 		err := root.RemoveNodeLink(path[0])
@@ -115,7 +162,7 @@ func rmLink(ctx context.Context, ds dag.DAGService, root *dag.Node, path []strin
 			return nil, err
 		}
 
-		_, err = ds.Add(root)
+		_, err = e.ds.Add(root)
 		if err != nil {
 			return nil, err
 		}
@@ -123,12 +170,12 @@ func rmLink(ctx context.Context, ds dag.DAGService, root *dag.Node, path []strin
 		return root, nil
 	}
 
-	nd, err := root.GetLinkedNode(ctx, ds, path[0])
+	nd, err := e.getLinkedNode(ctx, root, path[0])
 	if err != nil {
 		return nil, err
 	}
 
-	nnode, err := rmLink(ctx, ds, nd, path[1:])
+	nnode, err := e.rmLink(ctx, nd, path[1:])
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +186,7 @@ func rmLink(ctx context.Context, ds dag.DAGService, root *dag.Node, path []strin
 		return nil, err
 	}
 
-	_, err = ds.Add(root)
+	_, err = e.ds.Add(root)
 	if err != nil {
 		return nil, err
 	}
@@ -122,3 +122,96 @@ func testInsert(t *testing.T, e *Editor, path, data string, create bool, experr
 
 	assertNodeAtPath(t, e.ds, e.root, path, ck)
 }
+
+func TestMemoryEditor(t *testing.T) {
+	src := mdtest.Mock()
+	ctx := context.Background()
+
+	// a/b/c, built in src
+	c := &dag.Node{Data: []byte("c")}
+	b := new(dag.Node)
+	if err := b.AddNodeLink("c", c); err != nil {
+		t.Fatal(err)
+	}
+	a := new(dag.Node)
+	if err := a.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	root := new(dag.Node)
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+	rk, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck, err := c.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh copy, the editor changes the nodes it is given
+	rootcopy, err := src.Get(ctx, rk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewMemoryDagEditor(src, rootcopy)
+	d := &dag.Node{Data: []byte("d")}
+	if _, err := src.Add(d); err != nil {
+		t.Fatal(err)
+	}
+	dk, _ := d.Key()
+	create := func() *dag.Node { return new(dag.Node) }
+	if err := e.InsertNodeAtPath(ctx, "a/b/d", d, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.InsertNodeAtPath(ctx, "x/y", d, create); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RmLink(ctx, "a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RmLink(ctx, "a/nope"); err == nil {
+		t.Fatal("removing a missing link should fail")
+	}
+
+	// nothing is written to src before WriteOutputTo
+	nk, err := e.GetNode().Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Get(ctx, nk); err != dag.ErrNotFound {
+		t.Fatal("edited root was written to src early")
+	}
+
+	if err := e.WriteOutputTo(src); err != nil {
+		t.Fatal(err)
+	}
+	nroot, err := src.Get(ctx, nk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNodeAtPath(t, src, nroot, "a/b/d", dk)
+	assertNodeAtPath(t, src, nroot, "x/y", dk)
+	na, err := nroot.GetLinkedNode(ctx, src, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb, err := na.GetLinkedNode(ctx, src, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nb.GetNodeLink("c"); err == nil {
+		t.Fatal("removed link is still there")
+	}
+
+	// the original is still in src
+	oroot, err := src.Get(ctx, rk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNodeAtPath(t, src, oroot, "a/b/c", ck)
+}
@@ -4,6 +4,7 @@ package path
 import (
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
@@ -11,6 +12,9 @@ import (
 
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+	upb "github.com/ipfs/go-ipfs/unixfs/pb"
 	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 )
 
@@ -111,27 +115,20 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 	// for each of the path components
 	for _, name := range names {
 
-		var next key.Key
-		var nlink *merkledag.Link
-		// for each of the links in nd, the current object
-		for _, link := range nd.Links {
-			if link.Name == name {
-				next = key.Key(link.Hash)
-				nlink = link
-				break
-			}
+		nlink, err := s.findLink(ctx, nd, name)
+		if err != nil {
+			return result, err
 		}
-
-		if next == "" {
+		if nlink == nil {
 			n, _ := nd.Multihash()
 			return result, ErrNoLink{name: name, node: n}
 		}
+		next := key.Key(nlink.Hash)
 
 		if nlink.Node == nil {
 			// fetch object for link and assign to nd
 			ctx, cancel := context.WithTimeout(ctx, time.Minute)
 			defer cancel()
-			var err error
 			nd, err = s.DAG.Get(ctx, next)
 			if err != nil {
 				return append(result, nd), err
@@ -145,3 +142,29 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 	}
 	return result, nil
 }
+
+// findLink returns the link named name in nd, or nil. The entries of a
+// sharded directory are looked up in its shards.
+func (s *Resolver) findLink(ctx context.Context, nd *merkledag.Node, name string) (*merkledag.Link, error) {
+	if pbd, err := ft.FromBytes(nd.Data); err == nil && pbd.GetType() == upb.Data_HAMTShard {
+		shard, err := hamt.NewHamtFromDag(s.DAG, nd)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		l, err := shard.Find(ctx, name)
+		if err == os.ErrNotExist {
+			return nil, nil
+		}
+		return l, err
+	}
+
+	// for each of the links in nd, the current object
+	for _, link := range nd.Links {
+		if link.Name == name {
+			return link, nil
+		}
+	}
+	return nil, nil
+}
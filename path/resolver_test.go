@@ -10,6 +10,8 @@ import (
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
 	dagmock "github.com/ipfs/go-ipfs/merkledag/test"
 	path "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	util "github.com/ipfs/go-ipfs/util"
 )
 
@@ -71,3 +73,60 @@ func TestRecurivePathResolution(t *testing.T) {
 			p.String(), key.String(), cKey.String()))
 	}
 }
+
+func TestShardedPathResolution(t *testing.T) {
+	ctx := context.Background()
+	dagService := dagmock.Mock()
+
+	old := uio.ShardSplitThreshold
+	uio.ShardSplitThreshold = 10
+	defer func() { uio.ShardSplitThreshold = old }()
+
+	dir := uio.NewDirectory(dagService)
+	var cKey key.Key
+	for i := 0; i < 100; i++ {
+		c, k := randNode()
+		if _, err := dagService.Add(c); err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddChildNode(ctx, fmt.Sprintf("child%d", i), c); err != nil {
+			t.Fatal(err)
+		}
+		if i == 42 {
+			cKey = k
+		}
+	}
+	root, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbd, err := ft.FromBytes(root.Data); err != nil || pbd.GetType() != ft.THAMTShard {
+		t.Fatal("directory was not sharded")
+	}
+	rootKey, err := dagService.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &path.Resolver{DAG: dagService}
+	p, err := path.FromSegments("/ipfs/", rootKey.String(), "child42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := resolver.ResolvePath(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := node.Key(); k != cKey {
+		t.Fatal("resolved the wrong child")
+	}
+
+	p, err = path.FromSegments("/ipfs/", rootKey.String(), "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = resolver.ResolvePath(ctx, p)
+	if _, ok := err.(path.ErrNoLink); !ok {
+		t.Fatal("expected ErrNoLink, got", err)
+	}
+}
@@ -0,0 +1,128 @@
+package pin
+
+import (
+	"encoding/json"
+	"errors"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// Repos before version 3 stored the pin sets as JSON, along with a
+// refcount for every indirectly pinned key.
+var (
+	legacyRecursiveKey = ds.NewKey("/local/pins/recursive/keys")
+	legacyDirectKey    = ds.NewKey("/local/pins/direct/keys")
+	legacyIndirectKey  = ds.NewKey("/local/pins/indirect/keys")
+)
+
+// ConvertLegacyPins stores the JSON pin sets of d as merkledag objects in
+// dserv, and removes them. The indirect refcounts are dropped.
+func ConvertLegacyPins(d ds.ThreadSafeDatastore, dserv mdag.DAGService) error {
+	var recursive, direct []key.Key
+	if err := loadLegacySet(d, legacyRecursiveKey, &recursive); err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	if err := loadLegacySet(d, legacyDirectKey, &direct); err != nil && err != ds.ErrNotFound {
+		return err
+	}
+
+	p := NewPinner(d, dserv).(*pinner)
+	for _, k := range recursive {
+		p.recursePin.AddBlock(k)
+	}
+	for _, k := range direct {
+		p.directPin.AddBlock(k)
+	}
+	if err := p.Flush(); err != nil {
+		return err
+	}
+
+	for _, k := range []ds.Key{legacyRecursiveKey, legacyDirectKey, legacyIndirectKey} {
+		if err := d.Delete(k); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevertLegacyPins undoes ConvertLegacyPins, storing the pins as JSON
+// again. The indirect refcounts are rebuilt from the recursive pins.
+func RevertLegacyPins(d ds.ThreadSafeDatastore, dserv mdag.DAGService) error {
+	if has, err := d.Has(legacyRecursiveKey); err != nil {
+		return err
+	} else if has {
+		// the conversion did not get as far as removing them
+		if err := d.Delete(pinDatastoreKey); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+
+	pn, err := LoadPinner(d, dserv)
+	if err != nil {
+		return err
+	}
+	p := pn.(*pinner)
+
+	// the refcounts were kept per path from a recursive pin
+	refs := make(map[string]int)
+	var count func(nd *mdag.Node) error
+	count = func(nd *mdag.Node) error {
+		for _, l := range nd.Links {
+			refs[key.B58KeyEncode(key.Key(l.Hash))]++
+			child, err := l.GetNode(context.TODO(), dserv)
+			if err != nil {
+				return err
+			}
+			if err := count(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, k := range p.recursePin.GetKeys() {
+		nd, err := dserv.Get(context.TODO(), k)
+		if err != nil {
+			return err
+		}
+		if err := count(nd); err != nil {
+			return err
+		}
+	}
+
+	if err := storeLegacySet(d, legacyDirectKey, p.directPin.GetKeys()); err != nil {
+		return err
+	}
+	if err := storeLegacySet(d, legacyRecursiveKey, p.recursePin.GetKeys()); err != nil {
+		return err
+	}
+	if err := storeLegacySet(d, legacyIndirectKey, refs); err != nil {
+		return err
+	}
+	return d.Delete(pinDatastoreKey)
+}
+
+func storeLegacySet(d ds.Datastore, k ds.Key, val interface{}) error {
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	return d.Put(k, buf)
+}
+
+func loadLegacySet(d ds.Datastore, k ds.Key, val interface{}) error {
+	buf, err := d.Get(k)
+	if err != nil {
+		return err
+	}
+
+	bf, ok := buf.([]byte)
+	if !ok {
+		return errors.New("invalid pin set value in datastore")
+	}
+	return json.Unmarshal(bf, val)
+}
@@ -3,13 +3,12 @@
 package pin
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	nsds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	"github.com/ipfs/go-ipfs/blocks/set"
@@ -18,28 +17,41 @@ import (
 )
 
 var log = logging.Logger("pin")
-var recursePinDatastoreKey = ds.NewKey("/local/pins/recursive/keys")
-var directPinDatastoreKey = ds.NewKey("/local/pins/direct/keys")
-var indirectPinDatastoreKey = ds.NewKey("/local/pins/indirect/keys")
+
+// pinDatastoreKey holds the key of the object the pin sets are stored in.
+var pinDatastoreKey = ds.NewKey("/local/pins")
+
+const (
+	linkDirect    = "direct"
+	linkRecursive = "recursive"
+)
 
 type PinMode int
 
 const (
 	Recursive PinMode = iota
 	Direct
-	Indirect
 	NotPinned
 )
 
 type Pinner interface {
+	// IsPinned returns whether k is pinned directly, recursively, or
+	// indirectly through a recursive pin of one of its ancestors.
 	IsPinned(key.Key) bool
 	Pin(context.Context, *mdag.Node, bool) error
 	Unpin(context.Context, key.Key, bool) error
 	Flush() error
 	GetManual() ManualPinner
 	DirectKeys() []key.Key
-	IndirectKeys() map[key.Key]int
 	RecursiveKeys() []key.Key
+
+	// IndirectKeys walks the recursive pins, counting the links to each
+	// of their descendants.
+	IndirectKeys(context.Context) (map[key.Key]int, error)
+
+	// InternalPins returns the keys of the objects storing the pin
+	// sets, which have to be kept as well.
+	InternalPins() []key.Key
 }
 
 // ManualPinner is for manually editing the pin structure
@@ -56,28 +68,27 @@ type pinner struct {
 	lock       sync.RWMutex
 	recursePin set.BlockSet
 	directPin  set.BlockSet
-	indirPin   *indirectPin
-	dserv      mdag.DAGService
-	dstore     ds.ThreadSafeDatastore
-}
 
-// NewPinner creates a new pinner using the given datastore as a backend
-func NewPinner(dstore ds.ThreadSafeDatastore, serv mdag.DAGService) Pinner {
+	// internalPin tracks the keys of the objects storing the pin state,
+	// so gc does not delete them.
+	internalPin map[key.Key]struct{}
 
-	// Load set from given datastore...
-	rcds := nsds.Wrap(dstore, recursePinDatastoreKey)
-	rcset := set.NewDBWrapperSet(rcds, set.NewSimpleBlockSet())
+	// dirty is set when the sets changed since they were last flushed.
+	dirty bool
 
-	dirds := nsds.Wrap(dstore, directPinDatastoreKey)
-	dirset := set.NewDBWrapperSet(dirds, set.NewSimpleBlockSet())
+	dserv  mdag.DAGService
+	dstore ds.ThreadSafeDatastore
+}
 
-	nsdstore := nsds.Wrap(dstore, indirectPinDatastoreKey)
+// NewPinner creates a new pinner using the given datastore as a backend
+func NewPinner(dstore ds.ThreadSafeDatastore, serv mdag.DAGService) Pinner {
 	return &pinner{
-		recursePin: rcset,
-		directPin:  dirset,
-		indirPin:   NewIndirectPin(nsdstore),
-		dserv:      serv,
-		dstore:     dstore,
+		recursePin:  set.NewSimpleBlockSet(),
+		directPin:   set.NewSimpleBlockSet(),
+		internalPin: make(map[key.Key]struct{}),
+		dserv:       serv,
+		dstore:      dstore,
+		dirty:       true,
 	}
 }
 
@@ -99,7 +110,11 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 			p.directPin.RemoveBlock(k)
 		}
 
-		err := p.pinLinks(ctx, node)
+		// fetch the whole graph, so it is all there once pinned. A session
+		// keeps asking the peers that have it.
+		sctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		err := fetchLinks(sctx, mdag.NewSession(sctx, p.dserv), node)
 		if err != nil {
 			return err
 		}
@@ -116,6 +131,7 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 
 		p.directPin.AddBlock(k)
 	}
+	p.dirty = true
 	return nil
 }
 
@@ -124,81 +140,124 @@ func (p *pinner) Unpin(ctx context.Context, k key.Key, recursive bool) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	if p.recursePin.HasKey(k) {
-		if recursive {
-			p.recursePin.RemoveBlock(k)
-			node, err := p.dserv.Get(ctx, k)
-			if err != nil {
-				return err
-			}
-
-			return p.unpinLinks(ctx, node)
-		} else {
+		if !recursive {
 			return fmt.Errorf("%s is pinned recursively", k)
 		}
+		p.recursePin.RemoveBlock(k)
 	} else if p.directPin.HasKey(k) {
 		p.directPin.RemoveBlock(k)
-		return nil
-	} else if p.indirPin.HasKey(k) {
-		return fmt.Errorf("%s is pinned indirectly. indirect pins cannot be removed directly", k)
 	} else {
-		return fmt.Errorf("%s is not pinned", k)
+		indirect, err := p.isIndirect(ctx, k)
+		switch {
+		case err != nil:
+			return fmt.Errorf("%s is not pinned directly or recursively, and checking for indirect pins failed: %s", k, err)
+		case indirect:
+			return fmt.Errorf("%s is pinned indirectly. indirect pins cannot be removed directly", k)
+		default:
+			return fmt.Errorf("%s is not pinned", k)
+		}
 	}
+	p.dirty = true
+	return nil
 }
 
-func (p *pinner) unpinLinks(ctx context.Context, node *mdag.Node) error {
-	for _, l := range node.Links {
-		node, err := l.GetNode(ctx, p.dserv)
+func fetchLinks(ctx context.Context, dserv mdag.DAGService, node *mdag.Node) error {
+	for _, ng := range dserv.GetDAG(ctx, node) {
+		subnode, err := ng.Get(ctx)
 		if err != nil {
+			// TODO: Maybe just log and continue?
 			return err
 		}
-
-		k, err := node.Key()
+		err = fetchLinks(ctx, dserv, subnode)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		p.indirPin.Decrement(k)
+// walkRecursive calls fn with every link below the recursive pins, getting
+// the nodes from dserv. Each node is only visited once, fn returning false
+// stops the walk. With skipMissing, the nodes dserv does not have are
+// walked as if they had no links. The caller must hold the lock.
+func (p *pinner) walkRecursive(ctx context.Context, dserv mdag.DAGService, skipMissing bool, fn func(key.Key) bool) error {
+	visited := make(map[key.Key]struct{})
+	var walk func(k key.Key) (bool, error)
+	walk = func(k key.Key) (bool, error) {
+		if _, ok := visited[k]; ok {
+			return true, nil
+		}
+		visited[k] = struct{}{}
 
-		err = p.unpinLinks(ctx, node)
+		nd, err := dserv.Get(ctx, k)
+		if err == mdag.ErrNotFound && skipMissing {
+			return true, nil
+		}
 		if err != nil {
+			return false, err
+		}
+		for _, l := range nd.Links {
+			lk := key.Key(l.Hash)
+			if !fn(lk) {
+				return false, nil
+			}
+			if more, err := walk(lk); !more || err != nil {
+				return more, err
+			}
+		}
+		return true, nil
+	}
+
+	for _, k := range p.recursePin.GetKeys() {
+		if more, err := walk(k); !more || err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *pinner) pinIndirectRecurse(ctx context.Context, node *mdag.Node) error {
-	k, err := node.Key()
+// maxIndirectWalk is how many links isIndirect looks at before giving up.
+const maxIndirectWalk = 1 << 16
+
+var errIndirectWalkLimit = errors.New("too many pinned links to check for indirect pins")
+
+// isIndirect returns whether k is below a recursive pin. Only the nodes in
+// the local blockstore are walked, and at most maxIndirectWalk links. The
+// caller must hold the lock.
+func (p *pinner) isIndirect(ctx context.Context, k key.Key) (bool, error) {
+	found := false
+	seen := 0
+	err := p.walkRecursive(ctx, mdag.NewOffline(p.dserv), true, func(lk key.Key) bool {
+		found = lk == k
+		seen++
+		return !found && seen < maxIndirectWalk
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
-
-	p.indirPin.Increment(k)
-	return p.pinLinks(ctx, node)
-}
-
-func (p *pinner) pinLinks(ctx context.Context, node *mdag.Node) error {
-	for _, ng := range p.dserv.GetDAG(ctx, node) {
-		subnode, err := ng.Get(ctx)
-		if err != nil {
-			// TODO: Maybe just log and continue?
-			return err
-		}
-		err = p.pinIndirectRecurse(ctx, subnode)
-		if err != nil {
-			return err
-		}
+	if !found && seen >= maxIndirectWalk {
+		return false, errIndirectWalkLimit
 	}
-	return nil
+	return found, nil
 }
 
-// IsPinned returns whether or not the given key is pinned
+// IsPinned returns whether or not the given key is pinned. When it can't
+// tell whether the key is pinned indirectly, it is taken as pinned.
 func (p *pinner) IsPinned(key key.Key) bool {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
-	return p.recursePin.HasKey(key) ||
-		p.directPin.HasKey(key) ||
-		p.indirPin.HasKey(key)
+	if p.recursePin.HasKey(key) || p.directPin.HasKey(key) {
+		return true
+	}
+	if _, ok := p.internalPin[key]; ok {
+		return true
+	}
+	indirect, err := p.isIndirect(context.TODO(), key)
+	if err != nil {
+		log.Errorf("cannot check for indirect pins of %s: %s", key, err)
+		return true
+	}
+	return indirect
 }
 
 func (p *pinner) RemovePinWithMode(key key.Key, mode PinMode) {
@@ -207,43 +266,62 @@ func (p *pinner) RemovePinWithMode(key key.Key, mode PinMode) {
 	switch mode {
 	case Direct:
 		p.directPin.RemoveBlock(key)
-	case Indirect:
-		p.indirPin.Decrement(key)
 	case Recursive:
 		p.recursePin.RemoveBlock(key)
 	default:
 		// programmer error, panic OK
 		panic("unrecognized pin type")
 	}
+	p.dirty = true
 }
 
 // LoadPinner loads a pinner and its keysets from the given datastore
 func LoadPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error) {
 	p := new(pinner)
 
+	rootKeyI, err := d.Get(pinDatastoreKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load pin state: %v", err)
+	}
+	rootKeyBytes, ok := rootKeyI.([]byte)
+	if !ok {
+		return nil, errors.New("cannot load pin state: not stored as []byte")
+	}
+	rootKey := key.Key(rootKeyBytes)
+
+	// the pin state is local, don't wait on the network for it
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
+
+	root, err := dserv.Get(ctx, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find pinning root object: %v", err)
+	}
+
+	internalPin := map[key.Key]struct{}{
+		rootKey: struct{}{},
+	}
+	recordInternal := func(k key.Key) {
+		internalPin[k] = struct{}{}
+	}
+
 	{ // load recursive set
-		var recurseKeys []key.Key
-		if err := loadSet(d, recursePinDatastoreKey, &recurseKeys); err != nil {
-			return nil, err
+		recurseKeys, err := loadSet(ctx, dserv, root, linkRecursive, recordInternal)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load recursive pins: %v", err)
 		}
 		p.recursePin = set.SimpleSetFromKeys(recurseKeys)
 	}
 
 	{ // load direct set
-		var directKeys []key.Key
-		if err := loadSet(d, directPinDatastoreKey, &directKeys); err != nil {
-			return nil, err
+		directKeys, err := loadSet(ctx, dserv, root, linkDirect, recordInternal)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load direct pins: %v", err)
 		}
 		p.directPin = set.SimpleSetFromKeys(directKeys)
 	}
 
-	{ // load indirect set
-		var err error
-		p.indirPin, err = loadIndirPin(d, indirectPinDatastoreKey)
-		if err != nil {
-			return nil, err
-		}
-	}
+	p.internalPin = internalPin
 
 	// assign services
 	p.dserv = dserv
@@ -254,62 +332,96 @@ func LoadPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error)
 
 // DirectKeys returns a slice containing the directly pinned keys
 func (p *pinner) DirectKeys() []key.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	return p.directPin.GetKeys()
 }
 
-// IndirectKeys returns a slice containing the indirectly pinned keys
-func (p *pinner) IndirectKeys() map[key.Key]int {
-	return p.indirPin.GetRefs()
+// IndirectKeys returns a map of the indirectly pinned keys to the number
+// of links to them
+func (p *pinner) IndirectKeys(ctx context.Context) (map[key.Key]int, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	refs := make(map[key.Key]int)
+	err := p.walkRecursive(ctx, p.dserv, false, func(k key.Key) bool {
+		refs[k]++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
 }
 
 // RecursiveKeys returns a slice containing the recursively pinned keys
 func (p *pinner) RecursiveKeys() []key.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	return p.recursePin.GetKeys()
 }
 
-// Flush encodes and writes pinner keysets to the datastore
+// InternalPins returns the keys of the objects storing the pin sets
+func (p *pinner) InternalPins() []key.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var out []key.Key
+	for k := range p.internalPin {
+		out = append(out, k)
+	}
+	return out
+}
+
+// Flush stores the pin sets as merkledag objects, and records the key of
+// their root in the datastore
 func (p *pinner) Flush() error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	err := storeSet(p.dstore, directPinDatastoreKey, p.directPin.GetKeys())
-	if err != nil {
-		return err
+	if !p.dirty {
+		return nil
 	}
 
-	err = storeSet(p.dstore, recursePinDatastoreKey, p.recursePin.GetKeys())
-	if err != nil {
-		return err
-	}
+	ctx := context.TODO()
 
-	err = storeIndirPin(p.dstore, indirectPinDatastoreKey, p.indirPin)
-	if err != nil {
-		return err
+	internalPin := map[key.Key]struct{}{}
+	recordInternal := func(k key.Key) {
+		internalPin[k] = struct{}{}
 	}
-	return nil
-}
 
-// helpers to marshal / unmarshal a pin set
-func storeSet(d ds.Datastore, k ds.Key, val interface{}) error {
-	buf, err := json.Marshal(val)
-	if err != nil {
-		return err
+	root := &mdag.Node{}
+	for _, s := range []struct {
+		name string
+		keys []key.Key
+	}{
+		{linkDirect, p.directPin.GetKeys()},
+		{linkRecursive, p.recursePin.GetKeys()},
+	} {
+		n, err := storeSet(ctx, p.dserv, s.keys, recordInternal)
+		if err != nil {
+			return err
+		}
+		k, err := p.dserv.Add(n)
+		if err != nil {
+			return err
+		}
+		recordInternal(k)
+		if err := root.AddNodeLinkClean(s.name, n); err != nil {
+			return err
+		}
 	}
 
-	return d.Put(k, buf)
-}
-
-func loadSet(d ds.Datastore, k ds.Key, val interface{}) error {
-	buf, err := d.Get(k)
+	k, err := p.dserv.Add(root)
 	if err != nil {
 		return err
 	}
-
-	bf, ok := buf.([]byte)
-	if !ok {
-		return errors.New("invalid pin set value in datastore")
+	internalPin[k] = struct{}{}
+	if err := p.dstore.Put(pinDatastoreKey, []byte(k)); err != nil {
+		return fmt.Errorf("cannot store pin state: %v", err)
 	}
-	return json.Unmarshal(bf, val)
+	p.internalPin = internalPin
+	p.dirty = false
+	return nil
 }
 
 // PinWithMode is a method on ManualPinners, allowing the user to have fine
@@ -322,9 +434,8 @@ func (p *pinner) PinWithMode(k key.Key, mode PinMode) {
 		p.recursePin.AddBlock(k)
 	case Direct:
 		p.directPin.AddBlock(k)
-	case Indirect:
-		p.indirPin.Increment(k)
 	}
+	p.dirty = true
 }
 
 func (p *pinner) GetManual() ManualPinner {
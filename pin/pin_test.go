@@ -8,6 +8,7 @@ import (
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	blocks "github.com/ipfs/go-ipfs/blocks"
 	"github.com/ipfs/go-ipfs/blocks/blockstore"
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	bs "github.com/ipfs/go-ipfs/blockservice"
@@ -228,3 +229,147 @@ func TestPinRecursiveFail(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFlushInternalPins(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+
+	dserv := mdag.NewDAGService(bserv)
+
+	p := NewPinner(dstore, dserv)
+
+	a, ak := randNode()
+	if _, err := dserv.Add(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	internal := p.InternalPins()
+	if len(internal) == 0 {
+		t.Fatal("flushing should record the objects holding the pins")
+	}
+	for _, k := range internal {
+		if k == ak {
+			t.Fatal("pinned object reported as internal")
+		}
+		if has, _ := bstore.Has(k); !has {
+			t.Fatalf("internal pin %s was not stored", k)
+		}
+	}
+
+	np, err := LoadPinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(np.InternalPins()) != len(internal) {
+		t.Fatal("loading the pins should find the same internal pins")
+	}
+	if keys := np.RecursiveKeys(); len(keys) != 1 || keys[0] != ak {
+		t.Fatal("recursive pin was not loaded back")
+	}
+}
+
+// waitingExchange never finds a block, it waits for the request to be
+// cancelled like a network exchange would.
+type waitingExchange struct{}
+
+func (waitingExchange) GetBlock(ctx context.Context, k key.Key) (*blocks.Block, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (waitingExchange) GetBlocks(ctx context.Context, ks []key.Key) (<-chan *blocks.Block, error) {
+	out := make(chan *blocks.Block)
+	go func() {
+		<-ctx.Done()
+		close(out)
+	}()
+	return out, nil
+}
+
+func (waitingExchange) HasBlock(*blocks.Block) error { return nil }
+func (waitingExchange) Close() error                 { return nil }
+
+func TestIndirectCheckStaysLocal(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := mdag.NewDAGService(bs.New(bstore, waitingExchange{}))
+	p := NewPinner(dstore, dserv)
+
+	// a is pinned recursively, but its child is not in the blockstore
+	a, ak := randNode()
+	c, _ := randNode()
+	if err := a.AddNodeLinkClean("child", c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dserv.Add(a); err != nil {
+		t.Fatal(err)
+	}
+	p.GetManual().PinWithMode(ak, Recursive)
+
+	_, dk := randNode()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if p.IsPinned(dk) {
+			t.Error("unrelated key reported as pinned")
+		}
+		if err := p.Unpin(context.Background(), dk, true); err == nil {
+			t.Error("unpinning an unpinned key should fail")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("checking for indirect pins waited on the network")
+	}
+}
+
+func TestListKeysWhilePinning(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+	p := NewPinner(dstore, dserv)
+
+	done := make(chan error)
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			nd, _ := randNode()
+			if _, err := dserv.Add(nd); err != nil {
+				done <- err
+				return
+			}
+			if err := p.Pin(ctx, nd, i%2 == 0); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err, ok := <-done:
+			if ok {
+				t.Fatal(err)
+			}
+			if n := len(p.RecursiveKeys()) + len(p.DirectKeys()); n != 500 {
+				t.Fatalf("expected 500 pins, got %d", n)
+			}
+			return
+		default:
+			p.RecursiveKeys()
+			p.DirectKeys()
+		}
+	}
+}
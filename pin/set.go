@@ -0,0 +1,203 @@
+package pin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin/internal/pb"
+)
+
+// A pin set is stored as a tree of merkledag nodes. Each node starts its
+// Data with a varint-prefixed pb.Set header. A node with a zero Fanout
+// links to the keys of the set directly. Larger sets are split into
+// Fanout subtrees, the key k going to the subtree at index
+// hash(Seed, k) % Fanout.
+//
+// The tree only depends on the keys in the set, so a flush stores again
+// just the nodes whose keys changed.
+const (
+	setVersion = 1
+
+	// defaultFanout is the number of subtrees a set too large for a
+	// single node is split into.
+	defaultFanout = 256
+
+	// maxItems is the number of keys a single node links to directly.
+	maxItems = 8192
+)
+
+// keyObserver is told the key of every node storing a set.
+type keyObserver func(key.Key)
+
+func hash(seed uint32, k key.Key) uint32 {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], seed)
+	h := fnv.New32a()
+	h.Write(buf[:])
+	h.Write([]byte(k))
+	return h.Sum32()
+}
+
+// storeSet writes the tree holding keys to dag, and returns its root. The
+// root itself is not added.
+func storeSet(ctx context.Context, dag mdag.DAGService, keys []key.Key, internalKeys keyObserver) (*mdag.Node, error) {
+	return storeItems(ctx, dag, keys, 0, internalKeys)
+}
+
+func storeItems(ctx context.Context, dag mdag.DAGService, keys []key.Key, depth uint32, internalKeys keyObserver) (*mdag.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	n := new(mdag.Node)
+	if len(keys) <= maxItems {
+		sorted := make(key.KeySlice, len(keys))
+		copy(sorted, keys)
+		sort.Sort(sorted)
+
+		if err := writeHdr(n, &pb.Set{
+			Version: proto.Uint32(setVersion),
+			Fanout:  proto.Uint32(0),
+			Seed:    proto.Uint32(depth),
+		}); err != nil {
+			return nil, err
+		}
+		n.Links = make([]*mdag.Link, 0, len(sorted))
+		for _, k := range sorted {
+			n.Links = append(n.Links, &mdag.Link{Hash: mh.Multihash(k)})
+		}
+		return n, nil
+	}
+
+	// using the depth as the seed keeps the tree deterministic, and still
+	// spreads keys that collided at the level above
+	seed := depth
+	if err := writeHdr(n, &pb.Set{
+		Version: proto.Uint32(setVersion),
+		Fanout:  proto.Uint32(defaultFanout),
+		Seed:    proto.Uint32(seed),
+	}); err != nil {
+		return nil, err
+	}
+
+	buckets := make([][]key.Key, defaultFanout)
+	for _, k := range keys {
+		h := hash(seed, k) % defaultFanout
+		buckets[h] = append(buckets[h], k)
+	}
+
+	n.Links = make([]*mdag.Link, 0, defaultFanout)
+	for _, items := range buckets {
+		child, err := storeItems(ctx, dag, items, depth+1, internalKeys)
+		if err != nil {
+			return nil, err
+		}
+		size, err := child.Size()
+		if err != nil {
+			return nil, err
+		}
+		childKey, err := dag.Add(child)
+		if err != nil {
+			return nil, err
+		}
+		internalKeys(childKey)
+		n.Links = append(n.Links, &mdag.Link{
+			Hash: mh.Multihash(childKey),
+			Size: size,
+		})
+	}
+	return n, nil
+}
+
+func writeHdr(n *mdag.Node, hdr *pb.Set) error {
+	hdrData, err := proto.Marshal(hdr)
+	if err != nil {
+		return err
+	}
+	n.Data = make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(hdrData))
+	written := binary.PutUvarint(n.Data, uint64(len(hdrData)))
+	n.Data = append(n.Data[:written], hdrData...)
+	return nil
+}
+
+func readHdr(n *mdag.Node) (*pb.Set, error) {
+	hdrLen, num := binary.Uvarint(n.Data)
+	if num <= 0 {
+		return nil, errors.New("invalid Set header length")
+	}
+	buf := n.Data[num:]
+	if hdrLen > uint64(len(buf)) {
+		return nil, errors.New("impossibly large Set header length")
+	}
+
+	var hdr pb.Set
+	if err := proto.Unmarshal(buf[:hdrLen], &hdr); err != nil {
+		return nil, err
+	}
+	if v := hdr.GetVersion(); v != setVersion {
+		return nil, fmt.Errorf("unsupported Set version: %d", v)
+	}
+	if f := hdr.GetFanout(); f != 0 && int(f) != len(n.Links) {
+		return nil, fmt.Errorf("Set node with fanout %d has %d links", f, len(n.Links))
+	}
+	return &hdr, nil
+}
+
+// loadSet reads the set linked to from root under name.
+func loadSet(ctx context.Context, dag mdag.DAGService, root *mdag.Node, name string, internalKeys keyObserver) ([]key.Key, error) {
+	l, err := root.GetNodeLink(name)
+	if err != nil {
+		return nil, err
+	}
+	internalKeys(key.Key(l.Hash))
+	n, err := l.GetNode(ctx, dag)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []key.Key
+	walk := func(k key.Key) error {
+		keys = append(keys, k)
+		return nil
+	}
+	if err := walkItems(ctx, dag, n, walk, internalKeys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func walkItems(ctx context.Context, dag mdag.DAGService, n *mdag.Node, fn func(key.Key) error, internalKeys keyObserver) error {
+	hdr, err := readHdr(n)
+	if err != nil {
+		return err
+	}
+
+	if hdr.GetFanout() == 0 {
+		for _, l := range n.Links {
+			if err := fn(key.Key(l.Hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, l := range n.Links {
+		subtree, err := l.GetNode(ctx, dag)
+		if err != nil {
+			return err
+		}
+		internalKeys(key.Key(l.Hash))
+		if err := walkItems(ctx, dag, subtree, fn, internalKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package pin
+
+import (
+	"fmt"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	bs "github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func newTestDag() mdag.DAGService {
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return mdag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+}
+
+func testKeys(n int) []key.Key {
+	keys := make([]key.Key, n)
+	for i := range keys {
+		keys[i] = key.Key(u.Hash([]byte(fmt.Sprint(i))))
+	}
+	return keys
+}
+
+// storeRoot stores keys under a root node, the way Flush does.
+func storeRoot(t *testing.T, dag mdag.DAGService, keys []key.Key) (*mdag.Node, map[key.Key]struct{}) {
+	internal := make(map[key.Key]struct{})
+	n, err := storeSet(context.Background(), dag, keys, func(k key.Key) {
+		internal[k] = struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := new(mdag.Node)
+	if err := root.AddNodeLinkClean(linkRecursive, n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dag.Add(n); err != nil {
+		t.Fatal(err)
+	}
+	return root, internal
+}
+
+func testSetRoundtrip(t *testing.T, n int) {
+	dag := newTestDag()
+	keys := testKeys(n)
+	root, _ := storeRoot(t, dag, keys)
+
+	loaded, err := loadSet(context.Background(), dag, root, linkRecursive, func(key.Key) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(keys) {
+		t.Fatalf("expected %d keys, loaded %d", len(keys), len(loaded))
+	}
+	seen := make(map[key.Key]bool)
+	for _, k := range loaded {
+		seen[k] = true
+	}
+	for _, k := range keys {
+		if !seen[k] {
+			t.Fatalf("key %s missing from the loaded set", k)
+		}
+	}
+}
+
+func TestSetEmpty(t *testing.T) {
+	testSetRoundtrip(t, 0)
+}
+
+func TestSetSingleNode(t *testing.T) {
+	testSetRoundtrip(t, maxItems)
+}
+
+func TestSetFanout(t *testing.T) {
+	testSetRoundtrip(t, 3*maxItems)
+}
+
+func TestSetIncremental(t *testing.T) {
+	dag := newTestDag()
+	keys := testKeys(3 * maxItems)
+
+	first, before := storeRoot(t, dag, keys)
+	again, _ := storeRoot(t, dag, keys)
+	k1, _ := first.Key()
+	k2, _ := again.Key()
+	if k1 != k2 {
+		t.Fatal("storing the same set twice gave different objects")
+	}
+
+	keys[0] = key.Key(u.Hash([]byte("changed")))
+	_, after := storeRoot(t, dag, keys)
+	changed := 0
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			changed++
+		}
+	}
+	// the buckets of the removed and the added key
+	if changed > 2 {
+		t.Fatalf("changing one key rewrote %d set nodes", changed)
+	}
+}
@@ -0,0 +1,22 @@
+package config
+
+// Bitswap tracks the configuration of the block exchange.
+type Bitswap struct {
+	// Strategy ranks the peers wanting blocks from us. "fair" serves them
+	// in turn, "reciprocity" serves first the ones that sent us the most.
+	// Empty means "fair".
+	Strategy string
+
+	// RateLimit caps the bytes per second sent to all peers, PeerRateLimit
+	// the bytes per second sent to each of them, and PeerMaxOutstanding the
+	// bytes of blocks on their way to a peer at once. In B, kB, kiB, MB,
+	// ...; empty means no limit.
+	RateLimit          string
+	PeerRateLimit      string
+	PeerMaxOutstanding string
+
+	// Prefetch is the number of blocks of a file that cat, get and the
+	// gateway request ahead of the one being read. 0 means 10, a negative
+	// value requests the blocks only as they are read.
+	Prefetch int
+}
@@ -31,6 +31,8 @@ type Config struct {
 	API              API                   // local node's API settings
 	Swarm            SwarmConfig
 	Log              Log
+	Bitswap          Bitswap    // local node's block exchange settings
+	Reprovider       Reprovider // local node's announcing of the blocks it holds
 }
 
 const (
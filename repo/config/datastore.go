@@ -3,10 +3,94 @@ package config
 // DefaultDataStoreDirectory is the directory to store all the local IPFS data.
 const DefaultDataStoreDirectory = "datastore"
 
+// DefaultBlocksDirectory is the directory to store the local IPFS blocks.
+const DefaultBlocksDirectory = "blocks"
+
 // Datastore tracks the configuration of the datastore.
 type Datastore struct {
 	Type string
 	Path string
+
+	StorageMax         string // in B, kB, kiB, MB, ...; empty means no limit
+	StorageGCWatermark int64  // percentage of StorageMax at which to run GC, no GC without StorageMax
+	GCPeriod           string // in ns, us, ms, s, m, h
+
+	// HashOnRead makes the blockstore verify every block it reads against
+	// its hash, at the cost of hashing on every read.
+	HashOnRead bool
+
+	// Mounts describes the datastores that make up the repo's keyspace.
+	// An empty list selects the default layout, see DefaultDatastoreMounts.
+	Mounts []DatastoreMount `json:",omitempty"`
+}
+
+// DatastoreMount describes a single datastore backend mounted at a key
+// prefix of the repo's datastore.
+type DatastoreMount struct {
+	Prefix string // key prefix this datastore is mounted at, e.g. "/blocks"
+	Type   string // backend type, "flatfs", "leveldb" or "s3"
+	Path   string `json:",omitempty"` // location of local backends, relative to the repo root
+
+	Flatfs  *FlatfsParams  `json:",omitempty"`
+	Leveldb *LeveldbParams `json:",omitempty"`
+	S3      *S3Params      `json:",omitempty"`
+}
+
+// FlatfsParams holds the flatfs specific datastore parameters.
+type FlatfsParams struct {
+	// ShardWidth is the number of key bytes used to select the directory
+	// a block is stored in. It cannot be changed once data is written.
+	ShardWidth int
+
+	// NoSync disables fsyncing files and directories after every write.
+	// Faster, but blocks may be lost on a crash.
+	NoSync bool
+}
+
+// LeveldbParams holds the leveldb specific datastore parameters.
+type LeveldbParams struct {
+	// Compression is either "none" or "snappy".
+	Compression string
+}
+
+// S3Params holds the parameters of an S3 datastore. Credentials are read
+// from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+type S3Params struct {
+	Bucket string
+	Region string // AWS region name, e.g. "us-east-1"
+
+	// Endpoint overrides the region's endpoint, for S3 compatible stores.
+	Endpoint string `json:",omitempty"`
+
+	// Prefix is prepended to the keys, so repos can share a bucket.
+	Prefix string `json:",omitempty"`
+}
+
+// DefaultDatastoreMounts returns the layout used by repos that do not
+// configure one: flatfs for the blocks, leveldb for everything else.
+func DefaultDatastoreMounts() []DatastoreMount {
+	return []DatastoreMount{
+		{
+			Prefix: "/blocks",
+			Type:   "flatfs",
+			Path:   DefaultBlocksDirectory,
+			// 4TB of 256kB objects ~=17M objects, splitting that 256-way
+			// leads to ~66k objects per dir, splitting 256*256-way leads to
+			// only 256.
+			//
+			// The keys seen by the block store have predictable prefixes,
+			// including "/" from datastore.Key and 2 bytes from multihash. To
+			// reach a uniform 256-way split, we need approximately 4 bytes of
+			// prefix.
+			Flatfs: &FlatfsParams{ShardWidth: 4},
+		},
+		{
+			Prefix:  "/",
+			Type:    "leveldb",
+			Path:    DefaultDataStoreDirectory,
+			Leveldb: &LeveldbParams{Compression: "none"},
+		},
+	}
 }
 
 // DataStorePath returns the default data store path given a configuration root
@@ -83,8 +83,11 @@ func datastoreConfig() (*Datastore, error) {
 		return nil, err
 	}
 	return &Datastore{
-		Path: dspath,
-		Type: "leveldb",
+		Path:               dspath,
+		Type:               "leveldb",
+		StorageGCWatermark: 90, // 90%
+		GCPeriod:           "1h",
+		Mounts:             DefaultDatastoreMounts(),
 	}, nil
 }
 
@@ -0,0 +1,14 @@
+package config
+
+// Reprovider tracks the configuration of the periodic announcing of the
+// blocks the node holds.
+type Reprovider struct {
+	// Interval is the time between two runs, as a duration like "12h".
+	// Empty means "12h", "0" only runs when asked with 'ipfs bitswap
+	// reprovide'.
+	Interval string
+
+	// Strategy picks the blocks announced: "all" of them, the ones of the
+	// "pinned" DAGs, or only the "roots" of the pins. Empty means "all".
+	Strategy string
+}
@@ -0,0 +1,328 @@
+package fsrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
+	levelds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/leveldb"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/measure"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/mount"
+	ldbopts "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	serialize "github.com/ipfs/go-ipfs/repo/fsrepo/serialize"
+	dir "github.com/ipfs/go-ipfs/thirdparty/dir"
+	s3datastore "github.com/ipfs/go-ipfs/thirdparty/s3-datastore"
+	util "github.com/ipfs/go-ipfs/util"
+)
+
+// specFile records the layout the datastores on disk were created with, so
+// later config edits that would make them unreadable are caught at open.
+const specFile = "datastore_spec"
+
+// diskMount is the part of a config.DatastoreMount that determines the
+// format of the data on disk. Tunables that can safely change between runs
+// (sync mode, compression) are left out.
+type diskMount struct {
+	Prefix     string
+	Type       string
+	Path       string
+	ShardWidth int `json:",omitempty"`
+}
+
+// datastoreMounts returns the configured datastore mounts, falling back to
+// the default layout when none are configured.
+func datastoreMounts(c *config.Datastore) []config.DatastoreMount {
+	if len(c.Mounts) == 0 {
+		return config.DefaultDatastoreMounts()
+	}
+	return c.Mounts
+}
+
+// validateMounts checks the datastore mounts for consistency before anything
+// is opened or written.
+func validateMounts(mounts []config.DatastoreMount) error {
+	prefixes := make(map[string]bool)
+	paths := make(map[string]bool)
+	for _, m := range mounts {
+		p := ds.NewKey(m.Prefix).String()
+		if prefixes[p] {
+			return fmt.Errorf("datastore: prefix %s mounted more than once", p)
+		}
+		prefixes[p] = true
+
+		if isLocal(m) {
+			if m.Path == "" || filepath.IsAbs(m.Path) || strings.HasPrefix(path.Clean(m.Path), "..") {
+				return fmt.Errorf("datastore %s: path must be relative to the repo root, got %q", p, m.Path)
+			}
+			if paths[path.Clean(m.Path)] {
+				return fmt.Errorf("datastore %s: path %s used by more than one datastore", p, m.Path)
+			}
+			paths[path.Clean(m.Path)] = true
+		}
+
+		switch m.Type {
+		case "flatfs":
+			if m.Flatfs == nil || m.Flatfs.ShardWidth <= 0 {
+				return fmt.Errorf("datastore %s: flatfs requires a positive ShardWidth", p)
+			}
+		case "leveldb":
+			if _, err := leveldbCompression(m.Leveldb); err != nil {
+				return fmt.Errorf("datastore %s: %s", p, err)
+			}
+		case "s3":
+			if m.S3 == nil || m.S3.Bucket == "" {
+				return fmt.Errorf("datastore %s: s3 requires a Bucket", p)
+			}
+			if m.S3.Region == "" && m.S3.Endpoint == "" {
+				return fmt.Errorf("datastore %s: s3 requires a Region or an Endpoint", p)
+			}
+		default:
+			return fmt.Errorf("datastore %s: unknown type %q", p, m.Type)
+		}
+	}
+	if !prefixes["/"] {
+		return errors.New("datastore: no datastore mounted at /")
+	}
+	return nil
+}
+
+// isLocal reports whether the datastore keeps its data inside the repo.
+func isLocal(m config.DatastoreMount) bool {
+	return m.Type != "s3"
+}
+
+func leveldbCompression(p *config.LeveldbParams) (ldbopts.Compression, error) {
+	if p == nil {
+		return ldbopts.NoCompression, nil
+	}
+	switch p.Compression {
+	case "", "none":
+		return ldbopts.NoCompression, nil
+	case "snappy":
+		return ldbopts.SnappyCompression, nil
+	default:
+		return 0, fmt.Errorf("unknown leveldb compression %q", p.Compression)
+	}
+}
+
+func toDiskSpec(mounts []config.DatastoreMount) []diskMount {
+	spec := make([]diskMount, 0, len(mounts))
+	for _, m := range mounts {
+		dm := diskMount{
+			Prefix: ds.NewKey(m.Prefix).String(),
+			Type:   m.Type,
+			Path:   path.Clean(m.Path),
+		}
+		if m.Flatfs != nil {
+			dm.ShardWidth = m.Flatfs.ShardWidth
+		}
+		if m.S3 != nil {
+			// the data lives in the bucket, record where.
+			dm.Path = "s3://" + path.Join(m.S3.Bucket, m.S3.Prefix)
+		}
+		spec = append(spec, dm)
+	}
+	sort.Sort(byPrefix(spec))
+	return spec
+}
+
+type byPrefix []diskMount
+
+func (s byPrefix) Len() int           { return len(s) }
+func (s byPrefix) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPrefix) Less(i, j int) bool { return s[i].Prefix < s[j].Prefix }
+
+// readDiskSpec returns the datastore layout recorded in the repo. Repos
+// created before the layout was configurable have no spec file and always
+// use the default layout.
+func readDiskSpec(repoPath string) ([]diskMount, error) {
+	var spec []diskMount
+	err := serialize.ReadConfigFile(path.Join(repoPath, specFile), &spec)
+	switch {
+	case os.IsNotExist(err):
+		return toDiskSpec(config.DefaultDatastoreMounts()), nil
+	case err != nil:
+		return nil, err
+	}
+	sort.Sort(byPrefix(spec))
+	return spec, nil
+}
+
+func writeDiskSpec(repoPath string, spec []diskMount) error {
+	return serialize.WriteConfigFile(path.Join(repoPath, specFile), spec)
+}
+
+// checkDiskSpec returns an error if the configured datastore layout differs
+// from the one on disk.
+func checkDiskSpec(repoPath string, mounts []config.DatastoreMount) error {
+	onDisk, err := readDiskSpec(repoPath)
+	if err != nil {
+		return err
+	}
+	configured := toDiskSpec(mounts)
+	if reflect.DeepEqual(onDisk, configured) {
+		return nil
+	}
+	return fmt.Errorf("datastore configuration does not match the repo on disk.\n"+
+		"configured: %s\non disk:    %s\n"+
+		"Restore the Datastore.Mounts config or convert the repo before continuing.",
+		describeSpec(configured), describeSpec(onDisk))
+}
+
+func describeSpec(spec []diskMount) string {
+	parts := make([]string, 0, len(spec))
+	for _, m := range spec {
+		s := fmt.Sprintf("%s=%s:%s", m.Prefix, m.Type, m.Path)
+		if m.ShardWidth != 0 {
+			s += fmt.Sprintf("(shard width %d)", m.ShardWidth)
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+// initDatastores checks the datastore directories are writable and records
+// the layout they are going to be created with.
+func initDatastores(repoPath string, conf *config.Config) error {
+	mounts := datastoreMounts(&conf.Datastore)
+	if err := validateMounts(mounts); err != nil {
+		return err
+	}
+
+	// The actual datastore contents are initialized lazily when Opened.
+	// During Init, we merely check that the directories are writeable.
+	for _, m := range mounts {
+		if !isLocal(m) {
+			continue
+		}
+		if err := dir.Writable(path.Join(repoPath, m.Path)); err != nil {
+			return fmt.Errorf("datastore: %s", err)
+		}
+	}
+	return writeDiskSpec(repoPath, toDiskSpec(mounts))
+}
+
+// datastoresInitialized reports whether the datastores have been set up,
+// either with a spec file or in the legacy fixed layout.
+func datastoresInitialized(repoPath string) bool {
+	return util.FileExists(path.Join(repoPath, specFile)) ||
+		util.FileExists(path.Join(repoPath, config.DefaultDataStoreDirectory))
+}
+
+func openMount(repoPath string, m config.DatastoreMount) (ds.ThreadSafeDatastore, error) {
+	p := path.Join(repoPath, m.Path)
+	switch m.Type {
+	case "flatfs":
+		d, err := flatfs.New(p, m.Flatfs.ShardWidth)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open flatfs datastore: %s", err)
+		}
+		d.SetSync(!m.Flatfs.NoSync)
+		return d, nil
+	case "leveldb":
+		comp, err := leveldbCompression(m.Leveldb)
+		if err != nil {
+			return nil, err
+		}
+		d, err := levelds.NewDatastore(p, &levelds.Options{
+			Compression: comp,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to open leveldb datastore: %s", err)
+		}
+		return d, nil
+	case "s3":
+		d, err := s3datastore.New(s3datastore.Config{
+			Bucket:   m.S3.Bucket,
+			Region:   m.S3.Region,
+			Endpoint: m.S3.Endpoint,
+			Prefix:   m.S3.Prefix,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to open s3 datastore: %s", err)
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("unknown datastore type %q", m.Type)
+}
+
+// metricsName keeps the metric names of the default layout stable.
+func metricsName(m config.DatastoreMount) string {
+	name := strings.Trim(ds.NewKey(m.Prefix).String(), "/")
+	if name == "" {
+		return m.Type
+	}
+	return strings.Replace(name, "/", ".", -1)
+}
+
+// openDatastores opens every configured datastore and mounts them together
+// into a single datastore. The writes to the local ones are reported to u,
+// unless it is nil.
+func openDatastores(repoPath, metricsPrefix string, c *config.Datastore, u *usageTracker) (*mount.Datastore, error) {
+	mounts := datastoreMounts(c)
+	if err := validateMounts(mounts); err != nil {
+		return nil, err
+	}
+	if err := checkDiskSpec(repoPath, mounts); err != nil {
+		return nil, err
+	}
+	if !util.FileExists(path.Join(repoPath, specFile)) {
+		if err := writeDiskSpec(repoPath, toDiskSpec(mounts)); err != nil {
+			return nil, err
+		}
+	}
+
+	var opened []mount.Mount
+	success := false
+	defer func() {
+		if success {
+			return
+		}
+		for _, m := range opened {
+			if c, ok := m.Datastore.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+
+	for _, m := range mounts {
+		d, err := openMount(repoPath, m)
+		if err != nil {
+			return nil, err
+		}
+		var backend ds.Datastore = d
+		if u != nil && isLocal(m) {
+			backend = &trackedDatastore{Datastore: d, u: u}
+		}
+		opened = append(opened, mount.Mount{
+			Prefix:    ds.NewKey(m.Prefix),
+			Datastore: measure.New(metricsPrefix+metricsName(m), backend),
+		})
+	}
+
+	// mount picks the first matching prefix, so the most specific
+	// prefixes have to come first.
+	sorted := make([]mount.Mount, len(opened))
+	copy(sorted, opened)
+	sort.Sort(byMountDepth(sorted))
+
+	success = true
+	return mount.New(sorted), nil
+}
+
+type byMountDepth []mount.Mount
+
+func (s byMountDepth) Len() int      { return len(s) }
+func (s byMountDepth) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byMountDepth) Less(i, j int) bool {
+	return len(s[i].Prefix.List()) > len(s[j].Prefix.List())
+}
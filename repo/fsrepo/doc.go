@@ -3,6 +3,7 @@
 // TODO explain the package roadmap...
 //
 //   .ipfs/
+//   ├── blocks/
 //   ├── client/
 //   |   ├── client.lock          <------ protects client/ + signals its own pid
 //   │   ├── ipfs-client.cpuprof
@@ -15,6 +16,8 @@
 //   │   ├── ipfs-daemon.memprof
 //   │   └── logs/
 //   ├── datastore/
+//   ├── datastore_spec           <------ layout of the datastores on disk
+//   ├── migration-backups/       <------ version and config saved before each migration
 //   ├── repo.lock                <------ protects datastore/ and config
 //   └── version
 package fsrepo
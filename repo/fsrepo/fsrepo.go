@@ -6,16 +6,12 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
-	levelds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/leveldb"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/measure"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/mount"
-	ldbopts "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
 	repo "github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/common"
 	config "github.com/ipfs/go-ipfs/repo/config"
@@ -30,7 +26,7 @@ import (
 )
 
 // version number that we are currently expecting to see
-var RepoVersion = "2"
+var RepoVersion = "3"
 
 var migrationInstructions = `See https://github.com/ipfs/fs-repo-migrations/blob/master/run.md
 Sorry for the inconvenience. In the future, these will run automatically.`
@@ -55,10 +51,22 @@ func (err NoRepoError) Error() string {
 	return fmt.Sprintf("no ipfs repo found in %s.\nplease run: ipfs init", err.Path)
 }
 
+// NeedMigrationError is returned by Open for repos at an older version that
+// Migrate can upgrade.
+type NeedMigrationError struct {
+	Path       string
+	Have, Want string
+}
+
+var _ error = NeedMigrationError{}
+
+func (err NeedMigrationError) Error() string {
+	return fmt.Sprintf("ipfs repo in %s is at version %s, this program needs version %s.\nplease run: ipfs daemon --migrate",
+		err.Path, err.Have, err.Want)
+}
+
 const (
-	leveldbDirectory = "datastore"
-	flatfsDirectory  = "blocks"
-	apiFile          = "api"
+	apiFile = "api"
 )
 
 var (
@@ -94,6 +102,10 @@ type FSRepo struct {
 	lockfile io.Closer
 	config   *config.Config
 	ds       ds.ThreadSafeDatastore
+	// mounts is the datastore layout ds was opened with
+	mounts []config.DatastoreMount
+	// usage counts the bytes the repo takes up
+	usage *usageTracker
 }
 
 var _ repo.Repo = (*FSRepo)(nil)
@@ -143,6 +155,9 @@ func open(repoPath string) (repo.Repo, error) {
 	}
 
 	if ver != RepoVersion {
+		if _, err := mfsr.Plan(ver, RepoVersion); err == nil {
+			return nil, NeedMigrationError{Path: r.path, Have: ver, Want: RepoVersion}
+		}
 		return nil, fmt.Errorf(errIncorrectRepoFmt, ver, RepoVersion)
 	}
 
@@ -166,6 +181,30 @@ func open(repoPath string) (repo.Repo, error) {
 	return r, nil
 }
 
+// Migrate upgrades the repo at repoPath to RepoVersion by running the
+// registered migrations in-process. See mfsr.RepoPath.Migrate for how
+// failures are rolled back.
+func Migrate(repoPath string, opts mfsr.Options) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	r, err := newFSRepo(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := checkInitialized(r.path); err != nil {
+		return err
+	}
+
+	lk, err := lockfile.Lock(r.path)
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+
+	return mfsr.RepoPath(r.path).Migrate(RepoVersion, opts)
+}
+
 func newFSRepo(rpath string) (*FSRepo, error) {
 	expPath, err := u.TildeExpansion(path.Clean(rpath))
 	if err != nil {
@@ -233,7 +272,6 @@ func initConfig(path string, conf *config.Config) error {
 }
 
 // Init initializes a new FSRepo at the given path with the provided config.
-// TODO add support for custom datastores.
 func Init(repoPath string, conf *config.Config) error {
 
 	// packageLock must be held to ensure that the repo is not initialized more
@@ -249,16 +287,8 @@ func Init(repoPath string, conf *config.Config) error {
 		return err
 	}
 
-	// The actual datastore contents are initialized lazily when Opened.
-	// During Init, we merely check that the directory is writeable.
-	leveldbPath := path.Join(repoPath, leveldbDirectory)
-	if err := dir.Writable(leveldbPath); err != nil {
-		return fmt.Errorf("datastore: %s", err)
-	}
-
-	flatfsPath := path.Join(repoPath, flatfsDirectory)
-	if err := dir.Writable(flatfsPath); err != nil {
-		return fmt.Errorf("datastore: %s", err)
+	if err := initDatastores(repoPath, conf); err != nil {
+		return err
 	}
 
 	if err := dir.Writable(path.Join(repoPath, "logs")); err != nil {
@@ -345,29 +375,6 @@ func (r *FSRepo) openConfig() error {
 
 // openDatastore returns an error if the config file is not present.
 func (r *FSRepo) openDatastore() error {
-	leveldbPath := path.Join(r.path, leveldbDirectory)
-	var err error
-	// save leveldb reference so it can be neatly closed afterward
-	leveldbDS, err := levelds.NewDatastore(leveldbPath, &levelds.Options{
-		Compression: ldbopts.NoCompression,
-	})
-	if err != nil {
-		return errors.New("unable to open leveldb datastore")
-	}
-
-	// 4TB of 256kB objects ~=17M objects, splitting that 256-way
-	// leads to ~66k objects per dir, splitting 256*256-way leads to
-	// only 256.
-	//
-	// The keys seen by the block store have predictable prefixes,
-	// including "/" from datastore.Key and 2 bytes from multihash. To
-	// reach a uniform 256-way split, we need approximately 4 bytes of
-	// prefix.
-	blocksDS, err := flatfs.New(path.Join(r.path, flatfsDirectory), 4)
-	if err != nil {
-		return errors.New("unable to open flatfs datastore")
-	}
-
 	// Add our PeerID to metrics paths to keep them unique
 	//
 	// As some tests just pass a zero-value Config to fsrepo.Init,
@@ -378,26 +385,19 @@ func (r *FSRepo) openDatastore() error {
 		id = fmt.Sprintf("uninitialized_%p", r)
 	}
 	prefix := "fsrepo." + id + ".datastore."
-	metricsBlocks := measure.New(prefix+"blocks", blocksDS)
-	metricsLevelDB := measure.New(prefix+"leveldb", leveldbDS)
-	mountDS := mount.New([]mount.Mount{
-		{
-			Prefix:    ds.NewKey("/blocks"),
-			Datastore: metricsBlocks,
-		},
-		{
-			Prefix:    ds.NewKey("/"),
-			Datastore: metricsLevelDB,
-		},
-	})
+	r.usage = newUsageTracker(r.path)
+	mountDS, err := openDatastores(r.path, prefix, &r.config.Datastore, r.usage)
+	if err != nil {
+		return err
+	}
 	// Make sure it's ok to claim the virtual datastore from mount as
 	// threadsafe. There's no clean way to make mount itself provide
 	// this information without copy-pasting the code into two
 	// variants. This is the same dilemma as the `[].byte` attempt at
-	// introducing const types to Go.
-	var _ ds.ThreadSafeDatastore = blocksDS
-	var _ ds.ThreadSafeDatastore = leveldbDS
+	// introducing const types to Go. openMount only hands out
+	// ThreadSafeDatastores.
 	r.ds = ds2.ClaimThreadSafe{mountDS}
+	r.mounts = datastoreMounts(&r.config.Datastore)
 	return nil
 }
 
@@ -589,6 +589,67 @@ func (r *FSRepo) Datastore() ds.ThreadSafeDatastore {
 	return d
 }
 
+// GetStorageUsage returns the storage space taken by the repo in bytes. It
+// is kept up to date as the datastores are written to, and may be slightly
+// over. Datastores that keep their data outside the repo directory, like
+// s3, are not accounted for.
+func (r *FSRepo) GetStorageUsage() (uint64, error) {
+	return r.usage.usage()
+}
+
+// Stat reports the repo's path, version and the storage used by each of
+// its datastores.
+func (r *FSRepo) Stat() (*repo.Stat, error) {
+	ver, err := mfsr.RepoPath(r.path).Version()
+	if err != nil {
+		return nil, err
+	}
+	// the tracked usage is an estimate, the stat walks the repo
+	usage, err := diskUsage(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &repo.Stat{
+		Path:         r.path,
+		Version:      ver,
+		StorageUsage: usage,
+	}
+	for _, m := range r.mounts {
+		ns := repo.NamespaceStat{
+			Prefix: m.Prefix,
+			Type:   m.Type,
+		}
+		if isLocal(m) {
+			ns.Size, err = diskUsage(path.Join(r.path, m.Path))
+			if err != nil {
+				return nil, err
+			}
+		}
+		st.Namespaces = append(st.Namespaces, ns)
+	}
+	return st, nil
+}
+
+// diskUsage sums up the size of the regular files under root.
+func diskUsage(root string) (uint64, error) {
+	var du uint64
+	err := filepath.Walk(root, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			// files may disappear while we walk, e.g. during gc
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if f != nil && f.Mode().IsRegular() {
+			du += uint64(f.Size())
+		}
+		return nil
+	})
+	return du, err
+}
+
 var _ io.Closer = &FSRepo{}
 var _ repo.Repo = &FSRepo{}
 
@@ -610,8 +671,5 @@ func isInitializedUnsynced(repoPath string) bool {
 	if !configIsInitialized(repoPath) {
 		return false
 	}
-	if !util.FileExists(path.Join(repoPath, leveldbDirectory)) {
-		return false
-	}
-	return true
+	return datastoresInitialized(repoPath)
 }
@@ -3,10 +3,16 @@ package fsrepo
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/aws"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3/s3test"
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	"github.com/ipfs/go-ipfs/repo/config"
+	serialize "github.com/ipfs/go-ipfs/repo/fsrepo/serialize"
 	"github.com/ipfs/go-ipfs/thirdparty/assert"
 )
 
@@ -116,3 +122,181 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestCustomDatastoreLayout(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("layout", t)
+
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{
+			Prefix: "/blocks",
+			Type:   "flatfs",
+			Path:   "flatblocks",
+			Flatfs: &config.FlatfsParams{ShardWidth: 2, NoSync: true},
+		},
+		{
+			Prefix:  "/",
+			Type:    "leveldb",
+			Path:    "leveldb",
+			Leveldb: &config.LeveldbParams{Compression: "snappy"},
+		},
+	}
+	assert.Nil(Init(path, conf), t, "should initialize successfully")
+	assert.True(IsInitialized(path), t, "should be initialized")
+
+	r, err := Open(path)
+	assert.Nil(err, t, "should open successfully")
+	k := datastore.NewKey("/blocks/CIQKEY")
+	assert.Nil(r.Datastore().Put(k, []byte("data")), t, "Put should be successful")
+	assert.Nil(r.Close(), t)
+
+	_, err = ioutil.ReadDir(filepath.Join(path, "flatblocks"))
+	assert.Nil(err, t, "blocks should be stored in the configured directory")
+}
+
+func TestDatastoreLayoutMismatch(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("mismatch", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	mounts := config.DefaultDatastoreMounts()
+	mounts[0].Flatfs.ShardWidth = 2
+	err := setDatastoreMounts(path, mounts)
+	assert.Nil(err, t)
+	_, err = Open(path)
+	assert.Err(err, t, "changing the shard width of existing blocks should fail")
+
+	mounts = config.DefaultDatastoreMounts()
+	mounts[0].Flatfs.NoSync = true
+	err = setDatastoreMounts(path, mounts)
+	assert.Nil(err, t)
+	r, err := Open(path)
+	assert.Nil(err, t, "changing the sync mode should be allowed")
+	assert.Nil(r.Close(), t)
+}
+
+func TestInvalidDatastoreLayout(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("invalid", t)
+
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: "flatfs", Path: "blocks", Flatfs: &config.FlatfsParams{ShardWidth: 4}},
+	}
+	assert.Err(Init(path, conf), t, "a layout without a root mount should be rejected")
+
+	conf.Datastore.Mounts = append(conf.Datastore.Mounts, config.DatastoreMount{
+		Prefix: "/", Type: "bogus", Path: "datastore",
+	})
+	assert.Err(Init(path, conf), t, "unknown datastore types should be rejected")
+}
+
+func setDatastoreMounts(path string, mounts []config.DatastoreMount) error {
+	filename, err := config.Filename(path)
+	if err != nil {
+		return err
+	}
+	conf, err := serialize.Load(filename)
+	if err != nil {
+		return err
+	}
+	conf.Datastore.Mounts = mounts
+	return serialize.WriteConfigFile(filename, conf)
+}
+
+func TestS3BlocksMount(t *testing.T) {
+	srv, err := s3test.NewServer(&s3test.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	client := s3.New(aws.Auth{}, aws.Region{
+		Name:                 "faux-region-1",
+		S3Endpoint:           srv.URL(),
+		S3LocationConstraint: true,
+	})
+	assert.Nil(client.Bucket("blocks").PutBucket(s3.Private), t, "create bucket")
+
+	path := testRepoPath("s3", t)
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{
+			Prefix: "/blocks",
+			Type:   "s3",
+			S3:     &config.S3Params{Bucket: "blocks", Endpoint: srv.URL(), Prefix: "node1"},
+		},
+		{
+			Prefix: "/",
+			Type:   "leveldb",
+			Path:   "datastore",
+		},
+	}
+	assert.Nil(Init(path, conf), t, "should initialize successfully")
+	r, err := Open(path)
+	assert.Nil(err, t, "should open successfully")
+
+	k := datastore.NewKey("/blocks/CIQKEY")
+	assert.Nil(r.Datastore().Put(k, []byte("data")), t, "Put should be successful")
+	assert.Nil(r.Close(), t)
+
+	has, err := client.Bucket("blocks").Exists("node1/CIQKEY")
+	assert.Nil(err, t)
+	assert.True(has, t, "block should be stored in the bucket")
+}
+
+func TestStorageUsage(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("usage", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	before, err := r.GetStorageUsage()
+	assert.Nil(err, t)
+
+	data := make([]byte, 100000)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/blocks/CIQBIG"), data), t)
+
+	after, err := r.GetStorageUsage()
+	assert.Nil(err, t)
+	assert.True(after >= before+uint64(len(data)), t, "usage should grow by the size of the block")
+
+	// the usage is kept, not walked again on every call
+	assert.Nil(ioutil.WriteFile(filepath.Join(path, "extra"), data, 0600), t)
+	kept, err := r.GetStorageUsage()
+	assert.Nil(err, t)
+	assert.True(kept == after, t, "usage should not walk the repo again")
+
+	assert.Nil(r.Datastore().Delete(datastore.NewKey("/blocks/CIQBIG")), t)
+	deleted, err := r.GetStorageUsage()
+	assert.Nil(err, t)
+	assert.True(deleted == kept-uint64(len(data)), t, "a delete should take the size of the block off without a walk")
+}
+
+func TestStat(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("stat", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	data := make([]byte, 50000)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/blocks/CIQSTAT"), data), t)
+
+	st, err := r.Stat()
+	assert.Nil(err, t)
+	assert.True(st.Path == path, t, "stat should report the repo path")
+	assert.True(st.Version == RepoVersion, t, "stat should report the repo version")
+	assert.True(len(st.Namespaces) == 2, t, "default layout has two namespaces")
+	for _, ns := range st.Namespaces {
+		if ns.Prefix == "/blocks" {
+			assert.True(ns.Size >= uint64(len(data)), t, "blocks namespace should hold the block")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package fsrepo
+
+import (
+	"fmt"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	pin "github.com/ipfs/go-ipfs/pin"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	serialize "github.com/ipfs/go-ipfs/repo/fsrepo/serialize"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
+)
+
+func init() {
+	mfsr.Register(&mfsr.Migration{
+		From:        "2",
+		To:          "3",
+		Description: "store the pin sets as merkledag objects",
+		Apply: func(rp mfsr.RepoPath) error {
+			return withPinStorage(rp, pin.ConvertLegacyPins)
+		},
+		Revert: func(rp mfsr.RepoPath) error {
+			return withPinStorage(rp, pin.RevertLegacyPins)
+		},
+	})
+}
+
+// withPinStorage opens the datastore of the repo at rp, and calls fn with
+// it and an offline DAGService on top of it.
+func withPinStorage(rp mfsr.RepoPath, fn func(ds.ThreadSafeDatastore, mdag.DAGService) error) error {
+	configFilename, err := config.Filename(string(rp))
+	if err != nil {
+		return err
+	}
+	conf, err := serialize.Load(configFilename)
+	if err != nil {
+		return err
+	}
+
+	var id byte
+	prefix := fmt.Sprintf("fsrepo.migration_%p.datastore.", &id)
+	mountDS, err := openDatastores(string(rp), prefix, &conf.Datastore, nil)
+	if err != nil {
+		return err
+	}
+	d := ds2.ClaimThreadSafe{Batching: mountDS}
+	defer d.Close()
+
+	bs := bstore.NewBlockstore(d)
+	dserv := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	return fn(d, dserv)
+}
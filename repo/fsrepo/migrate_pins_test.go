@@ -0,0 +1,59 @@
+package fsrepo
+
+import (
+	"encoding/json"
+	"testing"
+
+	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	pin "github.com/ipfs/go-ipfs/pin"
+	"github.com/ipfs/go-ipfs/repo/config"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	"github.com/ipfs/go-ipfs/thirdparty/assert"
+)
+
+func TestMigratePins(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("pins", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	// a version 2 repo, with a recursive pin stored as JSON
+	r, err := Open(path)
+	assert.Nil(err, t)
+	bs := bstore.NewBlockstore(r.Datastore())
+	dserv := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	nd := &mdag.Node{Data: []byte("pinned")}
+	k, err := dserv.Add(nd)
+	assert.Nil(err, t)
+	buf, err := json.Marshal([]key.Key{k})
+	assert.Nil(err, t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/local/pins/recursive/keys"), buf), t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/local/pins/direct/keys"), []byte("[]")), t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/local/pins/indirect/keys"), []byte("{}")), t)
+	assert.Nil(r.Close(), t)
+	assert.Nil(mfsr.RepoPath(path).WriteVersion("2"), t)
+
+	_, err = Open(path)
+	_, ok := err.(NeedMigrationError)
+	assert.True(ok, t, "opening a version 2 repo should ask for a migration")
+
+	assert.Nil(Migrate(path, mfsr.Options{}), t)
+
+	r, err = Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+	bs = bstore.NewBlockstore(r.Datastore())
+	dserv = mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	p, err := pin.LoadPinner(r.Datastore(), dserv)
+	assert.Nil(err, t)
+	keys := p.RecursiveKeys()
+	assert.True(len(keys) == 1 && keys[0] == k, t, "the recursive pin should be migrated")
+
+	has, err := r.Datastore().Has(datastore.NewKey("/local/pins/recursive/keys"))
+	assert.Nil(err, t)
+	assert.False(has, t, "the JSON pin sets should be removed")
+}
@@ -0,0 +1,224 @@
+package mfsr
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// BackupDir is the directory, relative to the repo, that Migrate copies
+// the files it may have to restore into.
+const BackupDir = "migration-backups"
+
+// BackupFiles are the repo files saved before migrating and restored if a
+// migration fails.
+var BackupFiles = []string{VersionFile, "config", "datastore_spec"}
+
+// Migration upgrades a repo from version From to version To.
+type Migration struct {
+	From, To    string
+	Description string
+
+	// Apply performs the migration. It need not touch the version file,
+	// Migrate updates it once Apply succeeds.
+	Apply func(rp RepoPath) error
+
+	// Revert undoes whatever Apply changed besides the BackupFiles. It
+	// is called when Apply or a later migration fails, so it must cope
+	// with a partially applied migration. It may be nil if Apply only
+	// changes BackupFiles.
+	Revert func(rp RepoPath) error
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%s-to-%s", m.From, m.To)
+}
+
+var registered = make(map[string]*Migration)
+
+// Register makes a migration available to Migrate. It is meant to be called
+// from the init function of the file defining the migration, and panics if
+// a migration from the same version is already registered.
+func Register(m *Migration) {
+	if m.Apply == nil {
+		panic("mfsr: migration " + m.String() + " has no Apply function")
+	}
+	if _, ok := registered[m.From]; ok {
+		panic("mfsr: a migration from version " + m.From + " is already registered")
+	}
+	registered[m.From] = m
+}
+
+// Plan returns the migrations that take a repo from version from to
+// version to, in the order they have to run.
+func Plan(from, to string) ([]*Migration, error) {
+	var plan []*Migration
+	seen := make(map[string]bool)
+	for v := from; v != to; {
+		if seen[v] {
+			return nil, fmt.Errorf("migrations from version %s loop back to version %s", from, v)
+		}
+		seen[v] = true
+
+		m, ok := registered[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration from repo version %s to %s", v, to)
+		}
+		plan = append(plan, m)
+		v = m.To
+	}
+	return plan, nil
+}
+
+// Options control how Migrate runs.
+type Options struct {
+	// DryRun only reports the migrations that would run.
+	DryRun bool
+
+	// Out receives progress messages. It may be nil.
+	Out io.Writer
+}
+
+func (o Options) printf(format string, args ...interface{}) {
+	if o.Out != nil {
+		fmt.Fprintf(o.Out, format, args...)
+	}
+}
+
+// Migrate runs the migrations needed to bring the repo to version to. The
+// BackupFiles are saved first; if a migration fails, the migrations run so
+// far are reverted and the saved files restored, leaving the repo as it
+// was. The backup is kept after a successful run. The caller must hold
+// the repo lock.
+func (rp RepoPath) Migrate(to string, opts Options) error {
+	from, err := rp.Version()
+	if err != nil {
+		return err
+	}
+	if from == to {
+		opts.printf("repo is already at version %s\n", to)
+		return nil
+	}
+
+	plan, err := Plan(from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range plan {
+		opts.printf("migration %s: %s\n", m, m.Description)
+	}
+	if opts.DryRun {
+		opts.printf("dry run, repo left at version %s\n", from)
+		return nil
+	}
+
+	backup := path.Join(string(rp), BackupDir, fmt.Sprintf("%s-to-%s-%d", from, to, time.Now().Unix()))
+	saved, err := rp.backup(backup)
+	if err != nil {
+		return fmt.Errorf("backing up repo before migrating: %s", err)
+	}
+	opts.printf("backed up %s to %s\n", strings.Join(saved, ", "), backup)
+
+	for i, m := range plan {
+		opts.printf("running migration %s...\n", m)
+		err := m.Apply(rp)
+		if err == nil {
+			err = rp.WriteVersion(m.To)
+		}
+		if err == nil {
+			continue
+		}
+
+		opts.printf("migration %s failed, rolling back\n", m)
+		if rerr := rp.rollback(plan[:i+1], backup); rerr != nil {
+			return fmt.Errorf("migration %s failed: %s\nrollback failed too: %s\nthe original files are in %s",
+				m, err, rerr, backup)
+		}
+		return fmt.Errorf("migration %s failed: %s\nrepo rolled back to version %s", m, err, from)
+	}
+
+	opts.printf("repo migrated to version %s\n", to)
+	return nil
+}
+
+// backup copies the BackupFiles that exist into dir, and returns their
+// names.
+func (rp RepoPath) backup(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	var saved []string
+	for _, name := range BackupFiles {
+		fn := path.Join(string(rp), name)
+		fi, err := os.Stat(fn)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path.Join(dir, name), data, fi.Mode()); err != nil {
+			return nil, err
+		}
+		saved = append(saved, name)
+	}
+	return saved, nil
+}
+
+// rollback reverts the given migrations in reverse order, then restores the
+// BackupFiles from dir. Files that did not exist before migrating are
+// removed.
+func (rp RepoPath) rollback(applied []*Migration, dir string) error {
+	for i := len(applied) - 1; i >= 0; i-- {
+		m := applied[i]
+		if m.Revert == nil {
+			continue
+		}
+		if err := m.Revert(rp); err != nil {
+			return fmt.Errorf("reverting migration %s: %s", m, err)
+		}
+	}
+
+	for _, name := range BackupFiles {
+		target := path.Join(string(rp), name)
+		saved := path.Join(dir, name)
+		fi, err := os.Stat(saved)
+		if os.IsNotExist(err) {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(saved)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(target, data, fi.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to fn and renames it
+// into place, so fn never holds partial content.
+func writeFileAtomic(fn string, data []byte, perm os.FileMode) error {
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
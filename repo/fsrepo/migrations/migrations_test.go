@@ -0,0 +1,166 @@
+package mfsr
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func testRepo(t *testing.T, version string) RepoPath {
+	dir, err := ioutil.TempDir("", "mfsr-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := RepoPath(dir)
+	if err := rp.WriteVersion(version); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "config"), []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func readConfig(t *testing.T, rp RepoPath) string {
+	data, err := ioutil.ReadFile(path.Join(string(rp), "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeConfig(rp RepoPath, s string) error {
+	return ioutil.WriteFile(path.Join(string(rp), "config"), []byte(s), 0600)
+}
+
+// the tests register migrations between versions of their own, so they
+// don't interfere with the real ones.
+func init() {
+	Register(&Migration{
+		From: "test-ok-1", To: "test-ok-2",
+		Apply: func(rp RepoPath) error { return writeConfig(rp, "two") },
+	})
+	Register(&Migration{
+		From: "test-ok-2", To: "test-ok-3",
+		Apply: func(rp RepoPath) error { return writeConfig(rp, "three") },
+	})
+
+	Register(&Migration{
+		From: "test-fail-1", To: "test-fail-2",
+		Apply: func(rp RepoPath) error {
+			if err := writeConfig(rp, "two"); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(path.Join(string(rp), "extra"), nil, 0600)
+		},
+		Revert: func(rp RepoPath) error {
+			return os.Remove(path.Join(string(rp), "extra"))
+		},
+	})
+	Register(&Migration{
+		From: "test-fail-2", To: "test-fail-3",
+		Apply: func(rp RepoPath) error {
+			writeConfig(rp, "half written")
+			return errors.New("boom")
+		},
+	})
+
+	Register(&Migration{
+		From: "test-loop-1", To: "test-loop-2",
+		Apply: func(rp RepoPath) error { return nil },
+	})
+	Register(&Migration{
+		From: "test-loop-2", To: "test-loop-1",
+		Apply: func(rp RepoPath) error { return nil },
+	})
+}
+
+func TestPlan(t *testing.T) {
+	plan, err := Plan("test-ok-1", "test-ok-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].To != "test-ok-2" || plan[1].To != "test-ok-3" {
+		t.Fatal("unexpected plan", plan)
+	}
+
+	if _, err := Plan("test-ok-1", "test-ok-4"); err == nil {
+		t.Fatal("expected an error for a version without migration")
+	}
+	if _, err := Plan("test-loop-1", "test-loop-3"); err == nil {
+		t.Fatal("expected an error for looping migrations")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	rp := testRepo(t, "test-ok-1")
+	defer os.RemoveAll(string(rp))
+
+	if err := rp.Migrate("test-ok-3", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.CheckVersion("test-ok-3"); err != nil {
+		t.Fatal(err)
+	}
+	if readConfig(t, rp) != "three" {
+		t.Fatal("migrations were not applied")
+	}
+
+	backups, err := ioutil.ReadDir(path.Join(string(rp), BackupDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatal("expected one backup, got", len(backups))
+	}
+	data, err := ioutil.ReadFile(path.Join(string(rp), BackupDir, backups[0].Name(), "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "original" {
+		t.Fatal("backup should hold the original config")
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	rp := testRepo(t, "test-ok-1")
+	defer os.RemoveAll(string(rp))
+
+	out := new(bytes.Buffer)
+	if err := rp.Migrate("test-ok-3", Options{DryRun: true, Out: out}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.CheckVersion("test-ok-1"); err != nil {
+		t.Fatal(err)
+	}
+	if readConfig(t, rp) != "original" {
+		t.Fatal("dry run changed the config")
+	}
+	if !bytes.Contains(out.Bytes(), []byte("test-ok-2-to-test-ok-3")) {
+		t.Fatal("dry run should list the migrations, got", out.String())
+	}
+	if _, err := os.Stat(path.Join(string(rp), BackupDir)); !os.IsNotExist(err) {
+		t.Fatal("dry run should not make a backup")
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	rp := testRepo(t, "test-fail-1")
+	defer os.RemoveAll(string(rp))
+
+	if err := rp.Migrate("test-fail-3", Options{}); err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	if err := rp.CheckVersion("test-fail-1"); err != nil {
+		t.Fatal(err)
+	}
+	if readConfig(t, rp) != "original" {
+		t.Fatal("config was not restored")
+	}
+	if _, err := os.Stat(path.Join(string(rp), "extra")); !os.IsNotExist(err) {
+		t.Fatal("first migration was not reverted")
+	}
+}
@@ -0,0 +1,156 @@
+package fsrepo
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+)
+
+// usageTracker keeps count of the bytes the repo takes up, so that checking
+// it before every write does not walk the whole repo. The repo is walked
+// once, then the bytes put in the local datastores are added to that and
+// the bytes deleted from them taken off. Overwrites and the puts made
+// during the walk may be counted twice: the usage errs on the high side.
+// A delete of a value whose size is unknown makes the next call walk the
+// repo again.
+type usageTracker struct {
+	// delta and stale are first to keep delta 64-bit aligned
+	delta int64
+	stale int32
+
+	root string
+
+	lk     sync.Mutex
+	walked uint64
+}
+
+func newUsageTracker(root string) *usageTracker {
+	return &usageTracker{root: root, stale: 1}
+}
+
+func (u *usageTracker) usage() (uint64, error) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	if atomic.CompareAndSwapInt32(&u.stale, 1, 0) {
+		atomic.StoreInt64(&u.delta, 0)
+		du, err := diskUsage(u.root)
+		if err != nil {
+			atomic.StoreInt32(&u.stale, 1)
+			return 0, err
+		}
+		u.walked = du
+	}
+	delta := atomic.LoadInt64(&u.delta)
+	if delta < 0 && uint64(-delta) > u.walked {
+		return 0, nil
+	}
+	return uint64(int64(u.walked) + delta), nil
+}
+
+func (u *usageTracker) put(val interface{}) {
+	if b, ok := val.([]byte); ok {
+		atomic.AddInt64(&u.delta, int64(len(b)))
+	}
+}
+
+// deleted takes size bytes off the usage, a negative size being unknown.
+func (u *usageTracker) deleted(size int64) {
+	if size < 0 {
+		atomic.StoreInt32(&u.stale, 1)
+		return
+	}
+	atomic.AddInt64(&u.delta, -size)
+}
+
+// trackedDatastore reports the writes to a datastore kept in the repo
+// directory to a usageTracker.
+type trackedDatastore struct {
+	ds.Datastore
+	u *usageTracker
+}
+
+func (d *trackedDatastore) Put(key ds.Key, val interface{}) error {
+	if err := d.Datastore.Put(key, val); err != nil {
+		return err
+	}
+	d.u.put(val)
+	return nil
+}
+
+func (d *trackedDatastore) Delete(key ds.Key) error {
+	size := d.size(key)
+	err := d.Datastore.Delete(key)
+	if err == nil {
+		d.u.deleted(size)
+	}
+	return err
+}
+
+// size returns the size of the value stored under key, -1 if it is unknown.
+func (d *trackedDatastore) size(key ds.Key) int64 {
+	val, err := d.Datastore.Get(key)
+	if err != nil {
+		return -1
+	}
+	b, ok := val.([]byte)
+	if !ok {
+		return -1
+	}
+	return int64(len(b))
+}
+
+func (d *trackedDatastore) Batch() (ds.Batch, error) {
+	bds, ok := d.Datastore.(ds.Batching)
+	if !ok {
+		return nil, ds.ErrBatchUnsupported
+	}
+	b, err := bds.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedBatch{Batch: b, d: d}, nil
+}
+
+func (d *trackedDatastore) Close() error {
+	if c, ok := d.Datastore.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type trackedBatch struct {
+	ds.Batch
+	d *trackedDatastore
+
+	puts    []interface{}
+	deletes []int64
+}
+
+func (b *trackedBatch) Put(key ds.Key, val interface{}) error {
+	if err := b.Batch.Put(key, val); err != nil {
+		return err
+	}
+	b.puts = append(b.puts, val)
+	return nil
+}
+
+func (b *trackedBatch) Delete(key ds.Key) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.deletes = append(b.deletes, b.d.size(key))
+	return nil
+}
+
+func (b *trackedBatch) Commit() error {
+	// count the batch even if committing it failed halfway
+	for _, v := range b.puts {
+		b.d.u.put(v)
+	}
+	for _, size := range b.deletes {
+		b.d.u.deleted(size)
+	}
+	return b.Batch.Commit()
+}
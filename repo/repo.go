@@ -22,8 +22,30 @@ type Repo interface {
 
 	Datastore() datastore.ThreadSafeDatastore
 
+	// GetStorageUsage returns the number of bytes the repo takes up.
+	GetStorageUsage() (uint64, error)
+
+	// Stat reports where the repo is and how its storage is used.
+	Stat() (*Stat, error)
+
 	// SetAPIAddr sets the API address in the repo.
 	SetAPIAddr(addr string) error
 
 	io.Closer
 }
+
+// Stat describes a repo and the storage used by each of its datastores.
+type Stat struct {
+	Path         string
+	Version      string
+	StorageUsage uint64
+	Namespaces   []NamespaceStat
+}
+
+// NamespaceStat is the storage used by the datastore mounted at Prefix.
+// Size is only known for datastores that keep their data in the repo.
+type NamespaceStat struct {
+	Prefix string
+	Type   string
+	Size   uint64 `json:",omitempty"`
+}
@@ -2,21 +2,102 @@ package s3datastore
 
 import (
 	"errors"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cenkalti/backoff"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/aws"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3"
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	query "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	goprocess "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/goprocess"
+	logging "github.com/ipfs/go-ipfs/vendor/QmXJkcEXB6C9h6Ytb6rrUTFU56Ro62zxgrbxTT3dgjQGA8/go-log"
 )
 
-var _ datastore.ThreadSafeDatastore = &S3Datastore{}
+var log = logging.Logger("s3datastore")
 
-var errTODO = errors.New("TODO")
+var _ datastore.ThreadSafeDatastore = &S3Datastore{}
+var _ datastore.Batching = &S3Datastore{}
 
 var ErrInvalidType = errors.New("s3 datastore: invalid type error")
 
+// DefaultRetryTimeout is how long transient errors are retried for when
+// S3Datastore.RetryTimeout is not set.
+const DefaultRetryTimeout = 30 * time.Second
+
+// listPageSize is the number of keys requested per List call while querying.
+const listPageSize = 1000
+
 type S3Datastore struct {
 	Client *s3.S3
 	Bucket string
+
+	// Prefix is prepended to all keys, so several datastores can share a
+	// bucket.
+	Prefix string
+
+	// RetryTimeout bounds how long a request failing with transient
+	// errors is retried before giving up.
+	RetryTimeout time.Duration
+}
+
+// Config describes where an S3Datastore keeps its data.
+type Config struct {
+	Bucket string
+	// Region is the name of an AWS region, e.g. "us-east-1".
+	Region string
+	// Endpoint overrides the region's endpoint, for S3 compatible stores.
+	Endpoint string
+	Prefix   string
+}
+
+// New returns an S3Datastore for the given config. Credentials are taken
+// from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+func New(c Config) (*S3Datastore, error) {
+	if c.Bucket == "" {
+		return nil, errors.New("s3 datastore: no bucket given")
+	}
+	auth, err := aws.EnvAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	region, ok := aws.Regions[c.Region]
+	switch {
+	case c.Endpoint != "":
+		region = aws.Region{
+			Name:       c.Region,
+			S3Endpoint: c.Endpoint,
+		}
+	case !ok:
+		return nil, errors.New("s3 datastore: unknown region " + c.Region)
+	}
+
+	return &S3Datastore{
+		Client: s3.New(auth, region),
+		Bucket: c.Bucket,
+		Prefix: c.Prefix,
+	}, nil
+}
+
+func (ds *S3Datastore) bucket() *s3.Bucket {
+	return ds.Client.Bucket(ds.Bucket)
+}
+
+// objectName maps a datastore key to the name of the S3 object holding it.
+func (ds *S3Datastore) objectName(key datastore.Key) string {
+	return path.Join("/", ds.Prefix, key.String())
+}
+
+// keyFor is the inverse of objectName for the names returned by List, which
+// carry no leading slash.
+func (ds *S3Datastore) keyFor(name string) datastore.Key {
+	base := strings.TrimPrefix(path.Join("/", ds.Prefix), "/")
+	return datastore.NewKey(strings.TrimPrefix(name, base))
 }
 
 func (ds *S3Datastore) Put(key datastore.Key, value interface{}) (err error) {
@@ -25,23 +106,156 @@ func (ds *S3Datastore) Put(key datastore.Key, value interface{}) (err error) {
 		return ErrInvalidType
 	}
 	// TODO extract perms and s3 options
-	return ds.Client.Bucket(ds.Bucket).Put(key.String(), data, "application/protobuf", s3.PublicRead, s3.Options{})
+	return ds.retry(func() error {
+		return ds.bucket().Put(ds.objectName(key), data, "application/protobuf", s3.Private, s3.Options{})
+	})
 }
 
 func (ds *S3Datastore) Get(key datastore.Key) (value interface{}, err error) {
-	return ds.Client.Bucket(ds.Bucket).Get(key.String())
+	var data []byte
+	err = ds.retry(func() error {
+		var err error
+		data, err = ds.bucket().Get(ds.objectName(key))
+		return err
+	})
+	if isNotFound(err) {
+		return nil, datastore.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 func (ds *S3Datastore) Has(key datastore.Key) (exists bool, err error) {
-	return ds.Client.Bucket(ds.Bucket).Exists(key.String())
+	err = ds.retry(func() error {
+		var err error
+		exists, err = ds.bucket().Exists(ds.objectName(key))
+		return err
+	})
+	return exists, err
 }
 
 func (ds *S3Datastore) Delete(key datastore.Key) (err error) {
-	return ds.Client.Bucket(ds.Bucket).Del(key.String())
+	return ds.retry(func() error {
+		return ds.bucket().Del(ds.objectName(key))
+	})
 }
 
+// Query lists the keys under q.Prefix page by page. Filters, orders, limits
+// and offsets are applied naively on top of the listing.
 func (ds *S3Datastore) Query(q query.Query) (query.Results, error) {
-	return nil, errors.New("TODO implement query for s3 datastore?")
+	prefix := strings.TrimPrefix(ds.objectName(datastore.NewKey(q.Prefix)), "/")
+	if q.Prefix == "" || q.Prefix == "/" {
+		prefix = strings.TrimPrefix(path.Join("/", ds.Prefix), "/")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	b := query.NewResultBuilder(q)
+	b.Process.Go(func(worker goprocess.Process) {
+		marker := ""
+		for {
+			var resp *s3.ListResp
+			err := ds.retry(func() error {
+				var err error
+				resp, err = ds.bucket().List(prefix, "", marker, listPageSize)
+				return err
+			})
+			if err != nil {
+				send(worker, b.Output, query.Result{Error: err})
+				return
+			}
+
+			for _, obj := range resp.Contents {
+				e := query.Entry{Key: ds.keyFor(obj.Key).String()}
+				if !q.KeysOnly {
+					v, err := ds.Get(datastore.NewKey(e.Key))
+					if err != nil {
+						send(worker, b.Output, query.Result{Error: err})
+						return
+					}
+					e.Value = v
+				}
+				if !send(worker, b.Output, query.Result{Entry: e}) {
+					return
+				}
+			}
+
+			if !resp.IsTruncated {
+				return
+			}
+			marker = resp.NextMarker
+		}
+	})
+	go b.Process.CloseAfterChildren()
+
+	qr := b.Results()
+	qr = query.NaiveQueryApply(q, qr)
+	return qr, nil
+}
+
+// send delivers r unless the query was closed early.
+func send(worker goprocess.Process, out chan<- query.Result, r query.Result) bool {
+	select {
+	case out <- r:
+		return true
+	case <-worker.Closing():
+		return false
+	}
+}
+
+// Batch returns a batch that applies its operations one by one on Commit,
+// as S3 has no multi-object writes.
+func (ds *S3Datastore) Batch() (datastore.Batch, error) {
+	return datastore.NewBasicBatch(ds), nil
 }
 
 func (ds *S3Datastore) IsThreadSafe() {}
+
+// retry runs op until it succeeds, fails with an error that is not
+// transient, or RetryTimeout elapses.
+func (ds *S3Datastore) retry(op func() error) error {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = ds.RetryTimeout
+	if b.MaxElapsedTime == 0 {
+		b.MaxElapsedTime = DefaultRetryTimeout
+	}
+
+	var permanent error
+	err := backoff.RetryNotify(func() error {
+		err := op()
+		if err != nil && !isTransient(err) {
+			permanent = err
+			return nil
+		}
+		return err
+	}, b, func(err error, wait time.Duration) {
+		log.Debugf("s3 request failed, retrying in %s: %s", wait, err)
+	})
+	if permanent != nil {
+		return permanent
+	}
+	return err
+}
+
+// isTransient reports whether a failed request is worth retrying.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *s3.Error:
+		switch e.Code {
+		case "InternalError", "SlowDown", "RequestTimeout", "ServiceUnavailable":
+			return true
+		}
+		return e.StatusCode >= 500
+	case *url.Error, net.Error:
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*s3.Error)
+	return ok && (e.StatusCode == 404 || e.Code == "NoSuchKey")
+}
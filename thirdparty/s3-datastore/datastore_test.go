@@ -0,0 +1,194 @@
+package s3datastore
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/aws"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3/s3test"
+	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	query "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+)
+
+const testBucket = "ipfs-test"
+
+// newTestDatastore starts a fake S3 server and returns a datastore using a
+// fresh bucket on it.
+func newTestDatastore(t *testing.T, endpoint func(string) string) (*S3Datastore, func()) {
+	srv, err := s3test.NewServer(&s3test.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := srv.URL()
+	if endpoint != nil {
+		u = endpoint(u)
+	}
+	client := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{
+		Name:                 "faux-region-1",
+		S3Endpoint:           u,
+		S3LocationConstraint: true, // s3test server requires a LocationConstraint
+	})
+	if err := client.Bucket(testBucket).PutBucket(s3.Private); err != nil {
+		t.Fatal(err)
+	}
+	ds := &S3Datastore{
+		Client:       client,
+		Bucket:       testBucket,
+		Prefix:       "repo",
+		RetryTimeout: 5 * time.Second,
+	}
+	return ds, srv.Quit
+}
+
+func TestPutGetHasDelete(t *testing.T) {
+	ds, done := newTestDatastore(t, nil)
+	defer done()
+
+	k := datastore.NewKey("/CIQFOO")
+	if has, err := ds.Has(k); err != nil || has {
+		t.Fatalf("expected key to be absent, got %v, %v", has, err)
+	}
+	if _, err := ds.Get(k); err != datastore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := ds.Put(k, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := ds.Has(k); err != nil || !has {
+		t.Fatalf("expected key to be present, got %v, %v", has, err)
+	}
+	v, err := ds.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.([]byte), []byte("bar")) {
+		t.Fatalf("got back wrong value: %q", v)
+	}
+
+	if err := ds.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := ds.Has(k); err != nil || has {
+		t.Fatalf("expected key to be deleted, got %v, %v", has, err)
+	}
+}
+
+func TestPutInvalidType(t *testing.T) {
+	ds, done := newTestDatastore(t, nil)
+	defer done()
+
+	if err := ds.Put(datastore.NewKey("/foo"), "not bytes"); err != ErrInvalidType {
+		t.Fatalf("expected ErrInvalidType, got %v", err)
+	}
+}
+
+func TestQueryKeys(t *testing.T) {
+	ds, done := newTestDatastore(t, nil)
+	defer done()
+
+	// a datastore sharing the bucket under another prefix must not show up
+	other := *ds
+	other.Prefix = "other"
+	if err := other.Put(datastore.NewKey("/hidden"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ds.Batch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string]bool)
+	for _, s := range []string{"/a", "/b", "/c"} {
+		expected[s] = true
+		if err := b.Put(datastore.NewKey(s), []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d keys, got %d: %v", len(expected), len(entries), entries)
+	}
+	for _, e := range entries {
+		if !expected[e.Key] {
+			t.Fatalf("unexpected key %q", e.Key)
+		}
+	}
+}
+
+// flakyProxy fails the first n requests with 503 before passing requests
+// through to the fake server.
+type flakyProxy struct {
+	mu    sync.Mutex
+	fails int
+	proxy http.Handler
+}
+
+func (p *flakyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	fail := p.fails > 0
+	if fail {
+		p.fails--
+	}
+	p.mu.Unlock()
+	if fail {
+		http.Error(w, "slow down", http.StatusServiceUnavailable)
+		return
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	flaky := &flakyProxy{}
+	var front *httptest.Server
+	ds, done := newTestDatastore(t, func(backend string) string {
+		u, err := url.Parse(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flaky.proxy = httputil.NewSingleHostReverseProxy(u)
+		front = httptest.NewServer(flaky)
+		return front.URL
+	})
+	defer done()
+	defer front.Close()
+
+	flaky.mu.Lock()
+	flaky.fails = 2
+	flaky.mu.Unlock()
+
+	k := datastore.NewKey("/retried")
+	if err := ds.Put(k, []byte("data")); err != nil {
+		t.Fatalf("put should succeed after retrying: %s", err)
+	}
+	if _, err := ds.Get(k); err != nil {
+		t.Fatal(err)
+	}
+
+	flaky.mu.Lock()
+	flaky.fails = 1 << 20
+	flaky.mu.Unlock()
+
+	ds.RetryTimeout = 500 * time.Millisecond
+	if err := ds.Put(k, []byte("data")); err == nil {
+		t.Fatal("expected put to fail once retries are exhausted")
+	}
+}
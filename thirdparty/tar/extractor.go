@@ -8,6 +8,7 @@ import (
 	gopath "path"
 	fp "path/filepath"
 	"strings"
+	"time"
 )
 
 type Extractor struct {
@@ -30,6 +31,18 @@ func (te *Extractor) Extract(reader io.Reader) error {
 		rootIsDir = true
 	}
 
+	// directory modes and times are set once their contents are written,
+	// which a read-only mode would prevent and would otherwise change the
+	// times
+	var dirs []*tar.Header
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			path := te.outputPath(dirs[i].Name)
+			restoreDirMode(path, dirs[i].FileInfo().Mode().Perm())
+			setModTime(path, dirs[i].ModTime)
+		}
+	}()
+
 	// files come recursively in order (i == 0 is root directory)
 	for i := 0; ; i++ {
 		header, err := tarReader.Next()
@@ -45,6 +58,7 @@ func (te *Extractor) Extract(reader io.Reader) error {
 			if err := te.extractDir(header, i); err != nil {
 				return err
 			}
+			dirs = append(dirs, header)
 		case tar.TypeReg:
 			if err := te.extractFile(header, tarReader, i, rootExists, rootIsDir); err != nil {
 				return err
@@ -78,7 +92,8 @@ func (te *Extractor) extractDir(h *tar.Header, depth int) error {
 		te.Path = path
 	}
 
-	err := os.MkdirAll(path, 0755)
+	// the contents still have to be written, whatever the mode
+	err := os.MkdirAll(path, h.FileInfo().Mode().Perm()|0700)
 	if err != nil {
 		return err
 	}
@@ -105,16 +120,44 @@ func (te *Extractor) extractFile(h *tar.Header, r *tar.Reader, depth int, rootEx
 		} // else if old file exists, just overwrite it.
 	}
 
-	file, err := os.Create(path)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, h.FileInfo().Mode().Perm())
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
 	_, err = io.Copy(file, r)
 	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
 		return err
 	}
 
+	setModTime(path, h.ModTime)
 	return nil
 }
+
+// restoreDirMode takes off the owner permissions extractDir added to the
+// directory at path, that perm does not have. Failing to is not worth
+// failing the extraction for.
+func restoreDirMode(path string, perm os.FileMode) {
+	added := 0700 &^ perm
+	if added == 0 {
+		return
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	os.Chmod(path, st.Mode().Perm()&^added)
+}
+
+// setModTime sets the modification time of path to mtime. Failing to is
+// not worth failing the extraction for.
+func setModTime(path string, mtime time.Time) {
+	if mtime.IsZero() {
+		return
+	}
+	os.Chtimes(path, mtime, mtime)
+}
@@ -29,8 +29,10 @@ func (i *identityWriteCloser) Close() error {
 	return nil
 }
 
-// DagArchive is equivalent to `ipfs getdag $hash | maybe_tar | maybe_gzip`
-func DagArchive(ctx cxt.Context, nd *mdag.Node, name string, dag mdag.DAGService, archive bool, compression int) (io.Reader, error) {
+// DagArchive is equivalent to `ipfs getdag $hash | maybe_tar | maybe_gzip`.
+// prefetch is the number of blocks of a file requested ahead of the one
+// being written.
+func DagArchive(ctx cxt.Context, nd *mdag.Node, name string, dag mdag.DAGService, archive bool, compression int, prefetch int) (io.Reader, error) {
 
 	_, filename := path.Split(name)
 
@@ -69,6 +71,7 @@ func DagArchive(ctx cxt.Context, nd *mdag.Node, name string, dag mdag.DAGService
 		if checkErrAndClosePipe(err) {
 			return nil, err
 		}
+		dagr.SetPrefetch(prefetch)
 
 		go func() {
 			if _, err := dagr.WriteTo(maybeGzw); checkErrAndClosePipe(err) {
@@ -84,6 +87,7 @@ func DagArchive(ctx cxt.Context, nd *mdag.Node, name string, dag mdag.DAGService
 		if checkErrAndClosePipe(err) {
 			return nil, err
 		}
+		w.Prefetch = prefetch
 
 		go func() {
 			// write all the nodes recursively
@@ -22,23 +22,38 @@ type Writer struct {
 	Dag  mdag.DAGService
 	TarW *tar.Writer
 
+	// Prefetch is the number of blocks of a file requested ahead of the
+	// one being written, see uio.DagReader.SetPrefetch.
+	Prefetch int
+
 	ctx cxt.Context
 }
 
 // NewWriter wraps given io.Writer.
 func NewWriter(ctx cxt.Context, dag mdag.DAGService, archive bool, compression int, w io.Writer) (*Writer, error) {
 	return &Writer{
-		Dag:  dag,
-		TarW: tar.NewWriter(w),
-		ctx:  ctx,
+		Dag:      dag,
+		TarW:     tar.NewWriter(w),
+		Prefetch: uio.DefaultPrefetch,
+		ctx:      ctx,
 	}, nil
 }
 
-func (w *Writer) writeDir(nd *mdag.Node, fpath string) error {
-	if err := writeDirHeader(w.TarW, fpath); err != nil {
+func (w *Writer) writeDir(nd *mdag.Node, pb *upb.Data, fpath string) error {
+	if err := writeDirHeader(w.TarW, fpath, pb); err != nil {
 		return err
 	}
 
+	if pb.GetType() == upb.Data_HAMTShard {
+		// fetch the entries spread over the shards like those of a
+		// plain directory
+		links, err := uio.Links(w.ctx, w.Dag, nd)
+		if err != nil {
+			return err
+		}
+		nd = &mdag.Node{Links: links}
+	}
+
 	for i, ng := range w.Dag.GetDAG(w.ctx, nd) {
 		child, err := ng.Get(w.ctx)
 		if err != nil {
@@ -55,11 +70,12 @@ func (w *Writer) writeDir(nd *mdag.Node, fpath string) error {
 }
 
 func (w *Writer) writeFile(nd *mdag.Node, pb *upb.Data, fpath string) error {
-	if err := writeFileHeader(w.TarW, fpath, pb.GetFilesize()); err != nil {
+	if err := writeFileHeader(w.TarW, fpath, pb); err != nil {
 		return err
 	}
 
 	dagr := uio.NewDataFileReader(w.ctx, nd, pb, w.Dag)
+	dagr.SetPrefetch(w.Prefetch)
 	if _, err := dagr.WriteTo(w.TarW); err != nil {
 		return err
 	}
@@ -76,8 +92,8 @@ func (w *Writer) WriteNode(nd *mdag.Node, fpath string) error {
 	switch pb.GetType() {
 	case upb.Data_Metadata:
 		fallthrough
-	case upb.Data_Directory:
-		return w.writeDir(nd, fpath)
+	case upb.Data_Directory, upb.Data_HAMTShard:
+		return w.writeDir(nd, pb, fpath)
 	case upb.Data_Raw:
 		fallthrough
 	case upb.Data_File:
@@ -93,27 +109,39 @@ func (w *Writer) Close() error {
 	return w.TarW.Close()
 }
 
-func writeDirHeader(w *tar.Writer, fpath string) error {
+func writeDirHeader(w *tar.Writer, fpath string, pb *upb.Data) error {
+	mode, mtime := fileInfo(pb, 0777)
 	return w.WriteHeader(&tar.Header{
 		Name:     fpath,
 		Typeflag: tar.TypeDir,
-		Mode:     0777,
-		ModTime:  time.Now(),
-		// TODO: set mode, dates, etc. when added to unixFS
+		Mode:     mode,
+		ModTime:  mtime,
 	})
 }
 
-func writeFileHeader(w *tar.Writer, fpath string, size uint64) error {
+func writeFileHeader(w *tar.Writer, fpath string, pb *upb.Data) error {
+	mode, mtime := fileInfo(pb, 0644)
 	return w.WriteHeader(&tar.Header{
 		Name:     fpath,
-		Size:     int64(size),
+		Size:     int64(pb.GetFilesize()),
 		Typeflag: tar.TypeReg,
-		Mode:     0644,
-		ModTime:  time.Now(),
-		// TODO: set mode, dates, etc. when added to unixFS
+		Mode:     mode,
+		ModTime:  mtime,
 	})
 }
 
+// fileInfo returns the mode and modification time recorded in pb, or the
+// default mode and the current time.
+func fileInfo(pb *upb.Data, mode int64) (int64, time.Time) {
+	if pb.Mode != nil {
+		mode = int64(pb.GetMode())
+	}
+	if mtime, ok := ft.ModTime(pb); ok {
+		return mode, mtime
+	}
+	return mode, time.Now()
+}
+
 func writeSymlinkHeader(w *tar.Writer, target, fpath string) error {
 	return w.WriteHeader(&tar.Header{
 		Name:     fpath,
@@ -4,6 +4,8 @@ package unixfs
 
 import (
 	"errors"
+	"os"
+	"time"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	pb "github.com/ipfs/go-ipfs/unixfs/pb"
@@ -14,6 +16,7 @@ const (
 	TFile      = pb.Data_File
 	TDirectory = pb.Data_Directory
 	TMetadata  = pb.Data_Metadata
+	THAMTShard = pb.Data_HAMTShard
 )
 
 var ErrMalformedFileFormat = errors.New("malformed data in file format")
@@ -62,6 +65,32 @@ func FolderPBData() []byte {
 	return data
 }
 
+// HAMTShardData returns the data of a HAMT directory shard, with the
+// bitfield of its occupied slots.
+func HAMTShardData(bitfield []byte, fanout uint64, hashType uint64) ([]byte, error) {
+	pbdata := new(pb.Data)
+	typ := pb.Data_HAMTShard
+	pbdata.Type = &typ
+	pbdata.Data = bitfield
+	pbdata.Fanout = proto.Uint64(fanout)
+	pbdata.HashType = proto.Uint64(hashType)
+	return proto.Marshal(pbdata)
+}
+
+// IsDirectory reports whether data is that of a directory, sharded or not.
+func IsDirectory(data []byte) bool {
+	pbdata := new(pb.Data)
+	if err := proto.Unmarshal(data, pbdata); err != nil {
+		return false
+	}
+	switch pbdata.GetType() {
+	case pb.Data_Directory, pb.Data_HAMTShard:
+		return true
+	default:
+		return false
+	}
+}
+
 func WrapData(b []byte) []byte {
 	pbdata := new(pb.Data)
 	typ := pb.Data_Raw
@@ -91,6 +120,75 @@ func SymlinkData(path string) ([]byte, error) {
 	return out, nil
 }
 
+// SetFileInfo records the permission bits of mode and the modification
+// time mtime in the unixfs data of a node. A zero mode or mtime is not
+// recorded, so the data, and the hash of its node, can stay as they were.
+func SetFileInfo(data []byte, mode os.FileMode, mtime time.Time) ([]byte, error) {
+	pbdata := new(pb.Data)
+	if err := proto.Unmarshal(data, pbdata); err != nil {
+		return nil, err
+	}
+	if perm := mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky); perm != 0 {
+		pbdata.Mode = proto.Uint32(unixMode(perm))
+	}
+	if !mtime.IsZero() {
+		pbdata.Mtime = proto.Int64(mtime.Unix())
+	}
+	return proto.Marshal(pbdata)
+}
+
+// Mode returns the permission bits recorded in pbdata, if any.
+func Mode(pbdata *pb.Data) (os.FileMode, bool) {
+	if pbdata.Mode == nil {
+		return 0, false
+	}
+	return fileMode(pbdata.GetMode()), true
+}
+
+// ModTime returns the modification time recorded in pbdata, if any.
+func ModTime(pbdata *pb.Data) (time.Time, bool) {
+	if pbdata.Mtime == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(pbdata.GetMtime(), 0), true
+}
+
+// the mode is stored with the unix bits, os.FileMode has its own for
+// setuid, setgid and sticky
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+func unixMode(m os.FileMode) uint32 {
+	u := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		u |= unixSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		u |= unixSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		u |= unixSticky
+	}
+	return u
+}
+
+func fileMode(u uint32) os.FileMode {
+	m := os.FileMode(u) & os.ModePerm
+	if u&unixSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if u&unixSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if u&unixSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
 func UnwrapData(data []byte) ([]byte, error) {
 	pbdata := new(pb.Data)
 	err := proto.Unmarshal(data, pbdata)
@@ -130,6 +228,10 @@ type FSNode struct {
 
 	// node type of this node
 	Type pb.Data_DataType
+
+	// recorded permission bits and modification time, kept as they were
+	mode  *uint32
+	mtime *int64
 }
 
 func FSNodeFromBytes(b []byte) (*FSNode, error) {
@@ -144,6 +246,8 @@ func FSNodeFromBytes(b []byte) (*FSNode, error) {
 	n.blocksizes = pbn.Blocksizes
 	n.subtotal = pbn.GetFilesize() - uint64(len(n.Data))
 	n.Type = pbn.GetType()
+	n.mode = pbn.Mode
+	n.mtime = pbn.Mtime
 	return n, nil
 }
 
@@ -164,6 +268,8 @@ func (n *FSNode) GetBytes() ([]byte, error) {
 	pbn.Filesize = proto.Uint64(uint64(len(n.Data)) + n.subtotal)
 	pbn.Blocksizes = n.blocksizes
 	pbn.Data = n.Data
+	pbn.Mode = n.mode
+	pbn.Mtime = n.mtime
 	return proto.Marshal(pbn)
 }
 
@@ -1,7 +1,10 @@
 package unixfs
 
 import (
+	"bytes"
+	"os"
 	"testing"
+	"time"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 
@@ -37,3 +40,52 @@ func TestFSNode(t *testing.T) {
 		t.Fatal("Datasize calculations incorrect!")
 	}
 }
+
+func TestFileInfo(t *testing.T) {
+	data := FilePBData([]byte("hello"), 5)
+
+	same, err := SetFileInfo(data, 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(same, data) {
+		t.Fatal("recording nothing should leave the data as it was")
+	}
+
+	mtime := time.Unix(1234567890, 0)
+	withInfo, err := SetFileInfo(data, 0755|os.ModeSetuid, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbn, err := FromBytes(withInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbn.GetMode() != 04755 {
+		t.Fatalf("expected mode 04755, got %o", pbn.GetMode())
+	}
+	if mode, ok := Mode(pbn); !ok || mode != 0755|os.ModeSetuid {
+		t.Fatal("mode did not round trip, got", mode)
+	}
+	if mt, ok := ModTime(pbn); !ok || !mt.Equal(mtime) {
+		t.Fatal("mtime did not round trip, got", mt)
+	}
+
+	// modifying the file keeps them
+	fsn, err := FSNodeFromBytes(withInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsn.Data = []byte("changed")
+	b, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbn, err = FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ModTime(pbn); !ok || pbn.GetMode() != 04755 {
+		t.Fatal("FSNode lost the recorded mode and mtime")
+	}
+}
@@ -0,0 +1,435 @@
+// Package hamt implements directories sharded as a hash array mapped trie.
+//
+// A shard is a unixfs HAMTShard node with a slot for each of its fanout
+// possible indexes. The entry named n goes into the slot given by the next
+// log2(fanout) bits of the hash of n, which holds either the entry itself,
+// or a shard one level down with every entry that shares those bits.
+//
+// The links of a shard are ordered by slot, and named by the slot index in
+// uppercase hex, padded to the width of the largest index. The link to an
+// entry has its name appended, the link to a shard has nothing more. As
+// shards that hold a single entry are folded into their parent, the trie
+// only depends on the entries it holds, and not on the order they were
+// added in.
+package hamt
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	format "github.com/ipfs/go-ipfs/unixfs"
+	upb "github.com/ipfs/go-ipfs/unixfs/pb"
+)
+
+const (
+	// HashFnv1a64 identifies the 64 bit FNV-1a hash, the one the shards
+	// place entries with.
+	HashFnv1a64 = 1
+
+	// DefaultFanout is the number of slots of a shard.
+	DefaultFanout = 256
+
+	// MaxFanout is the largest number of slots a shard can have, the
+	// bitfield and the links of a shard are sized by it.
+	MaxFanout = 1024
+)
+
+var errHashExhausted = errors.New("hamt: names with the same hash")
+
+// Shard is a node of a sharded directory.
+type Shard struct {
+	dserv dag.DAGService
+
+	// one child for each set bit, in order
+	bitfield *big.Int
+	children []*child
+
+	tableSize    int
+	tableSizeLg2 int
+	padLen       int
+
+	// multihash function code the shards are hashed with
+	hashFunc int
+
+	// file info of the directory, only recorded in the root shard
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// child is an entry, or a shard that may not be loaded yet.
+type child struct {
+	name  string // of the entry, empty for a shard
+	link  *dag.Link
+	shard *Shard
+}
+
+func (c *child) isShard() bool {
+	return c.shard != nil || c.name == ""
+}
+
+// NewShard returns an empty shard with size slots. size has to be a power
+// of two, up to MaxFanout.
+func NewShard(dserv dag.DAGService, size int) (*Shard, error) {
+	if size > MaxFanout {
+		return nil, fmt.Errorf("hamt: fanout %d is larger than %d", size, MaxFanout)
+	}
+	lg2 := 0
+	for 1<<uint(lg2) < size {
+		lg2++
+	}
+	if size <= 1 || 1<<uint(lg2) != size {
+		return nil, fmt.Errorf("hamt: fanout %d is not a power of two", size)
+	}
+	return &Shard{
+		dserv:        dserv,
+		bitfield:     new(big.Int),
+		tableSize:    size,
+		tableSizeLg2: lg2,
+		padLen:       len(fmt.Sprintf("%X", size-1)),
+	}, nil
+}
+
+// NewHamtFromDag loads the shard stored in nd. Its children shards are
+// only loaded when they are needed.
+func NewHamtFromDag(dserv dag.DAGService, nd *dag.Node) (*Shard, error) {
+	pbd, err := format.FromBytes(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+	if pbd.GetType() != upb.Data_HAMTShard {
+		return nil, errors.New("hamt: node is not a HAMT shard")
+	}
+	if pbd.GetHashType() != HashFnv1a64 {
+		return nil, fmt.Errorf("hamt: unsupported hash type %d", pbd.GetHashType())
+	}
+
+	ds, err := NewShard(dserv, int(pbd.GetFanout()))
+	if err != nil {
+		return nil, err
+	}
+	ds.bitfield.SetBytes(pbd.GetData())
+	ds.hashFunc = nd.HashFunc()
+	ds.mode, _ = format.Mode(pbd)
+	ds.mtime, _ = format.ModTime(pbd)
+
+	prev := -1
+	for _, l := range nd.Links {
+		if len(l.Name) < ds.padLen {
+			return nil, fmt.Errorf("hamt: invalid link name %q", l.Name)
+		}
+		idx, err := strconv.ParseUint(l.Name[:ds.padLen], 16, 32)
+		if err != nil || int(idx) <= prev || int(idx) >= ds.tableSize || ds.bitfield.Bit(int(idx)) != 1 {
+			return nil, fmt.Errorf("hamt: invalid link name %q", l.Name)
+		}
+		prev = int(idx)
+
+		ds.children = append(ds.children, &child{
+			name: l.Name[ds.padLen:],
+			link: &dag.Link{Name: l.Name[ds.padLen:], Size: l.Size, Hash: l.Hash, Raw: l.Raw},
+		})
+	}
+	if len(ds.children) != bitCount(ds.bitfield) {
+		return nil, errors.New("hamt: bitfield does not match the links")
+	}
+	return ds, nil
+}
+
+// SetHashFunc sets the multihash function the shard, and the shards below
+// it, are hashed with.
+func (ds *Shard) SetHashFunc(code int) {
+	ds.hashFunc = code
+	for _, ch := range ds.children {
+		if ch.shard != nil {
+			ch.shard.SetHashFunc(code)
+		}
+	}
+}
+
+// SetFileInfo records mode and mtime in the root shard, as
+// unixfs.SetFileInfo does for a directory node.
+func (ds *Shard) SetFileInfo(mode os.FileMode, mtime time.Time) {
+	ds.mode = mode
+	ds.mtime = mtime
+}
+
+// Node returns the node storing the shard. The shards below it are added
+// to the DAGService, the returned node is not.
+func (ds *Shard) Node() (*dag.Node, error) {
+	out := new(dag.Node)
+	out.SetHashFunc(ds.hashFunc)
+	cindex := 0
+	for i := 0; i < ds.tableSize; i++ {
+		if ds.bitfield.Bit(i) == 0 {
+			continue
+		}
+		ch := ds.children[cindex]
+		cindex++
+
+		prefix := ds.linkPrefix(i)
+		if ch.shard != nil {
+			nd, err := ch.shard.Node()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := ds.dserv.Add(nd); err != nil {
+				return nil, err
+			}
+			if err := out.AddNodeLinkClean(prefix, nd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		out.AddRawLink(prefix+ch.name, ch.link)
+	}
+
+	data, err := format.HAMTShardData(ds.bitfield.Bytes(), uint64(ds.tableSize), HashFnv1a64)
+	if err != nil {
+		return nil, err
+	}
+	if ds.mode != 0 || !ds.mtime.IsZero() {
+		if data, err = format.SetFileInfo(data, ds.mode, ds.mtime); err != nil {
+			return nil, err
+		}
+	}
+	out.Data = data
+	return out, nil
+}
+
+// Set adds nd under name, replacing the entry named name if there is one.
+func (ds *Shard) Set(ctx context.Context, name string, nd *dag.Node) error {
+	lnk, err := dag.MakeLink(nd)
+	if err != nil {
+		return err
+	}
+	return ds.SetLink(ctx, name, lnk)
+}
+
+// SetLink adds the entry lnk links to under name, replacing the entry
+// named name if there is one.
+func (ds *Shard) SetLink(ctx context.Context, name string, lnk *dag.Link) error {
+	l := &dag.Link{Name: name, Size: lnk.Size, Hash: lnk.Hash, Raw: lnk.Raw}
+	return ds.modifyValue(ctx, newHashBits(name), name, l)
+}
+
+// Remove removes the entry named name.
+func (ds *Shard) Remove(ctx context.Context, name string) error {
+	return ds.modifyValue(ctx, newHashBits(name), name, nil)
+}
+
+// Find returns the link to the entry named name, or os.ErrNotExist.
+func (ds *Shard) Find(ctx context.Context, name string) (*dag.Link, error) {
+	hv := newHashBits(name)
+	cur := ds
+	for {
+		idx, err := hv.next(cur.tableSizeLg2)
+		if err != nil {
+			return nil, err
+		}
+		if cur.bitfield.Bit(idx) == 0 {
+			return nil, os.ErrNotExist
+		}
+		ch, err := cur.getChild(ctx, cur.childIndex(idx))
+		if err != nil {
+			return nil, err
+		}
+		if ch.shard == nil {
+			if ch.name != name {
+				return nil, os.ErrNotExist
+			}
+			l := *ch.link
+			return &l, nil
+		}
+		cur = ch.shard
+	}
+}
+
+// EnumLinks returns the links to all the entries, in the trie's order.
+func (ds *Shard) EnumLinks(ctx context.Context) ([]*dag.Link, error) {
+	var links []*dag.Link
+	err := ds.ForEachLink(ctx, func(l *dag.Link) error {
+		links = append(links, l)
+		return nil
+	})
+	return links, err
+}
+
+// ForEachLink calls f with the link to every entry, in the trie's order.
+func (ds *Shard) ForEachLink(ctx context.Context, f func(*dag.Link) error) error {
+	for i := range ds.children {
+		ch, err := ds.getChild(ctx, i)
+		if err != nil {
+			return err
+		}
+		if ch.shard != nil {
+			if err := ch.shard.ForEachLink(ctx, f); err != nil {
+				return err
+			}
+			continue
+		}
+		l := *ch.link
+		if err := f(&l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getChild returns the child at index i of the children, loading it if
+// it is a shard.
+func (ds *Shard) getChild(ctx context.Context, i int) (*child, error) {
+	ch := ds.children[i]
+	if !ch.isShard() || ch.shard != nil {
+		return ch, nil
+	}
+
+	nd, err := ds.dserv.Get(ctx, key.Key(ch.link.Hash))
+	if err != nil {
+		return nil, err
+	}
+	sub, err := NewHamtFromDag(ds.dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	sub.hashFunc = ds.hashFunc
+	ch.shard = sub
+	return ch, nil
+}
+
+func (ds *Shard) modifyValue(ctx context.Context, hv *hashBits, name string, val *dag.Link) error {
+	idx, err := hv.next(ds.tableSizeLg2)
+	if err != nil {
+		return err
+	}
+
+	if ds.bitfield.Bit(idx) == 0 {
+		if val == nil {
+			return os.ErrNotExist
+		}
+		ds.insertChild(idx, &child{name: name, link: val})
+		return nil
+	}
+
+	cindex := ds.childIndex(idx)
+	ch, err := ds.getChild(ctx, cindex)
+	if err != nil {
+		return err
+	}
+
+	if ch.shard != nil {
+		if err := ch.shard.modifyValue(ctx, hv, name, val); err != nil {
+			return err
+		}
+		if val == nil {
+			// keep the trie canonical: a shard left with a single entry
+			// is replaced by the entry
+			sub := ch.shard
+			switch {
+			case len(sub.children) == 0:
+				ds.removeChild(idx, cindex)
+			case len(sub.children) == 1 && !sub.children[0].isShard():
+				ds.children[cindex] = sub.children[0]
+			}
+		}
+		return nil
+	}
+
+	if ch.name == name {
+		if val == nil {
+			ds.removeChild(idx, cindex)
+			return nil
+		}
+		ch.link = val
+		return nil
+	}
+	if val == nil {
+		return os.ErrNotExist
+	}
+
+	// both entries move to a new shard, where the next bits of their
+	// hashes tell them apart
+	sub, err := NewShard(ds.dserv, ds.tableSize)
+	if err != nil {
+		return err
+	}
+	sub.hashFunc = ds.hashFunc
+	chhv := &hashBits{b: hash(ch.name), consumed: hv.consumed}
+	if err := sub.modifyValue(ctx, hv, name, val); err != nil {
+		return err
+	}
+	if err := sub.modifyValue(ctx, chhv, ch.name, ch.link); err != nil {
+		return err
+	}
+	ds.children[cindex] = &child{shard: sub}
+	return nil
+}
+
+func (ds *Shard) insertChild(idx int, ch *child) {
+	i := ds.childIndex(idx)
+	ds.bitfield.SetBit(ds.bitfield, idx, 1)
+	ds.children = append(ds.children, nil)
+	copy(ds.children[i+1:], ds.children[i:])
+	ds.children[i] = ch
+}
+
+func (ds *Shard) removeChild(idx, cindex int) {
+	ds.bitfield.SetBit(ds.bitfield, idx, 0)
+	ds.children = append(ds.children[:cindex], ds.children[cindex+1:]...)
+}
+
+// childIndex returns the index in the children of the slot idx.
+func (ds *Shard) childIndex(idx int) int {
+	n := 0
+	for i := 0; i < idx; i++ {
+		n += int(ds.bitfield.Bit(i))
+	}
+	return n
+}
+
+func (ds *Shard) linkPrefix(idx int) string {
+	return fmt.Sprintf("%0*X", ds.padLen, idx)
+}
+
+func bitCount(b *big.Int) int {
+	n := 0
+	for i := 0; i < b.BitLen(); i++ {
+		n += int(b.Bit(i))
+	}
+	return n
+}
+
+func hash(name string) []byte {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum(nil)
+}
+
+// hashBits hands out the bits of a hash, from the most significant on.
+type hashBits struct {
+	b        []byte
+	consumed int
+}
+
+func newHashBits(name string) *hashBits {
+	return &hashBits{b: hash(name)}
+}
+
+func (hb *hashBits) next(n int) (int, error) {
+	if hb.consumed+n > len(hb.b)*8 {
+		return 0, errHashExhausted
+	}
+	out := 0
+	for i := 0; i < n; i++ {
+		bit := hb.b[hb.consumed/8] >> uint(7-hb.consumed%8) & 1
+		out = out<<1 | int(bit)
+		hb.consumed++
+	}
+	return out, nil
+}
@@ -0,0 +1,181 @@
+package hamt
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	dagmock "github.com/ipfs/go-ipfs/merkledag/test"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+)
+
+func makeShard(t *testing.T, ds dag.DAGService, names []string) *Shard {
+	s, err := NewShard(ds, DefaultFanout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &dag.Node{Data: ft.FolderPBData()}
+	for _, name := range names {
+		if err := s.Set(context.Background(), name, child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func shardKey(t *testing.T, s *Shard) key.Key {
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func testNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("entry-%d", i)
+	}
+	return names
+}
+
+func TestSetFind(t *testing.T) {
+	ds := dagmock.Mock()
+	names := testNames(2000)
+	s := makeShard(t, ds, names)
+
+	for _, name := range names {
+		l, err := s.Find(context.Background(), name)
+		if err != nil {
+			t.Fatalf("finding %s: %s", name, err)
+		}
+		if l.Name != name {
+			t.Fatalf("looked up %s, found %s", name, l.Name)
+		}
+	}
+	if _, err := s.Find(context.Background(), "missing"); err != os.ErrNotExist {
+		t.Fatal("expected os.ErrNotExist, got", err)
+	}
+}
+
+func TestLoadFromDag(t *testing.T) {
+	ds := dagmock.Mock()
+	names := testNames(2000)
+	nd, err := makeShard(t, ds, names).Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Add(nd); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewHamtFromDag(ds, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.EnumLinks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != len(names) {
+		t.Fatalf("expected %d entries, listed %d", len(names), len(links))
+	}
+	if _, err := s.Find(context.Background(), names[1234]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrderIndependent(t *testing.T) {
+	ds := dagmock.Mock()
+	names := testNames(1000)
+	first := shardKey(t, makeShard(t, ds, names))
+
+	shuffled := make([]string, len(names))
+	for i, j := range rand.Perm(len(names)) {
+		shuffled[i] = names[j]
+	}
+	if shardKey(t, makeShard(t, ds, shuffled)) != first {
+		t.Fatal("the trie depends on the order of the inserts")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ds := dagmock.Mock()
+	names := testNames(1000)
+	s := makeShard(t, ds, names)
+
+	for _, name := range names[500:] {
+		if err := s.Remove(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Remove(context.Background(), names[700]); err != os.ErrNotExist {
+		t.Fatal("expected os.ErrNotExist removing a removed entry, got", err)
+	}
+	if _, err := s.Find(context.Background(), names[700]); err != os.ErrNotExist {
+		t.Fatal("removed entry still found")
+	}
+
+	// removing leaves the trie adding only the rest would have built
+	if shardKey(t, s) != shardKey(t, makeShard(t, ds, names[:500])) {
+		t.Fatal("the trie after removals is not canonical")
+	}
+}
+
+func TestMaxFanout(t *testing.T) {
+	ds := dagmock.Mock()
+	data, err := ft.HAMTShardData(nil, MaxFanout*2, HashFnv1a64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHamtFromDag(ds, &dag.Node{Data: data}); err == nil {
+		t.Fatal("loaded a shard with a fanout over the limit")
+	}
+}
+
+func TestHashFuncAndFileInfo(t *testing.T) {
+	ds := dagmock.Mock()
+	s := makeShard(t, ds, testNames(2000))
+	s.SetHashFunc(mh.SHA2_512)
+	mtime := time.Unix(1234567890, 0)
+	s.SetFileInfo(0755, mtime)
+
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := 0
+	for _, l := range nd.Links {
+		if len(l.Name) != s.padLen {
+			continue
+		}
+		shards++
+		dec, err := mh.Decode(l.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec.Code != mh.SHA2_512 {
+			t.Fatalf("shard %s hashed with %x", l.Name, dec.Code)
+		}
+	}
+	if shards == 0 {
+		t.Fatal("expected shards below the root")
+	}
+	loaded, err := NewHamtFromDag(ds, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.mode != 0755 || !loaded.mtime.Equal(mtime) {
+		t.Fatalf("file info not kept, got %s %s", loaded.mode, loaded.mtime)
+	}
+}
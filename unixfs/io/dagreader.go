@@ -19,6 +19,10 @@ var ErrIsDir = errors.New("this dag node is a directory")
 
 var ErrCantReadSymlinks = errors.New("cannot currently read symlinks")
 
+// DefaultPrefetch is the number of child nodes a DagReader requests ahead
+// of the one being read, unless set otherwise with SetPrefetch.
+var DefaultPrefetch = 10
+
 // DagReader provides a way to easily read the data contained in a dag.
 type DagReader struct {
 	serv mdag.DAGService
@@ -33,12 +37,20 @@ type DagReader struct {
 	// will either be a bytes.Reader or a child DagReader
 	buf ReadSeekCloser
 
-	// NodeGetters for each of 'nodes' child links
+	// NodeGetters for the child links requested so far, nil for those not
+	// requested yet or already read
 	promises []mdag.NodeGetter
 
 	// the index of the child link currently being read from
 	linkPosition int
 
+	// the number of child links to request ahead of linkPosition
+	prefetch int
+
+	// context for the outstanding child requests, canceled on seek
+	fetchCtx    context.Context
+	fetchCancel func()
+
 	// current offset for the read head within the 'file'
 	offset int64
 
@@ -65,7 +77,7 @@ func NewDagReader(ctx context.Context, n *mdag.Node, serv mdag.DAGService) (*Dag
 	}
 
 	switch pb.GetType() {
-	case ftpb.Data_Directory:
+	case ftpb.Data_Directory, ftpb.Data_HAMTShard:
 		// Dont allow reading directories
 		return nil, ErrIsDir
 	case ftpb.Data_Raw:
@@ -89,33 +101,94 @@ func NewDagReader(ctx context.Context, n *mdag.Node, serv mdag.DAGService) (*Dag
 }
 
 func NewDataFileReader(ctx context.Context, n *mdag.Node, pb *ftpb.Data, serv mdag.DAGService) *DagReader {
+	dr := newDataFileReader(ctx, n, pb, serv, DefaultPrefetch)
+	// the readers of the children share a session, which ends on Close
+	dr.serv = mdag.NewSession(dr.ctx, serv)
+	return dr
+}
+
+func newDataFileReader(ctx context.Context, n *mdag.Node, pb *ftpb.Data, serv mdag.DAGService, prefetch int) *DagReader {
 	fctx, cancel := context.WithCancel(ctx)
-	promises := serv.GetDAG(fctx, n)
-	return &DagReader{
+	dr := &DagReader{
 		node:     n,
 		serv:     serv,
 		buf:      NewRSNCFromBytes(pb.GetData()),
-		promises: promises,
+		promises: make([]mdag.NodeGetter, len(n.Links)),
+		prefetch: prefetch,
 		ctx:      fctx,
 		cancel:   cancel,
 		pbdata:   pb,
 	}
+	dr.resetFetch()
+	return dr
+}
+
+// SetPrefetch sets the number of child nodes requested ahead of the one
+// being read, for this reader and the ones it creates for its children.
+// With n < 1, nodes are only requested once they are read from.
+// The child nodes already requested stay in flight.
+func (dr *DagReader) SetPrefetch(n int) {
+	dr.prefetch = n
+	if child, ok := dr.buf.(*DagReader); ok {
+		child.SetPrefetch(n)
+	}
+}
+
+// resetFetch cancels the outstanding child requests, and forgets about the
+// nodes they got.
+func (dr *DagReader) resetFetch() {
+	if dr.fetchCancel != nil {
+		dr.fetchCancel()
+	}
+	dr.fetchCtx, dr.fetchCancel = context.WithCancel(dr.ctx)
+	for i := range dr.promises {
+		dr.promises[i] = nil
+	}
+}
+
+// preload requests the child nodes from linkPosition to the end of the
+// prefetch window that weren't requested yet, in a single batch.
+func (dr *DagReader) preload() {
+	end := dr.linkPosition + dr.prefetch
+	if end <= dr.linkPosition {
+		end = dr.linkPosition + 1
+	}
+	if end > len(dr.node.Links) {
+		end = len(dr.node.Links)
+	}
+
+	beg := dr.linkPosition
+	for beg < end && dr.promises[beg] != nil {
+		beg++
+	}
+	if beg == end {
+		return
+	}
+	copy(dr.promises[beg:end], dr.serv.GetLinks(dr.fetchCtx, dr.node.Links[beg:end]))
 }
 
 // precalcNextBuf follows the next link in line and loads it from the DAGService,
 // setting the next buffer to read from
 func (dr *DagReader) precalcNextBuf(ctx context.Context) error {
 	dr.buf.Close() // Just to make sure
-	if dr.linkPosition >= len(dr.promises) {
+	if dr.linkPosition >= len(dr.node.Links) {
 		return io.EOF
 	}
 
+	dr.preload()
 	nxt, err := dr.promises[dr.linkPosition].Get(ctx)
 	if err != nil {
 		return err
 	}
+	// drop the node so that at most a window of them is kept around
+	dr.promises[dr.linkPosition] = nil
 	dr.linkPosition++
 
+	if nxt.IsRaw() {
+		dr.buf = NewRSNCFromBytes(nxt.Data)
+		return nil
+	}
+
 	pb := new(ftpb.Data)
 	err = proto.Unmarshal(nxt.Data, pb)
 	if err != nil {
@@ -123,11 +196,11 @@ func (dr *DagReader) precalcNextBuf(ctx context.Context) error {
 	}
 
 	switch pb.GetType() {
-	case ftpb.Data_Directory:
+	case ftpb.Data_Directory, ftpb.Data_HAMTShard:
 		// A directory should not exist within a file
 		return ft.ErrInvalidDirLocation
 	case ftpb.Data_File:
-		dr.buf = NewDataFileReader(dr.ctx, nxt, pb, dr.serv)
+		dr.buf = newDataFileReader(dr.ctx, nxt, pb, dr.serv, dr.prefetch)
 		return nil
 	case ftpb.Data_Raw:
 		dr.buf = NewRSNCFromBytes(pb.GetData())
@@ -224,6 +297,10 @@ func (dr *DagReader) Seek(offset int64, whence int) (int64, error) {
 		// Grab cached protobuf object (solely to make code look cleaner)
 		pb := dr.pbdata
 
+		// the nodes requested ahead of the old position are likely useless
+		// now, stop fetching them
+		dr.resetFetch()
+
 		// left represents the number of bytes remaining to seek to (from beginning)
 		left := offset
 		if int64(len(pb.Data)) >= offset {
@@ -0,0 +1,131 @@
+package io
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	bitswap "github.com/ipfs/go-ipfs/exchange/bitswap"
+	tn "github.com/ipfs/go-ipfs/exchange/bitswap/testnet"
+	imp "github.com/ipfs/go-ipfs/importer"
+	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	delay "github.com/ipfs/go-ipfs/thirdparty/delay"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func getTestFile(t testing.TB, ds mdag.DAGService, size int, blksize int64) ([]byte, *mdag.Node) {
+	data := make([]byte, size)
+	u.NewTimeSeededRand().Read(data)
+	nd, err := imp.BuildDagFromReader(ds, chunk.NewSizeSplitter(bytes.NewReader(data), blksize), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, nd
+}
+
+func TestReadPrefetch(t *testing.T) {
+	ds := mdtest.Mock()
+	data, nd := getTestFile(t, ds, 200000, 512)
+
+	for _, prefetch := range []int{0, 1, 3, 100} {
+		dr, err := NewDagReader(context.Background(), nd, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr.SetPrefetch(prefetch)
+
+		out, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("prefetch %d: read wrong data", prefetch)
+		}
+	}
+}
+
+func TestSeekPrefetch(t *testing.T) {
+	ds := mdtest.Mock()
+	data, nd := getTestFile(t, ds, 200000, 512)
+
+	dr, err := NewDagReader(context.Background(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.SetPrefetch(4)
+
+	buf := make([]byte, 1000)
+	for i := 0; i < 50; i++ {
+		offset := rand.Intn(len(data) - len(buf))
+		if _, err := dr.Seek(int64(offset), os.SEEK_SET); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dr.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, data[offset:offset+len(buf)]) {
+			t.Fatalf("read wrong data at offset %d", offset)
+		}
+	}
+}
+
+func benchmarkReadLatency(b *testing.B, latency time.Duration, prefetch int) {
+	const size = 1024 * 1024
+
+	vnet := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(latency))
+	sesgen := bitswap.NewTestSessionGenerator(vnet)
+	defer sesgen.Close()
+	insts := sesgen.Instances(2)
+
+	sds := mdag.NewDAGService(bserv.New(insts[0].Blockstore(), insts[0].Exchange))
+	data, nd := getTestFile(b, sds, size, 8192)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		// a fresh peer each time, so that nothing is fetched already
+		inst := sesgen.Next()
+		inst.Exchange.PeerConnected(insts[0].Peer)
+		rds := mdag.NewDAGService(bserv.New(inst.Blockstore(), inst.Exchange))
+		b.StartTimer()
+
+		dr, err := NewDagReader(context.Background(), nd, rds)
+		if err != nil {
+			b.Fatal(err)
+		}
+		dr.SetPrefetch(prefetch)
+		out, err := ioutil.ReadAll(dr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		dr.Close()
+
+		b.StopTimer()
+		if !bytes.Equal(out, data) {
+			b.Fatal("read wrong data")
+		}
+		inst.Exchange.Close()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkReadLatency20msNoPrefetch(b *testing.B) {
+	benchmarkReadLatency(b, 20*time.Millisecond, 0)
+}
+func BenchmarkReadLatency20msPrefetch4(b *testing.B) { benchmarkReadLatency(b, 20*time.Millisecond, 4) }
+func BenchmarkReadLatency20msPrefetch16(b *testing.B) {
+	benchmarkReadLatency(b, 20*time.Millisecond, 16)
+}
+func BenchmarkReadLatency20msPrefetch64(b *testing.B) {
+	benchmarkReadLatency(b, 20*time.Millisecond, 64)
+}
@@ -1,16 +1,31 @@
 package io
 
 import (
+	"os"
+
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	key "github.com/ipfs/go-ipfs/blocks/key"
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	format "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+	upb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
-type directoryBuilder struct {
+// ShardSplitThreshold is the number of entries past which a directory is
+// stored as a HAMT instead of a single node.
+var ShardSplitThreshold = 1000
+
+// Directory is a unixfs directory being built or modified. It is stored
+// as a single node until it grows past ShardSplitThreshold entries, then
+// it is sharded.
+type Directory struct {
 	dserv   mdag.DAGService
 	dirnode *mdag.Node
+	shard   *hamt.Shard
+
+	// multihash function code the shards are hashed with once sharded
+	hashFunc int
 }
 
 // NewEmptyDirectory returns an empty merkledag Node with a folder Data chunk
@@ -18,25 +33,161 @@ func NewEmptyDirectory() *mdag.Node {
 	return &mdag.Node{Data: format.FolderPBData()}
 }
 
-// NewDirectory returns a directoryBuilder. It needs a DAGService to add the Children
-func NewDirectory(dserv mdag.DAGService) *directoryBuilder {
-	db := new(directoryBuilder)
+// NewDirectory returns an empty Directory. It needs a DAGService to add the Children
+func NewDirectory(dserv mdag.DAGService) *Directory {
+	db := new(Directory)
 	db.dserv = dserv
 	db.dirnode = NewEmptyDirectory()
 	return db
 }
 
+// NewDirectoryFromNode returns the Directory stored in nd, sharded or not.
+func NewDirectoryFromNode(dserv mdag.DAGService, nd *mdag.Node) (*Directory, error) {
+	pbd, err := format.FromBytes(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pbd.GetType() {
+	case upb.Data_Directory:
+		return &Directory{dserv: dserv, dirnode: nd.Copy(), hashFunc: nd.HashFunc()}, nil
+	case upb.Data_HAMTShard:
+		shard, err := hamt.NewHamtFromDag(dserv, nd)
+		if err != nil {
+			return nil, err
+		}
+		return &Directory{dserv: dserv, shard: shard, hashFunc: nd.HashFunc()}, nil
+	default:
+		return nil, format.ErrInvalidDirLocation
+	}
+}
+
 // AddChild adds a (name, key)-pair to the root node.
-func (d *directoryBuilder) AddChild(ctx context.Context, name string, k key.Key) error {
+func (d *Directory) AddChild(ctx context.Context, name string, k key.Key) error {
 	cnode, err := d.dserv.Get(ctx, k)
 	if err != nil {
 		return err
 	}
 
-	return d.dirnode.AddNodeLinkClean(name, cnode)
+	return d.AddChildNode(ctx, name, cnode)
 }
 
-// GetNode returns the root of this directoryBuilder
-func (d *directoryBuilder) GetNode() *mdag.Node {
-	return d.dirnode
+// AddChildNode adds nd under name, replacing the entry named name if there
+// is one. nd is not added to the DAGService.
+func (d *Directory) AddChildNode(ctx context.Context, name string, nd *mdag.Node) error {
+	if d.shard != nil {
+		return d.shard.Set(ctx, name, nd)
+	}
+
+	if err := d.dirnode.RemoveNodeLink(name); err != nil && err != mdag.ErrNotFound {
+		return err
+	}
+	if err := d.dirnode.AddNodeLinkClean(name, nd); err != nil {
+		return err
+	}
+	if len(d.dirnode.Links) > ShardSplitThreshold {
+		return d.switchToSharding(ctx)
+	}
+	return nil
+}
+
+// SetHashFunc sets the multihash function the directory, and its shards
+// if it is sharded, are hashed with.
+func (d *Directory) SetHashFunc(code int) {
+	d.hashFunc = code
+	if d.shard != nil {
+		d.shard.SetHashFunc(code)
+		return
+	}
+	d.dirnode.SetHashFunc(code)
+}
+
+func (d *Directory) switchToSharding(ctx context.Context) error {
+	shard, err := hamt.NewShard(d.dserv, hamt.DefaultFanout)
+	if err != nil {
+		return err
+	}
+	shard.SetHashFunc(d.hashFunc)
+
+	// the mode and mtime recorded in the directory move to the shard
+	pbd, err := format.FromBytes(d.dirnode.Data)
+	if err != nil {
+		return err
+	}
+	mode, _ := format.Mode(pbd)
+	mtime, _ := format.ModTime(pbd)
+	shard.SetFileInfo(mode, mtime)
+
+	for _, l := range d.dirnode.Links {
+		if err := shard.SetLink(ctx, l.Name, l); err != nil {
+			return err
+		}
+	}
+	d.shard = shard
+	d.dirnode = nil
+	return nil
+}
+
+// Find returns the child named name, or os.ErrNotExist.
+func (d *Directory) Find(ctx context.Context, name string) (*mdag.Node, error) {
+	if d.shard != nil {
+		l, err := d.shard.Find(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return l.GetNode(ctx, d.dserv)
+	}
+
+	l, err := d.dirnode.GetNodeLink(name)
+	if err == mdag.ErrNotFound {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l.GetNode(ctx, d.dserv)
+}
+
+// RemoveChild removes the child named name, or returns os.ErrNotExist.
+func (d *Directory) RemoveChild(ctx context.Context, name string) error {
+	if d.shard != nil {
+		return d.shard.Remove(ctx, name)
+	}
+
+	err := d.dirnode.RemoveNodeLink(name)
+	if err == mdag.ErrNotFound {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// Links returns the links to all the children.
+func (d *Directory) Links(ctx context.Context) ([]*mdag.Link, error) {
+	if d.shard != nil {
+		return d.shard.EnumLinks(ctx)
+	}
+	return d.dirnode.Links, nil
+}
+
+// GetNode returns the root of this Directory. The shards below it are
+// added to the DAGService, the root is not.
+func (d *Directory) GetNode() (*mdag.Node, error) {
+	if d.shard != nil {
+		return d.shard.Node()
+	}
+	return d.dirnode, nil
+}
+
+// Links returns the links to the entries of the directory stored in nd,
+// which may be sharded.
+func Links(ctx context.Context, dserv mdag.DAGService, nd *mdag.Node) ([]*mdag.Link, error) {
+	pbd, err := format.FromBytes(nd.Data)
+	if err != nil || pbd.GetType() != upb.Data_HAMTShard {
+		return nd.Links, nil
+	}
+	shard, err := hamt.NewHamtFromDag(dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	return shard.EnumLinks(ctx)
 }
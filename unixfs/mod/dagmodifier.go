@@ -227,6 +227,23 @@ func (dm *DagModifier) Sync() error {
 // returns the new key of the passed in node and whether or not all the data in the reader
 // has been consumed.
 func (dm *DagModifier) modifyDag(node *mdag.Node, offset uint64, data io.Reader) (key.Key, bool, error) {
+	if node.IsRaw() {
+		buf := make([]byte, len(node.Data))
+		copy(buf, node.Data)
+		n, err := data.Read(buf[offset:])
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+
+		nd := mdag.NewRawNode(buf)
+		nd.SetHashFunc(node.HashFunc())
+		k, err := dm.dagserv.Add(nd)
+		if err != nil {
+			return "", false, err
+		}
+		return k, n < len(buf[offset:]), nil
+	}
+
 	f, err := ft.FromBytes(node.Data)
 	if err != nil {
 		return "", false, err
@@ -246,6 +263,7 @@ func (dm *DagModifier) modifyDag(node *mdag.Node, offset uint64, data io.Reader)
 		}
 
 		nd := &mdag.Node{Data: b}
+		nd.SetHashFunc(node.HashFunc())
 		k, err := dm.dagserv.Add(nd)
 		if err != nil {
 			return "", false, err
@@ -265,10 +283,6 @@ func (dm *DagModifier) modifyDag(node *mdag.Node, offset uint64, data io.Reader)
 	for i, bs := range f.GetBlocksizes() {
 		// We found the correct child to write into
 		if cur+bs > offset {
-			// Unpin block
-			ckey := key.Key(node.Links[i].Hash)
-			dm.mp.RemovePinWithMode(ckey, pin.Indirect)
-
 			child, err := node.Links[i].GetNode(dm.ctx, dm.dagserv)
 			if err != nil {
 				return "", false, err
@@ -278,9 +292,6 @@ func (dm *DagModifier) modifyDag(node *mdag.Node, offset uint64, data io.Reader)
 				return "", false, err
 			}
 
-			// pin the new node
-			dm.mp.PinWithMode(k, pin.Indirect)
-
 			offset += bs
 			node.Links[i].Hash = mh.Multihash(k)
 
@@ -310,6 +321,7 @@ func (dm *DagModifier) appendData(node *mdag.Node, blks <-chan []byte, errs <-ch
 		Dagserv:  dm.dagserv,
 		Maxlinks: help.DefaultLinksPerBlock,
 		NodeCB:   imp.BasicPinnerCB(dm.mp),
+		HashFunc: node.HashFunc(),
 	}
 
 	return trickle.TrickleAppend(dm.ctx, node, dbp.New(blks, errs))
@@ -443,6 +455,12 @@ func (dm *DagModifier) Truncate(size int64) error {
 
 // dagTruncate truncates the given node to 'size' and returns the modified Node
 func dagTruncate(ctx context.Context, nd *mdag.Node, size uint64, ds mdag.DAGService) (*mdag.Node, error) {
+	if nd.IsRaw() {
+		raw := mdag.NewRawNode(nd.Data[:size])
+		raw.SetHashFunc(nd.HashFunc())
+		return raw, nil
+	}
+
 	if len(nd.Links) == 0 {
 		// TODO: this can likely be done without marshaling and remarshaling
 		pbn, err := ft.FromBytes(nd.Data)
@@ -464,9 +482,12 @@ func dagTruncate(ctx context.Context, nd *mdag.Node, size uint64, ds mdag.DAGSer
 			return nil, err
 		}
 
-		childsize, err := ft.DataSize(child.Data)
-		if err != nil {
-			return nil, err
+		childsize := uint64(len(child.Data))
+		if !child.IsRaw() {
+			childsize, err = ft.DataSize(child.Data)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		// found the child we want to cut
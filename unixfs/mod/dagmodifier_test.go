@@ -1,6 +1,7 @@
 package mod
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -171,6 +172,47 @@ func TestDagModifierBasic(t *testing.T) {
 	}
 }
 
+func TestRawLeavesModify(t *testing.T) {
+	dserv, pins := getMockDagServ(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := make([]byte, 50000)
+	u.NewTimeSeededRand().Read(b)
+	dbp := &h.DagBuilderParams{
+		Dagserv:   dserv,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		NodeCB:    imp.BasicPinnerCB(pins),
+		RawLeaves: true,
+	}
+	n, err := trickle.TrickleLayout(dbp.New(chunk.Chan(sizeSplitterGen(500)(bytes.NewReader(b)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dagmod, err := NewDagModifier(ctx, n, dserv, pins, sizeSplitterGen(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = testModWrite(t, 1000, 4000, b, dagmod)
+	b = testModWrite(t, 49500, 4000, b, dagmod)
+
+	if err := dagmod.Truncate(12345); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dagmod.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dagmod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = arrComp(out, b[:12345]); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestMultiWrite(t *testing.T) {
 	dserv, pins := getMockDagServ(t)
 	_, n := getNode(t, dserv, 0, pins)
@@ -566,7 +608,10 @@ func TestCorrectPinning(t *testing.T) {
 		t.Fatal("Incorrect node recursively pinned")
 	}
 
-	indirpins := pins.IndirectKeys()
+	indirpins, err := pins.IndirectKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
 	children := enumerateChildren(t, nd, dserv)
 	if len(indirpins) != len(children) {
 		t.Log(len(indirpins), len(children))
@@ -29,6 +29,7 @@ const (
 	Data_File      Data_DataType = 2
 	Data_Metadata  Data_DataType = 3
 	Data_Symlink   Data_DataType = 4
+	Data_HAMTShard Data_DataType = 5
 )
 
 var Data_DataType_name = map[int32]string{
@@ -37,6 +38,7 @@ var Data_DataType_name = map[int32]string{
 	2: "File",
 	3: "Metadata",
 	4: "Symlink",
+	5: "HAMTShard",
 }
 var Data_DataType_value = map[string]int32{
 	"Raw":       0,
@@ -44,6 +46,7 @@ var Data_DataType_value = map[string]int32{
 	"File":      2,
 	"Metadata":  3,
 	"Symlink":   4,
+	"HAMTShard": 5,
 }
 
 func (x Data_DataType) Enum() *Data_DataType {
@@ -68,6 +71,10 @@ type Data struct {
 	Data             []byte         `protobuf:"bytes,2,opt" json:"Data,omitempty"`
 	Filesize         *uint64        `protobuf:"varint,3,opt,name=filesize" json:"filesize,omitempty"`
 	Blocksizes       []uint64       `protobuf:"varint,4,rep,name=blocksizes" json:"blocksizes,omitempty"`
+	Mode             *uint32        `protobuf:"varint,5,opt,name=mode" json:"mode,omitempty"`
+	Mtime            *int64         `protobuf:"varint,6,opt,name=mtime" json:"mtime,omitempty"`
+	HashType         *uint64        `protobuf:"varint,7,opt,name=hashType" json:"hashType,omitempty"`
+	Fanout           *uint64        `protobuf:"varint,8,opt,name=fanout" json:"fanout,omitempty"`
 	XXX_unrecognized []byte         `json:"-"`
 }
 
@@ -103,6 +110,34 @@ func (m *Data) GetBlocksizes() []uint64 {
 	return nil
 }
 
+func (m *Data) GetMode() uint32 {
+	if m != nil && m.Mode != nil {
+		return *m.Mode
+	}
+	return 0
+}
+
+func (m *Data) GetMtime() int64 {
+	if m != nil && m.Mtime != nil {
+		return *m.Mtime
+	}
+	return 0
+}
+
+func (m *Data) GetHashType() uint64 {
+	if m != nil && m.HashType != nil {
+		return *m.HashType
+	}
+	return 0
+}
+
+func (m *Data) GetFanout() uint64 {
+	if m != nil && m.Fanout != nil {
+		return *m.Fanout
+	}
+	return 0
+}
+
 type Metadata struct {
 	MimeType         *string `protobuf:"bytes,1,req" json:"MimeType,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`